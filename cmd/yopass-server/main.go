@@ -19,6 +19,7 @@ import (
 
 	"github.com/gorilla/securecookie"
 	"github.com/jhaals/yopass/pkg/server"
+	"github.com/jhaals/yopass/pkg/yopass"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -45,8 +46,11 @@ var licenseFlagSections = []struct {
 }{
 	{"Authentication / OIDC", "oidc", []string{
 		"oidc-issuer", "oidc-client-id", "oidc-client-secret", "oidc-redirect-url",
-		"require-auth", "oidc-session-key", "oidc-allowed-domains", "frontend-url",
-		"api-token",
+		"require-auth", "oidc-session-key", "oidc-allowed-domains", "oidc-groups-claim", "frontend-url",
+		"api-token", "oidc-access-token-audience", "oidc-access-token-scopes", "oidc-access-token-service-clients",
+	}},
+	{"Admin API", "admin", []string{
+		"admin-groups", "admin-api-token",
 	}},
 	{"Branding & Theming", "branding", []string{
 		"license-key", "app-name", "logo-url",
@@ -79,13 +83,17 @@ func flagSectionFiltered(filter func(*pflag.Flag) bool) *pflag.FlagSet {
 func init() {
 	pflag.String("address", "", "listen address (default 0.0.0.0)")
 	pflag.Int("port", 1337, "listen port")
-	pflag.String("database", "memcached", "database backend ('memcached' or 'redis')")
+	pflag.String("database", "memcached", "database backend ('memcached', 'redis', 'postgres' or 'bolt')")
 	pflag.String("asset-path", "public", "path to the assets folder")
 	pflag.Int("max-length", 10000, "max length of encrypted secret")
 	pflag.String("max-file-size", "512KB", "max file upload size (e.g. 10KB, 512KB, 1MB); capped at 1MB without a license key")
-	pflag.String("memcached", "localhost:11211", "memcached address")
+	pflag.String("memcached", "localhost:11211", "memcached address, or comma-separated addresses of a consistently hashed pool")
 	pflag.Int("metrics-port", -1, "metrics server listen port")
-	pflag.String("redis", "redis://localhost:6379/0", "Redis URL")
+	pflag.String("redis", "redis://localhost:6379/0", "Redis URL (redis-sentinel:// and redis-cluster:// select Sentinel or cluster mode)")
+	pflag.String("postgres", "postgres://localhost:5432/yopass", "PostgreSQL URL")
+	pflag.String("bolt-path", "/tmp/yopass/yopass.db", "path to the embedded database file")
+	pflag.String("encryption-keyring", "", "path to a keyring file (one '<id>:<base64 32-byte key>' per line, first key active) used to encrypt stored values at rest")
+	pflag.Bool("encryption-accept-plaintext", false, "with --encryption-keyring, still read values stored before encryption was enabled")
 	pflag.String("tls-cert", "", "path to TLS certificate")
 	pflag.String("tls-key", "", "path to TLS key")
 	pflag.Bool("force-onetime-secrets", false, "reject non onetime secrets from being created")
@@ -93,15 +101,20 @@ func init() {
 	pflag.String("cors-allow-origin", "*", "Access-Control-Allow-Origin")
 	pflag.Bool("disable-upload", false, "disable the /file upload endpoints")
 	pflag.Bool("read-only", false, "disable all secret creation endpoints (retrieval-only mode)")
+	pflag.Bool("require-manage-token", false, "only allow deleting secrets with the management token issued to their creator")
 	pflag.Bool("prefetch-secret", true, "Display information that the secret might be one time use")
+	pflag.Int32("max-pin-attempts", server.DefaultMaxPINAttempts, "number of invalid PINs after which a PIN-protected secret is destroyed")
+	pflag.Bool("two-step-retrieval", false, "only release one-time secrets to a POST redeeming the claim nonce returned by GET, so link previews cannot consume them")
 	pflag.Bool("disable-features", false, "disable features")
 	pflag.Bool("no-language-switcher", false, "disable the language switcher in the UI")
 	pflag.StringSlice("trusted-proxies", []string{}, "trusted proxy IP addresses or CIDR blocks for X-Forwarded-For header validation")
 	pflag.String("privacy-notice-url", "", "URL to privacy notice page")
 	pflag.String("imprint-url", "", "URL to imprint/legal notice page")
 	pflag.String("public-url", "", "base URL of the public/read-only instance used in generated secret links (e.g. https://secrets.example.com)")
-	pflag.String("default-expiry", "1h", "default expiry time for secrets [1h, 1d, 1w]")
-	pflag.String("force-expiration", "", "force all secrets to use this expiration time [1h, 1d, 1w]")
+	pflag.String("default-expiry", "1h", "default expiry time for secrets (e.g. 15m, 1h, 3d)")
+	pflag.String("force-expiration", "", "force all secrets to use this expiration time (e.g. 1d)")
+	pflag.String("min-expiration", "1h", "shortest expiration time clients may choose")
+	pflag.String("max-expiration", "1w", "longest expiration time clients may choose")
 	pflag.String("theme-light", server.DefaultThemeLight, "DaisyUI theme name for light mode")
 	pflag.String("theme-dark", server.DefaultThemeDark, "DaisyUI theme name for dark mode")
 	pflag.String("theme-custom-light", "", "JSON object of CSS variables for a custom light theme (e.g. '{\"--color-primary\":\"oklch(...)\"}')")
@@ -109,14 +122,27 @@ func init() {
 	pflag.String("app-name", "", "Custom application name shown in the UI (default: Yopass)")
 	pflag.String("logo-url", "", "URL to a logo image (e.g. /mylogo.svg for a file in the public directory)")
 	pflag.String("license-key", "", "JWT license key for premium features (theming, custom branding)")
-	pflag.String("file-store", "", "file store backend for large files ('disk' or 's3'), defaults to database storage")
+	pflag.String("file-store", "", "file store backend for large files ('disk', 's3', 'azure', 'gcs' or 'bolt'), defaults to database storage")
 	pflag.String("file-store-path", "/tmp/yopass-files", "base path for disk file store")
 	pflag.String("file-store-s3-bucket", "", "S3 bucket name for file store")
 	pflag.String("file-store-s3-prefix", "yopass/", "S3 key prefix for file store")
 	pflag.String("file-store-s3-endpoint", "", "S3 endpoint URL (for MinIO/compatible)")
 	pflag.String("file-store-s3-region", "us-east-1", "S3 region")
-	pflag.Int("cleanup-interval", 60, "file cleanup interval in seconds")
-	pflag.Bool("disable-file-cleanup", false, "disable the file store cleanup goroutine (use when S3 lifecycle rules handle expiration)")
+	pflag.String("file-store-azure-container", "", "Azure Blob Storage container name for file store")
+	pflag.String("file-store-azure-prefix", "yopass/", "Azure blob name prefix for file store")
+	pflag.String("file-store-azure-connection-string", "", "Azure Storage connection string (also works with Azurite)")
+	pflag.String("file-store-azure-url", "", "Azure Blob service URL including a SAS token, used when no connection string is set")
+	pflag.String("file-store-gcs-bucket", "", "Google Cloud Storage bucket name for file store (credentials via Application Default Credentials)")
+	pflag.String("file-store-gcs-prefix", "yopass/", "GCS object name prefix for file store")
+	pflag.Int("cleanup-interval", 60, "interval in seconds for removing expired files, PostgreSQL rows and bolt entries")
+	pflag.Bool("disable-file-cleanup", false, "disable the file store cleanup goroutine (use when S3, Azure or GCS lifecycle rules handle expiration)")
+	pflag.String("rate-limit-create", "", "per-client rate limit for creating and extending secrets and files, as <requests>/<period> (e.g. 30/1m); empty disables")
+	pflag.String("rate-limit-retrieve", "", "per-client rate limit for reading, checking and deleting secrets and files, as <requests>/<period>; empty disables")
+	pflag.String("rate-limit-request", "", "per-client rate limit for the secret request endpoints, as <requests>/<period>; empty disables")
+	pflag.String("rate-limit-auth", "", "per-client rate limit for OIDC login, as <requests>/<period>; empty disables")
+	pflag.Int("ban-not-found-threshold", 0, "ban client IPs receiving this many 404s from secret and file endpoints within --ban-not-found-window (0 disables)")
+	pflag.Int("ban-not-found-window", 600, "window in seconds for counting 404s towards --ban-not-found-threshold")
+	pflag.Int("ban-duration", 900, "duration in seconds of a ban for too many 404s")
 	pflag.Bool("health-check", false, "Perform health check and exit")
 	pflag.String("oidc-issuer", "", "OIDC issuer URL (e.g. https://accounts.google.com)")
 	pflag.String("oidc-client-id", "", "OIDC OAuth2 client ID")
@@ -125,7 +151,13 @@ func init() {
 	pflag.Bool("require-auth", false, "require authentication to create secrets (needs --oidc-issuer and a valid license)")
 	pflag.String("oidc-session-key", "", "64-byte hex-encoded session key for multi-instance deployments (generate with: openssl rand -hex 64)")
 	pflag.StringSlice("oidc-allowed-domains", []string{}, "restrict secret creation to users whose email matches one of these domains (comma-separated, e.g. corp.example.com,example.com)")
+	pflag.String("oidc-groups-claim", server.DefaultOIDCGroupsClaim, "OIDC claim listing the user's groups, matched against the allowed groups of restricted secrets")
+	pflag.StringSlice("oidc-access-token-audience", []string{}, "accept JWT access tokens from --oidc-issuer as bearer credentials when their audience includes one of these values (comma-separated)")
+	pflag.StringSlice("oidc-access-token-scopes", []string{}, "scopes a bearer access token must grant (comma-separated)")
+	pflag.StringSlice("oidc-access-token-service-clients", []string{}, "OAuth client IDs whose bearer access tokens without an email act as service identities (comma-separated)")
 	pflag.StringSlice("api-token", []string{}, "static bearer token granting machine clients access to the --require-auth gated creation endpoints, formatted as name:secret (comma-separated for multiple; generate secrets with: openssl rand -hex 32)")
+	pflag.StringSlice("admin-groups", []string{}, "OIDC groups (see --oidc-groups-claim) whose members may use the /admin API (comma-separated)")
+	pflag.StringSlice("admin-api-token", []string{}, "static bearer token for the /admin API, formatted as name:secret (comma-separated for multiple; generate secrets with: openssl rand -hex 32)")
 	pflag.String("frontend-url", "", "frontend base URL for post-login redirect in split deployments (e.g. http://localhost:3000)")
 	pflag.Bool("audit-log", false, "enable structured audit logging to NDJSON (requires valid license)")
 	pflag.String("audit-log-file", "", "file path for audit log output (default: stdout)")
@@ -199,6 +231,8 @@ func main() {
 		logger.Fatal("failed to initialize OIDC provider", zap.Error(err))
 	}
 
+	accessTokens := setupAccessTokens(logger, oidcProvider)
+
 	apiTokens, err := resolveAPITokens()
 	if err != nil {
 		logger.Fatal(err.Error(), zap.Error(err))
@@ -211,6 +245,14 @@ func main() {
 		logger.Info("API token authentication enabled", zap.Strings("tokens", names))
 	}
 
+	adminAPITokens, err := server.ParseAPITokens(getStringSliceCSV("admin-api-token"))
+	if err != nil {
+		logger.Fatal("invalid --admin-api-token", zap.Error(err))
+	}
+	if len(adminAPITokens) > 0 || len(getStringSliceCSV("admin-groups")) > 0 {
+		logger.Info("admin API enabled", zap.Strings("groups", getStringSliceCSV("admin-groups")), zap.Int("tokens", len(adminAPITokens)))
+	}
+
 	auditLogger, err := setupAuditLogger(logger)
 	if err != nil {
 		logger.Fatal("failed to initialize audit logger", zap.Error(err))
@@ -250,12 +292,20 @@ func main() {
 		}
 	}
 
+	// Wrapped after the file store and before the cleanup goroutines see
+	// it: those need the concrete backend, and file blobs are client-side
+	// encrypted already.
+	storedDB, err := setupEncryption(logger, db)
+	if err != nil {
+		logger.Fatal("failed to setup encryption at rest", zap.Error(err))
+	}
+
 	cert := viper.GetString("tls-cert")
 	key := viper.GetString("tls-key")
 	quit := make(chan os.Signal, 1)
 
 	y := server.Server{
-		DB:                  db,
+		DB:                  storedDB,
 		FileStore:           fileStore,
 		MaxLength:           viper.GetInt("max-length"),
 		MaxFileSize:         maxFileSize,
@@ -275,14 +325,21 @@ func main() {
 		ReadOnly:              viper.GetBool("read-only"),
 		DisableUpload:         viper.GetBool("disable-upload"),
 		PrefetchSecret:        viper.GetBool("prefetch-secret"),
+		TwoStepRetrieval:      viper.GetBool("two-step-retrieval"),
+		MaxPINAttempts:        viper.GetInt32("max-pin-attempts"),
 		DisableFeatures:       viper.GetBool("disable-features"),
 		NoLanguageSwitcher:    viper.GetBool("no-language-switcher"),
 		DisableSecretRequests: viper.GetBool("disable-secret-requests"),
 		DisableReadReceipts:   viper.GetBool("disable-read-receipts"),
 
 		RequireAuth:         viper.GetBool("require-auth"),
+		RequireManageToken:  viper.GetBool("require-manage-token"),
 		AllowedEmailDomains: getStringSliceCSV("oidc-allowed-domains"),
+		OIDCGroupsClaim:     viper.GetString("oidc-groups-claim"),
 		APITokens:           apiTokens,
+		AccessTokens:        accessTokens,
+		AdminGroups:         getStringSliceCSV("admin-groups"),
+		AdminAPITokens:      adminAPITokens,
 
 		CORSAllowOrigin:  viper.GetString("cors-allow-origin"),
 		FrontendURL:      viper.GetString("frontend-url"),
@@ -299,6 +356,18 @@ func main() {
 
 		DefaultExpiry:   viper.GetString("default-expiry"),
 		ForceExpiration: viper.GetString("force-expiration"),
+		MinExpiration:   viper.GetString("min-expiration"),
+		MaxExpiration:   viper.GetString("max-expiration"),
+
+		RateLimits:           rateLimits(),
+		NotFoundBanThreshold: viper.GetInt("ban-not-found-threshold"),
+		NotFoundBanWindow:    time.Duration(viper.GetInt("ban-not-found-window")) * time.Second,
+		NotFoundBanDuration:  time.Duration(viper.GetInt("ban-duration")) * time.Second,
+	}
+	// With Redis the limits hold across replicas; other backends keep them
+	// per instance.
+	if r, ok := db.(*server.Redis); ok {
+		y.RateLimitStore = r
 	}
 	// Start cleanup goroutine for file store (disk or S3)
 	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
@@ -309,6 +378,20 @@ func main() {
 	if licenseStatus.Valid {
 		go server.StartLicenseExpiryMonitor(cleanupCtx, licenseStatus, time.Hour, logger)
 	}
+	// Expired rows are already invisible to reads; the reaper only reclaims
+	// their space, so it runs regardless of --disable-file-cleanup.
+	if pg, ok := db.(*server.Postgres); ok {
+		interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
+		logger.Info("Starting PostgreSQL expiry cleanup", zap.Duration("interval", interval))
+		go server.StartPostgresCleanup(cleanupCtx, pg, interval, logger)
+	}
+	// The bolt sweep also covers a bolt file store, since both live in the
+	// same file.
+	if bdb, ok := db.(*server.Bolt); ok {
+		interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
+		logger.Info("Starting bolt expiry cleanup", zap.Duration("interval", interval))
+		go server.StartBoltCleanup(cleanupCtx, bdb, interval, logger)
+	}
 	if fileStore != nil && !viper.GetBool("disable-file-cleanup") {
 		if ds, ok := fileStore.(*server.DiskFileStore); ok {
 			interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
@@ -318,6 +401,14 @@ func main() {
 			interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
 			logger.Info("Starting S3 file store cleanup", zap.Duration("interval", interval))
 			go server.StartS3Cleanup(cleanupCtx, s3s, interval, logger)
+		} else if azs, ok := fileStore.(*server.AzureFileStore); ok {
+			interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
+			logger.Info("Starting Azure file store cleanup", zap.Duration("interval", interval))
+			go server.StartAzureCleanup(cleanupCtx, azs, interval, logger)
+		} else if gcs, ok := fileStore.(*server.GCSFileStore); ok {
+			interval := time.Duration(viper.GetInt("cleanup-interval")) * time.Second
+			logger.Info("Starting GCS file store cleanup", zap.Duration("interval", interval))
+			go server.StartGCSCleanup(cleanupCtx, gcs, interval, logger)
 		}
 	}
 
@@ -377,6 +468,13 @@ func main() {
 	if webhooks != nil {
 		webhooks.Stop()
 	}
+	// Stop the sweeps before closing the bolt file they work on.
+	cleanupCancel()
+	if bdb, ok := db.(*server.Bolt); ok {
+		if err := bdb.Close(); err != nil {
+			logger.Error("failed to close bolt database", zap.Error(err))
+		}
+	}
 	if err := auditLogger.Sync(); err != nil {
 		logger.Error("failed to flush audit log on shutdown", zap.Error(err))
 	}
@@ -388,6 +486,48 @@ func main() {
 // entries fail fast at startup instead of being silently dropped in the UI.
 var unsafeCSSVarChars = regexp.MustCompile(`[;{}<>]`)
 
+// validateExpirationFlags checks that the expiration bounds form a valid
+// range and that the default and forced lifetimes fall inside it.
+func validateExpirationFlags() error {
+	policy := yopass.DefaultExpirationPolicy
+	for _, bound := range []struct {
+		flag string
+		dst  *int32
+	}{
+		{"min-expiration", &policy.Min},
+		{"max-expiration", &policy.Max},
+	} {
+		v := viper.GetString(bound.flag)
+		if v == "" {
+			continue
+		}
+		ttl, ok := yopass.ExpirationSeconds(v)
+		if !ok {
+			return fmt.Errorf("invalid --%s value %q, expected a duration such as 15m, 1h, 3d or 1w", bound.flag, v)
+		}
+		*bound.dst = ttl
+	}
+	if policy.Min > policy.Max {
+		return fmt.Errorf("--min-expiration %s is longer than --max-expiration %s",
+			yopass.FormatExpiration(policy.Min), yopass.FormatExpiration(policy.Max))
+	}
+
+	for _, flag := range []string{"default-expiry", "force-expiration"} {
+		v := viper.GetString(flag)
+		if v == "" {
+			continue
+		}
+		ttl, ok := yopass.ExpirationSeconds(v)
+		if !ok {
+			return fmt.Errorf("invalid --%s value %q, expected a duration such as 15m, 1h, 3d or 1w", flag, v)
+		}
+		if !policy.Allows(ttl) {
+			return fmt.Errorf("--%s %s must be %s (set by --min-expiration and --max-expiration)", flag, v, policy)
+		}
+	}
+	return nil
+}
+
 // validateFlags checks flag values and cross-flag requirements that need no
 // constructed dependencies, returning an error describing the first problem
 // found. An expired license (verified signature but past expiry) degrades
@@ -400,15 +540,8 @@ func validateFlags(license server.LicenseStatus, logger *zap.Logger) error {
 	// failed verification). An expired key is still "provided" and the
 	// server degrades instead of refusing to start.
 	noLicense := !licenseValid && !license.Expired()
-	if v := viper.GetString("default-expiry"); v != "" && !server.ValidExpiryString(v) {
-		return fmt.Errorf("invalid --default-expiry value %q, expected one of: 1h, 1d, 1w", v)
-	}
-
-	switch v := viper.GetString("force-expiration"); v {
-	case "", "1h", "1d", "1w":
-		// valid
-	default:
-		return fmt.Errorf("invalid --force-expiration value %q, expected one of: 1h, 1d, 1w", v)
+	if err := validateExpirationFlags(); err != nil {
+		return err
 	}
 
 	for _, flagName := range []string{"theme-light", "theme-dark"} {
@@ -451,6 +584,32 @@ func validateFlags(license server.LicenseStatus, logger *zap.Logger) error {
 		}
 	}
 
+	if len(getStringSliceCSV("oidc-access-token-audience")) > 0 && viper.GetString("oidc-issuer") == "" {
+		return errors.New("--oidc-access-token-audience requires OIDC (set --oidc-issuer)")
+	}
+	if len(getStringSliceCSV("oidc-access-token-scopes")) > 0 && len(getStringSliceCSV("oidc-access-token-audience")) == 0 {
+		return errors.New("--oidc-access-token-scopes requires --oidc-access-token-audience")
+	}
+	if len(getStringSliceCSV("oidc-access-token-service-clients")) > 0 && len(getStringSliceCSV("oidc-access-token-audience")) == 0 {
+		return errors.New("--oidc-access-token-service-clients requires --oidc-access-token-audience")
+	}
+
+	if len(getStringSliceCSV("admin-groups")) > 0 && viper.GetString("oidc-issuer") == "" {
+		return errors.New("--admin-groups requires OIDC (set --oidc-issuer)")
+	}
+	if len(getStringSliceCSV("admin-api-token")) > 0 && noLicense {
+		return errors.New("--admin-api-token requires a valid license key")
+	}
+
+	for _, class := range rateLimitClasses {
+		if _, err := server.ParseRateLimit(viper.GetString("rate-limit-" + class)); err != nil {
+			return fmt.Errorf("--rate-limit-%s: %w", class, err)
+		}
+	}
+	if viper.GetInt("ban-not-found-threshold") > 0 && (viper.GetInt("ban-not-found-window") <= 0 || viper.GetInt("ban-duration") <= 0) {
+		return errors.New("--ban-not-found-window and --ban-duration must be positive when --ban-not-found-threshold is set")
+	}
+
 	if viper.GetBool("audit-log") && noLicense {
 		return errors.New("--audit-log requires a valid license key")
 	}
@@ -465,6 +624,21 @@ func validateFlags(license server.LicenseStatus, logger *zap.Logger) error {
 	return nil
 }
 
+// rateLimitClasses lists the route classes with a --rate-limit-<class> flag.
+var rateLimitClasses = []string{server.RateLimitCreate, server.RateLimitRetrieve, server.RateLimitRequest, server.RateLimitAuth}
+
+// rateLimits returns the configured limits by route class. The flags are
+// validated by validateFlags.
+func rateLimits() map[string]server.RateLimit {
+	limits := map[string]server.RateLimit{}
+	for _, class := range rateLimitClasses {
+		if l, _ := server.ParseRateLimit(viper.GetString("rate-limit-" + class)); l.Enabled() {
+			limits[class] = l
+		}
+	}
+	return limits
+}
+
 // setupLicense verifies --license-key when provided and registers the
 // license expiry gauge on the registry.
 func setupLicense(logger *zap.Logger, registry *prometheus.Registry) server.LicenseStatus {
@@ -529,6 +703,21 @@ func setupOIDC(logger *zap.Logger, license server.LicenseStatus) (rp.RelyingPart
 	return provider, server.NewCookieCodec(sessionKey), nil
 }
 
+// setupAccessTokens builds the verifier for bearer JWT access tokens when
+// --oidc-access-token-audience is set. Tokens are checked against the keys
+// and signing algorithms the provider publishes for its ID tokens.
+func setupAccessTokens(logger *zap.Logger, provider rp.RelyingParty) *server.AccessTokenVerifier {
+	audiences := getStringSliceCSV("oidc-access-token-audience")
+	if provider == nil || len(audiences) == 0 {
+		return nil
+	}
+	scopes := getStringSliceCSV("oidc-access-token-scopes")
+	serviceClients := getStringSliceCSV("oidc-access-token-service-clients")
+	logger.Info("OIDC access token authentication enabled", zap.Strings("audience", audiences), zap.Strings("scopes", scopes), zap.Strings("service_clients", serviceClients))
+	idTokens := provider.IDTokenVerifier()
+	return server.NewAccessTokenVerifier(provider.Issuer(), idTokens.KeySet, idTokens.SupportedSignAlgs, audiences, scopes, serviceClients)
+}
+
 // resolveAPITokens parses --api-token and enforces that tokens are only
 // used together with --require-auth.
 func resolveAPITokens() ([]server.APIToken, error) {
@@ -666,9 +855,14 @@ func setupDatabase(logger *zap.Logger) (server.Database, error) {
 	var db server.Database
 	switch database := viper.GetString("database"); database {
 	case "memcached":
-		memcached := viper.GetString("memcached")
-		db = server.NewMemcached(memcached)
-		logger.Debug("configured Memcached", zap.String("address", memcached))
+		var nodes []string
+		for _, node := range strings.Split(viper.GetString("memcached"), ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				nodes = append(nodes, node)
+			}
+		}
+		db = server.NewMemcached(nodes...)
+		logger.Debug("configured Memcached", zap.Strings("nodes", nodes))
 	case "redis":
 		redis := viper.GetString("redis")
 		var err error
@@ -677,15 +871,59 @@ func setupDatabase(logger *zap.Logger) (server.Database, error) {
 			return nil, fmt.Errorf("invalid Redis URL: %w", err)
 		}
 		logger.Debug("configured Redis", zap.String("url", redis))
+	case "postgres":
+		var err error
+		db, err = server.NewPostgres(viper.GetString("postgres"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PostgreSQL URL: %w", err)
+		}
+		logger.Debug("configured PostgreSQL")
+	case "bolt":
+		path := viper.GetString("bolt-path")
+		var err error
+		db, err = server.NewBolt(path)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("configured bolt", zap.String("path", path))
 	default:
-		return nil, fmt.Errorf("unsupported database, expected 'memcached' or 'redis' got '%s'", database)
+		return nil, fmt.Errorf("unsupported database, expected 'memcached', 'redis', 'postgres' or 'bolt' got '%s'", database)
 	}
 	return db, nil
 }
 
-// performHealthCheck performs a health check on the provided database
+// setupEncryption wraps db in the encryption-at-rest layer when a keyring
+// is configured.
+func setupEncryption(logger *zap.Logger, db server.Database) (server.Database, error) {
+	path := viper.GetString("encryption-keyring")
+	if path == "" {
+		return db, nil
+	}
+	keyring, err := server.LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := server.NewEncryptedDatabase(db, keyring)
+	encrypted.AcceptPlaintext = viper.GetBool("encryption-accept-plaintext")
+	logger.Info("configured encryption at rest",
+		zap.String("active_key_id", keyring.ActiveKeyID()),
+		zap.Bool("accept_plaintext", encrypted.AcceptPlaintext),
+	)
+	return encrypted, nil
+}
+
+// performHealthCheck performs a health check on the provided database. A
+// degraded backend still passes, with a warning.
 func performHealthCheck(logger *zap.Logger, db server.Database) error {
-	if err := db.Health(); err != nil {
+	err := db.Health()
+	var degraded *server.DegradedError
+	if errors.As(err, &degraded) {
+		if logger != nil {
+			logger.Warn("database degraded", zap.Error(err))
+		}
+		return nil
+	}
+	if err != nil {
 		if logger != nil {
 			logger.Error("database health check failed", zap.Error(err))
 		}
@@ -719,7 +957,39 @@ func setupFileStore(logger *zap.Logger, db server.Database) (server.FileStore, e
 			viper.GetString("file-store-s3-endpoint"),
 			viper.GetString("file-store-s3-region"),
 		)
+	case "azure":
+		container := viper.GetString("file-store-azure-container")
+		if container == "" {
+			return nil, fmt.Errorf("file-store-azure-container is required when file-store=azure")
+		}
+		logger.Info("configured Azure file store",
+			zap.String("container", container),
+			zap.String("prefix", viper.GetString("file-store-azure-prefix")),
+		)
+		return server.NewAzureFileStore(
+			container,
+			viper.GetString("file-store-azure-prefix"),
+			viper.GetString("file-store-azure-connection-string"),
+			viper.GetString("file-store-azure-url"),
+		)
+	case "gcs":
+		bucket := viper.GetString("file-store-gcs-bucket")
+		if bucket == "" {
+			return nil, fmt.Errorf("file-store-gcs-bucket is required when file-store=gcs")
+		}
+		logger.Info("configured GCS file store",
+			zap.String("bucket", bucket),
+			zap.String("prefix", viper.GetString("file-store-gcs-prefix")),
+		)
+		return server.NewGCSFileStore(bucket, viper.GetString("file-store-gcs-prefix"))
+	case "bolt":
+		bdb, ok := db.(*server.Bolt)
+		if !ok {
+			return nil, fmt.Errorf("file-store=bolt requires --database bolt")
+		}
+		logger.Info("configured bolt file store")
+		return server.NewBoltFileStore(bdb), nil
 	default:
-		return nil, fmt.Errorf("unsupported file-store backend: %s (expected 'disk' or 's3')", viper.GetString("file-store"))
+		return nil, fmt.Errorf("unsupported file-store backend: %s (expected 'disk', 's3', 'azure', 'gcs' or 'bolt')", viper.GetString("file-store"))
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -43,6 +44,10 @@ func (m *mockDatabase) Put(key string, secret yopass.Secret) error {
 	return errors.New("not implemented")
 }
 
+func (m *mockDatabase) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return false, errors.New("not implemented")
+}
+
 func (m *mockDatabase) Delete(key string) (bool, error) {
 	return false, errors.New("not implemented")
 }
@@ -179,6 +184,30 @@ func TestSetupDatabase(t *testing.T) {
 	}
 }
 
+func TestSetupDatabaseMemcachedPool(t *testing.T) {
+	viper.Set("database", "memcached")
+	viper.Set("memcached", "127.0.0.1:1, 127.0.0.1:2,")
+	defer viper.Set("memcached", "localhost:11211")
+
+	db, err := setupDatabase(zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, ok := db.(*server.Memcached)
+	if !ok {
+		t.Fatalf("Expected *server.Memcached, got %T", db)
+	}
+	nodes, _ := m.NodeHealth()
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %v", nodes)
+	}
+	for _, addr := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		if _, ok := nodes[addr]; !ok {
+			t.Errorf("Expected node %s in %v", addr, nodes)
+		}
+	}
+}
+
 func TestSetupDatabaseRedis(t *testing.T) {
 	viper.Set("database", "redis")
 	viper.Set("redis", "redis://localhost:6379/0")
@@ -210,13 +239,158 @@ func TestSetupDatabaseRedisWithInvalidUrl(t *testing.T) {
 	}
 }
 
+func TestSetupDatabasePostgres(t *testing.T) {
+	viper.Set("database", "postgres")
+	viper.Set("postgres", "postgres://localhost:5432/yopass")
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	db, err := setupDatabase(logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := db.(*server.Postgres); !ok {
+		t.Fatalf("Expected *server.Postgres, got %T", db)
+	}
+
+	if logs.FilterMessage("configured PostgreSQL").Len() != 1 {
+		t.Error("Expected log message 'configured PostgreSQL'")
+	}
+}
+
+func TestSetupDatabasePostgresWithInvalidUrl(t *testing.T) {
+	viper.Set("database", "postgres")
+	viper.Set("postgres", "postgres://localhost:notaport/yopass")
+
+	core, _ := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	_, err := setupDatabase(logger)
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid PostgreSQL URL: ") {
+		t.Fatalf("Expected invalid PostgreSQL URL error, got %v", err)
+	}
+}
+
+func TestSetupDatabaseBolt(t *testing.T) {
+	viper.Set("database", "bolt")
+	viper.Set("bolt-path", filepath.Join(t.TempDir(), "yopass.db"))
+	defer viper.Set("database", "memcached")
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	db, err := setupDatabase(logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bdb, ok := db.(*server.Bolt)
+	if !ok {
+		t.Fatalf("Expected *server.Bolt, got %T", db)
+	}
+	defer bdb.Close()
+
+	if logs.FilterMessage("configured bolt").Len() != 1 {
+		t.Error("Expected log message 'configured bolt'")
+	}
+
+	viper.Set("file-store", "bolt")
+	defer viper.Set("file-store", "")
+	fs, err := setupFileStore(logger, db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := fs.(*server.BoltFileStore); !ok {
+		t.Fatalf("Expected *server.BoltFileStore, got %T", fs)
+	}
+}
+
+func TestSetupFileStoreCloud(t *testing.T) {
+	defer viper.Set("file-store", "")
+	db := server.NewMemcached("localhost:11211")
+
+	viper.Set("file-store", "azure")
+	viper.Set("file-store-azure-container", "")
+	if _, err := setupFileStore(zap.NewNop(), db); err == nil || err.Error() != "file-store-azure-container is required when file-store=azure" {
+		t.Fatalf("expected missing container error, got %v", err)
+	}
+
+	viper.Set("file-store-azure-container", "yopass")
+	viper.Set("file-store-azure-url", "https://account.blob.core.windows.net/?sv=sas")
+	defer viper.Set("file-store-azure-container", "")
+	defer viper.Set("file-store-azure-url", "")
+	fs, err := setupFileStore(zap.NewNop(), db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := fs.(*server.AzureFileStore); !ok {
+		t.Fatalf("Expected *server.AzureFileStore, got %T", fs)
+	}
+
+	viper.Set("file-store", "gcs")
+	viper.Set("file-store-gcs-bucket", "")
+	if _, err := setupFileStore(zap.NewNop(), db); err == nil || err.Error() != "file-store-gcs-bucket is required when file-store=gcs" {
+		t.Fatalf("expected missing bucket error, got %v", err)
+	}
+}
+
+func TestSetupFileStoreBoltRequiresBoltDatabase(t *testing.T) {
+	viper.Set("file-store", "bolt")
+	defer viper.Set("file-store", "")
+
+	_, err := setupFileStore(zap.NewNop(), server.NewMemcached("localhost:11211"))
+	expected := "file-store=bolt requires --database bolt"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected '%s', got '%v'", expected, err)
+	}
+}
+
+func TestSetupEncryption(t *testing.T) {
+	defer viper.Set("encryption-keyring", "")
+	defer viper.Set("encryption-accept-plaintext", false)
+	inner := server.NewMemcached("localhost:11211")
+
+	viper.Set("encryption-keyring", "")
+	db, err := setupEncryption(zap.NewNop(), inner)
+	if err != nil || db != server.Database(inner) {
+		t.Fatalf("expected the database unchanged without a keyring, got %T, %v", db, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keyring")
+	if err := os.WriteFile(path, []byte("k1:"+base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("encryption-keyring", path)
+	viper.Set("encryption-accept-plaintext", true)
+	core, logs := observer.New(zapcore.InfoLevel)
+	db, err = setupEncryption(zap.New(core), inner)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encrypted, ok := db.(*server.EncryptedDatabase)
+	if !ok {
+		t.Fatalf("Expected *server.EncryptedDatabase, got %T", db)
+	}
+	if !encrypted.AcceptPlaintext {
+		t.Error("Expected AcceptPlaintext to be set")
+	}
+	if logs.FilterMessage("configured encryption at rest").Len() != 1 {
+		t.Error("Expected log message 'configured encryption at rest'")
+	}
+
+	viper.Set("encryption-keyring", filepath.Join(t.TempDir(), "missing"))
+	if _, err := setupEncryption(zap.NewNop(), inner); err == nil {
+		t.Fatal("Expected error for missing keyring")
+	}
+}
+
 func TestSetupDatabaseInvalid(t *testing.T) {
 	viper.Set("database", "invalid")
 	core, _ := observer.New(zapcore.DebugLevel)
 	logger := zap.New(core)
 
 	_, err := setupDatabase(logger)
-	expected := `unsupported database, expected 'memcached' or 'redis' got 'invalid'`
+	expected := `unsupported database, expected 'memcached', 'redis', 'postgres' or 'bolt' got 'invalid'`
 	if err.Error() != expected {
 		t.Fatalf("Expected '%s', got '%v'", expected, err.Error())
 	}
@@ -612,6 +786,11 @@ func TestPerformHealthCheck(t *testing.T) {
 			wantErr:     true,
 			errContains: "database health check failed",
 		},
+		{
+			name:      "degraded database passes",
+			healthErr: &server.DegradedError{Reason: "replica 10.0.0.2:6379 is s_down"},
+			wantErr:   false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -38,18 +38,41 @@ func TestValidateFlags(t *testing.T) {
 		},
 		{
 			name:    "invalid default-expiry",
-			flags:   map[string]interface{}{"default-expiry": "2h"},
+			flags:   map[string]interface{}{"default-expiry": "2x"},
 			wantErr: "--default-expiry",
 		},
 		{
-			name:    "invalid force-expiration",
+			name:  "arbitrary default-expiry",
+			flags: map[string]interface{}{"default-expiry": "2h"},
+		},
+		{
+			name:    "force-expiration outside policy",
 			flags:   map[string]interface{}{"force-expiration": "30m"},
-			wantErr: "--force-expiration",
+			wantErr: "--force-expiration 30m must be between 1h and 1w",
 		},
 		{
 			name:  "valid force-expiration",
 			flags: map[string]interface{}{"force-expiration": "1d"},
 		},
+		{
+			name:  "force-expiration within widened policy",
+			flags: map[string]interface{}{"force-expiration": "30m", "min-expiration": "15m"},
+		},
+		{
+			name:    "default-expiry above max-expiration",
+			flags:   map[string]interface{}{"max-expiration": "30d", "default-expiry": "60d"},
+			wantErr: "--default-expiry 60d must be between 1h and 30d",
+		},
+		{
+			name:    "invalid min-expiration",
+			flags:   map[string]interface{}{"min-expiration": "soon"},
+			wantErr: "invalid --min-expiration",
+		},
+		{
+			name:    "min-expiration above max-expiration",
+			flags:   map[string]interface{}{"min-expiration": "2w", "max-expiration": "1w", "default-expiry": "1w"},
+			wantErr: "--min-expiration 2w is longer than --max-expiration 1w",
+		},
 		{
 			name:    "reserved theme name",
 			flags:   map[string]interface{}{"theme-light": "custom-light"},
@@ -136,6 +159,49 @@ func TestValidateFlags(t *testing.T) {
 			flags:   map[string]interface{}{"audit-log": true},
 			license: validLicense,
 		},
+		{
+			name:    "invalid rate limit",
+			flags:   map[string]interface{}{"rate-limit-create": "lots"},
+			wantErr: "--rate-limit-create: invalid rate limit",
+		},
+		{
+			name:  "valid rate limit",
+			flags: map[string]interface{}{"rate-limit-retrieve": "120/m"},
+		},
+		{
+			name:    "admin-groups without oidc-issuer",
+			flags:   map[string]interface{}{"admin-groups": "ops"},
+			license: validLicense,
+			wantErr: "--admin-groups requires OIDC",
+		},
+		{
+			name:    "access token audience without oidc-issuer",
+			flags:   map[string]interface{}{"oidc-access-token-audience": "yopass"},
+			license: validLicense,
+			wantErr: "--oidc-access-token-audience requires OIDC",
+		},
+		{
+			name:    "access token scopes without audience",
+			flags:   map[string]interface{}{"oidc-issuer": "https://issuer.example", "oidc-access-token-scopes": "yopass.create"},
+			license: validLicense,
+			wantErr: "--oidc-access-token-scopes requires --oidc-access-token-audience",
+		},
+		{
+			name:    "access token service clients without audience",
+			flags:   map[string]interface{}{"oidc-issuer": "https://issuer.example", "oidc-access-token-service-clients": "backup-job"},
+			license: validLicense,
+			wantErr: "--oidc-access-token-service-clients requires --oidc-access-token-audience",
+		},
+		{
+			name:    "admin-api-token requires license",
+			flags:   map[string]interface{}{"admin-api-token": "ops:0123456789abcdef"},
+			wantErr: "--admin-api-token requires a valid license key",
+		},
+		{
+			name:    "admin-api-token with license",
+			flags:   map[string]interface{}{"admin-api-token": "ops:0123456789abcdef"},
+			license: validLicense,
+		},
 		{
 			name:    "webhook-url requires license",
 			flags:   map[string]interface{}{"webhook-url": "https://hooks.example.com"},
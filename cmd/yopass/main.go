@@ -1,7 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -29,12 +31,33 @@ Examples:
       # Encrypt and share secret file
       yopass --file /path/to/secret.conf
 
+      # Encrypt and share several files or a whole directory as one bundle
+      yopass --file id_ed25519 --file id_ed25519.pub
+      yopass --file ./certs/
+
       # Share secret multiple time a whole day
       cat secret-notes.md | yopass --expiration=1d --one-time=false
 
-      # Decrypt secret to stdout
+      # Share secret for 15 minutes (if the server's --min-expiration allows it)
+      printf 'secret message' | yopass --expiration=15m
+
+      # Decrypt secret to stdout, or a bundle into the current directory
       yopass --decrypt https://yopass.se/#/...
 
+      # Decrypt a file into the current directory under its original name
+      yopass --decrypt https://yopass.se/#/f/... --output-original-name
+
+      # Share a secret with a read receipt and wait until it has been viewed
+      printf 'secret message' | yopass --receipt
+      yopass receipt --watch https://yopass.se/#/s/... <receipt token>
+
+      # Ask someone for a secret, and collect it once it has been provided
+      yopass request create --label "Staging database password"
+      yopass request fetch --wait
+
+      # Provide the secret for a request link, from stdin or --file
+      printf 'secret message' | yopass request fulfill https://yopass.se/#/r/...
+
 Website: %s
 `
 
@@ -72,11 +95,18 @@ func init() {
 	pflag.String("api", viper.GetString("api"), "Yopass API server location")
 	pflag.String("api-token", viper.GetString("api-token"), "API token for server authentication")
 	pflag.String("decrypt", viper.GetString("decrypt"), "Decrypt secret URL")
-	pflag.String("expiration", viper.GetString("expiration"), "Duration after which secret will be deleted [1h, 1d, 1w]")
-	pflag.String("file", viper.GetString("file"), "Read secret from file instead of stdin")
+	pflag.String("expiration", viper.GetString("expiration"), "Duration after which secret will be deleted (e.g. 15m, 1h, 3d, 1w)")
+	pflag.StringArray("file", viper.GetStringSlice("file"), "Read secret from file instead of stdin; repeat or pass a directory to share a bundle")
+	pflag.Bool("force", viper.GetBool("force"), "Overwrite an existing --output file")
 	pflag.String("key", viper.GetString("key"), "Manual encryption/decryption key")
+	pflag.String("label", viper.GetString("label"), "Label shown to the responder of a secret request (not encrypted)")
 	pflag.Bool("one-time", viper.GetBool("one-time"), "One-time download")
+	pflag.String("output", viper.GetString("output"), "Write the decrypted secret to this file, or into this directory under its original name")
+	pflag.Bool("output-original-name", viper.GetBool("output-original-name"), "Write the decrypted file under its original name, into --output or the current directory")
+	pflag.Bool("receipt", viper.GetBool("receipt"), "Request a read receipt and print its token")
 	pflag.String("url", viper.GetString("url"), "Yopass public URL")
+	pflag.Bool("wait", viper.GetBool("wait"), "Wait until a secret has been provided for the request to fetch")
+	pflag.Bool("watch", viper.GetBool("watch"), "Wait until the secret is viewed (exit 0) or expires unviewed (exit 2)")
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
 		fmt.Fprintln(os.Stderr, "Unable to bind flags:", err)
 		os.Exit(3)
@@ -89,7 +119,9 @@ func main() {
 	}
 
 	var err error
-	if viper.IsSet("decrypt") {
+	if args := pflag.Args(); len(args) > 0 {
+		err = runCommand(args, os.Stdin, os.Stdout)
+	} else if viper.IsSet("decrypt") {
 		err = decrypt(os.Stdout)
 	} else {
 		err = encryptStdinOrFile(os.Stdin, os.Stdout)
@@ -97,6 +129,10 @@ func main() {
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -118,8 +154,12 @@ func decrypt(out io.Writer) error {
 		key = viper.GetString("key")
 	}
 
+	target, err := resolveOutput()
+	if err != nil {
+		return err
+	}
 	if fileOpt {
-		return decryptFile(out, id, key)
+		return decryptFile(out, id, key, target)
 	}
 
 	msg, err := yopass.FetchWithToken(viper.GetString("api"), id, viper.GetString("api-token"))
@@ -127,37 +167,89 @@ func decrypt(out io.Writer) error {
 		return fmt.Errorf("Failed to fetch secret: %w", err)
 	}
 
-	pt, _, err := yopass.Decrypt(strings.NewReader(msg), key)
+	pt, filename, err := yopass.Decrypt(strings.NewReader(msg), key)
 	if err != nil {
 		return fmt.Errorf("Failed to decrypt secret: %w", err)
 	}
 
+	if target != nil {
+		return target.write(out, strings.NewReader(pt), filename)
+	}
 	_, err = fmt.Fprint(out, pt)
 	return err
 }
 
-func decryptFile(out io.Writer, id, key string) error {
-	data, err := yopass.FetchFileWithToken(viper.GetString("api"), id, viper.GetString("api-token"))
+// decryptFile downloads and decrypts a file. Bundles are extracted; other
+// files go to stdout unless target says otherwise.
+func decryptFile(out io.Writer, id, key string, target *outputTarget) error {
+	body, err := apiClient(viper.GetString("api")).DownloadFile(context.Background(), id, yopass.FetchOptions{})
 	if err != nil {
 		return fmt.Errorf("Failed to fetch file: %w", err)
 	}
+	defer body.Close()
+	download, done := withProgress(body, "Downloading", -1)
+	defer done()
 
-	pt, _, err := yopass.Decrypt(bytes.NewReader(data), key)
+	pt, filename, err := yopass.DecryptStream(download, key)
 	if err != nil {
 		return fmt.Errorf("Failed to decrypt file: %w", err)
 	}
 
-	_, err = fmt.Fprint(out, pt)
-	return err
+	plaintext := bufio.NewReader(pt)
+	if yopass.PeekBundle(plaintext) {
+		dir, err := target.bundleDir()
+		if err != nil {
+			return err
+		}
+		return extractBundle(out, plaintext, dir)
+	}
+	if target != nil {
+		return target.write(out, plaintext, filename)
+	}
+	if _, err := io.Copy(out, plaintext); err != nil {
+		return fmt.Errorf("Failed to decrypt file: %w", err)
+	}
+	return nil
+}
+
+// extractBundle recreates the files of a decrypted bundle in dir and prints
+// the path of every file written. ExtractBundle reads the plaintext to its
+// end, checking the final authentication tag, before any file is in place,
+// so nothing is written or printed for a corrupted bundle.
+func extractBundle(out io.Writer, pt io.Reader, dir string) error {
+	created, err := yopass.ExtractBundle(pt, dir)
+	if err != nil {
+		return fmt.Errorf("Failed to extract bundle: %w", err)
+	}
+	for _, name := range created {
+		fmt.Fprintln(out, name)
+	}
+	return nil
 }
 
 func encryptStdinOrFile(in *os.File, out io.Writer) error {
 	if viper.IsSet("file") {
-		return encryptFileByName(viper.GetString("file"), out)
+		files := filesToShare()
+		if len(files) == 1 {
+			if info, err := os.Stat(files[0]); err != nil || !info.IsDir() {
+				return encryptFileByName(files[0], out)
+			}
+		}
+		return encryptBundle(files, out)
 	}
 	return encryptStdin(in, out)
 }
 
+// filesToShare returns the --file values. They are read from the flag set
+// when given on the command line since viper splits list values on commas.
+func filesToShare() []string {
+	if f := pflag.CommandLine.Lookup("file"); f != nil && f.Changed {
+		files, _ := pflag.CommandLine.GetStringArray("file")
+		return files
+	}
+	return viper.GetStringSlice("file")
+}
+
 func encryptFileByName(filename string, out io.Writer) error {
 	in, err := os.Open(filename)
 	if err != nil {
@@ -165,14 +257,9 @@ func encryptFileByName(filename string, out io.Writer) error {
 	}
 	defer in.Close()
 
-	exp := expiration(viper.GetString("expiration"))
-	if exp == 0 {
-		return fmt.Errorf("Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)")
-	}
-
-	key, err := encryptionKey(viper.GetString("key"))
+	exp, key, config, err := encryptionSettings()
 	if err != nil {
-		return fmt.Errorf("Failed to generate encryption key: %w", err)
+		return err
 	}
 
 	stat, err := in.Stat()
@@ -180,22 +267,102 @@ func encryptFileByName(filename string, out io.Writer) error {
 		return fmt.Errorf("Failed to get file info: %w", err)
 	}
 
-	encryptBinary := yopass.EncryptBinary
-	if argon2Enabled() {
-		encryptBinary = yopass.EncryptBinaryWithArgon2
+	return uploadFile(out, key, exp, func(w io.Writer) error {
+		plaintext, done := withProgress(in, "Uploading", stat.Size())
+		defer done()
+		if err := encryptStream(config)(w, plaintext, key, stat.Name()); err != nil {
+			return fmt.Errorf("Failed to encrypt file: %w", err)
+		}
+		return nil
+	})
+}
+
+// encryptBundle shares several files and directories as a single encrypted
+// bundle. The server only sees one file, so its size limit applies to the
+// bundle as a whole.
+func encryptBundle(paths []string, out io.Writer) error {
+	files, err := yopass.CollectBundleFiles(paths...)
+	if err != nil {
+		return fmt.Errorf("Failed to open file: %w", err)
 	}
-	data, err := encryptBinary(in, key, stat.Name())
+
+	exp, key, config, err := encryptionSettings()
 	if err != nil {
-		return fmt.Errorf("Failed to encrypt file: %w", err)
+		return err
 	}
 
-	id, err := yopass.StoreFileWithToken(viper.GetString("api"), data, exp, viper.GetBool("one-time"), viper.GetString("api-token"))
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return uploadFile(out, key, exp, func(w io.Writer) error {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(yopass.WriteBundle(pw, files))
+		}()
+		defer pr.Close()
+
+		plaintext, done := withProgress(pr, "Uploading", size)
+		defer done()
+		if err := encryptStream(config)(w, plaintext, key, yopass.BundleFileName); err != nil {
+			return fmt.Errorf("Failed to encrypt bundle: %w", err)
+		}
+		return nil
+	})
+}
+
+// encryptStream returns the file encryption matching the server's key
+// derivation.
+func encryptStream(config yopass.ServerConfig) func(w io.Writer, r io.Reader, key, filename string) error {
+	if config.Argon2 {
+		return yopass.EncryptStreamWithArgon2
+	}
+	return yopass.EncryptStream
+}
+
+// uploadFile stores a file without holding it in memory: encrypt writes the
+// encrypted file into a pipe that streams straight into the request body.
+func uploadFile(out io.Writer, key string, exp int32, encrypt func(io.Writer) error) error {
+	pr, pw := io.Pipe()
+	encrypted := make(chan error, 1)
+	go func() {
+		err := encrypt(pw)
+		pw.CloseWithError(err)
+		encrypted <- err
+	}()
+
+	created, err := apiClient(viper.GetString("api")).UploadFile(context.Background(), pr, creationOptions(exp))
+	// Unblock the encryption if the server stopped reading early.
+	pr.Close()
+	if encErr := <-encrypted; encErr != nil && !errors.Is(encErr, io.ErrClosedPipe) {
+		return encErr
+	}
 	if err != nil {
 		return fmt.Errorf("Failed to store file: %w", err)
 	}
+	return printSecretURL(out, created, key, true)
+}
 
+// creationOptions returns the options for a secret or file from the flags.
+func creationOptions(exp int32) yopass.CreateOptions {
+	return yopass.CreateOptions{
+		Expiration: exp,
+		OneTime:    viper.GetBool("one-time"),
+		Receipt:    viper.GetBool("receipt"),
+	}
+}
+
+// printSecretURL prints the link to a stored secret, followed by its
+// receipt token if a receipt was requested.
+func printSecretURL(out io.Writer, created yopass.Created, key string, fileOpt bool) error {
 	url := viper.GetString("url")
-	_, err = fmt.Fprintln(out, yopass.SecretURL(url, id, key, true, viper.IsSet("key")))
+	if _, err := fmt.Fprintln(out, yopass.SecretURL(url, created.ID, key, fileOpt, viper.IsSet("key"))); err != nil {
+		return err
+	}
+	if created.ReceiptToken == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(out, "Receipt token: %s\n", created.ReceiptToken)
 	return err
 }
 
@@ -211,18 +378,13 @@ func encryptStdin(in *os.File, out io.Writer) error {
 }
 
 func encrypt(in io.ReadCloser, out io.Writer) error {
-	exp := expiration(viper.GetString("expiration"))
-	if exp == 0 {
-		return fmt.Errorf("Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)")
-	}
-
-	key, err := encryptionKey(viper.GetString("key"))
+	exp, key, config, err := encryptionSettings()
 	if err != nil {
-		return fmt.Errorf("Failed to generate encryption key: %w", err)
+		return err
 	}
 
 	encryptMessage := yopass.Encrypt
-	if argon2Enabled() {
+	if config.Argon2 {
 		encryptMessage = yopass.EncryptWithArgon2
 	}
 	msg, err := encryptMessage(in, key)
@@ -230,28 +392,50 @@ func encrypt(in io.ReadCloser, out io.Writer) error {
 		return fmt.Errorf("Failed to encrypt secret: %w", err)
 	}
 
-	id, err := yopass.StoreWithToken(viper.GetString("api"), yopass.Secret{
-		Expiration: exp,
-		Message:    msg,
-		OneTime:    viper.GetBool("one-time"),
-	}, viper.GetString("api-token"))
+	created, err := apiClient(viper.GetString("api")).CreateSecret(context.Background(), msg, creationOptions(exp))
 	if err != nil {
 		return fmt.Errorf("Failed to store secret: %w", err)
 	}
+	return printSecretURL(out, created, key, viper.IsSet("file"))
+}
 
-	url := viper.GetString("url")
-	_, err = fmt.Fprintln(out, yopass.SecretURL(url, id, key, viper.IsSet("file"), viper.IsSet("key")))
-	return err
+// encryptionSettings validates --expiration, resolves the encryption key and
+// reads the server /config endpoint, which tells the CLI whether the server
+// has Argon2 key derivation enabled (--argon2) and which expirations it
+// accepts. Config errors are ignored on purpose: the CLI then falls back to
+// the default key derivation, which every yopass server accepts, and leaves
+// the expiration check to the server. Decryption needs no configuration since
+// the S2K type is stored in the message.
+func encryptionSettings() (exp int32, key string, config yopass.ServerConfig, err error) {
+	exp = expiration(viper.GetString("expiration"))
+	if exp == 0 {
+		return 0, "", config, fmt.Errorf("Invalid expiration %q, use a duration such as 15m, 1h, 3d or 1w", viper.GetString("expiration"))
+	}
+
+	config, err = yopass.FetchServerConfigWithToken(viper.GetString("api"), viper.GetString("api-token"))
+	if err != nil {
+		config = yopass.ServerConfig{}
+	} else if err := checkExpiration(exp, config); err != nil {
+		return 0, "", config, err
+	}
+
+	key, err = encryptionKey(viper.GetString("key"))
+	if err != nil {
+		return 0, "", config, fmt.Errorf("Failed to generate encryption key: %w", err)
+	}
+	return exp, key, config, nil
 }
 
-// argon2Enabled reads the server /config endpoint and reports whether the
-// server has Argon2 key derivation enabled (--argon2). Errors are ignored
-// on purpose: if the config cannot be fetched the CLI falls back to the
-// default key derivation, which every yopass server accepts. Decryption
-// needs no configuration since the S2K type is stored in the message.
-func argon2Enabled() bool {
-	config, err := yopass.FetchServerConfigWithToken(viper.GetString("api"), viper.GetString("api-token"))
-	return err == nil && config.Argon2
+// checkExpiration reports an expiration the server would reject, so the
+// user gets the server's limits instead of a bare "Invalid expiration".
+func checkExpiration(exp int32, config yopass.ServerConfig) error {
+	if config.ForceExpiration != 0 && exp != config.ForceExpiration {
+		return fmt.Errorf("Expiration must be %s on this server", yopass.FormatExpiration(config.ForceExpiration))
+	}
+	if policy := config.ExpirationPolicy(); !policy.Allows(exp) {
+		return fmt.Errorf("Expiration must be %s on this server", policy)
+	}
+	return nil
 }
 
 func encryptionKey(key string) (string, error) {
@@ -262,7 +446,7 @@ func encryptionKey(key string) (string, error) {
 }
 
 // expiration converts a human-readable expiry duration to seconds, returning
-// 0 for unsupported values.
+// 0 for malformed values.
 func expiration(s string) int32 {
 	seconds, ok := yopass.ExpirationSeconds(s)
 	if !ok {
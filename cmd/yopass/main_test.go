@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -148,12 +149,66 @@ func TestInvalidExpiration(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected expiration validation error, got none")
 	}
-	want := "Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)"
+	want := `Invalid expiration "123", use a duration such as 15m, 1h, 3d or 1w`
 	if err.Error() != want {
 		t.Fatalf("expected %s, got %s", want, err.Error())
 	}
 }
 
+func TestExpirationPolicy(t *testing.T) {
+	db := &testDB{data: make(map[string]yopass.Secret)}
+	y := server.Server{
+		DB:            db,
+		FileStore:     server.NewDatabaseFileStore(db),
+		MaxLength:     10000,
+		Registry:      prometheus.NewRegistry(),
+		Logger:        zaptest.NewLogger(t),
+		MinExpiration: "15m",
+		MaxExpiration: "30d",
+	}
+	ts := httptest.NewServer(y.HTTPHandler())
+	defer ts.Close()
+
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	tests := []struct {
+		expiration string
+		wantErr    string
+	}{
+		{"15m", ""},
+		{"30d", ""},
+		{"1h30m", ""},
+		{"5m", "Expiration must be between 15m and 30d on this server"},
+		{"31d", "Expiration must be between 15m and 30d on this server"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expiration, func(t *testing.T) {
+			viper.Set("expiration", tc.expiration)
+			out := bytes.Buffer{}
+			err := encrypt(io.NopCloser(strings.NewReader("secret")), &out)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err)
+				}
+				id, _, _, _, err := yopass.ParseURL(strings.TrimSpace(out.String()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, _ := yopass.ExpirationSeconds(tc.expiration)
+				if got := db.data[id].Expiration; got != want {
+					t.Errorf("expected stored expiration %d, got %d", want, got)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("expected %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
 func TestMissingFileEncryption(t *testing.T) {
 	viper.Set("file", "xyz")
 	t.Cleanup(resetViper)
@@ -220,14 +275,87 @@ func TestCLIFileUpload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected no decryption error, got %q", err)
 	}
-	// Note yopass decrypt currently always prints the content to stdout. This
-	// could be changed to create a file, but will need to handle the case that
-	// the file already exists.
+	// Without --output, yopass decrypt prints the content to stdout.
 	if out.String() != msg {
 		t.Fatalf("expected secret to match original %q, got %q", msg, out.String())
 	}
 }
 
+func TestCLIBundle(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"id_ed25519":         "private key",
+		"certs/server.pem":   "certificate",
+		"certs/ca/chain.pem": "chain",
+	} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("one-time", false)
+	viper.Set("file", []string{filepath.Join(src, "id_ed25519"), filepath.Join(src, "certs")})
+	out := bytes.Buffer{}
+	if err := encryptStdinOrFile(nil, &out); err != nil {
+		t.Fatalf("expected no encryption error, got %q", err)
+	}
+
+	t.Chdir(t.TempDir())
+	viper.Set("decrypt", out.String())
+	out.Reset()
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected no decryption error, got %q", err)
+	}
+	want := "id_ed25519\ncerts/ca/chain.pem\ncerts/server.pem\n"
+	if out.String() != filepath.FromSlash(want) {
+		t.Errorf("expected extracted paths %q, got %q", want, out.String())
+	}
+	got, err := os.ReadFile(filepath.Join("certs", "ca", "chain.pem"))
+	if err != nil || string(got) != "chain" {
+		t.Errorf("expected extracted chain.pem, got %q (%v)", got, err)
+	}
+
+	// Extracting again must not overwrite the files already written.
+	out.Reset()
+	if err := decrypt(&out); err == nil || !strings.Contains(err.Error(), "Failed to extract bundle") {
+		t.Errorf("expected extract error for existing files, got %v", err)
+	}
+}
+
+func TestCLIBundleSizeLimitAppliesToTotal(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	// Each file is below the server's 10MB limit, together they are not.
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte(name), 6*1024*1024), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("file", []string{dir})
+	err := encryptStdinOrFile(nil, &bytes.Buffer{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to store file") {
+		t.Fatalf("expected the server to reject the bundle, got %v", err)
+	}
+}
+
 func TestDecryptWithoutCustomKey(t *testing.T) {
 	viper.Set("decrypt", "https://yopass.se/#/c/21701b28-fb3f-451d-8a52-3e6c9094e7ea")
 	err := decrypt(nil)
@@ -301,6 +429,14 @@ func TestExpiration(t *testing.T) {
 			"1w",
 			604800,
 		},
+		{
+			"15m",
+			900,
+		},
+		{
+			"30d",
+			2592000,
+		},
 		{
 			"invalid",
 			0,
@@ -419,6 +555,14 @@ func (db *testDB) Put(key string, secret yopass.Secret) error {
 	return nil
 }
 
+func (db *testDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	if _, ok := db.data[key]; ok {
+		return false, nil
+	}
+	db.data[key] = secret
+	return true, nil
+}
+
 func (db *testDB) Delete(key string) (bool, error) {
 	delete(db.data, key)
 	return true, nil
@@ -486,11 +630,18 @@ func TestCLIArgon2(t *testing.T) {
 		t.Fatalf("expected no encryption error, got %q", err)
 	}
 
-	// The stored ciphertext must use Argon2 key derivation.
-	if len(db.data) != 1 {
-		t.Fatalf("expected one stored secret, got %d", len(db.data))
+	// The stored ciphertext must use Argon2 key derivation. The server keeps
+	// the management record next to the secret.
+	secrets := map[string]yopass.Secret{}
+	for key, secret := range db.data {
+		if !strings.HasPrefix(key, "manage/") {
+			secrets[key] = secret
+		}
+	}
+	if len(secrets) != 1 {
+		t.Fatalf("expected one stored secret, got %d", len(secrets))
 	}
-	for _, secret := range db.data {
+	for _, secret := range secrets {
 		if mode := messageS2KMode(t, secret.Message); mode != s2k.Argon2S2K {
 			t.Errorf("expected S2K mode %d (Argon2), got %d", s2k.Argon2S2K, mode)
 		}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// outputTarget is where --output and --output-original-name write a
+// decrypted secret: the file path, or the directory that receives the file
+// under the name it was shared with when path is empty.
+type outputTarget struct {
+	dir  string
+	path string
+}
+
+// resolveOutput checks the output flags before the secret is fetched, so a
+// one-time secret is not used up by a download that cannot be saved. It
+// returns nil when the secret goes to stdout.
+func resolveOutput() (*outputTarget, error) {
+	output := viper.GetString("output")
+	original := viper.GetBool("output-original-name")
+	if output == "" && !original {
+		return nil, nil
+	}
+	if output == "" {
+		output = "."
+	}
+
+	info, err := os.Stat(output)
+	switch {
+	case err == nil && info.IsDir():
+		return &outputTarget{dir: output}, nil
+	case original:
+		return nil, fmt.Errorf("Output %s must be a directory with --output-original-name", output)
+	case err == nil && !info.Mode().IsRegular():
+		return nil, fmt.Errorf("Output %s is not a regular file", output)
+	case err == nil && !viper.GetBool("force"):
+		return nil, fmt.Errorf("Refusing to overwrite %s, use --force", output)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("Failed to check output: %w", err)
+	}
+
+	dir := filepath.Dir(output)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("Output directory %s does not exist", dir)
+	}
+	return &outputTarget{dir: dir, path: output}, nil
+}
+
+// bundleDir returns the directory a bundle is extracted into. A path that
+// does not exist yet becomes a new directory.
+func (t *outputTarget) bundleDir() (string, error) {
+	if t == nil {
+		return ".", nil
+	}
+	if t.path == "" {
+		return t.dir, nil
+	}
+	if err := os.Mkdir(t.path, 0o700); err != nil {
+		return "", fmt.Errorf("Failed to create output directory: %w", err)
+	}
+	return t.path, nil
+}
+
+// write stores the plaintext from r and prints the path written. The file is
+// only readable by the current user, and it is decrypted into a temporary
+// file first so that a failed integrity check leaves nothing behind. If the
+// destination appeared in the meantime, the temporary file is kept rather
+// than losing a one-time secret.
+func (t *outputTarget) write(out io.Writer, r io.Reader, filename string) error {
+	dest := t.path
+	if dest == "" {
+		name, err := safeFileName(filename)
+		if err != nil {
+			return err
+		}
+		dest = filepath.Join(t.dir, name)
+	}
+
+	tmp, err := os.CreateTemp(t.dir, ".yopass-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create output file: %w", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to decrypt file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to write output file: %w", err)
+	}
+	if err := placeFile(tmp.Name(), dest, viper.GetBool("force")); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, dest)
+	return err
+}
+
+// placeFile moves the decrypted file at tmp to dest. Without force it never
+// replaces an existing file.
+func placeFile(tmp, dest string, force bool) error {
+	if !force {
+		// Unlike a rename, a hard link fails if dest exists.
+		err := os.Link(tmp, dest)
+		if err == nil {
+			return os.Remove(tmp)
+		}
+		// Not every file system supports hard links, fall back to a rename.
+		if _, statErr := os.Lstat(dest); errors.Is(err, fs.ErrExist) || statErr == nil {
+			return fmt.Errorf("Refusing to overwrite %s, use --force; the decrypted file was kept as %s", dest, tmp)
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("Failed to write %s, the decrypted file was kept as %s: %w", dest, tmp, err)
+	}
+	return nil
+}
+
+// safeFileName reduces the file name stored in a secret to a plain name in
+// the output directory: the sender chooses it, so any directories in it,
+// including "..", are dropped.
+func safeFileName(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("The secret has no file name, set --output to a file path")
+	}
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if base == "." || base == ".." || base == "/" || strings.IndexFunc(base, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("The secret's file name %q is not usable, set --output to a file path", name)
+	}
+	return base, nil
+}
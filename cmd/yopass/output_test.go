@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/spf13/viper"
+)
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\Windows\evil.exe`, "evil.exe"},
+		{"/etc/cron.d/job", "job"},
+		{"certs/", "certs"},
+		{"", ""},
+		{"..", ""},
+		{"/", ""},
+		{"name\x1b[2J", ""},
+	}
+	for _, tc := range tests {
+		got, err := safeFileName(tc.name)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q: expected %q, got %q (%v)", tc.name, tc.want, got, err)
+		}
+	}
+}
+
+// setupOutputTest points the CLI at a test server that keeps files after
+// their first download.
+func setupOutputTest(t *testing.T) {
+	t.Helper()
+	ts, cleanup := newTestServer(t)
+	t.Cleanup(cleanup)
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	viper.Set("one-time", false)
+	t.Cleanup(resetViper)
+}
+
+// shareTestFile uploads a file named name and returns its URL.
+func shareTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	setupOutputTest(t)
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := encryptFileByName(file, &out); err != nil {
+		t.Fatalf("expected no encryption error, got %v", err)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+func TestCLIDecryptOriginalName(t *testing.T) {
+	link := shareTestFile(t, "report.txt", "quarterly numbers")
+	dir := t.TempDir()
+	viper.Set("decrypt", link)
+	viper.Set("output", dir)
+	viper.Set("output-original-name", true)
+
+	var out bytes.Buffer
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected no decryption error, got %v", err)
+	}
+	path := filepath.Join(dir, "report.txt")
+	if out.String() != path+"\n" {
+		t.Errorf("expected the written path, got %q", out.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the file to be private, got %v", info.Mode().Perm())
+	}
+	if got, _ := os.ReadFile(path); string(got) != "quarterly numbers" {
+		t.Errorf("expected the file contents, got %q", got)
+	}
+
+	// The name only shows up once the file is downloaded, so the decrypted
+	// file is kept next to the existing one instead of being lost.
+	if err := os.WriteFile(path, []byte("mine"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = decrypt(&out)
+	if err == nil || !strings.Contains(err.Error(), "Refusing to overwrite") {
+		t.Fatalf("expected an existing file to be kept, got %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "mine" {
+		t.Errorf("expected the existing file to be untouched, got %q", got)
+	}
+	if kept, _ := filepath.Glob(filepath.Join(dir, ".yopass-*")); len(kept) != 1 {
+		t.Errorf("expected the decrypted file to be kept, got %v", kept)
+	}
+
+	viper.Set("force", true)
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected --force to overwrite, got %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "quarterly numbers" {
+		t.Errorf("expected the file to be replaced, got %q", got)
+	}
+}
+
+func TestCLIDecryptOutputPath(t *testing.T) {
+	link := shareTestFile(t, "id_ed25519", "private key")
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("decrypt", link)
+	viper.Set("output", path)
+
+	var out bytes.Buffer
+	err := decrypt(&out)
+	if err == nil || !strings.Contains(err.Error(), "use --force") {
+		t.Fatalf("expected an existing file to be refused, got %v", err)
+	}
+
+	viper.Set("force", true)
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected no decryption error, got %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected an overwritten file to be private, got %v", info.Mode().Perm())
+	}
+	if got, _ := os.ReadFile(path); string(got) != "private key" {
+		t.Errorf("expected the file contents, got %q", got)
+	}
+}
+
+func TestCLIDecryptBundleOutput(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "certs")
+	if err := os.Mkdir(src, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "server.pem"), []byte("certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setupOutputTest(t)
+	viper.Set("file", []string{src})
+	var out bytes.Buffer
+	if err := encryptStdinOrFile(nil, &out); err != nil {
+		t.Fatalf("expected no encryption error, got %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	viper.Set("decrypt", out.String())
+	viper.Set("output", dest)
+	out.Reset()
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected no decryption error, got %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "certs", "server.pem")); err != nil || string(got) != "certificate" {
+		t.Errorf("expected the bundle in the output directory, got %q (%v)", got, err)
+	}
+}
+
+func TestCLIDecryptTamperedBundle(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "certs")
+	if err := os.Mkdir(src, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "server.pem"), []byte("certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files, err := yopass.CollectBundleFiles(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle, ciphertext bytes.Buffer
+	if err := yopass.WriteBundle(&bundle, files); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := yopass.GenerateKey()
+	if err := yopass.EncryptStream(&ciphertext, &bundle, key, yopass.BundleFileName); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupting the final bytes leaves every entry intact but breaks the
+	// authentication tag checked at the end of the stream.
+	tampered := ciphertext.Bytes()
+	tampered[len(tampered)-1] ^= 0xff
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tampered)
+	}))
+	t.Cleanup(ts.Close)
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	viper.Set("decrypt", yopass.SecretURL(ts.URL, "bundle", key, true, false))
+	viper.Set("output", dest)
+	var out bytes.Buffer
+	if err := decrypt(&out); err == nil {
+		t.Fatal("expected a tampered bundle to fail decryption")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "certs", "server.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected the extracted file to be removed, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no extracted paths to be reported, got %q", out.String())
+	}
+}
+
+func TestCLIDecryptOutputErrors(t *testing.T) {
+	t.Cleanup(resetViper)
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		output   string
+		original bool
+		want     string
+	}{
+		{file, true, "must be a directory"},
+		{filepath.Join(file, "nested"), false, "not a directory"},
+		{filepath.Join(t.TempDir(), "missing", "file"), false, "does not exist"},
+	}
+	for _, tc := range tests {
+		viper.Set("output", tc.output)
+		viper.Set("output-original-name", tc.original)
+		_, err := resolveOutput()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.output, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressOutput receives the transfer progress of files. It is only set
+// when stderr is a terminal, so scripts and logs stay free of it.
+var progressOutput io.Writer
+
+// progressInterval limits how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+func init() {
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		progressOutput = os.Stderr
+	}
+}
+
+// progressReader reports the bytes read through it on progressOutput.
+type progressReader struct {
+	r     io.Reader
+	verb  string
+	total int64
+	done  int64
+	drawn time.Time
+}
+
+// withProgress wraps r to report its progress on progressOutput. total is
+// the expected number of bytes, or -1 if unknown. The returned function ends
+// the progress line and must be called once the transfer is over.
+func withProgress(r io.Reader, verb string, total int64) (io.Reader, func()) {
+	if progressOutput == nil {
+		return r, func() {}
+	}
+	p := &progressReader{r: r, verb: verb, total: total}
+	return p, func() {
+		p.draw()
+		fmt.Fprintln(progressOutput)
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if time.Since(p.drawn) >= progressInterval {
+		p.draw()
+	}
+	return n, err
+}
+
+func (p *progressReader) draw() {
+	p.drawn = time.Now()
+	if p.total <= 0 {
+		fmt.Fprintf(progressOutput, "\r%s %s", p.verb, formatSize(p.done))
+		return
+	}
+	percent := min(p.done*100/p.total, 100)
+	fmt.Fprintf(progressOutput, "\r%s %s of %s (%d%%)", p.verb, formatSize(p.done), formatSize(p.total), percent)
+}
+
+// formatSize formats a byte count with a binary unit, e.g. 1.5 GiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 << 20:         "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+		(1 << 40) + 1:   "1.0 TiB",
+		1<<30 + 512<<20: "1.5 GiB",
+	}
+	for n, want := range tests {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d): expected %q, got %q", n, want, got)
+		}
+	}
+}
+
+func TestCLIFileProgress(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	var progress bytes.Buffer
+	progressOutput = &progress
+	t.Cleanup(func() { progressOutput = nil })
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 32*1024)
+	file := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := encryptFileByName(file, &out); err != nil {
+		t.Fatalf("expected no encryption error, got %v", err)
+	}
+	if !strings.HasSuffix(progress.String(), "\rUploading 512.0 KiB of 512.0 KiB (100%)\n") {
+		t.Errorf("expected upload progress, got %q", progress.String())
+	}
+
+	progress.Reset()
+	viper.Set("decrypt", out.String())
+	out.Reset()
+	if err := decrypt(&out); err != nil {
+		t.Fatalf("expected no decryption error, got %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("expected the file back, got %d bytes", out.Len())
+	}
+	if !strings.HasPrefix(progress.String(), "\rDownloading ") || !strings.HasSuffix(progress.String(), "\n") {
+		t.Errorf("expected download progress, got %q", progress.String())
+	}
+}
+
+func TestCLIFileUploadReadError(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+
+	// Reading a directory fails once encryption has started.
+	err := encryptFileByName(t.TempDir(), io.Discard)
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to encrypt file") {
+		t.Errorf("expected the read error to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/spf13/viper"
+)
+
+// exitExpired is the exit code of "receipt" when the secret expired without
+// being viewed, so scripts waiting for a hand-off can tell it from errors
+// (exit code 1).
+const exitExpired = 2
+
+// exitError ends the CLI with a specific exit code.
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// errExpiredUnviewed reports a secret whose receipt is gone: it expired, or
+// was deleted, before anyone viewed it.
+var errExpiredUnviewed = &exitError{code: exitExpired, msg: "Secret expired without being viewed"}
+
+// receiptCommand shows the read receipt of a secret, named by its ID or URL.
+// The receipt token may be omitted when --api-token created the secret and
+// holds the read-receipt scope. With --watch it waits until the secret is
+// viewed or expires, retrying transient server errors meanwhile.
+func receiptCommand(args []string, out io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: yopass receipt [--watch] <secret ID or URL> [<receipt token>]")
+	}
+	id := args[0]
+	if urlID, _, _, _, err := yopass.ParseURL(id); err == nil {
+		id = urlID
+	}
+	var token string
+	if len(args) == 2 {
+		token = args[1]
+	}
+
+	ctx := context.Background()
+	watch := viper.GetBool("watch")
+	client := apiClient(viper.GetString("api"))
+	if watch {
+		client = pollClient(viper.GetString("api"))
+	}
+	// expiresAt is known once a receipt has been fetched; a transient error
+	// before then, or after the secret expired, ends the watch.
+	var expiresAt int64
+	for {
+		receipt, err := client.Receipt(ctx, id, token)
+		if yopass.IsNotFound(err) {
+			return errExpiredUnviewed
+		}
+		if err != nil && (!watch || !transientError(err) || time.Now().Unix() >= expiresAt) {
+			return fmt.Errorf("Failed to fetch receipt: %w", err)
+		}
+		if err == nil {
+			if !watch || receipt.State == "viewed" {
+				return printReceipt(out, receipt)
+			}
+			expiresAt = receipt.ExpiresAt
+		}
+
+		wait := pollInterval
+		if left := time.Until(time.Unix(expiresAt, 0)); left <= 0 {
+			return errExpiredUnviewed
+		} else if left < wait {
+			wait = left
+		}
+		time.Sleep(wait)
+	}
+}
+
+func printReceipt(out io.Writer, r yopass.Receipt) error {
+	fmt.Fprintf(out, "State: %s\n", r.State)
+	if r.ViewedAt != 0 {
+		fmt.Fprintf(out, "Viewed: %s\n", formatTime(r.ViewedAt))
+	}
+	if r.MaxViews > 0 {
+		fmt.Fprintf(out, "Views: %d of %d\n", r.Views, r.MaxViews)
+		if r.LastViewedAt != 0 {
+			fmt.Fprintf(out, "Last viewed: %s\n", formatTime(r.LastViewedAt))
+		}
+	}
+	_, err := fmt.Fprintf(out, "Expires: %s\n", formatTime(r.ExpiresAt))
+	return err
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// receiptServer stands in for a licensed server with read receipts, which
+// the real handler only serves with a license.
+type receiptServer struct {
+	mu        sync.Mutex
+	state     string
+	expiresAt int64
+	gone      bool
+	fetches   int
+	// unavailable answers that many further receipt fetches with 503.
+	unavailable int
+}
+
+func setupReceiptTest(t *testing.T) (*receiptServer, *httptest.Server) {
+	t.Helper()
+	rs := &receiptServer{state: "pending", expiresAt: time.Now().Add(time.Hour).Unix()}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		switch r.Method + " " + r.URL.Path {
+		case "GET /config":
+			json.NewEncoder(w).Encode(map[string]bool{"READ_RECEIPTS": true})
+		case "POST /create/secret":
+			var body struct {
+				Receipt bool `json:"receipt"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			created := map[string]string{"message": "secret-id"}
+			if body.Receipt {
+				created["receipt_token"] = "receipt-token"
+			}
+			json.NewEncoder(w).Encode(created)
+		case "GET /secret/secret-id/receipt":
+			rs.fetches++
+			if rs.unavailable > 0 {
+				rs.unavailable--
+				http.Error(w, `{"message":"unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if r.Header.Get("X-Yopass-Receipt-Token") != "receipt-token" {
+				http.Error(w, `{"message":"Invalid receipt token"}`, http.StatusUnauthorized)
+				return
+			}
+			if rs.gone {
+				http.Error(w, `{"message":"Receipt not found"}`, http.StatusNotFound)
+				return
+			}
+			receipt := map[string]interface{}{"state": rs.state, "one_time": true, "created_at": 1, "expires_at": rs.expiresAt}
+			if rs.state == "viewed" {
+				receipt["viewed_at"] = 2
+			}
+			json.NewEncoder(w).Encode(receipt)
+		default:
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+	return rs, ts
+}
+
+func TestCLIReceipt(t *testing.T) {
+	_, ts := setupReceiptTest(t)
+
+	stdin, err := tempFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdin.Name())
+	defer stdin.Close()
+	viper.Set("receipt", true)
+	var out bytes.Buffer
+	if err := encryptStdinOrFile(stdin, &out); err != nil {
+		t.Fatalf("expected no encryption error, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], ts.URL+"/#/s/secret-id/") || lines[1] != "Receipt token: receipt-token" {
+		t.Fatalf("expected the secret URL and receipt token, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand([]string{"receipt", lines[0], "receipt-token"}, nil, &out); err != nil {
+		t.Fatalf("receipt: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "State: pending\n") || strings.Contains(out.String(), "Viewed:") {
+		t.Errorf("expected a pending receipt, got %q", out.String())
+	}
+}
+
+func TestCLIReceiptWatch(t *testing.T) {
+	rs, _ := setupReceiptTest(t)
+	pollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { pollInterval = 5 * time.Second })
+	viper.Set("watch", true)
+
+	go func() {
+		// View the secret once watch has started polling.
+		for {
+			rs.mu.Lock()
+			if rs.fetches > 0 {
+				rs.state = "viewed"
+				rs.mu.Unlock()
+				return
+			}
+			rs.mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"receipt", "secret-id", "receipt-token"}, nil, &out); err != nil {
+		t.Fatalf("receipt --watch: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "State: viewed\nViewed: ") {
+		t.Errorf("expected a viewed receipt, got %q", out.String())
+	}
+	if rs.fetches < 2 {
+		t.Errorf("expected watch to poll until viewed, got %d fetches", rs.fetches)
+	}
+}
+
+func TestCLIReceiptWatchTransientErrors(t *testing.T) {
+	rs, _ := setupReceiptTest(t)
+	pollInterval = 10 * time.Millisecond
+	pollBackoff = time.Millisecond
+	t.Cleanup(func() { pollInterval, pollBackoff = 5*time.Second, time.Second })
+	viper.Set("watch", true)
+
+	go func() {
+		// The server becomes unavailable for longer than a poll retries
+		// once watch has started, and the secret is viewed meanwhile.
+		for {
+			rs.mu.Lock()
+			if rs.fetches > 0 {
+				rs.unavailable = 3 * (pollRetries + 1)
+				rs.state = "viewed"
+				rs.mu.Unlock()
+				return
+			}
+			rs.mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"receipt", "secret-id", "receipt-token"}, nil, &out); err != nil {
+		t.Fatalf("receipt --watch: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "State: viewed\n") {
+		t.Errorf("expected a viewed receipt, got %q", out.String())
+	}
+
+	// Without --watch an error is reported at once.
+	viper.Set("watch", false)
+	rs.mu.Lock()
+	rs.unavailable = 1
+	rs.mu.Unlock()
+	if err := runCommand([]string{"receipt", "secret-id", "receipt-token"}, nil, &out); err == nil {
+		t.Error("expected an unavailable server to be an error without --watch")
+	}
+}
+
+func TestCLIReceiptExpired(t *testing.T) {
+	rs, _ := setupReceiptTest(t)
+	pollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { pollInterval = 5 * time.Second })
+
+	var out bytes.Buffer
+	var exitErr *exitError
+	rs.gone = true
+	err := runCommand([]string{"receipt", "secret-id", "receipt-token"}, nil, &out)
+	if !errors.As(err, &exitErr) || exitErr.code != exitExpired {
+		t.Errorf("expected a missing receipt to exit with %d, got %v", exitExpired, err)
+	}
+
+	rs.gone = false
+	rs.expiresAt = time.Now().Add(-time.Second).Unix()
+	viper.Set("watch", true)
+	err = runCommand([]string{"receipt", "secret-id", "receipt-token"}, nil, &out)
+	if !errors.As(err, &exitErr) || exitErr.code != exitExpired {
+		t.Errorf("expected watch to exit with %d once the secret expired, got %v", exitExpired, err)
+	}
+
+	err = runCommand([]string{"receipt", "secret-id", "wrong-token"}, nil, &out)
+	if err == nil || errors.As(err, &exitErr) {
+		t.Errorf("expected a wrong token to be an error, got %v", err)
+	}
+
+	err = runCommand([]string{"receipt"}, nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "Usage: yopass receipt") {
+		t.Errorf("expected a usage error, got %v", err)
+	}
+}
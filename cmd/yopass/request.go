@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/spf13/viper"
+)
+
+// pollInterval is how often "request fetch --wait" and "receipt --watch"
+// check the server.
+var pollInterval = 5 * time.Second
+
+// requestIDPattern matches request IDs, which name the files requests are
+// stored in.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// storedRequest is what the CLI keeps about a request it created. The
+// private key and management token never reach the server, so the request
+// can only be collected from the machine that created it.
+type storedRequest struct {
+	ID          string `json:"id"`
+	Token       string `json:"token"`
+	PrivateKey  string `json:"private_key"`
+	Fingerprint string `json:"fingerprint"`
+	Label       string `json:"label,omitempty"`
+	API         string `json:"api"`
+	URL         string `json:"url"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// apiClient returns a client for the configured server.
+func apiClient(api string) *yopass.Client {
+	return yopass.NewClient(api, yopass.WithToken(viper.GetString("api-token")))
+}
+
+// Retries of a single poll by "request fetch --wait" and "receipt --watch";
+// a poll that still fails with a transient error is retried at the next
+// interval until the request or secret expires.
+var (
+	pollRetries = 3
+	pollBackoff = time.Second
+)
+
+// pollClient returns a client for the configured server that retries rate
+// limiting and unavailability with backoff, for commands polling it.
+func pollClient(api string) *yopass.Client {
+	return yopass.NewClient(api,
+		yopass.WithToken(viper.GetString("api-token")),
+		yopass.WithRetries(pollRetries, pollBackoff))
+}
+
+// transientError reports whether err may go away on its own: a transport
+// error, rate limiting or a server-side failure.
+func transientError(err error) bool {
+	var se *yopass.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.StatusCode == 0 || se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+}
+
+// runCommand runs the subcommand named by the positional arguments.
+func runCommand(args []string, in *os.File, out io.Writer) error {
+	switch args[0] {
+	case "request":
+		return requestCommand(args[1:], in, out)
+	case "receipt":
+		return receiptCommand(args[1:], out)
+	default:
+		return fmt.Errorf("Unknown command %q, see --help", args[0])
+	}
+}
+
+func requestCommand(args []string, in *os.File, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Missing request command, use create, fulfill or fetch")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) != 1 {
+			return fmt.Errorf("Usage: yopass request create [--label <label>]")
+		}
+		return createRequest(out)
+	case "fulfill":
+		if len(args) != 2 {
+			return fmt.Errorf("Usage: yopass request fulfill <request URL>")
+		}
+		return fulfillRequest(args[1], in, out)
+	case "fetch":
+		if len(args) > 2 {
+			return fmt.Errorf("Usage: yopass request fetch [--wait] [<request ID or URL>]")
+		}
+		var arg string
+		if len(args) == 2 {
+			arg = args[1]
+		}
+		return fetchRequest(arg, out)
+	default:
+		return fmt.Errorf("Unknown request command %q, use create, fulfill or fetch", args[0])
+	}
+}
+
+// createRequest generates a key pair, registers its public key with the
+// server and prints the link to hand to the responder. The private key and
+// management token are stored locally for "request fetch".
+func createRequest(out io.Writer) error {
+	exp := expiration(viper.GetString("expiration"))
+	if exp == 0 {
+		return fmt.Errorf("Invalid expiration %q, use a duration such as 15m, 1h, 3d or 1w", viper.GetString("expiration"))
+	}
+
+	ctx := context.Background()
+	api := viper.GetString("api")
+	client := apiClient(api)
+	if config, err := client.Config(ctx); err == nil {
+		if !config.SecretRequests {
+			return fmt.Errorf("Secret requests are not enabled on this server")
+		}
+		if err := checkExpiration(exp, config); err != nil {
+			return err
+		}
+	}
+
+	key, err := yopass.GenerateRequestKey()
+	if err != nil {
+		return fmt.Errorf("Failed to generate key pair: %w", err)
+	}
+	created, err := client.CreateRequest(ctx, key.PublicKey, viper.GetString("label"), exp)
+	if err != nil {
+		return fmt.Errorf("Failed to create request: %w", err)
+	}
+
+	url := viper.GetString("url")
+	err = saveRequest(storedRequest{
+		ID:          created.ID,
+		Token:       created.Token,
+		PrivateKey:  key.PrivateKey,
+		Fingerprint: key.Fingerprint,
+		Label:       viper.GetString("label"),
+		API:         api,
+		URL:         url,
+		ExpiresAt:   created.ExpiresAt,
+	})
+	if err != nil {
+		// Without the private key the request is useless; do not leave a
+		// live link behind.
+		if rerr := client.RevokeRequest(ctx, created.ID, created.Token); rerr != nil {
+			return fmt.Errorf("Failed to store request: %w (revoking it failed too: %v)", err, rerr)
+		}
+		return fmt.Errorf("Failed to store request: %w", err)
+	}
+
+	_, err = fmt.Fprintln(out, yopass.RequestURL(url, created.ID, key.Fingerprint))
+	return err
+}
+
+// fulfillRequest encrypts stdin, or the file given with --file, to the
+// public key of the request behind link and provides it to the requester.
+func fulfillRequest(link string, in *os.File, out io.Writer) error {
+	if !strings.HasPrefix(link, viper.GetString("url")) {
+		return fmt.Errorf("Unconfigured yopass request URL, set --api and --url")
+	}
+	id, fingerprint, err := yopass.ParseRequestURL(link)
+	if err != nil {
+		return fmt.Errorf("Invalid yopass request URL: %w", err)
+	}
+
+	ctx := context.Background()
+	client := apiClient(viper.GetString("api"))
+	request, err := client.GetRequest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch request: %w", err)
+	}
+	if request.State != "pending" {
+		return fmt.Errorf("A secret has already been provided for this request")
+	}
+	// The fingerprint in the link never reaches the server, so a public key
+	// swapped on the server is detected before anything is encrypted to it.
+	if err := yopass.VerifyRequestKey(request.PublicKey, fingerprint); err != nil {
+		return fmt.Errorf("Refusing to provide the secret: %w", err)
+	}
+
+	r, filename, err := requestInput(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	msg, err := yopass.EncryptForRequest(r, request.PublicKey, filename)
+	if err != nil {
+		return fmt.Errorf("Failed to encrypt secret: %w", err)
+	}
+	kind := yopass.RequestKindText
+	if filename != "" {
+		kind = yopass.RequestKindFile
+	}
+	if err := client.FulfillRequest(ctx, id, msg, kind); err != nil {
+		return fmt.Errorf("Failed to provide secret: %w", err)
+	}
+
+	if request.Label != "" {
+		_, err = fmt.Fprintf(out, "Secret provided for %q\n", request.Label)
+	} else {
+		_, err = fmt.Fprintln(out, "Secret provided")
+	}
+	return err
+}
+
+// requestInput returns the secret to provide for a request: the single file
+// given with --file, along with its name, or stdin.
+func requestInput(in *os.File) (io.ReadCloser, string, error) {
+	if !viper.IsSet("file") {
+		info, err := in.Stat()
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to get file info: %w", err)
+		}
+		if info.Mode()&os.ModeCharDevice != 0 {
+			return nil, "", fmt.Errorf("No filename or piped input to encrypt given")
+		}
+		return io.NopCloser(in), "", nil
+	}
+
+	files := filesToShare()
+	if len(files) != 1 {
+		return nil, "", fmt.Errorf("Only a single file can be provided for a request")
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to open file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, "", fmt.Errorf("Only a single file can be provided for a request")
+	}
+	return f, info.Name(), nil
+}
+
+// fetchRequest collects and decrypts the secret provided for a stored
+// request, named by its ID or link or, when only one request is stored,
+// implied. With --wait it polls until the secret has been provided.
+func fetchRequest(arg string, out io.Writer) error {
+	stored, err := findRequest(arg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client := apiClient(stored.API)
+	if viper.GetBool("wait") {
+		if err := waitForRequest(ctx, pollClient(stored.API), stored); err != nil {
+			return err
+		}
+	}
+
+	secret, err := client.FetchRequestSecret(ctx, stored.ID, stored.Token)
+	var se *yopass.ServerError
+	switch {
+	case errors.As(err, &se) && se.StatusCode == http.StatusConflict:
+		return fmt.Errorf("No secret has been provided yet, use --wait to wait for it")
+	case yopass.IsNotFound(err):
+		removeRequest(stored.ID)
+		return fmt.Errorf("Request %s has expired or was revoked", stored.ID)
+	case err != nil:
+		return fmt.Errorf("Failed to fetch secret: %w", err)
+	}
+	// The server deleted the request when handing out the secret; the
+	// private key has no further use.
+	defer removeRequest(stored.ID)
+
+	pt, _, err := yopass.DecryptRequestSecret(strings.NewReader(secret.Message), stored.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt secret: %w", err)
+	}
+	_, err = fmt.Fprint(out, pt)
+	return err
+}
+
+// waitForRequest polls a request until a secret has been provided.
+// Transient errors are retried until the request expires.
+func waitForRequest(ctx context.Context, client *yopass.Client, stored storedRequest) error {
+	for {
+		request, err := client.GetRequest(ctx, stored.ID)
+		if yopass.IsNotFound(err) {
+			removeRequest(stored.ID)
+			return fmt.Errorf("Request %s has expired or was revoked", stored.ID)
+		}
+		if err != nil && (!transientError(err) || ctx.Err() != nil || time.Now().Unix() >= stored.ExpiresAt) {
+			return fmt.Errorf("Failed to fetch request: %w", err)
+		}
+		if err == nil && request.State != "pending" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// requestDir returns the directory requests are stored in.
+func requestDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "yopass", "requests"), nil
+}
+
+// saveRequest stores a request readable only by the current user.
+func saveRequest(r storedRequest) error {
+	if !requestIDPattern.MatchString(r.ID) {
+		return fmt.Errorf("unexpected request ID %q", r.ID)
+	}
+	dir, err := requestDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, r.ID+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadRequests returns the stored requests that have not expired yet,
+// removing expired ones.
+func loadRequests() ([]storedRequest, error) {
+	dir, err := requestDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var requests []storedRequest
+	now := time.Now().Unix()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var r storedRequest
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("invalid request file %s: %w", path, err)
+		}
+		if r.ExpiresAt != 0 && r.ExpiresAt < now {
+			removeRequest(r.ID)
+			continue
+		}
+		requests = append(requests, r)
+	}
+	return requests, nil
+}
+
+// findRequest returns the stored request named by arg, a request ID or
+// link. An empty arg selects the only stored request.
+func findRequest(arg string) (storedRequest, error) {
+	requests, err := loadRequests()
+	if err != nil {
+		return storedRequest{}, fmt.Errorf("Failed to read stored requests: %w", err)
+	}
+
+	id := arg
+	if linkID, _, err := yopass.ParseRequestURL(arg); err == nil {
+		id = linkID
+	}
+	if id == "" {
+		switch len(requests) {
+		case 0:
+			return storedRequest{}, fmt.Errorf("No stored requests, create one with yopass request create")
+		case 1:
+			return requests[0], nil
+		}
+		ids := make([]string, len(requests))
+		for i, r := range requests {
+			ids[i] = r.ID
+		}
+		return storedRequest{}, fmt.Errorf("Several requests are stored, pass one of: %s", strings.Join(ids, ", "))
+	}
+	for _, r := range requests {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return storedRequest{}, fmt.Errorf("Request %s was not created on this machine or has expired", id)
+}
+
+// removeRequest deletes a stored request and its private key.
+func removeRequest(id string) {
+	if dir, err := requestDir(); err == nil && requestIDPattern.MatchString(id) {
+		os.Remove(filepath.Join(dir, id+".json"))
+	}
+}
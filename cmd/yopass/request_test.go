@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/spf13/viper"
+)
+
+// requestServer stands in for a licensed server with secret requests: the
+// feature is license-gated, so the real handler cannot serve it in tests.
+type requestServer struct {
+	mu        sync.Mutex
+	enabled   bool
+	publicKey string
+	token     string
+	secret    string
+	kind      string
+	gets      int
+}
+
+func newRequestServer(t *testing.T) (*requestServer, *httptest.Server) {
+	rs := &requestServer{enabled: true}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		var body map[string]string
+		if r.Method == http.MethodPost && r.URL.Path != "/request" {
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /config":
+			json.NewEncoder(w).Encode(map[string]bool{"SECRET_REQUESTS": rs.enabled})
+		case "POST /request":
+			var req struct {
+				PublicKey string `json:"public_key"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			rs.publicKey, rs.token = req.PublicKey, "req-token"
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": "req-id", "token": rs.token, "expires_at": time.Now().Add(time.Hour).Unix()})
+		case "GET /request/req-id":
+			rs.gets++
+			state := "pending"
+			if rs.secret != "" {
+				state = "fulfilled"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"public_key": rs.publicKey, "label": "vpn", "state": state})
+		case "POST /request/req-id/secret":
+			rs.secret, rs.kind = body["message"], body["kind"]
+			json.NewEncoder(w).Encode(map[string]string{"message": "secret provided"})
+		case "GET /request/req-id/secret":
+			if r.Header.Get("X-Yopass-Request-Token") != rs.token {
+				http.Error(w, `{"message":"Invalid request token"}`, http.StatusUnauthorized)
+				return
+			}
+			if rs.secret == "" {
+				http.Error(w, `{"message":"No secret has been provided yet"}`, http.StatusConflict)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"message": rs.secret, "kind": rs.kind})
+		default:
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return rs, ts
+}
+
+func setupRequestTest(t *testing.T) (*requestServer, *httptest.Server) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	rs, ts := newRequestServer(t)
+	viper.Set("api", ts.URL)
+	viper.Set("url", ts.URL)
+	t.Cleanup(resetViper)
+	return rs, ts
+}
+
+func TestCLISecretRequest(t *testing.T) {
+	rs, ts := setupRequestTest(t)
+	viper.Set("label", "vpn")
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"request", "create"}, nil, &out); err != nil {
+		t.Fatalf("request create: %v", err)
+	}
+	link := strings.TrimSpace(out.String())
+	if !strings.HasPrefix(link, ts.URL+"/#/r/req-id/") {
+		t.Fatalf("expected a request link, got %q", link)
+	}
+	dir, _ := requestDir()
+	info, err := os.Stat(filepath.Join(dir, "req-id.json"))
+	if err != nil {
+		t.Fatalf("expected the request to be stored: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the stored request to be private, got %v", info.Mode().Perm())
+	}
+
+	out.Reset()
+	err = runCommand([]string{"request", "fetch"}, nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "No secret has been provided yet") {
+		t.Errorf("expected fetch to report the pending request, got %v", err)
+	}
+
+	stdin, err := tempFile("db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdin.Name())
+	defer stdin.Close()
+	if err := runCommand([]string{"request", "fulfill", link}, stdin, &out); err != nil {
+		t.Fatalf("request fulfill: %v", err)
+	}
+	if rs.kind != yopass.RequestKindText || strings.Contains(rs.secret, "db-password") {
+		t.Errorf("expected an encrypted text response, got %s %q", rs.kind, rs.secret)
+	}
+
+	out.Reset()
+	if err := runCommand([]string{"request", "fetch"}, nil, &out); err != nil {
+		t.Fatalf("request fetch: %v", err)
+	}
+	if out.String() != "db-password" {
+		t.Errorf("expected the provided secret, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "req-id.json")); !os.IsNotExist(err) {
+		t.Errorf("expected the collected request to be removed, got %v", err)
+	}
+}
+
+func TestCLISecretRequestFileAndWait(t *testing.T) {
+	rs, ts := setupRequestTest(t)
+	pollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { pollInterval = 5 * time.Second })
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"request", "create"}, nil, &out); err != nil {
+		t.Fatalf("request create: %v", err)
+	}
+	link := strings.TrimSpace(out.String())
+
+	go func() {
+		// Provide the secret once fetch has started polling.
+		for {
+			rs.mu.Lock()
+			gets := rs.gets
+			rs.mu.Unlock()
+			if gets > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		msg, err := yopass.EncryptForRequest(strings.NewReader("apiVersion: v1"), rs.publicKey, "kubeconfig")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := yopass.NewClient(ts.URL).FulfillRequest(t.Context(), "req-id", msg, yopass.RequestKindFile); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	viper.Set("wait", true)
+	out.Reset()
+	if err := runCommand([]string{"request", "fetch", link}, nil, &out); err != nil {
+		t.Fatalf("request fetch --wait: %v", err)
+	}
+	if out.String() != "apiVersion: v1" {
+		t.Errorf("expected the provided file, got %q", out.String())
+	}
+}
+
+func TestCLIRequestFulfillFile(t *testing.T) {
+	rs, _ := setupRequestTest(t)
+	var out bytes.Buffer
+	if err := runCommand([]string{"request", "create"}, nil, &out); err != nil {
+		t.Fatalf("request create: %v", err)
+	}
+	link := strings.TrimSpace(out.String())
+
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, []byte("private key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("file", []string{file})
+	if err := runCommand([]string{"request", "fulfill", link}, nil, &out); err != nil {
+		t.Fatalf("request fulfill --file: %v", err)
+	}
+	if rs.kind != yopass.RequestKindFile {
+		t.Errorf("expected a file response, got %q", rs.kind)
+	}
+	dir, _ := requestDir()
+	stored, _ := os.ReadFile(filepath.Join(dir, "req-id.json"))
+	var r storedRequest
+	json.Unmarshal(stored, &r)
+	_, filename, err := yopass.DecryptRequestSecret(strings.NewReader(rs.secret), r.PrivateKey)
+	if err != nil || filename != "id_ed25519" {
+		t.Errorf("expected the filename inside the message, got %q, %v", filename, err)
+	}
+}
+
+func TestCLIRequestFulfillSwappedKey(t *testing.T) {
+	rs, _ := setupRequestTest(t)
+	var out bytes.Buffer
+	if err := runCommand([]string{"request", "create"}, nil, &out); err != nil {
+		t.Fatalf("request create: %v", err)
+	}
+	link := strings.TrimSpace(out.String())
+
+	other, err := yopass.GenerateRequestKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.publicKey = other.PublicKey
+	stdin, err := tempFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdin.Name())
+	defer stdin.Close()
+	err = runCommand([]string{"request", "fulfill", link}, stdin, &out)
+	if !errors.Is(err, yopass.ErrFingerprintMismatch) {
+		t.Errorf("expected a swapped key to be refused, got %v", err)
+	}
+	if rs.secret != "" {
+		t.Error("expected nothing to be provided")
+	}
+}
+
+func TestCLIRequestErrors(t *testing.T) {
+	rs, _ := setupRequestTest(t)
+	var out bytes.Buffer
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"unknown"}, "Unknown command"},
+		{[]string{"request"}, "Missing request command"},
+		{[]string{"request", "list"}, "Unknown request command"},
+		{[]string{"request", "fulfill"}, "Usage: yopass request fulfill"},
+		{[]string{"request", "fulfill", "https://elsewhere.example.com/#/r/id/fp"}, "Unconfigured yopass request URL"},
+		{[]string{"request", "fetch"}, "No stored requests"},
+		{[]string{"request", "fetch", "other-id"}, "was not created on this machine"},
+	}
+	for _, tc := range tests {
+		err := runCommand(tc.args, nil, &out)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: expected an error containing %q, got %v", tc.args, tc.want, err)
+		}
+	}
+
+	rs.enabled = false
+	err := runCommand([]string{"request", "create"}, nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("expected create to fail without secret requests, got %v", err)
+	}
+}
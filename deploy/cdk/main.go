@@ -13,6 +13,7 @@ import (
 
 	"github.com/akrylysov/algnhsa"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/jhaals/yopass/pkg/server"
@@ -262,7 +263,30 @@ func (d *Dynamo) deleteItem(key string) error {
 
 // Put item in Dynamo
 func (d *Dynamo) Put(key string, secret yopass.Secret) error {
-	input := &dynamodb.PutItemInput{
+	_, err := d.svc.PutItem(d.putItemInput(key, secret))
+	return err
+}
+
+// PutIfAbsent puts the item unless a live one exists, using a conditional
+// write. Items past their TTL that DynamoDB has not removed yet are replaced.
+func (d *Dynamo) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	input := d.putItemInput(key, secret)
+	input.ConditionExpression = aws.String("attribute_not_exists(id) OR #ttl < :now")
+	input.ExpressionAttributeNames = map[string]*string{"#ttl": aws.String("ttl")}
+	input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+		":now": {N: aws.String(fmt.Sprintf("%d", time.Now().Unix()))},
+	}
+	_, err := d.svc.PutItem(input)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// putItemInput builds the request storing secret at key.
+func (d *Dynamo) putItemInput(key string, secret yopass.Secret) *dynamodb.PutItemInput {
+	return &dynamodb.PutItemInput{
 		// TABLE GENERATED NAME
 		Item: map[string]*dynamodb.AttributeValue{
 			"id": {
@@ -285,8 +309,6 @@ func (d *Dynamo) Put(key string, secret yopass.Secret) error {
 		},
 		TableName: aws.String(d.tableName),
 	}
-	_, err := d.svc.PutItem(input)
-	return err
 }
 
 // Status returns the secret without deleting it (safe for one-time secrets).
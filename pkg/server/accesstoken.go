@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"go.uber.org/zap"
+)
+
+// Machine clients that obtain JWT access tokens from the OIDC provider, for
+// example through the client-credentials grant, can present them as bearer
+// tokens instead of signing in through the browser. A verified token acts as
+// a session for everything a session cookie is accepted for.
+
+// accessTokenLeeway tolerates clock skew between the provider and this
+// server when checking exp and nbf.
+const accessTokenLeeway = 30 * time.Second
+
+// AccessTokenVerifier validates JWT access tokens issued by the OIDC
+// provider: signature against the provider's JWKS, issuer, expiry, audience
+// and scopes.
+type AccessTokenVerifier struct {
+	issuer         string
+	keySet         oidc.KeySet
+	signAlgs       []string
+	audiences      []string
+	requiredScopes []string
+	serviceClients []string
+}
+
+// NewAccessTokenVerifier returns a verifier accepting tokens from issuer,
+// signed by a key in keySet with one of signAlgs (RS256 when empty), whose
+// audience includes one of audiences and that grant all of requiredScopes.
+// Tokens without an email issued to one of serviceClients act as service
+// identities.
+func NewAccessTokenVerifier(issuer string, keySet oidc.KeySet, signAlgs, audiences, requiredScopes, serviceClients []string) *AccessTokenVerifier {
+	return &AccessTokenVerifier{
+		issuer:         issuer,
+		keySet:         keySet,
+		signAlgs:       signAlgs,
+		audiences:      audiences,
+		requiredScopes: requiredScopes,
+		serviceClients: serviceClients,
+	}
+}
+
+var (
+	// errAccessTokenScope rejects tokens lacking one of the required scopes.
+	errAccessTokenScope = errors.New("access token lacks a required scope")
+	// errAccessTokenEmailUnverified rejects tokens whose email the provider
+	// reports as unverified.
+	errAccessTokenEmailUnverified = errors.New("access token email is not verified")
+)
+
+// Verify validates token and returns its claims.
+func (v *AccessTokenVerifier) Verify(ctx context.Context, token string) (*oidc.AccessTokenClaims, error) {
+	claims := new(oidc.AccessTokenClaims)
+	payload, err := oidc.ParseToken(token, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := oidc.CheckIssuer(claims, v.issuer); err != nil {
+		return nil, err
+	}
+	if err := oidc.CheckSignature(ctx, token, payload, claims, v.signAlgs, v.keySet); err != nil {
+		return nil, err
+	}
+	if err := oidc.CheckExpiration(claims, -accessTokenLeeway); err != nil {
+		return nil, err
+	}
+	if nbf := claims.NotBefore.AsTime(); !nbf.IsZero() && time.Now().Add(accessTokenLeeway).Before(nbf) {
+		return nil, errors.New("access token is not valid yet")
+	}
+	if err := oidc.CheckSubject(claims); err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(v.audiences, func(a string) bool { return slices.Contains(claims.Audience, a) }) {
+		return nil, fmt.Errorf("%w: expected one of %v", oidc.ErrAudience, v.audiences)
+	}
+	granted := accessTokenScopes(claims)
+	for _, s := range v.requiredScopes {
+		if !slices.Contains(granted, s) {
+			return nil, fmt.Errorf("%w: %s", errAccessTokenScope, s)
+		}
+	}
+	if email, _ := claims.Claims["email"].(string); email != "" && !accessTokenEmailVerified(claims) {
+		return nil, errAccessTokenEmailUnverified
+	}
+	return claims, nil
+}
+
+// accessTokenEmailVerified reports whether the email_verified claim allows
+// trusting the token's email. Providers that omit the claim are trusted, as
+// for browser sign-in; some send it as a string.
+func accessTokenEmailVerified(claims *oidc.AccessTokenClaims) bool {
+	switch v := claims.Claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v != "false"
+	default:
+		return true
+	}
+}
+
+// accessTokenClient returns the OAuth client a token was issued to and
+// whether it is a service client: one whose tokens identify the client
+// itself, because the subject is the client or it is listed in
+// serviceClients.
+func (v *AccessTokenVerifier) accessTokenClient(claims *oidc.AccessTokenClaims) (string, bool) {
+	client := claims.ClientID
+	if client == "" {
+		client = claims.AuthorizedParty
+	}
+	if client == "" {
+		return claims.Subject, false
+	}
+	return client, claims.Subject == client || slices.Contains(v.serviceClients, client)
+}
+
+// accessTokenScopes returns the scopes granted by a token: the standard
+// space-delimited scope claim, or the scp claim some providers use instead.
+func accessTokenScopes(claims *oidc.AccessTokenClaims) []string {
+	if len(claims.Scopes) > 0 {
+		return claims.Scopes
+	}
+	scp := claimStrings(claims.Claims["scp"])
+	if len(scp) == 1 {
+		return strings.Fields(scp[0])
+	}
+	return scp
+}
+
+// looksLikeJWT reports whether a bearer credential has the three segments
+// of a JWT, so opaque API tokens are never parsed as one.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// accessTokenSession returns the session for a request carrying a valid JWT
+// access token. It returns nil without an error when the request carries no
+// JWT or access tokens are not accepted.
+func (y *Server) accessTokenSession(r *http.Request) (*sessionData, error) {
+	if y.AccessTokens == nil {
+		return nil, nil
+	}
+	token := bearerToken(r)
+	if !looksLikeJWT(token) {
+		return nil, nil
+	}
+	claims, err := y.AccessTokens.Verify(r.Context(), token)
+	if err != nil {
+		y.Logger.Debug("Rejected bearer access token", zap.Error(err))
+		return nil, err
+	}
+	return y.accessTokenClaimsSession(claims), nil
+}
+
+// accessTokenClaimsSession maps verified claims onto a session. Tokens
+// without an email from a service client, as issued through the
+// client-credentials grant, become service identities named after their
+// client. Other tokens without an email have no identity the
+// allowed-domains restriction could admit.
+func (y *Server) accessTokenClaimsSession(claims *oidc.AccessTokenClaims) *sessionData {
+	client, service := y.AccessTokens.accessTokenClient(claims)
+	s := &sessionData{
+		Sub:    claims.Subject,
+		Groups: claimStrings(claims.Claims[y.oidcGroupsClaim()]),
+	}
+	s.Email, _ = claims.Claims["email"].(string)
+	s.Name, _ = claims.Claims["name"].(string)
+	if s.Email == "" && service {
+		s.Email = "service:" + client
+		s.Service = true
+	}
+	if s.Name == "" {
+		s.Name = client
+	}
+	if len(s.Groups) > maxSessionGroups {
+		s.Groups = s.Groups[:maxSessionGroups]
+	}
+	return s
+}
+
+// sessionAllowed applies the allowed-domains restriction to a session.
+// Service identities have no email domain and are not restricted by it.
+func (y *Server) sessionAllowed(s *sessionData) bool {
+	return s.Service || y.emailAllowed(s.Email)
+}
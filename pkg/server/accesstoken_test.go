@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+)
+
+const testAccessTokenIssuer = "https://idp.example.com"
+
+// testJWKS stands in for the provider's JWKS endpoint and signs access
+// tokens with its key.
+type testJWKS struct {
+	signer jose.Signer
+	server *httptest.Server
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: key, KeyID: "test-key", Algorithm: string(jose.RS256), Use: "sig"}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jwk}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk.Public()}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(public)
+	}))
+	t.Cleanup(server.Close)
+	return &testJWKS{signer: signer, server: server}
+}
+
+// token signs claims merged over a valid set of defaults; a nil value
+// removes a default.
+func (j *testJWKS) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	c := map[string]interface{}{
+		"iss":       testAccessTokenIssuer,
+		"sub":       "svc-backup",
+		"aud":       []string{"yopass"},
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"iat":       time.Now().Unix(),
+		"client_id": "backup-job",
+		"scope":     "yopass.write other",
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := j.signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// verifier accepts the default tokens, treating backup-job as a service
+// client.
+func (j *testJWKS) verifier(scopes ...string) *AccessTokenVerifier {
+	return NewAccessTokenVerifier(testAccessTokenIssuer, rp.NewRemoteKeySet(http.DefaultClient, j.server.URL), nil, []string{"yopass", "yopass-api"}, scopes, []string{"backup-job"})
+}
+
+func TestAccessTokenVerifier(t *testing.T) {
+	jwks := newTestJWKS(t)
+	other := newTestJWKS(t)
+	v := jwks.verifier("yopass.write")
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"valid", jwks.token(t, nil), true},
+		{"second audience", jwks.token(t, map[string]interface{}{"aud": "yopass-api"}), true},
+		{"scp claim", jwks.token(t, map[string]interface{}{"scope": nil, "scp": []string{"yopass.write"}}), true},
+		{"wrong issuer", jwks.token(t, map[string]interface{}{"iss": "https://evil.example.com"}), false},
+		{"wrong audience", jwks.token(t, map[string]interface{}{"aud": "other-api"}), false},
+		{"expired", jwks.token(t, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"no expiry", jwks.token(t, map[string]interface{}{"exp": nil}), false},
+		{"not yet valid", jwks.token(t, map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), false},
+		{"missing scope", jwks.token(t, map[string]interface{}{"scope": "other"}), false},
+		{"missing subject", jwks.token(t, map[string]interface{}{"sub": nil}), false},
+		{"unknown key", other.token(t, nil), false},
+		{"tampered", jwks.token(t, nil)[:40] + "x" + jwks.token(t, nil)[41:], false},
+		{"verified email", jwks.token(t, map[string]interface{}{"email": "carol@example.com", "email_verified": true}), true},
+		{"unverified email", jwks.token(t, map[string]interface{}{"email": "carol@example.com", "email_verified": false}), false},
+		{"unverified email as string", jwks.token(t, map[string]interface{}{"email": "carol@example.com", "email_verified": "false"}), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			_, err := v.Verify(req.Context(), tc.token)
+			if (err == nil) != tc.wantOK {
+				t.Errorf("expected ok=%v, got %v", tc.wantOK, err)
+			}
+		})
+	}
+}
+
+func TestAccessTokenVerifierSigningAlgorithms(t *testing.T) {
+	jwks := newTestJWKS(t)
+	token := jwks.token(t, nil)
+	keySet := rp.NewRemoteKeySet(http.DefaultClient, jwks.server.URL)
+	audiences := []string{"yopass"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := NewAccessTokenVerifier(testAccessTokenIssuer, keySet, []string{"ES256"}, audiences, nil, nil).Verify(req.Context(), token); err == nil {
+		t.Error("expected an RS256 token to be rejected when the provider only supports ES256")
+	}
+	if _, err := NewAccessTokenVerifier(testAccessTokenIssuer, keySet, []string{"ES256", "RS256"}, audiences, nil, nil).Verify(req.Context(), token); err != nil {
+		t.Errorf("expected an RS256 token to be accepted when the provider supports it, got %v", err)
+	}
+}
+
+func TestAccessTokenRequireAuth(t *testing.T) {
+	jwks := newTestJWKS(t)
+	srv := newServerWithOIDC(t, newTestDB())
+	srv.RequireAuth = true
+	srv.AllowedEmailDomains = []string{"example.com"}
+	srv.AccessTokens = jwks.verifier()
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	create := func(token string) int {
+		return serve(handler, createSecretRequestWithAuth("Bearer "+token)).Code
+	}
+	if code := create(jwks.token(t, nil)); code != http.StatusOK {
+		t.Fatalf("client-credentials token: expected 200, got %d", code)
+	}
+	last := audit.events[len(audit.events)-1]
+	if last.UserSubject != "svc-backup" || last.UserEmail != "service:backup-job" {
+		t.Errorf("expected the client to be audited, got %+v", last)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"email": "carol@example.com"})); code != http.StatusOK {
+		t.Errorf("user token: expected 200, got %d", code)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"email": "mallory@other.org"})); code != http.StatusForbidden {
+		t.Errorf("user token outside the allowed domains: expected 403, got %d", code)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"sub": "deploy-job", "client_id": "deploy-job"})); code != http.StatusOK {
+		t.Errorf("token whose subject is its client: expected 200, got %d", code)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"sub": "mallory", "client_id": "web-app"})); code != http.StatusForbidden {
+		t.Errorf("user token without an email: expected 403, got %d", code)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"email": "mallory@example.com", "email_verified": false})); code != http.StatusUnauthorized {
+		t.Errorf("token with an unverified email: expected 401, got %d", code)
+	}
+	if code := create(jwks.token(t, map[string]interface{}{"aud": "other-api"})); code != http.StatusUnauthorized {
+		t.Errorf("invalid token: expected 401, got %d", code)
+	}
+}
+
+func TestAccessTokenRetrievesRestrictedSecret(t *testing.T) {
+	jwks := newTestJWKS(t)
+	srv := newServerWithOIDC(t, newTestDB())
+	srv.AccessTokens = jwks.verifier()
+	handler := srv.HTTPHandler()
+
+	id := createAs(t, &srv, handler, "alice", map[string]interface{}{"allowed_groups": []string{"backup"}})
+	get := func(token string) int {
+		req := httptest.NewRequest("GET", "/secret/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(handler, req).Code
+	}
+	if code := get(jwks.token(t, map[string]interface{}{"groups": []string{"dev"}})); code != http.StatusForbidden {
+		t.Errorf("token outside the allowed groups: expected 403, got %d", code)
+	}
+	if code := get(jwks.token(t, map[string]interface{}{"groups": []string{"backup"}})); code != http.StatusOK {
+		t.Errorf("token in an allowed group: expected 200, got %d", code)
+	}
+}
+
+func TestAccessTokensDisabled(t *testing.T) {
+	jwks := newTestJWKS(t)
+	srv := newServerWithOIDC(t, newTestDB())
+	srv.RequireAuth = true
+	handler := srv.HTTPHandler()
+	rr := serve(handler, createSecretRequestWithAuth("Bearer "+jwks.token(t, nil)))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without AccessTokens, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "authentication required") {
+		t.Errorf("unexpected body %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// The admin API under /admin lets operators revoke secrets, purge a user's
+// secrets and switch ReadOnly and DisableUpload without a redeploy. It is
+// open to signed-in users in one of the AdminGroups (read from the OIDC
+// groups claim) and to callers presenting one of the AdminAPITokens or a
+// managed API token with the admin scope.
+
+// adminTogglesKey stores the runtime toggle overrides. The record never
+// expires so an override survives restarts until an operator clears it.
+const adminTogglesKey = "admin/toggles"
+
+// adminTogglesRefresh is how long an instance serves its cached toggles
+// before reading them again, bounding how long other instances sharing the
+// database take to follow a change.
+const adminTogglesRefresh = 5 * time.Second
+
+// runtimeToggles overrides the ReadOnly and DisableUpload settings; a nil
+// field follows the configured value.
+type runtimeToggles struct {
+	ReadOnly      *bool `json:"read_only"`
+	DisableUpload *bool `json:"disable_upload"`
+}
+
+// toggleCache holds the last toggles read from the database. The lock only
+// guards the fields: the database is read outside it by one caller at a
+// time while the others keep serving the cached toggles.
+type toggleCache struct {
+	mu         sync.Mutex
+	toggles    runtimeToggles
+	loadedAt   time.Time
+	refreshing bool
+	// version counts local writes so a refresh that read the database
+	// before one does not replace it with the older toggles.
+	version uint64
+}
+
+// set applies toggles this instance just stored.
+func (c *toggleCache) set(t runtimeToggles) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.toggles, c.loadedAt = t, time.Now()
+	c.version++
+}
+
+// adminEnabled reports whether the admin API is configured. Like
+// oidcEnabled it is not gated on the license expiring at runtime: operators
+// must keep the ability to revoke a leaked secret.
+func (y *Server) adminEnabled() bool {
+	return len(y.AdminAPITokens) > 0 || (y.oidcEnabled() && len(y.AdminGroups) > 0)
+}
+
+// runtimeToggles returns the current toggle overrides. Only instances with
+// the admin API read them.
+func (y *Server) runtimeToggles() runtimeToggles {
+	c := y.toggles
+	if c == nil {
+		return runtimeToggles{}
+	}
+	c.mu.Lock()
+	if c.refreshing || time.Since(c.loadedAt) < adminTogglesRefresh {
+		defer c.mu.Unlock()
+		return c.toggles
+	}
+	c.refreshing = true
+	version := c.version
+	c.mu.Unlock()
+
+	t, ok := y.loadRuntimeToggles()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if c.version != version {
+		return c.toggles
+	}
+	// Backends report a missing record with different errors, so any failed
+	// read keeps the previous toggles until the next refresh; before the
+	// first override is stored those are the configured values.
+	c.loadedAt = time.Now()
+	if ok {
+		c.toggles = t
+	}
+	return c.toggles
+}
+
+// loadRuntimeToggles reads the stored toggle overrides.
+func (y *Server) loadRuntimeToggles() (runtimeToggles, bool) {
+	var t runtimeToggles
+	s, err := y.DB.Status(adminTogglesKey)
+	if err != nil {
+		y.Logger.Debug("Unable to read admin toggles", zap.Error(err))
+		return t, false
+	}
+	if err := json.Unmarshal([]byte(s.Message), &t); err != nil {
+		y.Logger.Error("Unable to decode admin toggles", zap.Error(err))
+		return t, false
+	}
+	return t, true
+}
+
+// readOnly reports whether write endpoints are disabled, honoring a runtime
+// override of ReadOnly.
+func (y *Server) readOnly() bool {
+	if t := y.runtimeToggles(); t.ReadOnly != nil {
+		return *t.ReadOnly
+	}
+	return y.ReadOnly
+}
+
+// uploadDisabled reports whether file endpoints are disabled, honoring a
+// runtime override of DisableUpload.
+func (y *Server) uploadDisabled() bool {
+	if t := y.runtimeToggles(); t.DisableUpload != nil {
+		return *t.DisableUpload
+	}
+	return y.DisableUpload
+}
+
+// Route matchers for the endpoints the toggles switch off. A route that does
+// not match falls through to the static file server, so a disabled endpoint
+// answers 404 exactly as if it were not registered.
+
+func (y *Server) whenWritable(*http.Request, *mux.RouteMatch) bool {
+	return !y.readOnly()
+}
+
+func (y *Server) whenUploads(*http.Request, *mux.RouteMatch) bool {
+	return !y.uploadDisabled()
+}
+
+func (y *Server) whenFileWritable(*http.Request, *mux.RouteMatch) bool {
+	return !y.readOnly() && !y.uploadDisabled()
+}
+
+// adminSession returns the session of an admin API caller: a synthetic one
+// for a token with ScopeAdmin, or the signed-in user when they belong to one
+// of the AdminGroups. It writes the error response and audit event itself.
+func (y *Server) adminSession(w http.ResponseWriter, r *http.Request) (*sessionData, bool) {
+	if s := y.tokenSession(r); s != nil && s.hasScope(ScopeAdmin) {
+		return s, true
+	}
+	s, err := y.getSession(r)
+	if err != nil || s == nil {
+		y.newAuditor("admin.access", y.getRealClientIP(r), nil).denied("authentication required")
+		jsonError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+	audit := y.newAuditor("admin.access", y.getRealClientIP(r), s)
+	if !y.sessionAllowed(s) {
+		audit.denied("email domain not permitted")
+		jsonError(w, http.StatusForbidden, "email domain not permitted")
+		return nil, false
+	}
+	for _, g := range s.Groups {
+		for _, admin := range y.AdminGroups {
+			if g == admin {
+				return s, true
+			}
+		}
+	}
+	audit.denied("not an admin")
+	jsonError(w, http.StatusForbidden, "admin role required")
+	return nil, false
+}
+
+// requireAdminMiddleware only passes admin API callers, carrying their
+// session on the request context.
+func (y *Server) requireAdminMiddleware(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		s, ok := y.adminSession(w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withSession(r.Context(), s)))
+	})
+}
+
+// adminStatus reports the license, the health of the database and the
+// effective toggles.
+func (y *Server) adminStatus(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.status_checked", y.getRealClientIP(request), session)
+
+	license := map[string]interface{}{
+		"valid":   y.License.CurrentlyValid(),
+		"expired": y.License.Expired(),
+	}
+	if y.License.Valid {
+		license["licensee"] = y.License.Licensee
+		license["expires_at"] = y.License.ExpiresAt.Unix()
+		license["days_until_expiry"] = int(y.License.DaysUntilExpiry())
+	}
+
+	// Unlike /ready, operators see the error details.
+	database := map[string]interface{}{"status": "ok"}
+	nodeErrs, dbErr := y.databaseHealth()
+	var degraded *DegradedError
+	if errors.As(dbErr, &degraded) {
+		database["status"] = "degraded"
+		database["error"] = degraded.Reason
+	} else if dbErr != nil {
+		database["status"] = "unavailable"
+		database["error"] = dbErr.Error()
+	}
+	if len(nodeErrs) > 0 {
+		nodes := map[string]string{}
+		for addr, err := range nodeErrs {
+			nodes[addr] = "ok"
+			if err != nil {
+				nodes[addr] = err.Error()
+			}
+		}
+		database["nodes"] = nodes
+	}
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version":  y.Version,
+		"license":  license,
+		"database": database,
+		"toggles":  y.togglesResponse(),
+	})
+}
+
+// togglesResponse describes the effective toggles and their overrides.
+func (y *Server) togglesResponse() map[string]interface{} {
+	t := y.runtimeToggles()
+	return map[string]interface{}{
+		"read_only":      y.readOnly(),
+		"disable_upload": y.uploadDisabled(),
+		"overrides":      t,
+	}
+}
+
+// putAdminToggles replaces the toggle overrides. A null or missing field
+// clears its override so the configured value applies again.
+func (y *Server) putAdminToggles(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.toggles_updated", y.getRealClientIP(request), session)
+
+	var t runtimeToggles
+	if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 4096)).Decode(&t); err != nil {
+		audit.failure("unable to parse json")
+		jsonError(w, http.StatusBadRequest, "Unable to parse json")
+		return
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		audit.failure("unable to encode toggles")
+		jsonError(w, http.StatusInternalServerError, "Failed to store toggles")
+		return
+	}
+	if err := y.DB.Put(adminTogglesKey, yopass.Secret{Message: string(data)}); err != nil {
+		y.Logger.Error("Failed to store admin toggles", zap.Error(err))
+		audit.failure("database error")
+		jsonError(w, http.StatusInternalServerError, "Failed to store toggles")
+		return
+	}
+	// This instance applies the change at once; others within
+	// adminTogglesRefresh.
+	y.toggles.set(t)
+
+	y.Logger.Info("Admin toggles updated",
+		zap.String("user", sessionEmail(session)),
+		zap.Boolp("read_only", t.ReadOnly),
+		zap.Boolp("disable_upload", t.DisableUpload))
+	audit.success()
+	y.writeJSON(w, http.StatusOK, y.togglesResponse())
+}
+
+// adminRevokeSecret deletes the secret, file or request with the given ID,
+// auditing the deletion under its usual event as well, and marks it revoked
+// in its creator's index.
+func (y *Server) adminRevokeSecret(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.secret_revoked", y.getRealClientIP(request), session)
+	id := mux.Vars(request)["key"]
+	audit.setSecretID(id)
+
+	e := creatorEntry{ID: id}
+	switch {
+	case y.exists(id):
+		e.Kind = WebhookKindSecret
+	case y.exists(streamKeyPrefix + id):
+		e.Kind = WebhookKindFile
+	case y.exists(requestKeyPrefix + id):
+		e.Kind = WebhookKindRequest
+	default:
+		audit.failure("not found")
+		jsonError(w, http.StatusNotFound, "Secret not found")
+		return
+	}
+
+	// The management record names the creator index; read it before the
+	// revocation deletes it.
+	m, _ := y.loadManagement(id)
+	if !y.revokeCreatorEntry(request, e, session) {
+		audit.failure("not found")
+		jsonError(w, http.StatusNotFound, "Secret not found")
+		return
+	}
+	if m.Owner != "" {
+		y.markCreatorEntriesRevoked(m.Owner, []string{id})
+	}
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]string{"id": id, "kind": e.Kind})
+}
+
+func (y *Server) exists(key string) bool {
+	_, err := y.DB.Status(key)
+	return err == nil
+}
+
+// adminPurgeUser revokes everything listed in a user's creator index, which
+// only covers what they created while signed in.
+func (y *Server) adminPurgeUser(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.user_purged", y.getRealClientIP(request), session)
+
+	var body struct {
+		Subject string `json:"subject"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 4096)).Decode(&body); err != nil {
+		audit.failure("unable to parse json")
+		jsonError(w, http.StatusBadRequest, "Unable to parse json")
+		return
+	}
+	if body.Subject = strings.TrimSpace(body.Subject); body.Subject == "" {
+		audit.failure("missing subject")
+		jsonError(w, http.StatusBadRequest, "A subject is required")
+		return
+	}
+	audit.setTargetSubject(body.Subject)
+
+	owner := creatorOwner(&sessionData{Sub: body.Subject})
+	now := time.Now().Unix()
+	revoked := []string{}
+	for _, e := range y.loadCreatorIndex(owner).Entries {
+		if !e.Revoked && now < e.ExpiresAt && y.revokeCreatorEntry(request, e, session) {
+			revoked = append(revoked, e.ID)
+		}
+	}
+	y.markCreatorEntriesRevoked(owner, revoked)
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]interface{}{"subject": body.Subject, "revoked": revoked})
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const adminTestToken = "0123456789abcdef0123456789abcdef"
+
+func newAdminTestServer(t *testing.T, db Database) Server {
+	t.Helper()
+	srv := newServerWithOIDC(t, db)
+	srv.AdminGroups = []string{"ops"}
+	tokens, err := ParseAPITokens([]string{"deploy:" + adminTestToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.AdminAPITokens = tokens
+	return srv
+}
+
+// adminRequest sends an admin API request authenticated with the admin token.
+func adminRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+adminTestToken)
+	return serve(handler, req)
+}
+
+func TestAdminAccess(t *testing.T) {
+	srv := newAdminTestServer(t, newTestDB())
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	if rr := recipientRequest(t, &srv, handler, "GET", "/admin/status", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("without session: expected 401, got %d", rr.Code)
+	}
+	if rr := recipientRequest(t, &srv, handler, "GET", "/admin/status", "dev@example.com", "dev"); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin: expected 403, got %d", rr.Code)
+	}
+	last := audit.events[len(audit.events)-1]
+	if last.Event != "admin.access" || last.Outcome != OutcomeDenied || last.UserEmail != "dev@example.com" {
+		t.Errorf("expected a denied admin.access event, got %+v", last)
+	}
+
+	// Regular API tokens do not grant admin access.
+	srv.APITokens, _ = ParseAPITokens([]string{"ci:" + strings.Repeat("b", 32)})
+	req := httptest.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer "+strings.Repeat("b", 32))
+	if rr := serve(handler, req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("API token: expected 401, got %d", rr.Code)
+	}
+
+	if rr := recipientRequest(t, &srv, handler, "GET", "/admin/status", "carol@example.com", "dev", "ops"); rr.Code != http.StatusOK {
+		t.Errorf("admin group member: expected 200, got %d", rr.Code)
+	}
+	rr := adminRequest(handler, "GET", "/admin/status", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin token: expected 200, got %d", rr.Code)
+	}
+	last = audit.events[len(audit.events)-1]
+	if last.Event != "admin.status_checked" || last.UserEmail != "service:deploy" {
+		t.Errorf("expected admin.status_checked by the token, got %+v", last)
+	}
+
+	var status struct {
+		License struct {
+			Valid bool `json:"valid"`
+		} `json:"license"`
+		Database struct {
+			Status string `json:"status"`
+		} `json:"database"`
+		Toggles struct {
+			ReadOnly bool `json:"read_only"`
+		} `json:"toggles"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.License.Valid || status.Database.Status != "ok" || status.Toggles.ReadOnly {
+		t.Errorf("unexpected status: %s", rr.Body.String())
+	}
+}
+
+func TestAdminRevokeSecret(t *testing.T) {
+	db := newTestDB()
+	srv := newAdminTestServer(t, db)
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	id := createAs(t, &srv, handler, "alice", nil)
+	rr := adminRequest(handler, "DELETE", "/admin/secrets/"+id, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"kind":"secret"`) {
+		t.Fatalf("revoke: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(id); err == nil {
+		t.Error("revoked secret should be deleted")
+	}
+	if _, err := db.Get(manageKeyPrefix + id); err == nil {
+		t.Error("revoked secret's management record should be deleted")
+	}
+	if got := listMine(t, &srv, handler, "alice")[id]; got.State != CreatorStateRevoked {
+		t.Errorf("expected the creator's entry to be revoked, got %+v", got)
+	}
+	var events []string
+	for _, e := range audit.events {
+		events = append(events, e.Event)
+	}
+	if !strings.Contains(strings.Join(events, ","), "secret.deleted,admin.secret_revoked") {
+		t.Errorf("expected the deletion and the admin action to be audited, got %v", events)
+	}
+
+	upload := serve(handler, streamUploadRequest(pgpBody("leaked"), "3600", "false", ""))
+	var created map[string]string
+	json.Unmarshal(upload.Body.Bytes(), &created)
+	if rr := adminRequest(handler, "DELETE", "/admin/secrets/"+created["message"], ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"kind":"file"`) {
+		t.Fatalf("revoke file: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(fileDataKeyPrefix + created["message"]); err == nil {
+		t.Error("revoked file data should be deleted")
+	}
+
+	if rr := adminRequest(handler, "DELETE", "/admin/secrets/"+id, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown secret: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestAdminPurgeUser(t *testing.T) {
+	db := newTestDB()
+	srv := newAdminTestServer(t, db)
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	first := createAs(t, &srv, handler, "alice", nil)
+	second := createAs(t, &srv, handler, "alice", nil)
+	bobID := createAs(t, &srv, handler, "bob", nil)
+
+	rr := adminRequest(handler, "POST", "/admin/users/purge", `{"subject": "alice"}`)
+	var resp struct {
+		Revoked []string `json:"revoked"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp.Revoked) != 2 {
+		t.Fatalf("purge: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, id := range []string{first, second} {
+		if _, err := db.Get(id); err == nil {
+			t.Errorf("secret %s should be purged", id)
+		}
+	}
+	if _, err := db.Get(bobID); err != nil {
+		t.Error("another user's secret must survive the purge")
+	}
+	last := audit.events[len(audit.events)-1]
+	if last.Event != "admin.user_purged" || last.TargetSubject != "alice" || last.UserEmail != "service:deploy" {
+		t.Errorf("unexpected audit event %+v", last)
+	}
+	for id, e := range listMine(t, &srv, handler, "alice") {
+		if e.State != CreatorStateRevoked {
+			t.Errorf("entry %s: expected revoked, got %s", id, e.State)
+		}
+	}
+
+	if rr := adminRequest(handler, "POST", "/admin/users/purge", `{"subject": " "}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing subject: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestAdminToggles(t *testing.T) {
+	db := newTestDB()
+	srv := newAdminTestServer(t, db)
+	srv.ReadOnly = true
+	handler := srv.HTTPHandler()
+
+	createBody := `{"message": "` + strings.ReplaceAll(pgpTestMessage, "\n", "\\n") + `", "expiration": 3600}`
+	create := func() int {
+		return serve(handler, httptest.NewRequest("POST", "/create/secret", strings.NewReader(createBody))).Code
+	}
+	if code := create(); code != http.StatusNotFound {
+		t.Fatalf("read-only at startup: expected 404, got %d", code)
+	}
+
+	rr := adminRequest(handler, "PUT", "/admin/toggles", `{"read_only": false, "disable_upload": true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("toggles: %d %s", rr.Code, rr.Body.String())
+	}
+	if code := create(); code != http.StatusOK {
+		t.Errorf("read-only switched off: expected 200, got %d", code)
+	}
+	if rr := serve(handler, streamUploadRequest(pgpBody("x"), "3600", "false", "")); rr.Code != http.StatusNotFound {
+		t.Errorf("uploads switched off: expected 404, got %d", rr.Code)
+	}
+	var config map[string]interface{}
+	json.Unmarshal(serve(handler, httptest.NewRequest("GET", "/config", nil)).Body.Bytes(), &config)
+	if config["READ_ONLY"] != false || config["DISABLE_UPLOAD"] != true {
+		t.Errorf("config should report the runtime toggles: %v", config)
+	}
+
+	// Another instance sharing the database follows once its cache expires.
+	other := newAdminTestServer(t, db)
+	other.ReadOnly = true
+	other.HTTPHandler()
+	if other.readOnly() || !other.uploadDisabled() {
+		t.Error("another instance should read the stored toggles")
+	}
+
+	// Clearing an override restores the configured value.
+	if rr := adminRequest(handler, "PUT", "/admin/toggles", `{"read_only": null}`); rr.Code != http.StatusOK {
+		t.Fatalf("clear toggles: %d %s", rr.Code, rr.Body.String())
+	}
+	if code := create(); code != http.StatusNotFound {
+		t.Errorf("override cleared: expected 404, got %d", code)
+	}
+	other.toggles.loadedAt = time.Time{}
+	if !other.readOnly() || other.uploadDisabled() {
+		t.Error("another instance should follow the cleared toggles after a refresh")
+	}
+
+	if rr := adminRequest(handler, "PUT", "/admin/toggles", `{"read_only": "yes"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid toggles: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestAdminTogglesRefreshDoesNotBlock(t *testing.T) {
+	mem := newTestDB()
+	srv := newAdminTestServer(t, mem)
+	srv.ReadOnly = true
+	db := &stalledStatusDB{Database: mem, read: make(chan struct{}), release: make(chan struct{})}
+	srv.DB = db
+	handler := srv.HTTPHandler()
+	if rr := adminRequest(handler, "PUT", "/admin/toggles", `{"read_only": false}`); rr.Code != http.StatusOK {
+		t.Fatalf("set toggles: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// One caller refreshes from a slow database...
+	srv.toggles.mu.Lock()
+	srv.toggles.loadedAt = time.Time{}
+	srv.toggles.mu.Unlock()
+	db.arm(adminTogglesKey)
+	done := make(chan bool)
+	go func() { done <- srv.readOnly() }()
+	<-db.read
+
+	// ...while the others keep serving the cached toggles.
+	served := make(chan bool)
+	go func() { served <- srv.readOnly() }()
+	select {
+	case readOnly := <-served:
+		if readOnly {
+			t.Error("expected the cached override during the refresh")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("toggles blocked behind the refresh")
+	}
+	close(db.release)
+	if <-done {
+		t.Error("expected the stored override after the refresh")
+	}
+}
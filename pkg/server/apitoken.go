@@ -58,30 +58,100 @@ func bearerToken(r *http.Request) string {
 	return ""
 }
 
-// apiTokenSession returns a synthetic session for a request carrying a
-// valid API bearer token, or nil when no configured token matches.
-// The presented credential is hashed once and compared against digests
-// precomputed at parse time using constant-time equality, so a partial
-// match against a configured secret is not observable through timing.
-func (y *Server) apiTokenSession(r *http.Request) *sessionData {
-	if len(y.APITokens) == 0 {
-		return nil
+// API token scopes. Static --api-token tokens hold every scope but
+// ScopeAdmin, static --admin-api-token tokens only ScopeAdmin; tokens managed
+// through the admin API hold the scopes they were created with.
+const (
+	ScopeCreateSecret  = "create-secret"
+	ScopeCreateFile    = "create-file"
+	ScopeCreateRequest = "create-request"
+	ScopeReadReceipt   = "read-receipt"
+	ScopeAdmin         = "admin"
+)
+
+// apiTokenScopes lists the valid scopes.
+var apiTokenScopes = []string{ScopeCreateSecret, ScopeCreateFile, ScopeCreateRequest, ScopeReadReceipt, ScopeAdmin}
+
+// staticAPITokenScopes are the scopes of --api-token tokens.
+var staticAPITokenScopes = []string{ScopeCreateSecret, ScopeCreateFile, ScopeCreateRequest, ScopeReadReceipt}
+
+// tokenSession returns a synthetic session for a request carrying a valid
+// bearer token: a static API or admin token, or a token managed through the
+// admin API. It returns nil when no token matches.
+func (y *Server) tokenSession(r *http.Request) *sessionData {
+	if t, ok := matchAPIToken(r, y.APITokens); ok {
+		return &sessionData{
+			Sub:    "api-token:" + t.Name,
+			Email:  "service:" + t.Name,
+			Name:   t.Name,
+			Scopes: staticAPITokenScopes,
+		}
+	}
+	if t, ok := matchAPIToken(r, y.AdminAPITokens); ok {
+		return &sessionData{
+			Sub:    "admin-token:" + t.Name,
+			Email:  "service:" + t.Name,
+			Name:   t.Name,
+			Scopes: []string{ScopeAdmin},
+		}
+	}
+	if t, ok := y.matchManagedToken(r); ok {
+		return &sessionData{
+			Sub:    "api-token:" + t.Name,
+			Email:  "service:" + t.Name,
+			Name:   t.Name,
+			Scopes: t.Scopes,
+		}
+	}
+	return nil
+}
+
+// hasScope reports whether s may act within scope. Interactive sessions
+// carry no scopes and are not limited by them.
+func (s *sessionData) hasScope(scope string) bool {
+	if s.Scopes == nil {
+		return true
+	}
+	for _, sc := range s.Scopes {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope rejects token identities on the request context that lack
+// scope. It runs behind requireAuthMiddleware.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s := sessionFromContext(r.Context()); s != nil && !s.hasScope(scope) {
+			jsonError(w, http.StatusForbidden, "API token lacks the "+scope+" scope")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// matchAPIToken returns the token among tokens presented as the request's
+// bearer credential. The presented credential is hashed once and compared
+// against digests precomputed at parse time using constant-time equality,
+// so a partial match against a configured secret is not observable through
+// timing.
+func matchAPIToken(r *http.Request, tokens []APIToken) (APIToken, bool) {
+	if len(tokens) == 0 {
+		return APIToken{}, false
 	}
 	presented := bearerToken(r)
 	if presented == "" {
-		return nil
+		return APIToken{}, false
 	}
 	presentedSum := sha256.Sum256([]byte(presented))
-	for _, t := range y.APITokens {
+	for _, t := range tokens {
 		if hmac.Equal(presentedSum[:], t.digest[:]) {
-			return &sessionData{
-				Sub:   "api-token:" + t.Name,
-				Email: "service:" + t.Name,
-				Name:  t.Name,
-			}
+			return t, true
 		}
 	}
-	return nil
+	return APIToken{}, false
 }
 
 // sessionContextKey carries an already-authenticated session on the request
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// Managed API tokens are created and revoked through the admin API instead of
+// --api-token. They carry scopes, an optional expiry and the time they were
+// last used, and are only honored while the admin API is enabled.
+
+// apiTokensKey stores every managed token in one record, so listing them
+// needs no key scan on any backend. The record never expires; expired tokens
+// are dropped whenever it is rewritten.
+const apiTokensKey = "admin/api-tokens"
+
+// maxManagedTokens bounds the record like maxCreatorEntries bounds a creator
+// index.
+const maxManagedTokens = 1000
+
+// apiTokenUsageResolution is how often the last use of a token is written,
+// so busy tokens do not rewrite the record on every request.
+const apiTokenUsageResolution = time.Minute
+
+// apiTokenNamePattern restricts names to what reads well in audit logs and
+// URLs.
+var apiTokenNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// managedToken is the stored representation of a managed API token. Only
+// the hash of the token is kept.
+type managedToken struct {
+	Name       string   `json:"name"`
+	TokenHash  string   `json:"token_hash"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	CreatedBy  string   `json:"created_by,omitempty"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+}
+
+// expired reports whether the token is past its expiry; zero never expires.
+func (t managedToken) expired(now int64) bool {
+	return t.ExpiresAt != 0 && now >= t.ExpiresAt
+}
+
+type managedTokens struct {
+	Tokens []managedToken `json:"tokens"`
+}
+
+// errTokensUnchanged aborts an update of the managed tokens that has nothing
+// to change.
+var errTokensUnchanged = errors.New("api tokens unchanged")
+
+// loadManagedTokens fetches the managed tokens; a missing or unreadable
+// record holds none.
+func (y *Server) loadManagedTokens() managedTokens {
+	var ts managedTokens
+	s, err := y.DB.Status(apiTokensKey)
+	if err != nil {
+		return ts
+	}
+	if err := json.Unmarshal([]byte(s.Message), &ts); err != nil {
+		y.Logger.Error("Unable to decode API tokens", zap.Error(err))
+		return managedTokens{}
+	}
+	return ts
+}
+
+// updateManagedTokens atomically mutates the managed tokens via
+// Database.Update, starting the record atomically when it does not exist
+// yet so concurrent first tokens are all kept.
+func (y *Server) updateManagedTokens(fn func(*managedTokens) error) error {
+	apply := func(s yopass.Secret) (yopass.Secret, error) {
+		var ts managedTokens
+		if s.Message != "" {
+			if err := json.Unmarshal([]byte(s.Message), &ts); err != nil {
+				return s, err
+			}
+		}
+		if err := fn(&ts); err != nil {
+			return s, err
+		}
+		now := time.Now().Unix()
+		kept := ts.Tokens[:0]
+		for _, t := range ts.Tokens {
+			if !t.expired(now) {
+				kept = append(kept, t)
+			}
+		}
+		ts.Tokens = kept
+		data, err := json.Marshal(ts)
+		if err != nil {
+			return s, err
+		}
+		return yopass.Secret{Message: string(data)}, nil
+	}
+	return updateOrCreate(y.DB, apiTokensKey, apply)
+}
+
+// matchManagedToken returns the unexpired managed token presented as the
+// request's bearer credential and records its use.
+func (y *Server) matchManagedToken(r *http.Request) (managedToken, bool) {
+	if !y.adminEnabled() {
+		return managedToken{}, false
+	}
+	presented := bearerToken(r)
+	if presented == "" {
+		return managedToken{}, false
+	}
+	now := time.Now().Unix()
+	for _, t := range y.loadManagedTokens().Tokens {
+		if !tokenMatchesHash(presented, t.TokenHash) {
+			continue
+		}
+		if t.expired(now) {
+			return managedToken{}, false
+		}
+		if now-t.LastUsedAt >= int64(apiTokenUsageResolution/time.Second) {
+			y.touchManagedToken(t.Name, now)
+		}
+		return t, true
+	}
+	return managedToken{}, false
+}
+
+// touchManagedToken records the last use of a token. Like read receipts it
+// is best-effort: errors are logged but never fail the request.
+func (y *Server) touchManagedToken(name string, now int64) {
+	err := y.updateManagedTokens(func(ts *managedTokens) error {
+		for i := range ts.Tokens {
+			if ts.Tokens[i].Name == name {
+				ts.Tokens[i].LastUsedAt = now
+				return nil
+			}
+		}
+		return errTokensUnchanged
+	})
+	if err != nil && !errors.Is(err, errTokensUnchanged) {
+		y.Logger.Error("Unable to record API token use", zap.Error(err))
+	}
+}
+
+// staticTokenName reports whether name belongs to a token configured with
+// --api-token or --admin-api-token.
+func (y *Server) staticTokenName(name string) bool {
+	for _, tokens := range [][]APIToken{y.APITokens, y.AdminAPITokens} {
+		for _, t := range tokens {
+			if t.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeScopes validates scopes and returns them deduplicated in the
+// order of apiTokenScopes.
+func normalizeScopes(scopes []string) ([]string, bool) {
+	want := map[string]bool{}
+	for _, s := range scopes {
+		want[strings.TrimSpace(s)] = true
+	}
+	var out []string
+	for _, s := range apiTokenScopes {
+		if want[s] {
+			out = append(out, s)
+			delete(want, s)
+		}
+	}
+	return out, len(out) > 0 && len(want) == 0
+}
+
+// listAPITokens lists the static and managed tokens without their secrets.
+func (y *Server) listAPITokens(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.tokens_listed", y.getRealClientIP(request), session)
+
+	tokens := []map[string]interface{}{}
+	for _, t := range y.APITokens {
+		tokens = append(tokens, map[string]interface{}{"name": t.Name, "scopes": staticAPITokenScopes, "static": true})
+	}
+	for _, t := range y.AdminAPITokens {
+		tokens = append(tokens, map[string]interface{}{"name": t.Name, "scopes": []string{ScopeAdmin}, "static": true})
+	}
+	now := time.Now().Unix()
+	managed := y.loadManagedTokens().Tokens
+	sort.Slice(managed, func(i, j int) bool { return managed[i].CreatedAt < managed[j].CreatedAt })
+	for _, t := range managed {
+		if t.expired(now) {
+			continue
+		}
+		entry := map[string]interface{}{
+			"name":       t.Name,
+			"scopes":     t.Scopes,
+			"static":     false,
+			"created_at": t.CreatedAt,
+		}
+		if t.CreatedBy != "" {
+			entry["created_by"] = t.CreatedBy
+		}
+		if t.ExpiresAt != 0 {
+			entry["expires_at"] = t.ExpiresAt
+		}
+		if t.LastUsedAt != 0 {
+			entry["last_used_at"] = t.LastUsedAt
+		}
+		tokens = append(tokens, entry)
+	}
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": tokens})
+}
+
+// createAPIToken creates a managed token and returns it. The token is only
+// ever shown in this response.
+func (y *Server) createAPIToken(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.token_created", y.getRealClientIP(request), session)
+
+	var body struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 4096)).Decode(&body); err != nil {
+		audit.failure("unable to parse json")
+		jsonError(w, http.StatusBadRequest, "Unable to parse json")
+		return
+	}
+	if !apiTokenNamePattern.MatchString(body.Name) {
+		audit.failure("invalid name")
+		jsonError(w, http.StatusBadRequest, "Name must be 1-64 letters, digits, '.', '_' or '-'")
+		return
+	}
+	audit.setTargetSubject("api-token:" + body.Name)
+	scopes, ok := normalizeScopes(body.Scopes)
+	if !ok {
+		audit.failure("invalid scopes")
+		jsonError(w, http.StatusBadRequest, "Scopes must be one or more of "+strings.Join(apiTokenScopes, ", "))
+		return
+	}
+	if body.ExpiresIn < 0 {
+		audit.failure("invalid expiry")
+		jsonError(w, http.StatusBadRequest, "expires_in must not be negative")
+		return
+	}
+	if y.staticTokenName(body.Name) {
+		audit.failure("name taken")
+		jsonError(w, http.StatusConflict, "A token with this name already exists")
+		return
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		y.Logger.Error("Unable to generate API token", zap.Error(err))
+		audit.failure("failed to generate token")
+		jsonError(w, http.StatusInternalServerError, "Unable to generate token")
+		return
+	}
+	now := time.Now().Unix()
+	t := managedToken{
+		Name:      body.Name,
+		TokenHash: tokenHash,
+		Scopes:    scopes,
+		CreatedAt: now,
+		CreatedBy: sessionEmail(session),
+	}
+	if body.ExpiresIn > 0 {
+		t.ExpiresAt = now + body.ExpiresIn
+	}
+
+	errNameTaken := errors.New("name taken")
+	errTooMany := errors.New("too many tokens")
+	err = y.updateManagedTokens(func(ts *managedTokens) error {
+		for _, existing := range ts.Tokens {
+			if existing.Name == t.Name && !existing.expired(now) {
+				return errNameTaken
+			}
+		}
+		if len(ts.Tokens) >= maxManagedTokens {
+			return errTooMany
+		}
+		ts.Tokens = append(ts.Tokens, t)
+		return nil
+	})
+	switch {
+	case errors.Is(err, errNameTaken):
+		audit.failure("name taken")
+		jsonError(w, http.StatusConflict, "A token with this name already exists")
+		return
+	case errors.Is(err, errTooMany):
+		audit.failure("too many tokens")
+		jsonError(w, http.StatusConflict, "Too many API tokens; revoke unused ones first")
+		return
+	case err != nil:
+		y.Logger.Error("Failed to store API token", zap.Error(err))
+		audit.failure("database error")
+		jsonError(w, http.StatusInternalServerError, "Failed to store token")
+		return
+	}
+
+	y.Logger.Info("API token created",
+		zap.String("user", sessionEmail(session)),
+		zap.String("token", t.Name),
+		zap.Strings("scopes", t.Scopes))
+	audit.success()
+	resp := map[string]interface{}{
+		"name":   t.Name,
+		"token":  token,
+		"scopes": t.Scopes,
+	}
+	if t.ExpiresAt != 0 {
+		resp["expires_at"] = t.ExpiresAt
+	}
+	y.writeJSON(w, http.StatusOK, resp)
+}
+
+// revokeAPIToken deletes a managed token. Static tokens can only be removed
+// from the configuration.
+func (y *Server) revokeAPIToken(w http.ResponseWriter, request *http.Request) {
+	session := sessionFromContext(request.Context())
+	audit := y.newAuditor("admin.token_revoked", y.getRealClientIP(request), session)
+	name := mux.Vars(request)["name"]
+	audit.setTargetSubject("api-token:" + name)
+
+	if y.staticTokenName(name) {
+		audit.failure("static token")
+		jsonError(w, http.StatusBadRequest, "Static tokens are removed from the server configuration")
+		return
+	}
+	err := y.updateManagedTokens(func(ts *managedTokens) error {
+		for i, t := range ts.Tokens {
+			if t.Name == name {
+				ts.Tokens = append(ts.Tokens[:i], ts.Tokens[i+1:]...)
+				return nil
+			}
+		}
+		return errTokensUnchanged
+	})
+	if errors.Is(err, errTokensUnchanged) || errors.Is(err, ErrKeyNotFound) {
+		audit.failure("not found")
+		jsonError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		y.Logger.Error("Failed to revoke API token", zap.Error(err))
+		audit.failure("database error")
+		jsonError(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+
+	y.Logger.Info("API token revoked", zap.String("user", sessionEmail(session)), zap.String("token", name))
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]string{"name": name})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+// createManagedToken creates a token through the admin API and returns it.
+func createManagedToken(t *testing.T, handler http.Handler, body string) string {
+	t.Helper()
+	rr := adminRequest(handler, "POST", "/admin/tokens", body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create token: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("create token: %s", rr.Body.String())
+	}
+	return resp.Token
+}
+
+func TestManagedAPITokens(t *testing.T) {
+	db := newTestDB()
+	srv := newAdminTestServer(t, db)
+	srv.RequireAuth = true
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	token := createManagedToken(t, handler, `{"name": "deploy-bot", "scopes": ["create-secret", "create-secret"], "expires_in": 3600}`)
+	last := audit.events[len(audit.events)-1]
+	if last.Event != "admin.token_created" || last.TargetSubject != "api-token:deploy-bot" {
+		t.Errorf("unexpected audit event %+v", last)
+	}
+
+	if rr := serve(handler, createSecretRequestWithAuth("Bearer "+token)); rr.Code != http.StatusOK {
+		t.Fatalf("create-secret scope: expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	last = audit.events[len(audit.events)-1]
+	if last.Event != "secret.created" || last.UserSubject != "api-token:deploy-bot" {
+		t.Errorf("expected the creation to be attributed to the token, got %+v", last)
+	}
+	upload := streamUploadRequest(pgpBody("data"), "3600", "false", "")
+	upload.Header.Set("Authorization", "Bearer "+token)
+	if rr := serve(handler, upload); rr.Code != http.StatusForbidden {
+		t.Errorf("missing create-file scope: expected 403, got %d", rr.Code)
+	}
+	req := httptest.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if rr := serve(handler, req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("missing admin scope: expected 401, got %d", rr.Code)
+	}
+
+	rr := adminRequest(handler, "GET", "/admin/tokens", "")
+	var list struct {
+		Tokens []struct {
+			Name       string   `json:"name"`
+			Scopes     []string `json:"scopes"`
+			Static     bool     `json:"static"`
+			CreatedBy  string   `json:"created_by"`
+			ExpiresAt  int64    `json:"expires_at"`
+			LastUsedAt int64    `json:"last_used_at"`
+		} `json:"tokens"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Tokens) != 2 || !list.Tokens[0].Static || list.Tokens[0].Name != "deploy" {
+		t.Fatalf("expected the static admin token and the managed one, got %s", rr.Body.String())
+	}
+	got := list.Tokens[1]
+	if got.Name != "deploy-bot" || strings.Join(got.Scopes, ",") != ScopeCreateSecret || got.CreatedBy != "service:deploy" ||
+		got.ExpiresAt == 0 || got.LastUsedAt == 0 {
+		t.Errorf("unexpected managed token %+v", got)
+	}
+	if strings.Contains(rr.Body.String(), "token_hash") {
+		t.Error("the listing must not include token hashes")
+	}
+
+	if rr := adminRequest(handler, "DELETE", "/admin/tokens/deploy-bot", ""); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(handler, createSecretRequestWithAuth("Bearer "+token)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: expected 401, got %d", rr.Code)
+	}
+	if rr := adminRequest(handler, "DELETE", "/admin/tokens/deploy-bot", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown token: expected 404, got %d", rr.Code)
+	}
+	if rr := adminRequest(handler, "DELETE", "/admin/tokens/deploy", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("static token: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestManagedAPITokenValidation(t *testing.T) {
+	srv := newAdminTestServer(t, newTestDB())
+	handler := srv.HTTPHandler()
+	createManagedToken(t, handler, `{"name": "ci", "scopes": ["create-file"]}`)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"name": "ci", "scopes": ["create-file"]}`, http.StatusConflict},
+		{`{"name": "deploy", "scopes": ["create-file"]}`, http.StatusConflict},
+		{`{"name": "bad name", "scopes": ["create-file"]}`, http.StatusBadRequest},
+		{`{"name": "other", "scopes": []}`, http.StatusBadRequest},
+		{`{"name": "other", "scopes": ["everything"]}`, http.StatusBadRequest},
+		{`{"name": "other", "scopes": ["admin"], "expires_in": -1}`, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		if rr := adminRequest(handler, "POST", "/admin/tokens", tc.body); rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.body, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestManagedAPITokenAdminScopeAndExpiry(t *testing.T) {
+	db := newTestDB()
+	srv := newAdminTestServer(t, db)
+	handler := srv.HTTPHandler()
+	token := createManagedToken(t, handler, `{"name": "oncall", "scopes": ["admin"], "expires_in": 60}`)
+
+	status := func() int {
+		req := httptest.NewRequest("GET", "/admin/status", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(handler, req).Code
+	}
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("admin scope: expected 200, got %d", code)
+	}
+
+	// Backdate the token past its expiry.
+	ts := srv.loadManagedTokens()
+	ts.Tokens[0].ExpiresAt = time.Now().Unix() - 1
+	data, _ := json.Marshal(ts)
+	if err := db.Put(apiTokensKey, yopass.Secret{Message: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+	if code := status(); code != http.StatusUnauthorized {
+		t.Errorf("expired token: expected 401, got %d", code)
+	}
+}
+
+func TestManagedAPITokenReadReceipt(t *testing.T) {
+	srv := newAdminTestServer(t, newTestDB())
+	srv.RequireAuth = true
+	handler := srv.HTTPHandler()
+	token := createManagedToken(t, handler, `{"name": "monitor", "scopes": ["create-secret", "read-receipt"]}`)
+	other := createManagedToken(t, handler, `{"name": "reader", "scopes": ["read-receipt"]}`)
+
+	body := `{"message":"` + pgpTestMessage + `","expiration":3600,"one_time":true,"receipt":true}`
+	req := httptest.NewRequest("POST", "/create/secret", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := serve(handler, req)
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusOK || created["receipt_token"] == "" {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+
+	receipt := func(bearer string) int {
+		req := httptest.NewRequest("GET", "/secret/"+created["message"]+"/receipt", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		return serve(handler, req).Code
+	}
+	if code := receipt(token); code != http.StatusOK {
+		t.Errorf("creating token: expected 200, got %d", code)
+	}
+	if code := receipt(other); code != http.StatusUnauthorized {
+		t.Errorf("another token: expected 401, got %d", code)
+	}
+}
+
+func TestManagedAPITokenConcurrentFirstCreate(t *testing.T) {
+	mem := newTestDB()
+	db := &racingCreateDB{Database: mem, key: apiTokensKey}
+	// The racing creation also found no tokens and starts the record first,
+	// so this one's create loses and must fall back to Update.
+	db.race = func() {
+		data, _ := json.Marshal(managedTokens{Tokens: []managedToken{{Name: "other-bot", TokenHash: "hash", Scopes: []string{"create-secret"}}}})
+		mem.PutIfAbsent(apiTokensKey, yopass.Secret{Message: string(data)})
+	}
+	srv := newAdminTestServer(t, mem)
+	srv.DB = db
+	srv.RequireAuth = true
+	handler := srv.HTTPHandler()
+
+	token := createManagedToken(t, handler, `{"name": "deploy-bot", "scopes": ["create-secret"]}`)
+	names := map[string]bool{}
+	for _, tok := range srv.loadManagedTokens().Tokens {
+		names[tok.Name] = true
+	}
+	if !names["deploy-bot"] || !names["other-bot"] || len(names) != 2 {
+		t.Errorf("expected both tokens, got %v", names)
+	}
+	if rr := serve(handler, createSecretRequestWithAuth("Bearer "+token)); rr.Code != http.StatusOK {
+		t.Errorf("created token: expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"time"
 
+	"github.com/jhaals/yopass/pkg/yopass"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -31,6 +32,11 @@ type AuditEvent struct {
 	OneTime           *bool        `json:"one_time,omitempty"`
 	ExpirationSeconds *int32       `json:"expiration_seconds,omitempty"`
 	RequireAuth       *bool        `json:"require_auth,omitempty"`
+	MaxViews          *int32       `json:"max_views,omitempty"`
+	RemainingViews    *int32       `json:"remaining_views,omitempty"`
+	Range             string       `json:"range,omitempty"`
+	MatchedRecipient  string       `json:"matched_recipient,omitempty"`
+	TargetSubject     string       `json:"target_subject,omitempty"`
 	Error             string       `json:"error,omitempty"`
 }
 
@@ -105,6 +111,21 @@ func (a *zapAuditLogger) Log(e AuditEvent) {
 	if e.RequireAuth != nil {
 		fields = append(fields, zap.Bool("require_auth", *e.RequireAuth))
 	}
+	if e.MaxViews != nil {
+		fields = append(fields, zap.Int32("max_views", *e.MaxViews))
+	}
+	if e.RemainingViews != nil {
+		fields = append(fields, zap.Int32("remaining_views", *e.RemainingViews))
+	}
+	if e.Range != "" {
+		fields = append(fields, zap.String("range", e.Range))
+	}
+	if e.MatchedRecipient != "" {
+		fields = append(fields, zap.String("matched_recipient", e.MatchedRecipient))
+	}
+	if e.TargetSubject != "" {
+		fields = append(fields, zap.String("target_subject", e.TargetSubject))
+	}
 	if e.Error != "" {
 		fields = append(fields, zap.String("error", e.Error))
 	}
@@ -147,6 +168,14 @@ func (y *Server) newAuditor(event, clientIP string, session *sessionData) *audit
 // The raw key is never stored — only a short SHA-256 fingerprint used for correlation.
 func (a *auditor) setSecretID(id string) { a.base.SecretID = redactSecretID(id) }
 
+// setMatchedRecipient attaches the identity that satisfied a restricted
+// secret's recipient list to all subsequently logged events.
+func (a *auditor) setMatchedRecipient(match string) { a.base.MatchedRecipient = match }
+
+// setTargetSubject attaches the subject an admin action is applied to: a
+// user's OIDC subject or an API token's "api-token:<name>".
+func (a *auditor) setTargetSubject(sub string) { a.base.TargetSubject = sub }
+
 // withEvent returns a copy of the auditor that logs under a different event
 // name, for handlers that emit a secondary event (e.g. cleanup failures).
 func (a *auditor) withEvent(event string) *auditor {
@@ -161,6 +190,19 @@ type auditField func(*AuditEvent)
 func withOneTime(v bool) auditField     { return func(e *AuditEvent) { e.OneTime = &v } }
 func withExpiration(v int32) auditField { return func(e *AuditEvent) { e.ExpirationSeconds = &v } }
 func withRequireAuth(v bool) auditField { return func(e *AuditEvent) { e.RequireAuth = &v } }
+func withRange(v string) auditField     { return func(e *AuditEvent) { e.Range = v } }
+
+// withViews records the view limit and remaining views of a view-limited
+// secret; it adds nothing for other secrets.
+func withViews(s yopass.Secret) auditField {
+	return func(e *AuditEvent) {
+		if s.MaxViews > 0 {
+			remaining := s.MaxViews - s.Views
+			e.MaxViews, e.RemainingViews = &s.MaxViews, &remaining
+		}
+	}
+}
+
 func withUser(email, sub string) auditField {
 	return func(e *AuditEvent) { e.UserEmail = email; e.UserSubject = sub }
 }
@@ -60,6 +60,9 @@ func TestNewAuditLogger_File(t *testing.T) {
 		OneTime:           boolPtr(true),
 		ExpirationSeconds: int32Ptr(3600),
 		RequireAuth:       boolPtr(false),
+		MaxViews:          int32Ptr(3),
+		RemainingViews:    int32Ptr(0),
+		Range:             "bytes 0-99/1000",
 	})
 	_ = l.Sync()
 
@@ -78,6 +81,9 @@ func TestNewAuditLogger_File(t *testing.T) {
 	assert.Equal(t, true, event["one_time"])
 	assert.Equal(t, float64(3600), event["expiration_seconds"])
 	assert.Equal(t, false, event["require_auth"])
+	assert.Equal(t, float64(3), event["max_views"])
+	assert.Equal(t, float64(0), event["remaining_views"])
+	assert.Equal(t, "bytes 0-99/1000", event["range"])
 
 	// secret_id must be redacted, not the raw value.
 	secretID, _ := event["secret_id"].(string)
@@ -107,7 +113,7 @@ func TestNewAuditLogger_OptionalFieldsSuppressed(t *testing.T) {
 	var event map[string]any
 	require.NoError(t, json.Unmarshal(data, &event))
 
-	for _, k := range []string{"secret_id", "user_email", "user_subject", "one_time", "expiration_seconds", "require_auth", "error"} {
+	for _, k := range []string{"secret_id", "user_email", "user_subject", "one_time", "expiration_seconds", "require_auth", "max_views", "remaining_views", "error"} {
 		_, ok := event[k]
 		assert.Falsef(t, ok, "field %q should be omitted when unset", k)
 	}
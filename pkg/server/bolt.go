@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// Bucket names in the embedded database file. Secrets and file blobs share
+// one file so a single data directory holds all server state.
+var (
+	boltSecretsBucket = []byte("secrets")
+	boltFilesBucket   = []byte("files")
+)
+
+// NewBolt opens (creating if needed) an embedded bbolt database at path.
+// bbolt holds an exclusive file lock, so a second process pointed at the
+// same file fails here instead of corrupting it.
+func NewBolt(path string) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create database directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSecretsBucket, boltFilesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize database: %w", err)
+	}
+	return &Bolt{db: db, path: path}, nil
+}
+
+// Bolt is an embedded, file-backed Database for single-instance deployments.
+// Every write is a bbolt transaction fsynced before it returns, so the file
+// is consistent after a crash. Freed pages are reused for new writes, but the
+// file never shrinks on its own; the cleanup sweep compacts it once most of
+// it is free space, e.g. after a burst of large files expired.
+type Bolt struct {
+	// mu guards db, which compaction swaps for a freshly written file.
+	// Transactions hold it for reading.
+	mu   sync.RWMutex
+	db   *bolt.DB
+	path string
+}
+
+// Close releases the file lock.
+func (b *Bolt) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.db.Close()
+}
+
+// view runs a read-only transaction.
+func (b *Bolt) view(fn func(*bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.View(fn)
+}
+
+// update runs a read-write transaction.
+func (b *Bolt) update(fn func(*bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Update(fn)
+}
+
+// boltRecord prefixes the secret JSON with its absolute expiry as a
+// big-endian unix timestamp; zero means the entry never expires, matching
+// how the other backends treat a zero expiration.
+func boltRecord(secret yopass.Secret) ([]byte, error) {
+	data, err := secret.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	var expiresAt int64
+	if secret.Expiration > 0 {
+		expiresAt = time.Now().Unix() + int64(secret.Expiration)
+	}
+	record := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(record, uint64(expiresAt))
+	copy(record[8:], data)
+	return record, nil
+}
+
+// boltExpired reports whether a stored record's TTL has elapsed.
+func boltExpired(record []byte, now time.Time) bool {
+	if len(record) < 8 {
+		return true
+	}
+	expiresAt := int64(binary.BigEndian.Uint64(record))
+	return expiresAt != 0 && now.Unix() >= expiresAt
+}
+
+// boltLookup decodes the live secret at key within tx. Expired entries are
+// reported as ErrKeyNotFound; StartBoltCleanup removes them later.
+func boltLookup(tx *bolt.Tx, key string) (yopass.Secret, error) {
+	var s yopass.Secret
+	record := tx.Bucket(boltSecretsBucket).Get([]byte(key))
+	if record == nil || boltExpired(record, time.Now()) {
+		return s, ErrKeyNotFound
+	}
+	if err := json.Unmarshal(record[8:], &s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// Status returns secret metadata without deleting it (safe for one-time secrets).
+func (b *Bolt) Status(key string) (yopass.Secret, error) {
+	var s yopass.Secret
+	err := b.view(func(tx *bolt.Tx) error {
+		var err error
+		s, err = boltLookup(tx, key)
+		return err
+	})
+	return s, err
+}
+
+// Get key from the embedded database. One-time secrets are deleted in the
+// same write transaction that reads them.
+func (b *Bolt) Get(key string) (yopass.Secret, error) {
+	var s yopass.Secret
+	err := b.update(func(tx *bolt.Tx) error {
+		var err error
+		s, err = boltLookup(tx, key)
+		if err != nil {
+			return err
+		}
+		if s.OneTime {
+			return tx.Bucket(boltSecretsBucket).Delete([]byte(key))
+		}
+		return nil
+	})
+	return s, err
+}
+
+// Put key in the embedded database.
+func (b *Bolt) Put(key string, secret yopass.Secret) error {
+	record, err := boltRecord(secret)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSecretsBucket).Put([]byte(key), record)
+	})
+}
+
+// PutIfAbsent stores key in the embedded database unless a live entry exists.
+// The check and write share one transaction, so they cannot interleave with
+// another writer.
+func (b *Bolt) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	record, err := boltRecord(secret)
+	if err != nil {
+		return false, err
+	}
+	var stored bool
+	err = b.update(func(tx *bolt.Tx) error {
+		if _, err := boltLookup(tx, key); !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		stored = true
+		return tx.Bucket(boltSecretsBucket).Put([]byte(key), record)
+	})
+	return stored && err == nil, err
+}
+
+// Update atomically applies fn to the value at key. bbolt allows a single
+// writer at a time, so the read-modify-write needs no retry loop; an error
+// from fn rolls the transaction back.
+func (b *Bolt) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
+	return b.update(func(tx *bolt.Tx) error {
+		s, err := boltLookup(tx, key)
+		if err != nil {
+			return err
+		}
+		updated, err := fn(s)
+		if err != nil {
+			return err
+		}
+		record, err := boltRecord(updated)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltSecretsBucket).Put([]byte(key), record)
+	})
+}
+
+// Delete key from the embedded database. An expired entry is removed too but
+// reported as not found, since to callers it was already gone.
+func (b *Bolt) Delete(key string) (bool, error) {
+	var deleted bool
+	err := b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSecretsBucket)
+		record := bucket.Get([]byte(key))
+		if record == nil {
+			return nil
+		}
+		deleted = !boltExpired(record, time.Now())
+		return bucket.Delete([]byte(key))
+	})
+	return deleted, err
+}
+
+// Health checks that the database file is open and readable.
+func (b *Bolt) Health() error {
+	return b.view(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltSecretsBucket) == nil {
+			return fmt.Errorf("bolt: secrets bucket missing")
+		}
+		return nil
+	})
+}
+
+// StartBoltCleanup runs a background goroutine that periodically removes
+// expired secrets and files from the embedded database. Reads already ignore
+// expired entries; the sweep frees their pages for reuse and compacts the
+// file when it is mostly free space.
+func StartBoltCleanup(ctx context.Context, store *Bolt, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanupExpiredBolt(store, logger)
+		}
+	}
+}
+
+func cleanupExpiredBolt(store *Bolt, logger *zap.Logger) {
+	now := time.Now()
+	var secrets, files int
+	err := store.update(func(tx *bolt.Tx) error {
+		// Collect copies first: deleting while iterating a bbolt cursor skips
+		// keys, and cursor keys are only valid until the page is modified.
+		var expired [][]byte
+		c := tx.Bucket(boltSecretsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if boltExpired(v, now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := tx.Bucket(boltSecretsBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+		secrets = len(expired)
+
+		expired = nil
+		fileBucket := tx.Bucket(boltFilesBucket)
+		c = fileBucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if meta, ok := boltFileMetaIn(fileBucket.Bucket(k)); !ok || now.Unix() > meta.ExpirationUnix {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := fileBucket.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		files = len(expired)
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Bolt cleanup: failed to remove expired entries", zap.Error(err))
+		return
+	}
+	if secrets > 0 || files > 0 {
+		logger.Debug("Cleaned up expired bolt entries", zap.Int("secrets", secrets), zap.Int("files", files))
+	}
+
+	if !store.needsCompaction() {
+		return
+	}
+	before, after, err := store.compact()
+	if err != nil {
+		logger.Error("Bolt cleanup: failed to compact database", zap.Error(err))
+		return
+	}
+	logger.Info("Compacted bolt database", zap.String("before", FormatSize(before)), zap.String("after", FormatSize(after)))
+}
+
+// boltCompactMinFree is the free space below which the file is not
+// compacted: compaction rewrites the whole file, blocking every request
+// meanwhile, so it only runs when it gives back a meaningful amount of disk.
+var boltCompactMinFree int64 = 64 << 20
+
+// boltCompactTxSize bounds the size of each compaction copy transaction.
+const boltCompactTxSize = 64 << 20
+
+// needsCompaction reports whether at least half of the file, and no less than
+// boltCompactMinFree, is free pages.
+func (b *Bolt) needsCompaction() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false
+	}
+	free := int64(b.db.Stats().FreeAlloc)
+	return free >= boltCompactMinFree && free*2 >= info.Size()
+}
+
+// boltRename replaces the database file with its compacted copy.
+var boltRename = os.Rename
+
+// compact copies the live data into a fresh file, which replaces the
+// database file. The current handle stays open until the copy has been
+// validated and renamed into place, so a failed compaction leaves the
+// database as it was. It returns the file sizes before and after.
+func (b *Bolt) compact() (before, after int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	tmp := b.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not create compacted database: %w", err)
+	}
+	if err := bolt.Compact(dst, b.db, boltCompactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("could not copy database: %w", err)
+	}
+	// The copy is checked through the handle that will serve it. The handle
+	// follows the file through the rename and keeps its lock on it.
+	err = dst.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSecretsBucket, boltFilesBucket} {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("bucket %s missing", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("could not validate compacted database: %w", err)
+	}
+	compacted, err := os.Stat(tmp)
+	if err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	if err := boltRename(tmp, b.path); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("could not replace database file: %w", err)
+	}
+	old := b.db
+	b.db = dst
+	// The old file is already unlinked; closing it only releases its pages.
+	old.Close()
+	if err := syncDir(filepath.Dir(b.path)); err != nil {
+		return 0, 0, fmt.Errorf("could not sync database directory: %w", err)
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+// syncDir fsyncs a directory so a rename within it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+var _ Database = (*Bolt)(nil)
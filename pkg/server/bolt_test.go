@@ -0,0 +1,248 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestBolt(t *testing.T) *Bolt {
+	t.Helper()
+	db, err := NewBolt(filepath.Join(t.TempDir(), "data", "yopass.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// expireBoltKey rewrites the stored expiry of key into the past.
+func expireBoltKey(t *testing.T, db *Bolt, key string) {
+	t.Helper()
+	require.NoError(t, db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltSecretsBucket)
+		record := append([]byte(nil), b.Get([]byte(key))...)
+		require.NotEmpty(t, record)
+		copy(record, []byte{0, 0, 0, 0, 0, 0, 0, 1})
+		return b.Put([]byte(key), record)
+	}))
+}
+
+func TestBolt(t *testing.T) {
+	db := newTestBolt(t)
+
+	require.NoError(t, db.Put("key", yopass.Secret{Message: "foo", Expiration: 3600}))
+	s, err := db.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", s.Message)
+
+	// Not one-time: still readable.
+	_, err = db.Get("key")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put("once", yopass.Secret{Message: "bar", OneTime: true, Expiration: 3600}))
+	st, err := db.Status("once")
+	require.NoError(t, err)
+	assert.True(t, st.OneTime)
+
+	s, err = db.Get("once")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", s.Message)
+	_, err = db.Get("once")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = db.Status("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.NoError(t, db.Health())
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yopass.db")
+	db, err := NewBolt(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Put("key", yopass.Secret{Message: "foo", Expiration: 3600}))
+	require.NoError(t, db.Close())
+
+	db, err = NewBolt(path)
+	require.NoError(t, err)
+	defer db.Close()
+	s, err := db.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", s.Message)
+}
+
+func TestBoltExpiry(t *testing.T) {
+	db := newTestBolt(t)
+
+	require.NoError(t, db.Put("key", yopass.Secret{Message: "foo", Expiration: 3600}))
+	expireBoltKey(t, db, "key")
+
+	_, err := db.Get("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = db.Status("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, db.Update("key", func(s yopass.Secret) (yopass.Secret, error) { return s, nil }), ErrKeyNotFound)
+
+	deleted, err := db.Delete("key")
+	require.NoError(t, err)
+	assert.False(t, deleted, "expired entry should be reported as not found")
+
+	// Zero expiration never expires.
+	require.NoError(t, db.Put("forever", yopass.Secret{Message: "foo"}))
+	_, err = db.Get("forever")
+	assert.NoError(t, err)
+}
+
+func TestBoltDelete(t *testing.T) {
+	db := newTestBolt(t)
+
+	require.NoError(t, db.Put("key", yopass.Secret{Message: "foo", Expiration: 3600}))
+	deleted, err := db.Delete("key")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = db.Delete("key")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestBoltUpdate(t *testing.T) {
+	db := newTestBolt(t)
+
+	err := db.Update("missing", func(s yopass.Secret) (yopass.Secret, error) { return s, nil })
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, db.Put("key", yopass.Secret{Message: "", Expiration: 3600}))
+
+	// fn errors are returned unchanged and roll back the write.
+	errStop := errors.New("stop")
+	err = db.Update("key", func(s yopass.Secret) (yopass.Secret, error) {
+		s.Message = "changed"
+		return s, errStop
+	})
+	assert.Equal(t, errStop, err)
+	s, err := db.Status("key")
+	require.NoError(t, err)
+	assert.Equal(t, "", s.Message)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, db.Update("key", func(s yopass.Secret) (yopass.Secret, error) {
+				s.Message += "x"
+				return s, nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	s, err = db.Status("key")
+	require.NoError(t, err)
+	assert.Len(t, s.Message, 20)
+}
+
+func TestBoltPutIfAbsent(t *testing.T) {
+	db := newTestBolt(t)
+
+	stored, err := db.PutIfAbsent("key", yopass.Secret{Message: "first", Expiration: 3600})
+	require.NoError(t, err)
+	assert.True(t, stored)
+
+	stored, err = db.PutIfAbsent("key", yopass.Secret{Message: "second", Expiration: 3600})
+	require.NoError(t, err)
+	assert.False(t, stored)
+	s, err := db.Status("key")
+	require.NoError(t, err)
+	assert.Equal(t, "first", s.Message)
+
+	// An expired entry is already gone to readers, so it is replaced.
+	expireBoltKey(t, db, "key")
+	stored, err = db.PutIfAbsent("key", yopass.Secret{Message: "third", Expiration: 3600})
+	require.NoError(t, err)
+	assert.True(t, stored)
+	s, err = db.Status("key")
+	require.NoError(t, err)
+	assert.Equal(t, "third", s.Message)
+}
+
+func TestBoltCleanup(t *testing.T) {
+	db := newTestBolt(t)
+	files := NewBoltFileStore(db)
+
+	require.NoError(t, db.Put("live", yopass.Secret{Message: "foo", Expiration: 3600}))
+	require.NoError(t, db.Put("dead", yopass.Secret{Message: "foo", Expiration: 3600}))
+	expireBoltKey(t, db, "dead")
+	require.NoError(t, files.putMeta("live-file", boltFileMeta{ExpirationUnix: time.Now().Unix() + 3600, Complete: true}))
+	require.NoError(t, files.putMeta("dead-file", boltFileMeta{ExpirationUnix: time.Now().Unix() - 1, Complete: true}))
+
+	cleanupExpiredBolt(db, zaptest.NewLogger(t))
+
+	require.NoError(t, db.db.View(func(tx *bolt.Tx) error {
+		assert.NotNil(t, tx.Bucket(boltSecretsBucket).Get([]byte("live")))
+		assert.Nil(t, tx.Bucket(boltSecretsBucket).Get([]byte("dead")))
+		assert.NotNil(t, tx.Bucket(boltFilesBucket).Bucket([]byte("live-file")))
+		assert.Nil(t, tx.Bucket(boltFilesBucket).Bucket([]byte("dead-file")))
+		return nil
+	}))
+}
+
+func TestBoltCompact(t *testing.T) {
+	boltCompactMinFree = 1 << 20
+	t.Cleanup(func() { boltCompactMinFree = 64 << 20 })
+	db := newTestBolt(t)
+	assert.False(t, db.needsCompaction())
+
+	large := yopass.Secret{Message: strings.Repeat("x", 2<<20), Expiration: 3600}
+	for i := range 8 {
+		require.NoError(t, db.Put(fmt.Sprintf("large-%d", i), large))
+	}
+	require.NoError(t, db.Put("live", yopass.Secret{Message: "foo", Expiration: 3600}))
+	for i := range 8 {
+		_, err := db.Delete(fmt.Sprintf("large-%d", i))
+		require.NoError(t, err)
+	}
+	assert.True(t, db.needsCompaction())
+
+	before, after, err := db.compact()
+	require.NoError(t, err)
+	assert.Less(t, after, before/4)
+	info, err := os.Stat(db.path)
+	require.NoError(t, err)
+	assert.Equal(t, after, info.Size())
+	assert.False(t, db.needsCompaction())
+
+	s, err := db.Get("live")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", s.Message)
+	require.NoError(t, db.Put("new", yopass.Secret{Message: "bar", Expiration: 3600}))
+}
+
+func TestBoltCompactFailureKeepsDatabase(t *testing.T) {
+	boltCompactMinFree = 0
+	t.Cleanup(func() { boltCompactMinFree = 64 << 20 })
+	boltRename = func(string, string) error { return errors.New("disk full") }
+	t.Cleanup(func() { boltRename = os.Rename })
+	db := newTestBolt(t)
+	require.NoError(t, db.Put("live", yopass.Secret{Message: "foo", Expiration: 3600}))
+
+	_, _, err := db.compact()
+	require.Error(t, err)
+	_, err = os.Stat(db.path + ".compact")
+	assert.True(t, os.IsNotExist(err), "the compacted copy should be removed")
+
+	s, err := db.Get("live")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", s.Message)
+	require.NoError(t, db.Put("new", yopass.Secret{Message: "bar", Expiration: 3600}))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// With TwoStepRetrieval, a GET of a one-time secret or file does not consume
+// it. The GET only returns the secret's metadata and a claim nonce; the
+// content is released when the nonce is POSTed back to the same URL. Link
+// unfurlers and mail scanners only ever issue the GET, so they can no longer
+// burn a one-time secret before its recipient opens it.
+
+// claimKeyPrefix namespaces claim nonces in the database. Nonces are stored
+// by their hash, so the database never holds a usable nonce.
+const claimKeyPrefix = "claim/"
+
+// claimNonceTTL bounds how long a claim nonce can be redeemed. It only has to
+// cover the recipient confirming the prompt in the UI.
+const claimNonceTTL = 5 * time.Minute
+
+// claimNonce is the stored representation of an issued claim nonce.
+type claimNonce struct {
+	// Key is the database key of the secret the nonce was issued for.
+	Key       string `json:"key"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// requiresClaim reports whether retrieving secret takes the two-step claim
+// protocol.
+func (y *Server) requiresClaim(secret yopass.Secret) bool {
+	return y.TwoStepRetrieval && secret.OneTime
+}
+
+// issueClaim answers the first retrieval step: it stores a fresh claim nonce
+// for the secret at dbKey and responds with the secret's metadata and the
+// nonce, without consuming the secret.
+func (y *Server) issueClaim(w http.ResponseWriter, dbKey string, secret yopass.Secret, audit *auditor) {
+	nonce, nonceHash, err := generateToken()
+	if err != nil {
+		y.Logger.Error("Failed to generate claim nonce", zap.Error(err))
+		audit.failure("failed to generate claim nonce")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return
+	}
+	expiresAt := time.Now().Add(claimNonceTTL).Unix()
+	data, err := json.Marshal(claimNonce{Key: dbKey, ExpiresAt: expiresAt})
+	if err != nil {
+		y.Logger.Error("Failed to encode claim nonce", zap.Error(err))
+		audit.failure("failed to encode claim nonce")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return
+	}
+	err = y.DB.Put(claimKeyPrefix+nonceHash, yopass.Secret{
+		Message:    string(data),
+		Expiration: int32(claimNonceTTL / time.Second),
+	})
+	if err != nil {
+		y.Logger.Error("Failed to store claim nonce", zap.Error(err))
+		audit.failure("database error")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return
+	}
+
+	audit.success(withOneTime(secret.OneTime), withRequireAuth(secret.RequireAuth))
+	response := map[string]interface{}{
+		"oneTime":        secret.OneTime,
+		"requireAuth":    secret.RequireAuth,
+		"claim":          nonce,
+		"claimExpiresIn": int32(claimNonceTTL / time.Second),
+	}
+	if secret.PINHash != "" {
+		response["pinRequired"] = true
+	}
+	y.writeJSON(w, http.StatusAccepted, response)
+}
+
+// redeemClaim answers the second retrieval step: it checks and invalidates
+// the claim nonce in the request body, which must have been issued for the
+// secret at dbKey. Every nonce can be redeemed once. It writes the error
+// response and audit event itself and reports whether the caller may go on
+// to claim the secret.
+func (y *Server) redeemClaim(w http.ResponseWriter, request *http.Request, dbKey string, audit *auditor) bool {
+	var body struct {
+		Claim string `json:"claim"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 4096)).Decode(&body); err != nil {
+		audit.failure("unable to parse json")
+		jsonError(w, http.StatusBadRequest, "Unable to parse json")
+		return false
+	}
+
+	claimKey := claimKeyPrefix + hashToken(body.Claim)
+	s, err := y.DB.Status(claimKey)
+	if body.Claim == "" || err != nil {
+		audit.denied("invalid claim")
+		jsonError(w, http.StatusUnauthorized, "Invalid or expired claim")
+		return false
+	}
+	var c claimNonce
+	if err := json.Unmarshal([]byte(s.Message), &c); err != nil || c.Key != dbKey || secondsUntil(c.ExpiresAt) == 0 {
+		audit.denied("invalid claim")
+		jsonError(w, http.StatusUnauthorized, "Invalid or expired claim")
+		return false
+	}
+
+	// Deleting the nonce is what redeems it; a concurrent request presenting
+	// the same nonce loses the delete.
+	deleted, err := y.DB.Delete(claimKey)
+	if err != nil {
+		y.Logger.Error("Failed to redeem claim nonce", zap.Error(err))
+		audit.failure("failed to redeem claim")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return false
+	}
+	if !deleted {
+		audit.denied("claim already redeemed")
+		jsonError(w, http.StatusUnauthorized, "Invalid or expired claim")
+		return false
+	}
+	return true
+}
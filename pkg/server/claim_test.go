@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+// requestClaim performs the first retrieval step and returns the claim nonce.
+func requestClaim(t *testing.T, handler http.Handler, path string) string {
+	t.Helper()
+	rr := manageRequest(handler, "GET", path, "", "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("GET %s: expected 202, got %d: %s", path, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		OneTime bool   `json:"oneTime"`
+		Claim   string `json:"claim"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.OneTime || resp.Claim == "" || resp.Message != "" {
+		t.Fatalf("unexpected claim response: %s", rr.Body.String())
+	}
+	return resp.Claim
+}
+
+func claimBody(nonce string) string {
+	return `{"claim": "` + nonce + `"}`
+}
+
+func TestTwoStepRetrieval(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	y.TwoStepRetrieval = true
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": true})
+
+	// Unfurlers only GET; every GET leaves the secret in place.
+	nonce := requestClaim(t, handler, "/secret/"+id)
+	requestClaim(t, handler, "/secret/"+id)
+	if _, err := db.Status(id); err != nil {
+		t.Fatal("GET must not consume a one-time secret")
+	}
+
+	if rr := manageRequest(handler, "POST", "/secret/"+id, "", claimBody("wrong")); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong nonce: expected 401, got %d", rr.Code)
+	}
+	rr := manageRequest(handler, "POST", "/secret/"+id, "", claimBody(nonce))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("redeeming claim: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var s yopass.Secret
+	if err := json.Unmarshal(rr.Body.Bytes(), &s); err != nil || s.Message == "" {
+		t.Fatalf("expected the secret, got %s", rr.Body.String())
+	}
+	if _, err := db.Status(id); err == nil {
+		t.Error("one-time secret should be consumed by the redeeming POST")
+	}
+	if _, err := db.Status(claimKeyPrefix + hashToken(nonce)); err == nil {
+		t.Error("redeemed nonce should be deleted")
+	}
+}
+
+func TestTwoStepRetrievalRejectsNonces(t *testing.T) {
+	tests := []struct {
+		name  string
+		nonce func(t *testing.T, y *Server, handler http.Handler, id, other string) string
+	}{
+		{"missing", func(*testing.T, *Server, http.Handler, string, string) string { return "" }},
+		{"issued for another secret", func(t *testing.T, _ *Server, handler http.Handler, _, other string) string {
+			return requestClaim(t, handler, "/secret/"+other)
+		}},
+		{"expired", func(t *testing.T, y *Server, handler http.Handler, id, _ string) string {
+			nonce := requestClaim(t, handler, "/secret/"+id)
+			data, _ := json.Marshal(claimNonce{Key: id, ExpiresAt: time.Now().Unix() - 1})
+			y.DB.Put(claimKeyPrefix+hashToken(nonce), yopass.Secret{Message: string(data)})
+			return nonce
+		}},
+		{"already redeemed", func(t *testing.T, y *Server, handler http.Handler, id, _ string) string {
+			nonce := requestClaim(t, handler, "/secret/"+id)
+			y.DB.Delete(claimKeyPrefix + hashToken(nonce))
+			return nonce
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newMemoryDB()
+			y := newRequestTestServer(t, db, false)
+			y.TwoStepRetrieval = true
+			handler := y.HTTPHandler()
+			id, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": true})
+			other, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": true})
+
+			nonce := tc.nonce(t, &y, handler, id, other)
+			rr := manageRequest(handler, "POST", "/secret/"+id, "", claimBody(nonce))
+			if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "Invalid or expired claim") {
+				t.Fatalf("expected 401, got %d %s", rr.Code, rr.Body.String())
+			}
+			if _, err := db.Status(id); err != nil {
+				t.Error("secret must survive a rejected claim")
+			}
+		})
+	}
+}
+
+func TestTwoStepRetrievalOnlyForOneTimeSecrets(t *testing.T) {
+	y := newRequestTestServer(t, newMemoryDB(), false)
+	y.TwoStepRetrieval = true
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": false})
+
+	rr := manageRequest(handler, "GET", "/secret/"+id, "", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"message"`) {
+		t.Fatalf("expected the secret right away, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTwoStepRetrievalFile(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.TwoStepRetrieval = true
+	handler := srv.HTTPHandler()
+	id, _ := uploadManagedFile(t, handler, "true")
+
+	nonce := requestClaim(t, handler, "/file/"+id)
+	if _, err := db.Status(streamKeyPrefix + id); err != nil {
+		t.Fatal("GET must not consume a one-time file")
+	}
+
+	req := httptest.NewRequest("POST", "/file/"+id, strings.NewReader(claimBody(nonce)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != pgpBody("managed-file") {
+		t.Fatalf("redeeming claim: got %d %q", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Status(streamKeyPrefix + id); err == nil {
+		t.Error("one-time file should be consumed by the redeeming POST")
+	}
+}
@@ -116,14 +116,20 @@ func TestRouteContract(t *testing.T) {
 			probes: []probe{
 				{name: "POST /create/secret", method: http.MethodPost, path: "/create/secret", body: contractSecretBody(), wantStatus: 200},
 				{name: "GET /secret/{key}", method: http.MethodGet, path: "/secret/" + contractTestID, wantStatus: 200},
+				{name: "POST /secret/{key} not registered", method: http.MethodPost, path: "/secret/" + contractTestID, wantStatus: 404},
 				{name: "GET /secret/{key}/status", method: http.MethodGet, path: "/secret/" + contractTestID + "/status", wantStatus: 200},
 				{name: "DELETE /secret/{key}", method: http.MethodDelete, path: "/secret/" + contractTestID, wantStatus: 204},
+				{name: "POST /secret/{key}/extend", method: http.MethodPost, path: "/secret/" + contractTestID + "/extend", body: `{"expiration": 86400}`, wantStatus: 401},
 				fileUploadProbe(200),
+				{name: "POST /create/file/upload", method: http.MethodPost, path: "/create/file/upload", wantStatus: 200},
 				{name: "GET /file/{key}", method: http.MethodGet, path: "/file/" + contractTestID, wantStatus: 200},
 				{name: "GET /file/{key}/status", method: http.MethodGet, path: "/file/" + contractTestID + "/status", wantStatus: 200},
 				{name: "DELETE /file/{key}", method: http.MethodDelete, path: "/file/" + contractTestID, wantStatus: 204},
+				{name: "POST /file/{key}/extend", method: http.MethodPost, path: "/file/" + contractTestID + "/extend", body: `{"expiration": 86400}`, wantStatus: 401},
 				{name: "POST /request not registered", method: http.MethodPost, path: "/request", wantStatus: 404},
 				{name: "GET /auth/login not registered", method: http.MethodGet, path: "/auth/login", wantStatus: 404},
+				{name: "GET /me/secrets not registered", method: http.MethodGet, path: "/me/secrets", wantStatus: 404},
+				{name: "GET /admin/status not registered", method: http.MethodGet, path: "/admin/status", wantStatus: 404},
 				{name: "GET /config", method: http.MethodGet, path: "/config", wantStatus: 200},
 				{name: "GET /health", method: http.MethodGet, path: "/health", wantStatus: 200},
 				{name: "GET /ready", method: http.MethodGet, path: "/ready", wantStatus: 200},
@@ -136,9 +142,29 @@ func TestRouteContract(t *testing.T) {
 			probes: []probe{
 				{name: "POST /create/secret not registered", method: http.MethodPost, path: "/create/secret", body: contractSecretBody(), wantStatus: 404},
 				fileUploadProbe(404),
+				{name: "POST /create/file/upload not registered", method: http.MethodPost, path: "/create/file/upload", wantStatus: 404},
 				{name: "GET /secret/{key} still works", method: http.MethodGet, path: "/secret/" + contractTestID, wantStatus: 200},
 				{name: "GET /file/{key} still works", method: http.MethodGet, path: "/file/" + contractTestID, wantStatus: 200},
 				{name: "DELETE /secret/{key} still works", method: http.MethodDelete, path: "/secret/" + contractTestID, wantStatus: 204},
+				{name: "POST /secret/{key}/extend not registered", method: http.MethodPost, path: "/secret/" + contractTestID + "/extend", body: `{"expiration": 86400}`, wantStatus: 404},
+				{name: "POST /file/{key}/extend not registered", method: http.MethodPost, path: "/file/" + contractTestID + "/extend", body: `{"expiration": 86400}`, wantStatus: 404},
+			},
+		},
+		{
+			name:   "management token required",
+			mutate: func(y *Server) { y.RequireManageToken = true },
+			probes: []probe{
+				{name: "DELETE /secret/{key} without token", method: http.MethodDelete, path: "/secret/" + contractTestID, wantStatus: 401},
+				{name: "DELETE /file/{key} without token", method: http.MethodDelete, path: "/file/" + contractTestID, wantStatus: 401},
+				{name: "GET /secret/{key} unaffected", method: http.MethodGet, path: "/secret/" + contractTestID, wantStatus: 200},
+			},
+		},
+		{
+			name:   "two-step retrieval",
+			mutate: func(y *Server) { y.TwoStepRetrieval = true },
+			probes: []probe{
+				{name: "POST /secret/{key} registered", method: http.MethodPost, path: "/secret/" + contractTestID, wantStatus: 200},
+				{name: "POST /file/{key} registered", method: http.MethodPost, path: "/file/" + contractTestID, wantStatus: 200},
 			},
 		},
 		{
@@ -146,6 +172,7 @@ func TestRouteContract(t *testing.T) {
 			mutate: func(y *Server) { y.DisableUpload = true },
 			probes: []probe{
 				fileUploadProbe(404),
+				{name: "POST /create/file/upload not registered", method: http.MethodPost, path: "/create/file/upload", wantStatus: 404},
 				{name: "GET /file/{key} not registered", method: http.MethodGet, path: "/file/" + contractTestID, wantStatus: 404},
 				{name: "POST /create/secret still works", method: http.MethodPost, path: "/create/secret", body: contractSecretBody(), wantStatus: 200},
 			},
@@ -172,6 +199,19 @@ func TestRouteContract(t *testing.T) {
 				{name: "POST /request not registered", method: http.MethodPost, path: "/request", body: `{}`, wantStatus: 404},
 			},
 		},
+		{
+			name: "licensed with admin token",
+			mutate: func(y *Server) {
+				y.License = validLicense()
+				y.AdminAPITokens, _ = ParseAPITokens([]string{"ops:" + strings.Repeat("a", minAPITokenLength)})
+			},
+			probes: []probe{
+				{name: "GET /admin/status", method: http.MethodGet, path: "/admin/status", wantStatus: 401},
+				{name: "GET /admin/status with token", method: http.MethodGet, path: "/admin/status", headers: map[string]string{"Authorization": "Bearer " + strings.Repeat("a", minAPITokenLength)}, wantStatus: 200},
+				{name: "GET /admin/tokens", method: http.MethodGet, path: "/admin/tokens", wantStatus: 401},
+				{name: "GET /admin/tokens with token", method: http.MethodGet, path: "/admin/tokens", headers: map[string]string{"Authorization": "Bearer " + strings.Repeat("a", minAPITokenLength)}, wantStatus: 200},
+			},
+		},
 		{
 			name:   "licensed secret requests disabled",
 			mutate: func(y *Server) { y.License = validLicense(); y.DisableSecretRequests = true },
@@ -243,7 +283,9 @@ func TestConfigContractKeys(t *testing.T) {
 			"DISABLE_FEATURES",
 			"DISABLE_UPLOAD",
 			"FORCE_ONETIME_SECRETS",
+			"MAX_EXPIRATION",
 			"MAX_FILE_SIZE",
+			"MIN_EXPIRATION",
 			"NO_LANGUAGE_SWITCHER",
 			"OIDC_ENABLED",
 			"PREFETCH_SECRET",
@@ -253,6 +295,7 @@ func TestConfigContractKeys(t *testing.T) {
 			"SECRET_REQUESTS",
 			"THEME_DARK",
 			"THEME_LIGHT",
+			"TWO_STEP_RETRIEVAL",
 		}
 		got := configKeys(t, nil)
 		if fmt.Sprint(got) != fmt.Sprint(want) {
@@ -271,8 +314,10 @@ func TestConfigContractKeys(t *testing.T) {
 			"FORCE_ONETIME_SECRETS",
 			"IMPRINT_URL",
 			"LOGO_URL",
+			"MAX_EXPIRATION",
 			"MAX_FILE_SIZE",
 			"MAX_REQUEST_FILE_SIZE",
+			"MIN_EXPIRATION",
 			"NO_LANGUAGE_SWITCHER",
 			"OIDC_ENABLED",
 			"PREFETCH_SECRET",
@@ -286,6 +331,7 @@ func TestConfigContractKeys(t *testing.T) {
 			"THEME_CUSTOM_LIGHT",
 			"THEME_DARK",
 			"THEME_LIGHT",
+			"TWO_STEP_RETRIEVAL",
 		}
 		got := configKeys(t, func(y *Server) {
 			y.License = validLicense()
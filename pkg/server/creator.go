@@ -0,0 +1,368 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// creatorKeyPrefix namespaces the per-creator indexes in the database. An
+// index is keyed by the hash of the creator's OIDC subject, so subjects never
+// appear in database keys.
+const creatorKeyPrefix = "creator/"
+
+// maxCreatorEntries bounds an index so it stays well within the item size of
+// every backend (Memcached's default is 1MB); the oldest entries are dropped
+// first.
+const maxCreatorEntries = 500
+
+// creatorEntryRetention is how long an entry stays listed as expired after the
+// secret it describes has expired. The index itself expires with its last
+// entry.
+const creatorEntryRetention = 24 * time.Hour
+
+// maxLabelLength bounds the labels creators attach to secrets and requests.
+const maxLabelLength = 100
+
+// labelHeader carries the label of a file upload.
+const labelHeader = "X-Yopass-Label"
+
+// States reported for the entries of GET /me/secrets besides the receipt and
+// request states (pending, viewed, fulfilled).
+const (
+	// CreatorStateClosed marks a secret that is gone before its expiry
+	// without a receipt telling why: consumed, deleted or destroyed.
+	CreatorStateClosed  = "closed"
+	CreatorStateRevoked = "revoked"
+	CreatorStateExpired = "expired"
+)
+
+// creatorIndex lists what one authenticated creator has shared. It only
+// holds IDs and metadata; the state of each entry is looked up when the index
+// is listed.
+type creatorIndex struct {
+	Entries []creatorEntry `json:"entries"`
+}
+
+type creatorEntry struct {
+	ID string `json:"id"`
+	// Kind is one of WebhookKindSecret, WebhookKindFile and
+	// WebhookKindRequest.
+	Kind      string `json:"kind"`
+	Label     string `json:"label,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	Revoked   bool   `json:"revoked,omitempty"`
+}
+
+// creatorOwner returns the index owner for a creator's session, or "" for
+// anonymous creators, whose secrets are not indexed.
+func creatorOwner(s *sessionData) string {
+	if s == nil || s.Sub == "" {
+		return ""
+	}
+	return hashToken(s.Sub)
+}
+
+// ttl returns the lifetime of the index: until its last entry is no longer
+// retained. Zero means every entry has lapsed.
+func (ix creatorIndex) ttl() int32 {
+	var last int64
+	for _, e := range ix.Entries {
+		if e.ExpiresAt > last {
+			last = e.ExpiresAt
+		}
+	}
+	return secondsUntil(last + int64(creatorEntryRetention/time.Second))
+}
+
+// prune drops entries past their retention and, beyond maxCreatorEntries,
+// the oldest ones.
+func (ix *creatorIndex) prune() {
+	cutoff := time.Now().Unix() - int64(creatorEntryRetention/time.Second)
+	kept := ix.Entries[:0]
+	for _, e := range ix.Entries {
+		if e.ExpiresAt > cutoff {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) > maxCreatorEntries {
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].CreatedAt < kept[j].CreatedAt })
+		kept = kept[len(kept)-maxCreatorEntries:]
+	}
+	ix.Entries = kept
+}
+
+// errIndexUnchanged aborts a creator index update that has nothing to change.
+var errIndexUnchanged = errors.New("creator index unchanged")
+
+// updateCreatorIndex atomically mutates the index of owner via
+// Database.Update. When create is set a missing index is started atomically,
+// so first entries racing to start it are all kept.
+func (y *Server) updateCreatorIndex(owner string, create bool, fn func(*creatorIndex) error) error {
+	apply := func(s yopass.Secret) (yopass.Secret, error) {
+		var ix creatorIndex
+		if s.Message != "" {
+			if err := json.Unmarshal([]byte(s.Message), &ix); err != nil {
+				return s, err
+			}
+		}
+		if err := fn(&ix); err != nil {
+			return s, err
+		}
+		ix.prune()
+		// Compute the TTL once: a zero expiration means "never expire" to
+		// the backends.
+		ttl := ix.ttl()
+		if ttl == 0 {
+			return s, errIndexUnchanged
+		}
+		data, err := json.Marshal(ix)
+		if err != nil {
+			return s, err
+		}
+		return yopass.Secret{Message: string(data), Expiration: ttl}, nil
+	}
+	if create {
+		return updateOrCreate(y.DB, creatorKeyPrefix+owner, apply)
+	}
+	return y.DB.Update(creatorKeyPrefix+owner, apply)
+}
+
+// addCreatorEntry records a newly created secret, file or request in the
+// index of its creator. Like read receipts it is best-effort: errors are
+// logged but never fail the creation.
+func (y *Server) addCreatorEntry(owner string, e creatorEntry) {
+	if owner == "" {
+		return
+	}
+	err := y.updateCreatorIndex(owner, true, func(ix *creatorIndex) error {
+		ix.Entries = append(ix.Entries, e)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errIndexUnchanged) {
+		y.Logger.Error("Unable to update creator index", zap.Error(err))
+	}
+}
+
+// extendCreatorEntry moves the expiry of an indexed secret along with an
+// extension. It is best-effort like addCreatorEntry.
+func (y *Server) extendCreatorEntry(owner, id string, expiresAt int64) {
+	if owner == "" {
+		return
+	}
+	err := y.updateCreatorIndex(owner, false, func(ix *creatorIndex) error {
+		for i := range ix.Entries {
+			if ix.Entries[i].ID == id {
+				ix.Entries[i].ExpiresAt = expiresAt
+				return nil
+			}
+		}
+		return errIndexUnchanged
+	})
+	if err != nil && !errors.Is(err, errIndexUnchanged) && !errors.Is(err, ErrKeyNotFound) {
+		y.Logger.Error("Unable to extend creator index entry", zap.Error(err))
+	}
+}
+
+// loadCreatorIndex fetches the index of owner; a missing or unreadable index
+// is empty.
+func (y *Server) loadCreatorIndex(owner string) creatorIndex {
+	var ix creatorIndex
+	s, err := y.DB.Status(creatorKeyPrefix + owner)
+	if err != nil {
+		return ix
+	}
+	if err := json.Unmarshal([]byte(s.Message), &ix); err != nil {
+		y.Logger.Error("Unable to decode creator index", zap.Error(err))
+		return creatorIndex{}
+	}
+	ix.prune()
+	return ix
+}
+
+// creatorEntryState derives the current state of an indexed entry from its
+// request record, or from its read receipt and whether it still exists. It
+// also returns when a viewed secret was first opened.
+func (y *Server) creatorEntryState(e creatorEntry, now int64) (string, int64) {
+	switch {
+	case e.Revoked:
+		return CreatorStateRevoked, 0
+	case now >= e.ExpiresAt:
+		return CreatorStateExpired, 0
+	}
+	if e.Kind == WebhookKindRequest {
+		r, ok := y.loadRequest(e.ID)
+		switch {
+		case !ok:
+			return CreatorStateClosed, 0
+		case r.State == RequestStateFulfilled:
+			return RequestStateFulfilled, 0
+		}
+		return RequestStatePending, 0
+	}
+	if r, ok := y.loadReceipt(e.ID); ok && r.State == ReceiptStateViewed {
+		return ReceiptStateViewed, r.ViewedAt
+	}
+	key := e.ID
+	if e.Kind == WebhookKindFile {
+		key = streamKeyPrefix + e.ID
+	}
+	if _, err := y.DB.Status(key); err != nil {
+		return CreatorStateClosed, 0
+	}
+	return ReceiptStatePending, 0
+}
+
+// listMySecrets returns what the signed-in user has created, newest first,
+// with each entry's current state.
+func (y *Server) listMySecrets(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	session, _ := y.getSession(request)
+	audit := y.newAuditor("me.secrets_listed", y.getRealClientIP(request), session)
+
+	ix := y.loadCreatorIndex(creatorOwner(session))
+	sort.SliceStable(ix.Entries, func(i, j int) bool { return ix.Entries[i].CreatedAt > ix.Entries[j].CreatedAt })
+	now := time.Now().Unix()
+	secrets := make([]map[string]interface{}, 0, len(ix.Entries))
+	for _, e := range ix.Entries {
+		state, viewedAt := y.creatorEntryState(e, now)
+		entry := map[string]interface{}{
+			"id":         e.ID,
+			"kind":       e.Kind,
+			"state":      state,
+			"created_at": e.CreatedAt,
+			"expires_at": e.ExpiresAt,
+		}
+		if e.Label != "" {
+			entry["label"] = e.Label
+		}
+		if viewedAt != 0 {
+			entry["viewed_at"] = viewedAt
+		}
+		secrets = append(secrets, entry)
+	}
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string]interface{}{"secrets": secrets})
+}
+
+// revokeMySecrets deletes several of the signed-in user's secrets, files and
+// requests at once. IDs that are not in the user's index, or already gone,
+// are reported as not found; the user can never revoke someone else's
+// secret this way.
+func (y *Server) revokeMySecrets(w http.ResponseWriter, request *http.Request) {
+	session, _ := y.getSession(request)
+	audit := y.newAuditor("me.secrets_revoked", y.getRealClientIP(request), session)
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 64*1024)).Decode(&body); err != nil {
+		audit.failure("unable to parse json")
+		jsonError(w, http.StatusBadRequest, "Unable to parse json")
+		return
+	}
+	if len(body.IDs) == 0 || len(body.IDs) > maxCreatorEntries {
+		audit.failure("invalid ids")
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d ids are required", maxCreatorEntries))
+		return
+	}
+
+	owner := creatorOwner(session)
+	entries := make(map[string]creatorEntry)
+	now := time.Now().Unix()
+	for _, e := range y.loadCreatorIndex(owner).Entries {
+		if !e.Revoked && now < e.ExpiresAt {
+			entries[e.ID] = e
+		}
+	}
+
+	revoked, notFound := []string{}, []string{}
+	for _, id := range body.IDs {
+		e, ok := entries[id]
+		if ok && y.revokeCreatorEntry(request, e, session) {
+			revoked = append(revoked, id)
+			delete(entries, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+
+	y.markCreatorEntriesRevoked(owner, revoked)
+
+	audit.success()
+	y.writeJSON(w, http.StatusOK, map[string][]string{"revoked": revoked, "not_found": notFound})
+}
+
+// markCreatorEntriesRevoked flags the entries with the given ids in the
+// index of owner as revoked.
+func (y *Server) markCreatorEntriesRevoked(owner string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	done := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		done[id] = true
+	}
+	err := y.updateCreatorIndex(owner, false, func(ix *creatorIndex) error {
+		for i := range ix.Entries {
+			if done[ix.Entries[i].ID] {
+				ix.Entries[i].Revoked = true
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errIndexUnchanged) && !errors.Is(err, ErrKeyNotFound) {
+		y.Logger.Error("Unable to mark revoked creator index entries", zap.Error(err))
+	}
+}
+
+// revokeCreatorEntry deletes one indexed secret, file or request the way its
+// DELETE endpoint does, auditing it under that endpoint's event. It reports
+// whether anything was deleted.
+func (y *Server) revokeCreatorEntry(request *http.Request, e creatorEntry, session *sessionData) bool {
+	event, key := "secret.deleted", e.ID
+	switch e.Kind {
+	case WebhookKindFile:
+		event, key = "file.deleted", streamKeyPrefix+e.ID
+	case WebhookKindRequest:
+		event, key = "request.revoked", requestKeyPrefix+e.ID
+	}
+	audit := y.newAuditor(event, y.getRealClientIP(request), session)
+	audit.setSecretID(e.ID)
+
+	deleted, err := y.DB.Delete(key)
+	if err != nil {
+		y.Logger.Error("Failed to revoke secret", zap.Error(err))
+		audit.failure("database error")
+		return false
+	}
+	if !deleted {
+		audit.failure("not found")
+		return false
+	}
+
+	if e.Kind == WebhookKindRequest {
+		audit.success()
+		y.webhookRequestClosed(e.ID)
+		return true
+	}
+	// With its metadata gone the file can no longer be downloaded; a blob
+	// that fails to delete is left to expire.
+	if e.Kind == WebhookKindFile && y.FileStore != nil {
+		if err := y.FileStore.Delete(request.Context(), e.ID); err != nil {
+			y.Logger.Error("Failed to delete streaming file", zap.Error(err))
+		}
+	}
+	y.deleteManagement(e.ID)
+	audit.success()
+	y.webhookDeleted(e.ID)
+	return true
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+// signedIn adds a session cookie for the user sub to req.
+func signedIn(t *testing.T, srv *Server, req *http.Request, sub string) *http.Request {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := srv.setSession(w, req, &sessionData{Sub: sub, Email: sub + "@example.com"}); err != nil {
+		t.Fatalf("setSession: %v", err)
+	}
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// createAs creates a text secret signed in as sub and returns its ID.
+func createAs(t *testing.T, srv *Server, handler http.Handler, sub string, fields map[string]interface{}) string {
+	t.Helper()
+	body := map[string]interface{}{"message": mustEncrypt("hunter2"), "expiration": 3600}
+	for k, v := range fields {
+		body[k] = v
+	}
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/create/secret", strings.NewReader(string(data)))
+	if sub != "" {
+		signedIn(t, srv, req, sub)
+	}
+	rr := serve(handler, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create secret: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp["message"]
+}
+
+type mySecret struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Label     string `json:"label"`
+	State     string `json:"state"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	ViewedAt  int64  `json:"viewed_at"`
+}
+
+func listMine(t *testing.T, srv *Server, handler http.Handler, sub string) map[string]mySecret {
+	t.Helper()
+	rr := serve(handler, signedIn(t, srv, httptest.NewRequest("GET", "/me/secrets", nil), sub))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Secrets []mySecret `json:"secrets"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]mySecret, len(resp.Secrets))
+	for _, s := range resp.Secrets {
+		byID[s.ID] = s
+	}
+	return byID
+}
+
+func TestMySecrets(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	handler := srv.HTTPHandler()
+
+	secretID := createAs(t, &srv, handler, "alice", map[string]interface{}{"label": "db password", "receipt": true})
+	createAs(t, &srv, handler, "", nil)
+	bobID := createAs(t, &srv, handler, "bob", nil)
+
+	upload := signedIn(t, &srv, streamUploadRequest(pgpBody("alice-file"), "3600", "false", ""), "alice")
+	upload.Header.Set(labelHeader, "backup key")
+	rr := serve(handler, upload)
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	fileID := created["message"]
+
+	reqBody, _ := json.Marshal(map[string]interface{}{"public_key": testPublicKey(t), "label": "vpn cert", "expiration": 3600})
+	rr = serve(handler, signedIn(t, &srv, httptest.NewRequest("POST", "/request", strings.NewReader(string(reqBody))), "alice"))
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	requestID := created["id"]
+
+	mine := listMine(t, &srv, handler, "alice")
+	if len(mine) != 3 {
+		t.Fatalf("expected alice's three entries, got %+v", mine)
+	}
+	for id, want := range map[string]mySecret{
+		secretID:  {Kind: WebhookKindSecret, Label: "db password", State: ReceiptStatePending},
+		fileID:    {Kind: WebhookKindFile, Label: "backup key", State: ReceiptStatePending},
+		requestID: {Kind: WebhookKindRequest, Label: "vpn cert", State: RequestStatePending},
+	} {
+		got := mine[id]
+		if got.Kind != want.Kind || got.Label != want.Label || got.State != want.State || got.ExpiresAt-got.CreatedAt != 3600 {
+			t.Errorf("entry %s: got %+v, want %+v", id, got, want)
+		}
+	}
+
+	// The receipt reports the view.
+	serve(handler, httptest.NewRequest("GET", "/secret/"+secretID, nil))
+	if got := listMine(t, &srv, handler, "alice")[secretID]; got.State != ReceiptStateViewed || got.ViewedAt == 0 {
+		t.Errorf("expected the secret to be viewed, got %+v", got)
+	}
+
+	// Bulk revoke only touches alice's own entries.
+	body := `{"ids": ["` + fileID + `", "` + requestID + `", "` + bobID + `"]}`
+	rr = serve(handler, signedIn(t, &srv, httptest.NewRequest("POST", "/me/secrets/revoke", strings.NewReader(body)), "alice"))
+	var revoked struct {
+		Revoked  []string `json:"revoked"`
+		NotFound []string `json:"not_found"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &revoked)
+	if rr.Code != http.StatusOK || len(revoked.Revoked) != 2 || len(revoked.NotFound) != 1 || revoked.NotFound[0] != bobID {
+		t.Fatalf("unexpected revoke response: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(bobID); err != nil {
+		t.Error("bob's secret must survive alice's revoke")
+	}
+	if _, err := db.Get(streamKeyPrefix + fileID); err == nil {
+		t.Error("revoked file metadata should be deleted")
+	}
+	if _, err := db.Get(fileDataKeyPrefix + fileID); err == nil {
+		t.Error("revoked file data should be deleted")
+	}
+	if _, err := db.Get(requestKeyPrefix + requestID); err == nil {
+		t.Error("revoked request should be deleted")
+	}
+	mine = listMine(t, &srv, handler, "alice")
+	if mine[fileID].State != CreatorStateRevoked || mine[requestID].State != CreatorStateRevoked {
+		t.Errorf("expected revoked entries, got %+v", mine)
+	}
+
+	if mine := listMine(t, &srv, handler, "bob"); len(mine) != 1 {
+		t.Errorf("expected bob's single entry, got %+v", mine)
+	}
+}
+
+func TestMySecretsStates(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	handler := srv.HTTPHandler()
+
+	consumed := createAs(t, &srv, handler, "alice", map[string]interface{}{"one_time": true})
+	expired := createAs(t, &srv, handler, "alice", nil)
+	lapsed := createAs(t, &srv, handler, "alice", nil)
+	serve(handler, httptest.NewRequest("GET", "/secret/"+consumed, nil))
+
+	owner := creatorOwner(&sessionData{Sub: "alice"})
+	err := srv.updateCreatorIndex(owner, false, func(ix *creatorIndex) error {
+		for i := range ix.Entries {
+			switch ix.Entries[i].ID {
+			case expired:
+				ix.Entries[i].ExpiresAt = time.Now().Unix() - 60
+			case lapsed:
+				ix.Entries[i].ExpiresAt = time.Now().Unix() - int64(creatorEntryRetention/time.Second) - 60
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mine := listMine(t, &srv, handler, "alice")
+	if mine[consumed].State != CreatorStateClosed {
+		t.Errorf("consumed secret without receipt: got %+v", mine[consumed])
+	}
+	if mine[expired].State != CreatorStateExpired {
+		t.Errorf("expired secret: got %+v", mine[expired])
+	}
+	if _, ok := mine[lapsed]; ok {
+		t.Error("entries past their retention should be dropped")
+	}
+
+	// The index lives as long as its newest entry is retained.
+	s, _ := db.Get(creatorKeyPrefix + owner)
+	if want := int32(3600 + creatorEntryRetention/time.Second); s.Expiration < want-5 || s.Expiration > want {
+		t.Errorf("unexpected index TTL %d, want about %d", s.Expiration, want)
+	}
+}
+
+func TestMySecretsExtend(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+	handler := srv.HTTPHandler()
+	req := signedIn(t, &srv, httptest.NewRequest("POST", "/create/secret", strings.NewReader(`{"message": "`+pgpTestMessage+`", "expiration": 3600}`)), "alice")
+	rr := serve(handler, req)
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	if rr := manageRequest(handler, "POST", "/secret/"+created["message"]+"/extend", created["manage_token"], `{"expiration": 86400}`); rr.Code != http.StatusOK {
+		t.Fatalf("extend: %d %s", rr.Code, rr.Body.String())
+	}
+	if got := listMine(t, &srv, handler, "alice")[created["message"]]; got.ExpiresAt-got.CreatedAt < 86000 {
+		t.Errorf("index entry not extended: %+v", got)
+	}
+}
+
+func TestMySecretsRequiresSession(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+	handler := srv.HTTPHandler()
+	if rr := serve(handler, httptest.NewRequest("GET", "/me/secrets", nil)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("list without session: expected 401, got %d", rr.Code)
+	}
+	if rr := serve(handler, httptest.NewRequest("POST", "/me/secrets/revoke", strings.NewReader(`{"ids": ["x"]}`))); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoke without session: expected 401, got %d", rr.Code)
+	}
+	if rr := serve(handler, signedIn(t, &srv, httptest.NewRequest("POST", "/me/secrets/revoke", strings.NewReader(`{"ids": []}`)), "alice")); rr.Code != http.StatusBadRequest {
+		t.Errorf("revoke without ids: expected 400, got %d", rr.Code)
+	}
+
+	// Without OIDC there is no dashboard.
+	y := newRequestTestServer(t, newMemoryDB(), true)
+	handler = y.HTTPHandler()
+	if rr := serve(handler, httptest.NewRequest("GET", "/me/secrets", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("without OIDC: expected 404, got %d", rr.Code)
+	}
+	// Anonymous creations are not indexed.
+	createAs(t, &y, handler, "", nil)
+	for key := range y.DB.(*memoryDB).data {
+		if strings.HasPrefix(key, creatorKeyPrefix) {
+			t.Errorf("unexpected creator index %s", key)
+		}
+	}
+}
+
+// racingCreateDB runs race once right before the first PutIfAbsent of key,
+// standing in for a concurrent writer that starts the key first and so makes
+// this one lose.
+type racingCreateDB struct {
+	Database
+	key  string
+	race func()
+	once sync.Once
+}
+
+func (db *racingCreateDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	if key == db.key {
+		db.once.Do(db.race)
+	}
+	return db.Database.PutIfAbsent(key, secret)
+}
+
+func TestCreatorIndexConcurrentFirstEntries(t *testing.T) {
+	mem := newMemoryDB()
+	db := &racingCreateDB{Database: mem, key: creatorKeyPrefix + "owner"}
+	y := newRequestTestServer(t, db, true)
+	now := time.Now().Unix()
+	entry := func(id string) creatorEntry {
+		return creatorEntry{ID: id, Kind: WebhookKindSecret, CreatedAt: now, ExpiresAt: now + 3600}
+	}
+	// The racing writer also found no index and starts it first, so this
+	// one's create loses and must fall back to Update.
+	db.race = func() {
+		data, _ := json.Marshal(creatorIndex{Entries: []creatorEntry{entry("second")}})
+		mem.PutIfAbsent(creatorKeyPrefix+"owner", yopass.Secret{Message: string(data), Expiration: 3600})
+	}
+
+	y.addCreatorEntry("owner", entry("first"))
+	ix := y.loadCreatorIndex("owner")
+	ids := map[string]int{}
+	for _, e := range ix.Entries {
+		ids[e.ID]++
+	}
+	if ids["first"] != 1 || ids["second"] != 1 {
+		t.Errorf("expected both entries once, got %+v", ix.Entries)
+	}
+}
@@ -10,10 +10,33 @@ import (
 // deleted before the write commits.
 var ErrKeyNotFound = errors.New("key not found")
 
+// DegradedError is returned by Health when the backend still serves reads and
+// writes but with reduced redundancy, such as a failed replica. Readiness
+// reports it without taking the instance out of rotation.
+type DegradedError struct {
+	Reason string
+}
+
+func (e *DegradedError) Error() string {
+	return "degraded: " + e.Reason
+}
+
+// NodeHealthChecker is implemented by backends spread over several nodes so
+// the readiness endpoint can report each one.
+type NodeHealthChecker interface {
+	// NodeHealth probes every node once and returns the result of each,
+	// keyed by address, together with the aggregate Health would return.
+	NodeHealth() (nodes map[string]error, err error)
+}
+
 // Database interface
 type Database interface {
 	Get(key string) (yopass.Secret, error)
 	Put(key string, secret yopass.Secret) error
+	// PutIfAbsent atomically stores secret at key unless a live value is
+	// already there and reports whether it stored it. Expired values count as
+	// absent.
+	PutIfAbsent(key string, secret yopass.Secret) (bool, error)
 	Delete(key string) (bool, error)
 	Status(key string) (yopass.Secret, error)
 	// Update atomically applies fn to the current value at key and stores the
@@ -24,3 +47,26 @@ type Database interface {
 	Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error
 	Health() error
 }
+
+// updateOrCreate applies fn to the value at key through Update, starting a
+// missing key with fn applied to an empty secret. The start goes through
+// PutIfAbsent: a writer that loses it to a concurrent start retries Update on
+// the winner's value, so neither change is lost.
+func updateOrCreate(db Database, key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
+	var err error
+	for i := 0; i < updateRetries; i++ {
+		err = db.Update(key, fn)
+		if !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		s, err := fn(yopass.Secret{})
+		if err != nil {
+			return err
+		}
+		stored, err := db.PutIfAbsent(key, s)
+		if err != nil || stored {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+// sealedPrefix marks a value sealed by EncryptedDatabase. The key ID and the
+// base64 nonce+ciphertext follow, separated by colons.
+const sealedPrefix = "yopass-sealed:v1:"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ErrUnknownKeyID is returned when a stored value was sealed with a key that
+// is no longer in the keyring.
+var ErrUnknownKeyID = errors.New("value sealed with unknown key ID")
+
+// Keyring holds the AES-256 keys used to seal stored values. The first key
+// seals new writes; every key can open existing values, so a key is rotated
+// by adding a new one in front and removing the old one once everything it
+// sealed has expired.
+type Keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// LoadKeyring reads a keyring file. See ParseKeyring for the format.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %w", err)
+	}
+	return ParseKeyring(data)
+}
+
+// ParseKeyring parses one key per line as "<id>:<base64 32-byte key>", e.g.
+//
+//	2026-10:3q2+7w...=
+//	2026-04:u7mZ9a...=
+//
+// Blank lines and lines starting with # are ignored. The first key is the
+// active one. Generate a key with: openssl rand -base64 32
+func ParseKeyring(data []byte) (*Keyring, error) {
+	k := &Keyring{aeads: map[string]cipher.AEAD{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("keyring line %d: expected <id>:<base64 key> with an alphanumeric id", line)
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("keyring line %d: duplicate key id %q", line, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("keyring line %d: key %q must be 32 bytes of base64", line, id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+		if k.active == "" {
+			k.active = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.active == "" {
+		return nil, errors.New("keyring contains no keys")
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the key sealing new values.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// seal encrypts plaintext with the active key, binding it to the storage key
+// as additional data so a sealed value cannot be replayed under another key.
+func (k *Keyring) seal(storageKey string, plaintext []byte) (string, error) {
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(storageKey))
+	return sealedPrefix + k.active + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) open(storageKey, value string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	if !ok {
+		return nil, errors.New("malformed sealed value")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed sealed value")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(storageKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not open sealed value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// NewEncryptedDatabase wraps db so every value is sealed with the keyring
+// before it reaches the backend.
+func NewEncryptedDatabase(db Database, keyring *Keyring) *EncryptedDatabase {
+	return &EncryptedDatabase{db: db, keyring: keyring}
+}
+
+// EncryptedDatabase is a Database decorator that seals each stored secret
+// with AES-256-GCM. The backend only sees the expiration and one-time flag,
+// which it needs for TTLs and delete-on-read; the message, request and
+// receipt records and every other field are inside the envelope.
+type EncryptedDatabase struct {
+	db      Database
+	keyring *Keyring
+
+	// AcceptPlaintext lets reads return values stored before encryption was
+	// enabled, so existing links keep working during the switch. Writes are
+	// always sealed.
+	AcceptPlaintext bool
+}
+
+func (e *EncryptedDatabase) seal(key string, secret yopass.Secret) (yopass.Secret, error) {
+	data, err := secret.ToJSON()
+	if err != nil {
+		return secret, err
+	}
+	sealed, err := e.keyring.seal(key, data)
+	if err != nil {
+		return secret, err
+	}
+	return yopass.Secret{Message: sealed, Expiration: secret.Expiration, OneTime: secret.OneTime}, nil
+}
+
+func (e *EncryptedDatabase) open(key string, stored yopass.Secret) (yopass.Secret, error) {
+	if !strings.HasPrefix(stored.Message, sealedPrefix) {
+		if e.AcceptPlaintext {
+			return stored, nil
+		}
+		return yopass.Secret{}, errors.New("refusing to read unsealed value")
+	}
+	data, err := e.keyring.open(key, stored.Message)
+	if err != nil {
+		return yopass.Secret{}, err
+	}
+	var s yopass.Secret
+	if err := json.Unmarshal(data, &s); err != nil {
+		return yopass.Secret{}, err
+	}
+	return s, nil
+}
+
+// Get returns and opens the value at key.
+func (e *EncryptedDatabase) Get(key string) (yopass.Secret, error) {
+	stored, err := e.db.Get(key)
+	if err != nil {
+		return stored, err
+	}
+	return e.open(key, stored)
+}
+
+// Status opens the value at key without consuming it.
+func (e *EncryptedDatabase) Status(key string) (yopass.Secret, error) {
+	stored, err := e.db.Status(key)
+	if err != nil {
+		return stored, err
+	}
+	return e.open(key, stored)
+}
+
+// Put seals secret and stores it at key.
+func (e *EncryptedDatabase) Put(key string, secret yopass.Secret) error {
+	sealed, err := e.seal(key, secret)
+	if err != nil {
+		return err
+	}
+	return e.db.Put(key, sealed)
+}
+
+// PutIfAbsent seals secret and stores it at key unless a value exists.
+func (e *EncryptedDatabase) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	sealed, err := e.seal(key, secret)
+	if err != nil {
+		return false, err
+	}
+	return e.db.PutIfAbsent(key, sealed)
+}
+
+// Update opens, modifies and reseals the value inside the backend's atomic
+// Update, so rewrites also migrate values to the active key.
+func (e *EncryptedDatabase) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
+	return e.db.Update(key, func(stored yopass.Secret) (yopass.Secret, error) {
+		s, err := e.open(key, stored)
+		if err != nil {
+			return stored, err
+		}
+		updated, err := fn(s)
+		if err != nil {
+			return stored, err
+		}
+		return e.seal(key, updated)
+	})
+}
+
+// Delete removes key from the backend.
+func (e *EncryptedDatabase) Delete(key string) (bool, error) {
+	return e.db.Delete(key)
+}
+
+// Health checks the wrapped backend.
+func (e *EncryptedDatabase) Health() error {
+	return e.db.Health()
+}
+
+// NodeHealth forwards per-node health from a multi-node backend; it reports
+// no nodes, only the backend's Health, when the wrapped backend has none.
+func (e *EncryptedDatabase) NodeHealth() (map[string]error, error) {
+	if checker, ok := e.db.(NodeHealthChecker); ok {
+		return checker.NodeHealth()
+	}
+	return nil, e.db.Health()
+}
+
+var _ Database = (*EncryptedDatabase)(nil)
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyLine(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func testKeyring(t *testing.T, lines ...string) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring([]byte(strings.Join(lines, "\n")))
+	require.NoError(t, err)
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	k := testKeyring(t, "# rotated 2026-10", "", testKeyLine("new", 'a'), testKeyLine("old", 'b'))
+	assert.Equal(t, "new", k.ActiveKeyID())
+	assert.Len(t, k.aeads, 2)
+
+	for name, data := range map[string]string{
+		"empty":         "# nothing\n",
+		"missing colon": "abc",
+		"bad id":        "a b:" + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"short key":     "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		"not base64":    "k1:!!!",
+		"duplicate id":  testKeyLine("k1", 'a') + "\n" + testKeyLine("k1", 'b'),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseKeyring([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	require.NoError(t, os.WriteFile(path, []byte(testKeyLine("k1", 'a')+"\n"), 0o600))
+	k, err := LoadKeyring(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", k.ActiveKeyID())
+
+	_, err = LoadKeyring(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedDatabase(t *testing.T) {
+	backend := newMemoryDB()
+	db := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k1", 'a')))
+
+	secret := yopass.Secret{Message: `{"label":"db password"}`, Expiration: 3600, OneTime: true, RequireAuth: true}
+	require.NoError(t, db.Put("key", secret))
+
+	stored := backend.data["key"]
+	assert.True(t, strings.HasPrefix(stored.Message, sealedPrefix+"k1:"))
+	assert.NotContains(t, stored.Message, "db password")
+	assert.Equal(t, int32(3600), stored.Expiration, "backend needs the TTL")
+	assert.True(t, stored.OneTime, "backend needs the one-time flag")
+	assert.False(t, stored.RequireAuth)
+
+	got, err := db.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, secret, got)
+
+	got, err = db.Status("key")
+	require.NoError(t, err)
+	assert.Equal(t, secret, got)
+
+	require.NoError(t, db.Update("key", func(s yopass.Secret) (yopass.Secret, error) {
+		s.Message = "updated"
+		return s, nil
+	}))
+	got, err = db.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", got.Message)
+
+	errStop := errors.New("stop")
+	assert.Equal(t, errStop, db.Update("key", func(s yopass.Secret) (yopass.Secret, error) {
+		return s, errStop
+	}))
+
+	deleted, err := db.Delete("key")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.NoError(t, db.Health())
+
+	created, err := db.PutIfAbsent("key", secret)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.True(t, strings.HasPrefix(backend.data["key"].Message, sealedPrefix+"k1:"))
+	created, err = db.PutIfAbsent("key", yopass.Secret{Message: "other"})
+	require.NoError(t, err)
+	assert.False(t, created)
+}
+
+func TestEncryptedDatabaseBindsStorageKey(t *testing.T) {
+	backend := newMemoryDB()
+	db := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k1", 'a')))
+	require.NoError(t, db.Put("a", yopass.Secret{Message: "foo"}))
+
+	// A sealed value copied to another key must not open there.
+	backend.data["b"] = backend.data["a"]
+	_, err := db.Get("b")
+	assert.Error(t, err)
+}
+
+func TestEncryptedDatabaseKeyRotation(t *testing.T) {
+	backend := newMemoryDB()
+	old := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k1", 'a')))
+	require.NoError(t, old.Put("key", yopass.Secret{Message: "foo"}))
+
+	rotated := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k2", 'b'), testKeyLine("k1", 'a')))
+	got, err := rotated.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", got.Message)
+
+	// Rewrites move the value to the active key.
+	require.NoError(t, rotated.Update("key", func(s yopass.Secret) (yopass.Secret, error) { return s, nil }))
+	assert.True(t, strings.HasPrefix(backend.data["key"].Message, sealedPrefix+"k2:"))
+
+	retired := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k3", 'c')))
+	_, err = retired.Get("key")
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+}
+
+func TestEncryptedDatabasePlaintext(t *testing.T) {
+	backend := newMemoryDB()
+	require.NoError(t, backend.Put("legacy", yopass.Secret{Message: "plain"}))
+
+	db := NewEncryptedDatabase(backend, testKeyring(t, testKeyLine("k1", 'a')))
+	_, err := db.Get("legacy")
+	assert.Error(t, err)
+
+	db.AcceptPlaintext = true
+	got, err := db.Get("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", got.Message)
+}
+
+func TestEncryptedDatabaseNodeHealth(t *testing.T) {
+	keyring := testKeyring(t, testKeyLine("k1", 'a'))
+	nodes, err := NewEncryptedDatabase(newMemoryDB(), keyring).NodeHealth()
+	assert.Nil(t, nodes)
+	assert.NoError(t, err)
+
+	want := map[string]error{"10.0.0.1:11211": nil}
+	nodes, err = NewEncryptedDatabase(&mockNodeHealthDB{nodes: want}, keyring).NodeHealth()
+	assert.Equal(t, want, nodes)
+	assert.NoError(t, err)
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 )
 
@@ -15,3 +17,74 @@ type FileStore interface {
 	Delete(ctx context.Context, key string) error
 	Health(ctx context.Context) error
 }
+
+// ErrRangeNotSatisfiable is returned by ranged reads starting at or past the
+// end of the object.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// RangeLoader is implemented by file stores that can read part of an object
+// without transferring the bytes before it. Stores without it still serve
+// ranges through loadFileRange, which skips the leading bytes of a full Load.
+type RangeLoader interface {
+	// LoadRange returns a reader for up to length bytes starting at offset,
+	// and the object's total size. A negative length reads to the end; a
+	// negative offset selects the last -offset bytes. Errors follow Load,
+	// plus ErrRangeNotSatisfiable, returned with the total size when known
+	// (-1 otherwise), when offset is at or past the end of the object.
+	LoadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error)
+}
+
+// resolveRange maps a LoadRange offset and length onto an object of the
+// given size, returning the first byte and the number of bytes served. ok is
+// false when the range is not satisfiable.
+func resolveRange(offset, length, size int64) (start, n int64, ok bool) {
+	if offset < 0 {
+		start = max(size+offset, 0)
+	} else {
+		start = offset
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	n = size - start
+	if length >= 0 && length < n {
+		n = length
+	}
+	return start, n, true
+}
+
+// readCloser combines a reader with the closer of the underlying source, for
+// stores returning a limited view of an open object.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// loadFileRange reads part of a stored file, natively when the store is a
+// RangeLoader and otherwise by discarding the leading bytes of a full Load.
+func loadFileRange(ctx context.Context, store FileStore, key string, offset, length int64) (io.ReadCloser, int64, error) {
+	if rl, ok := store.(RangeLoader); ok {
+		return rl.LoadRange(ctx, key, offset, length)
+	}
+	rc, size, err := store.Load(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, err = skipToRange(rc, offset, length, size)
+	return rc, size, err
+}
+
+// skipToRange narrows a reader over a whole object of the given size to the
+// requested range by discarding the bytes before it. rc is closed on error.
+func skipToRange(rc io.ReadCloser, offset, length, size int64) (io.ReadCloser, error) {
+	start, n, ok := resolveRange(offset, length, size)
+	if !ok {
+		rc.Close()
+		return nil, ErrRangeNotSatisfiable
+	}
+	if _, err := io.CopyN(io.Discard, rc, start); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("could not skip to range start: %w", err)
+	}
+	return readCloser{Reader: io.LimitReader(rc, n), Closer: rc}, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// azureExpiresMetadata is the blob metadata entry holding the expiry as an
+// HTTP date, the same format S3FileStore stores in the Expires header.
+const azureExpiresMetadata = "expires"
+
+// AzureFileStore stores encrypted files in an Azure Blob Storage container.
+type AzureFileStore struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+// NewAzureFileStore creates an AzureFileStore. connectionString takes
+// precedence and also covers Azurite; otherwise serviceURL must carry a SAS
+// token granting read, write, delete and list on the container.
+func NewAzureFileStore(container, prefix, connectionString, serviceURL string) (*AzureFileStore, error) {
+	var client *azblob.Client
+	var err error
+	switch {
+	case connectionString != "":
+		client, err = azblob.NewClientFromConnectionString(connectionString, nil)
+	case serviceURL != "":
+		client, err = azblob.NewClientWithNoCredential(serviceURL, nil)
+	default:
+		return nil, fmt.Errorf("azure file store needs a connection string or a service URL")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create Azure client: %w", err)
+	}
+	return &AzureFileStore{client: client, container: container, prefix: prefix}, nil
+}
+
+func (s *AzureFileStore) blobName(key string) string {
+	return s.prefix + key
+}
+
+// Save streams the data into a block blob, staging one block at a time, with
+// the expiration recorded in blob metadata.
+func (s *AzureFileStore) Save(ctx context.Context, key string, data io.Reader, _ int64, expiration int32) error {
+	expires := time.Now().Add(time.Duration(expiration) * time.Second).UTC().Format(http.TimeFormat)
+	_, err := s.client.UploadStream(ctx, s.container, s.blobName(key), data, &azblob.UploadStreamOptions{
+		Metadata: map[string]*string{azureExpiresMetadata: &expires},
+	})
+	if err != nil {
+		return fmt.Errorf("azure upload failed: %w", err)
+	}
+	return nil
+}
+
+// Load retrieves the blob from Azure.
+func (s *AzureFileStore) Load(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.client.DownloadStream(ctx, s.container, s.blobName(key), nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, 0, fmt.Errorf("azure download failed: %v: %w", err, ErrKeyNotFound)
+		}
+		return nil, 0, fmt.Errorf("azure download failed: %w", err)
+	}
+	var size int64
+	if resp.ContentLength != nil {
+		size = *resp.ContentLength
+	}
+	return resp.Body, size, nil
+}
+
+// Extend rewrites the expiry in the blob metadata in place. Setting metadata
+// replaces all of it, and the expiry is the only entry Save records.
+func (s *AzureFileStore) Extend(ctx context.Context, key string, expiration int32) error {
+	expires := time.Now().Add(time.Duration(expiration) * time.Second).UTC().Format(http.TimeFormat)
+	blob := s.client.ServiceClient().NewContainerClient(s.container).NewBlobClient(s.blobName(key))
+	_, err := blob.SetMetadata(ctx, map[string]*string{azureExpiresMetadata: &expires}, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return fmt.Errorf("azure extend failed: %v: %w", err, ErrKeyNotFound)
+		}
+		return fmt.Errorf("azure extend failed: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the blob from Azure. A missing blob is not an error,
+// matching S3 semantics.
+func (s *AzureFileStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteBlob(ctx, s.container, s.blobName(key), nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("azure delete failed: %w", err)
+	}
+	return nil
+}
+
+// Health checks Azure connectivity by reading the container properties.
+func (s *AzureFileStore) Health(ctx context.Context) error {
+	_, err := s.client.ServiceClient().NewContainerClient(s.container).GetProperties(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("azure health check failed: %w", err)
+	}
+	return nil
+}
+
+// azureBlobExpiry returns the expiry recorded in blob metadata. Metadata
+// names are case-insensitive in Azure, so the lookup is too.
+func azureBlobExpiry(metadata map[string]*string) (time.Time, bool) {
+	for name, value := range metadata {
+		if !strings.EqualFold(name, azureExpiresMetadata) || value == nil {
+			continue
+		}
+		expires, err := http.ParseTime(*value)
+		return expires, err == nil
+	}
+	return time.Time{}, false
+}
+
+var (
+	_ FileStore      = (*AzureFileStore)(nil)
+	_ ExpiryExtender = (*AzureFileStore)(nil)
+)
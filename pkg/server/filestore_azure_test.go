@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeAzure is a minimal in-memory Azure Blob Storage server for unit
+// testing, covering single-shot and staged block uploads.
+type fakeAzure struct {
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	metadata map[string]map[string]string
+	blocks   map[string][]byte // staged, uncommitted blocks by blob+"/"+blockid
+	server   *httptest.Server
+}
+
+func newFakeAzure(t *testing.T) *fakeAzure {
+	t.Helper()
+	f := &fakeAzure{
+		blobs:    make(map[string][]byte),
+		metadata: make(map[string]map[string]string),
+		blocks:   make(map[string][]byte),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func writeAzureError(w http.ResponseWriter, code string, status int) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func blobMetadata(h http.Header) map[string]string {
+	meta := map[string]string{}
+	for name, values := range h {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-meta-") {
+			meta[strings.TrimPrefix(lower, "x-ms-meta-")] = values[0]
+		}
+	}
+	return meta
+}
+
+func (f *fakeAzure) handle(w http.ResponseWriter, r *http.Request) {
+	// Path-style: /{container}/{blob...}
+	_, blob, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+
+	switch {
+	// Get Container Properties: GET /{container}?restype=container
+	case r.Method == http.MethodGet && blob == "" && q.Get("comp") == "":
+		w.WriteHeader(http.StatusOK)
+
+	// List Blobs: GET /{container}?restype=container&comp=list
+	case r.Method == http.MethodGet && blob == "" && q.Get("comp") == "list":
+		f.handleList(w, q.Get("prefix"))
+
+	// Put Block: PUT /{container}/{blob}?comp=block&blockid=...
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.blocks[blob+"/"+q.Get("blockid")] = body
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+
+	// Put Block List: PUT /{container}/{blob}?comp=blocklist
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			writeAzureError(w, "InvalidXmlDocument", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		var data []byte
+		for _, id := range list.Latest {
+			data = append(data, f.blocks[blob+"/"+id]...)
+			delete(f.blocks, blob+"/"+id)
+		}
+		f.blobs[blob] = data
+		f.metadata[blob] = blobMetadata(r.Header)
+		f.mu.Unlock()
+		w.Header().Set("ETag", `"abc123"`)
+		w.WriteHeader(http.StatusCreated)
+
+	// Set Blob Metadata: PUT /{container}/{blob}?comp=metadata
+	case r.Method == http.MethodPut && q.Get("comp") == "metadata":
+		f.mu.Lock()
+		_, ok := f.blobs[blob]
+		if ok {
+			f.metadata[blob] = blobMetadata(r.Header)
+		}
+		f.mu.Unlock()
+		if !ok {
+			writeAzureError(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"abc124"`)
+		w.WriteHeader(http.StatusOK)
+
+	// Put Blob: PUT /{container}/{blob}
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.blobs[blob] = body
+		f.metadata[blob] = blobMetadata(r.Header)
+		f.mu.Unlock()
+		w.Header().Set("ETag", `"abc123"`)
+		w.WriteHeader(http.StatusCreated)
+
+	// Get Blob: GET /{container}/{blob}
+	case r.Method == http.MethodGet:
+		f.mu.Lock()
+		data, ok := f.blobs[blob]
+		f.mu.Unlock()
+		if !ok {
+			writeAzureError(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.Header().Set("ETag", `"abc123"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+
+	// Delete Blob: DELETE /{container}/{blob}
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		_, ok := f.blobs[blob]
+		delete(f.blobs, blob)
+		delete(f.metadata, blob)
+		f.mu.Unlock()
+		if !ok {
+			writeAzureError(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeAzure) handleList(w http.ResponseWriter, prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="container"><Blobs>`)
+	for name, data := range f.blobs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		fmt.Fprintf(w, `<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties><Metadata>`, html.EscapeString(name), len(data))
+		for k, v := range f.metadata[name] {
+			fmt.Fprintf(w, `<%s>%s</%s>`, k, html.EscapeString(v), k)
+		}
+		fmt.Fprint(w, `</Metadata></Blob>`)
+	}
+	fmt.Fprint(w, `</Blobs><NextMarker /></EnumerationResults>`)
+}
+
+// newTestAzureFileStore builds an AzureFileStore pointed at the fake server.
+func newTestAzureFileStore(t *testing.T, fake *fakeAzure, prefix string) *AzureFileStore {
+	t.Helper()
+	client, err := azblob.NewClientWithNoCredential(fake.server.URL+"/", &azblob.ClientOptions{
+		// Disable retries so tests exercising errors fail fast.
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Azure client: %v", err)
+	}
+	return &AzureFileStore{client: client, container: "container", prefix: prefix}
+}
+
+func TestNewAzureFileStore(t *testing.T) {
+	if _, err := NewAzureFileStore("container", "", "", ""); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+	if _, err := NewAzureFileStore("container", "", "", "https://account.blob.core.windows.net/?sv=sas"); err != nil {
+		t.Fatalf("unexpected error for service URL: %v", err)
+	}
+	if _, err := NewAzureFileStore("container", "", "not a connection string", ""); err == nil {
+		t.Fatal("expected error for invalid connection string")
+	}
+}
+
+func TestAzureFileStoreHealth(t *testing.T) {
+	store := newTestAzureFileStore(t, newFakeAzure(t), "")
+	if err := store.Health(context.Background()); err != nil {
+		t.Fatalf("expected healthy, got: %v", err)
+	}
+}
+
+func TestAzureFileStoreSaveAndLoad(t *testing.T) {
+	fake := newFakeAzure(t)
+	store := newTestAzureFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+
+	for name, data := range map[string][]byte{
+		"small": []byte("hello encrypted world"),
+		// Larger than one 1 MiB block, so it is staged and committed.
+		"large": bytes.Repeat([]byte("0123456789abcdef"), 100_000),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Save(ctx, name, bytes.NewReader(data), int64(len(data)), 3600); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			fake.mu.Lock()
+			_, stored := fake.blobs["yopass/"+name]
+			fake.mu.Unlock()
+			if !stored {
+				t.Fatalf("expected blob stored under prefix yopass/%s", name)
+			}
+
+			rc, size, err := store.Load(ctx, name)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			defer rc.Close()
+			if size != int64(len(data)) {
+				t.Errorf("expected size %d, got %d", len(data), size)
+			}
+			got, _ := io.ReadAll(rc)
+			if !bytes.Equal(got, data) {
+				t.Errorf("content mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestAzureFileStoreSaveSetsExpires(t *testing.T) {
+	fake := newFakeAzure(t)
+	store := newTestAzureFileStore(t, fake, "")
+	if err := store.Save(context.Background(), "metakey", strings.NewReader("data"), 4, 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	fake.mu.Lock()
+	value := fake.metadata["metakey"][azureExpiresMetadata]
+	fake.mu.Unlock()
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		t.Fatalf("expires metadata is not a valid HTTP date: %q", value)
+	}
+	if expires.Before(time.Now()) {
+		t.Errorf("expected expiry in the future, got %s", expires)
+	}
+}
+
+func TestAzureFileStoreExtend(t *testing.T) {
+	fake := newFakeAzure(t)
+	store := newTestAzureFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+	if err := store.Save(ctx, "extkey", strings.NewReader("data"), 4, 60); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.Extend(ctx, "extkey", 86400); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	fake.mu.Lock()
+	value := fake.metadata["yopass/extkey"][azureExpiresMetadata]
+	fake.mu.Unlock()
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		t.Fatalf("expires metadata is not a valid HTTP date: %q", value)
+	}
+	if expires.Before(time.Now().Add(86000 * time.Second)) {
+		t.Errorf("expected the expiry to move a day ahead, got %s", expires)
+	}
+	if err := store.Extend(ctx, "missing", 86400); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing blob, got %v", err)
+	}
+}
+
+func TestAzureFileStoreLoadNotFound(t *testing.T) {
+	store := newTestAzureFileStore(t, newFakeAzure(t), "")
+	_, _, err := store.Load(context.Background(), "nonexistent")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestAzureFileStoreDelete(t *testing.T) {
+	store := newTestAzureFileStore(t, newFakeAzure(t), "")
+	ctx := context.Background()
+	if err := store.Save(ctx, "delkey", strings.NewReader("data"), 4, 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.Delete(ctx, "delkey"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, _, err := store.Load(ctx, "delkey"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound after deletion, got %v", err)
+	}
+	if err := store.Delete(ctx, "delkey"); err != nil {
+		t.Fatalf("deleting a missing blob should succeed, got %v", err)
+	}
+}
+
+func TestCleanupExpiredAzure(t *testing.T) {
+	fake := newFakeAzure(t)
+	store := newTestAzureFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+
+	for _, key := range []string{"expired", "valid"} {
+		if err := store.Save(ctx, key, strings.NewReader("data"), 4, 3600); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	fake.mu.Lock()
+	fake.metadata["yopass/expired"][azureExpiresMetadata] = time.Now().Add(-100 * time.Second).UTC().Format(http.TimeFormat)
+	// Blobs without expiry metadata and outside the prefix are left alone.
+	fake.blobs["yopass/external"] = []byte("data")
+	fake.blobs["other/expired"] = []byte("data")
+	fake.metadata["other/expired"] = map[string]string{azureExpiresMetadata: time.Now().Add(-100 * time.Second).UTC().Format(http.TimeFormat)}
+	fake.mu.Unlock()
+
+	cleanupExpiredAzure(ctx, store, zaptest.NewLogger(t))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.blobs["yopass/expired"]; ok {
+		t.Error("expected expired blob to be deleted")
+	}
+	for _, name := range []string{"yopass/valid", "yopass/external", "other/expired"} {
+		if _, ok := fake.blobs[name]; !ok {
+			t.Errorf("expected blob %s to remain", name)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltChunkSize is the size of the chunks a file is split into. Each chunk
+// is written in its own transaction so a slow upload never holds bbolt's
+// single write lock for longer than one chunk.
+const boltChunkSize = 1 << 20
+
+// boltFileMetaKey holds the boltFileMeta record inside a file's bucket.
+// Chunk keys are 8-byte big-endian indexes, which can never collide with it.
+var boltFileMetaKey = []byte("meta")
+
+// boltFileMeta tracks a stored file. It is written before the first chunk,
+// mirroring DiskFileStore's .meta sidecar, so the cleanup sweep can remove a
+// partial upload left behind by a crash once its expiration passes.
+type boltFileMeta struct {
+	ExpirationUnix int64 `json:"expiration_unix"`
+	Size           int64 `json:"size"`
+	Complete       bool  `json:"complete"`
+}
+
+// boltFileMetaIn decodes the metadata record of a file bucket.
+func boltFileMetaIn(b *bolt.Bucket) (boltFileMeta, bool) {
+	var meta boltFileMeta
+	if b == nil {
+		return meta, false
+	}
+	data := b.Get(boltFileMetaKey)
+	if data == nil || json.Unmarshal(data, &meta) != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+func boltChunkKey(i uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, i)
+	return k
+}
+
+// BoltFileStore stores encrypted files in the same embedded database file as
+// a Bolt Database, so a single-binary install keeps all state in one place.
+type BoltFileStore struct {
+	db *Bolt
+}
+
+// NewBoltFileStore creates a FileStore sharing the given Bolt database.
+func NewBoltFileStore(db *Bolt) *BoltFileStore {
+	return &BoltFileStore{db: db}
+}
+
+func (s *BoltFileStore) putMeta(key string, meta boltFileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("could not marshal metadata: %w", err)
+	}
+	return s.db.update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltFilesBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		return b.Put(boltFileMetaKey, data)
+	})
+}
+
+// Save streams data into fixed-size chunks. The file only becomes visible to
+// Load once the final metadata write marks it complete.
+func (s *BoltFileStore) Save(ctx context.Context, key string, data io.Reader, contentLength int64, expiration int32) error {
+	meta := boltFileMeta{ExpirationUnix: time.Now().Unix() + int64(expiration)}
+	// Replace any previous file under this key instead of mixing chunks.
+	if err := s.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := s.putMeta(key, meta); err != nil {
+		return fmt.Errorf("could not write metadata: %w", err)
+	}
+
+	buf := make([]byte, boltChunkSize)
+	for i := uint64(0); ; i++ {
+		if err := ctx.Err(); err != nil {
+			_ = s.Delete(context.Background(), key)
+			return err
+		}
+		n, readErr := io.ReadFull(data, buf)
+		if n > 0 {
+			chunk := buf[:n]
+			err := s.db.update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(boltFilesBucket).Bucket([]byte(key))
+				if b == nil {
+					return ErrKeyNotFound
+				}
+				return b.Put(boltChunkKey(i), chunk)
+			})
+			if err != nil {
+				_ = s.Delete(context.Background(), key)
+				return fmt.Errorf("could not write file: %w", err)
+			}
+			meta.Size += int64(n)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			_ = s.Delete(context.Background(), key)
+			return fmt.Errorf("could not write file: %w", readErr)
+		}
+	}
+
+	meta.Complete = true
+	if err := s.putMeta(key, meta); err != nil {
+		_ = s.Delete(context.Background(), key)
+		return fmt.Errorf("could not write metadata: %w", err)
+	}
+	return nil
+}
+
+// Load returns a reader streaming the file chunk by chunk. Each chunk is
+// copied out of its own read transaction, so a long download does not pin
+// a transaction (and the pages it references) for its whole duration.
+func (s *BoltFileStore) Load(_ context.Context, key string) (io.ReadCloser, int64, error) {
+	var meta boltFileMeta
+	err := s.db.view(func(tx *bolt.Tx) error {
+		var ok bool
+		meta, ok = boltFileMetaIn(tx.Bucket(boltFilesBucket).Bucket([]byte(key)))
+		if !ok || !meta.Complete || time.Now().Unix() > meta.ExpirationUnix {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not open file: %w", err)
+	}
+	return &boltFileReader{store: s, key: key, remaining: meta.Size}, meta.Size, nil
+}
+
+// boltFileReader reads a stored file's chunks in order.
+type boltFileReader struct {
+	store     *BoltFileStore
+	key       string
+	next      uint64
+	buf       []byte
+	remaining int64
+}
+
+func (r *boltFileReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		err := r.store.db.view(func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltFilesBucket).Bucket([]byte(r.key))
+			if b == nil {
+				return ErrKeyNotFound
+			}
+			chunk := b.Get(boltChunkKey(r.next))
+			if chunk == nil {
+				return io.ErrUnexpectedEOF
+			}
+			r.buf = append(r.buf[:0], chunk...)
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not read file: %w", err)
+		}
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *boltFileReader) Close() error { return nil }
+
+// Extend moves the expiration of a completely stored file.
+func (s *BoltFileStore) Extend(_ context.Context, key string, expiration int32) error {
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltFilesBucket).Bucket([]byte(key))
+		meta, ok := boltFileMetaIn(b)
+		if !ok || !meta.Complete || time.Now().Unix() > meta.ExpirationUnix {
+			return ErrKeyNotFound
+		}
+		meta.ExpirationUnix = time.Now().Unix() + int64(expiration)
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return b.Put(boltFileMetaKey, data)
+	})
+	if err != nil {
+		return fmt.Errorf("could not extend file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file and its metadata.
+func (s *BoltFileStore) Delete(_ context.Context, key string) error {
+	err := s.db.update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltFilesBucket).DeleteBucket([]byte(key))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete file: %w", err)
+	}
+	return nil
+}
+
+// Health delegates to the underlying database health check.
+func (s *BoltFileStore) Health(_ context.Context) error {
+	return s.db.Health()
+}
+
+var (
+	_ FileStore      = (*BoltFileStore)(nil)
+	_ ExpiryExtender = (*BoltFileStore)(nil)
+)
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltFileStore_SaveLoadDelete(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+	key := "abcdef01-1234-5678-9abc-def012345678"
+	// Larger than one chunk so the reader crosses chunk boundaries.
+	content := bytes.Repeat([]byte("0123456789"), boltChunkSize/5+3)
+
+	err := store.Save(context.Background(), key, bytes.NewReader(content), int64(len(content)), 3600)
+	require.NoError(t, err)
+
+	rc, size, err := store.Load(context.Background(), key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	require.NoError(t, store.Delete(context.Background(), key))
+	_, _, err = store.Load(context.Background(), key)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Deleting a missing file is not an error.
+	assert.NoError(t, store.Delete(context.Background(), key))
+}
+
+func TestBoltFileStore_SaveReplaces(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+	key := "abcdef01-1234-5678-9abc-def012345678"
+
+	long := bytes.Repeat([]byte("a"), boltChunkSize+10)
+	require.NoError(t, store.Save(context.Background(), key, bytes.NewReader(long), int64(len(long)), 3600))
+	require.NoError(t, store.Save(context.Background(), key, strings.NewReader("short"), 5, 3600))
+
+	rc, _, err := store.Load(context.Background(), key)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "short", string(got))
+}
+
+func TestBoltFileStore_LoadExpiredOrIncomplete(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+
+	require.NoError(t, store.putMeta("expired", boltFileMeta{ExpirationUnix: time.Now().Unix() - 1, Complete: true}))
+	_, _, err := store.Load(context.Background(), "expired")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, store.putMeta("partial", boltFileMeta{ExpirationUnix: time.Now().Unix() + 3600}))
+	_, _, err = store.Load(context.Background(), "partial")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestBoltFileStore_Extend(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+	key := "abcdef01-1234-5678-9abc-def012345678"
+	require.NoError(t, store.Save(context.Background(), key, strings.NewReader("data"), 4, 3600))
+	require.NoError(t, store.Extend(context.Background(), key, 86400))
+
+	var meta boltFileMeta
+	require.NoError(t, store.db.db.View(func(tx *bolt.Tx) error {
+		meta, _ = boltFileMetaIn(tx.Bucket(boltFilesBucket).Bucket([]byte(key)))
+		return nil
+	}))
+	assert.InDelta(t, time.Now().Unix()+86400, meta.ExpirationUnix, 5)
+	assert.True(t, meta.Complete)
+
+	require.NoError(t, store.putMeta("partial", boltFileMeta{ExpirationUnix: time.Now().Unix() + 3600}))
+	assert.ErrorIs(t, store.Extend(context.Background(), "partial", 86400), ErrKeyNotFound)
+	assert.ErrorIs(t, store.Extend(context.Background(), "missing", 86400), ErrKeyNotFound)
+}
+
+func TestBoltFileStore_SaveErrorRemovesPartial(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+	key := "abcdef01-1234-5678-9abc-def012345678"
+
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader("too large")), 3)
+	err := store.Save(context.Background(), key, body, -1, 3600)
+	var maxErr *http.MaxBytesError
+	assert.True(t, errors.As(err, &maxErr), "MaxBytesError should survive wrapping")
+
+	_, _, err = store.Load(context.Background(), key)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestBoltFileStore_Health(t *testing.T) {
+	store := NewBoltFileStore(newTestBolt(t))
+	assert.NoError(t, store.Health(context.Background()))
+}
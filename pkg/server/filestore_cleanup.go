@@ -3,15 +3,20 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
 )
 
 // StartDiskCleanup runs a background goroutine that periodically removes expired
@@ -146,3 +151,99 @@ func cleanupExpiredS3(ctx context.Context, store *S3FileStore, logger *zap.Logge
 		continuationToken = output.NextContinuationToken
 	}
 }
+
+// StartAzureCleanup runs a background goroutine that periodically removes
+// expired blobs by checking the expiry metadata set at upload.
+func StartAzureCleanup(ctx context.Context, store *AzureFileStore, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanupExpiredAzure(ctx, store, logger)
+		}
+	}
+}
+
+func cleanupExpiredAzure(ctx context.Context, store *AzureFileStore, logger *zap.Logger) {
+	now := time.Now()
+	pager := store.client.NewListBlobsFlatPager(store.container, &azblob.ListBlobsFlatOptions{
+		Prefix:  &store.prefix,
+		Include: azblob.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			logger.Warn("Azure cleanup: failed to list blobs", zap.Error(err))
+			return
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			name := *item.Name
+			// Blobs without expiry metadata (e.g. uploaded externally) are
+			// left alone.
+			expires, ok := azureBlobExpiry(item.Metadata)
+			if !ok || !now.After(expires) {
+				continue
+			}
+			if _, err := store.client.DeleteBlob(ctx, store.container, name, nil); err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+				logger.Warn("Azure cleanup: failed to delete expired blob", zap.String("key", name), zap.Error(err))
+			} else {
+				logger.Debug("Cleaned up expired Azure blob", zap.String("key", name))
+			}
+		}
+	}
+}
+
+// StartGCSCleanup runs a background goroutine that periodically removes
+// expired objects from the GCS file store by checking the Custom-Time set at
+// upload.
+func StartGCSCleanup(ctx context.Context, store *GCSFileStore, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanupExpiredGCS(ctx, store, logger)
+		}
+	}
+}
+
+func cleanupExpiredGCS(ctx context.Context, store *GCSFileStore, logger *zap.Logger) {
+	now := time.Now()
+	query := &storage.Query{Prefix: store.prefix}
+	if err := query.SetAttrSelection([]string{"Name", "CustomTime"}); err != nil {
+		logger.Warn("GCS cleanup: invalid query", zap.Error(err))
+		return
+	}
+	it := store.client.Bucket(store.bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return
+		}
+		if err != nil {
+			logger.Warn("GCS cleanup: failed to list objects", zap.Error(err))
+			return
+		}
+		// Objects without a Custom-Time (e.g. uploaded externally) are left
+		// alone.
+		if attrs.CustomTime.IsZero() || !now.After(attrs.CustomTime) {
+			continue
+		}
+		err = store.client.Bucket(store.bucket).Object(attrs.Name).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			logger.Warn("GCS cleanup: failed to delete expired object", zap.String("key", attrs.Name), zap.Error(err))
+		} else {
+			logger.Debug("Cleaned up expired GCS object", zap.String("key", attrs.Name))
+		}
+	}
+}
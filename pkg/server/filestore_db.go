@@ -56,12 +56,27 @@ func (d *DatabaseFileStore) Delete(_ context.Context, key string) error {
 	return err
 }
 
+// Extend moves the expiration of the stored file data.
+func (d *DatabaseFileStore) Extend(_ context.Context, key string, expiration int32) error {
+	err := d.DB.Update(fileDataKeyPrefix+key, func(s yopass.Secret) (yopass.Secret, error) {
+		s.Expiration = expiration
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("file data not found: %w", err)
+	}
+	return nil
+}
+
 // Health delegates to the underlying database health check.
 func (d *DatabaseFileStore) Health(_ context.Context) error {
 	return d.DB.Health()
 }
 
-var _ FileStore = (*DatabaseFileStore)(nil)
+var (
+	_ FileStore      = (*DatabaseFileStore)(nil)
+	_ ExpiryExtender = (*DatabaseFileStore)(nil)
+)
 
 // FormatSize formats bytes into a human-readable string (e.g. "1MB", "1.5GB").
 func FormatSize(b int64) string {
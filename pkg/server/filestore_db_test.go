@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -39,6 +40,16 @@ func (db *testDB) Put(key string, secret yopass.Secret) error {
 	return nil
 }
 
+func (db *testDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.store[key]; ok {
+		return false, nil
+	}
+	db.store[key] = secret
+	return true, nil
+}
+
 func (db *testDB) Delete(key string) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -216,3 +227,21 @@ func TestDatabaseFileStore_BinaryData(t *testing.T) {
 		}
 	}
 }
+
+func TestDatabaseFileStoreExtend(t *testing.T) {
+	db := newTestDB()
+	store := NewDatabaseFileStore(db)
+	ctx := context.Background()
+	if err := store.Save(ctx, "f", strings.NewReader("data"), 4, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Extend(ctx, "f", 7200); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := db.Get(fileDataKeyPrefix + "f"); s.Expiration != 7200 {
+		t.Errorf("expected expiration 7200, got %d", s.Expiration)
+	}
+	if err := store.Extend(ctx, "missing", 7200); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -65,6 +67,24 @@ func TestDiskFileStore_SaveWritesMeta(t *testing.T) {
 	assert.True(t, os.IsNotExist(err))
 }
 
+func TestDiskFileStore_Extend(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskFileStore(dir)
+	require.NoError(t, err)
+
+	key := "abcdef01-1234-5678-9abc-def012345678"
+	require.NoError(t, store.Save(context.Background(), key, bytes.NewReader([]byte("data")), 4, 3600))
+	require.NoError(t, store.Extend(context.Background(), key, 86400))
+
+	data, err := os.ReadFile(store.metaPath(key))
+	require.NoError(t, err)
+	var meta fileMeta
+	require.NoError(t, json.Unmarshal(data, &meta))
+	assert.InDelta(t, time.Now().Unix()+86400, meta.ExpirationUnix, 5)
+
+	assert.ErrorIs(t, store.Extend(context.Background(), "missing", 86400), ErrKeyNotFound)
+}
+
 func TestDiskFileStore_LoadNonExistent(t *testing.T) {
 	dir := t.TempDir()
 	store, err := NewDiskFileStore(dir)
@@ -107,3 +127,11 @@ func TestDiskFileStore_LargeFile(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, content, got)
 }
+
+func TestDiskFileStore_LoadRange(t *testing.T) {
+	store, err := NewDiskFileStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Save(context.Background(), "rangekey", bytes.NewReader([]byte("0123456789")), 10, 3600))
+
+	testRangeLoad(t, store.LoadRange, "rangekey")
+}
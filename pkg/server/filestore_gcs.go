@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSFileStore stores encrypted files in a Google Cloud Storage bucket. The
+// expiry is kept in the object's Custom-Time, which bucket lifecycle rules
+// can also act on (daysSinceCustomTime).
+type GCSFileStore struct {
+	client *storage.Client
+	bucket string
+	prefix string
+}
+
+// NewGCSFileStore creates a GCSFileStore using Application Default
+// Credentials. Setting STORAGE_EMULATOR_HOST points it at an emulator.
+func NewGCSFileStore(bucket, prefix string) (*GCSFileStore, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCS client: %w", err)
+	}
+	return &GCSFileStore{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *GCSFileStore) object(key string) *storage.ObjectHandle {
+	return s.client.Bucket(s.bucket).Object(s.prefix + key)
+}
+
+// Save streams the data to GCS with the expiration stored as Custom-Time.
+// The object only becomes visible once the writer is closed successfully.
+func (s *GCSFileStore) Save(ctx context.Context, key string, data io.Reader, _ int64, expiration int32) error {
+	// Cancelling the writer's context is the only way to abort an upload;
+	// closing it would commit whatever was written so far.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.object(key).NewWriter(ctx)
+	w.CustomTime = time.Now().Add(time.Duration(expiration) * time.Second)
+	if _, err := io.Copy(w, data); err != nil {
+		cancel()
+		_ = w.Close()
+		return fmt.Errorf("gcs upload failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("gcs upload failed: %w", err)
+	}
+	return nil
+}
+
+// Load retrieves the object from GCS.
+func (s *GCSFileStore) Load(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	r, err := s.object(key).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, 0, fmt.Errorf("gcs download failed: %v: %w", err, ErrKeyNotFound)
+		}
+		return nil, 0, fmt.Errorf("gcs download failed: %w", err)
+	}
+	return r, r.Attrs.Size, nil
+}
+
+// Extend moves the object's Custom-Time to the new expiry in place. GCS only
+// lets Custom-Time move forward, which is all an extension does.
+func (s *GCSFileStore) Extend(ctx context.Context, key string, expiration int32) error {
+	_, err := s.object(key).Update(ctx, storage.ObjectAttrsToUpdate{
+		CustomTime: time.Now().Add(time.Duration(expiration) * time.Second),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("gcs extend failed: %v: %w", err, ErrKeyNotFound)
+		}
+		return fmt.Errorf("gcs extend failed: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the object from GCS. A missing object is not an error,
+// matching S3 semantics.
+func (s *GCSFileStore) Delete(ctx context.Context, key string) error {
+	err := s.object(key).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("gcs delete failed: %w", err)
+	}
+	return nil
+}
+
+// Health checks GCS connectivity by reading the bucket attributes.
+func (s *GCSFileStore) Health(ctx context.Context) error {
+	if _, err := s.client.Bucket(s.bucket).Attrs(ctx); err != nil {
+		return fmt.Errorf("gcs health check failed: %w", err)
+	}
+	return nil
+}
+
+var (
+	_ FileStore      = (*GCSFileStore)(nil)
+	_ ExpiryExtender = (*GCSFileStore)(nil)
+)
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/option"
+)
+
+// fakeGCS is a minimal in-memory Cloud Storage server for unit testing. It
+// speaks the JSON API for uploads, metadata and listing and the XML API for
+// media downloads, which is what the Go client uses against an emulator.
+type fakeGCS struct {
+	mu          sync.Mutex
+	objects     map[string][]byte
+	customTimes map[string]string // RFC 3339 customTime per object
+	server      *httptest.Server
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	t.Helper()
+	f := &fakeGCS{
+		objects:     make(map[string][]byte),
+		customTimes: make(map[string]string),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func writeGCSError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": status, "message": http.StatusText(status)}})
+}
+
+func (f *fakeGCS) objectResource(name string) map[string]any {
+	res := map[string]any{"bucket": "bucket", "name": name, "size": strconv.Itoa(len(f.objects[name]))}
+	if ct := f.customTimes[name]; ct != "" {
+		res["customTime"] = ct
+	}
+	return res
+}
+
+func (f *fakeGCS) handle(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	// Multipart upload: POST /upload/storage/v1/b/{bucket}/o?uploadType=multipart
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		f.handleUpload(w, r)
+
+	// Bucket attributes: GET /storage/v1/b/{bucket}
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/storage/v1/b/") && !strings.Contains(strings.TrimPrefix(path, "/storage/v1/b/"), "/"):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"name": strings.TrimPrefix(path, "/storage/v1/b/")})
+
+	// List objects: GET /storage/v1/b/{bucket}/o
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/storage/v1/b/") && strings.HasSuffix(path, "/o"):
+		prefix := r.URL.Query().Get("prefix")
+		f.mu.Lock()
+		var items []map[string]any
+		for name := range f.objects {
+			if strings.HasPrefix(name, prefix) {
+				items = append(items, f.objectResource(name))
+			}
+		}
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"kind": "storage#objects", "items": items})
+
+	// Update object metadata: PATCH /storage/v1/b/{bucket}/o/{object}
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "/storage/v1/b/"):
+		_, name, _ := strings.Cut(strings.TrimPrefix(path, "/storage/v1/b/"), "/o/")
+		var attrs struct {
+			CustomTime string `json:"customTime"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+			writeGCSError(w, http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.objects[name]; !ok {
+			writeGCSError(w, http.StatusNotFound)
+			return
+		}
+		if attrs.CustomTime != "" {
+			f.customTimes[name] = attrs.CustomTime
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f.objectResource(name))
+
+	// Delete object: DELETE /storage/v1/b/{bucket}/o/{object}
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/storage/v1/b/"):
+		_, name, _ := strings.Cut(strings.TrimPrefix(path, "/storage/v1/b/"), "/o/")
+		f.mu.Lock()
+		_, ok := f.objects[name]
+		delete(f.objects, name)
+		delete(f.customTimes, name)
+		f.mu.Unlock()
+		if !ok {
+			writeGCSError(w, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	// XML API media download: GET /{bucket}/{object}
+	case r.Method == http.MethodGet:
+		_, name, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		f.mu.Lock()
+		data, ok := f.objects[name]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("x-goog-generation", "1")
+		w.Header().Set("x-goog-metageneration", "1")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+
+	default:
+		writeGCSError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeGCS) handleUpload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	metaPart, err := mr.NextPart()
+	if err != nil {
+		writeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	var meta struct {
+		Name       string `json:"name"`
+		CustomTime string `json:"customTime"`
+	}
+	if err := json.NewDecoder(metaPart).Decode(&meta); err != nil {
+		writeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	mediaPart, err := mr.NextPart()
+	if err != nil {
+		writeGCSError(w, http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(mediaPart)
+	if meta.Name == "" {
+		meta.Name = r.URL.Query().Get("name")
+	}
+
+	f.mu.Lock()
+	f.objects[meta.Name] = data
+	f.customTimes[meta.Name] = meta.CustomTime
+	res := f.objectResource(meta.Name)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// newTestGCSFileStore builds a GCSFileStore pointed at the fake server.
+func newTestGCSFileStore(t *testing.T, fake *fakeGCS, prefix string) *GCSFileStore {
+	t.Helper()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(fake.server.URL, "http://"))
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create GCS client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &GCSFileStore{client: client, bucket: "bucket", prefix: prefix}
+}
+
+func TestGCSFileStoreHealth(t *testing.T) {
+	store := newTestGCSFileStore(t, newFakeGCS(t), "")
+	if err := store.Health(context.Background()); err != nil {
+		t.Fatalf("expected healthy, got: %v", err)
+	}
+}
+
+func TestGCSFileStoreSaveAndLoad(t *testing.T) {
+	fake := newFakeGCS(t)
+	store := newTestGCSFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+
+	data := []byte("hello encrypted world")
+	if err := store.Save(ctx, "mykey", bytes.NewReader(data), int64(len(data)), 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	fake.mu.Lock()
+	_, stored := fake.objects["yopass/mykey"]
+	fake.mu.Unlock()
+	if !stored {
+		t.Fatal("expected object stored under prefix yopass/mykey")
+	}
+
+	rc, size, err := store.Load(ctx, "mykey")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer rc.Close()
+	if size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), size)
+	}
+	got, _ := io.ReadAll(rc)
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
+
+func TestGCSFileStoreSaveSetsCustomTime(t *testing.T) {
+	fake := newFakeGCS(t)
+	store := newTestGCSFileStore(t, fake, "")
+	if err := store.Save(context.Background(), "metakey", strings.NewReader("data"), 4, 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	fake.mu.Lock()
+	value := fake.customTimes["metakey"]
+	fake.mu.Unlock()
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("customTime is not RFC 3339: %q", value)
+	}
+	if expires.Before(time.Now()) {
+		t.Errorf("expected expiry in the future, got %s", expires)
+	}
+}
+
+func TestGCSFileStoreSaveReadError(t *testing.T) {
+	fake := newFakeGCS(t)
+	store := newTestGCSFileStore(t, fake, "")
+
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader("too large")), 3)
+	err := store.Save(context.Background(), "partial", body, -1, 3600)
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		t.Fatalf("expected MaxBytesError, got %v", err)
+	}
+	fake.mu.Lock()
+	_, stored := fake.objects["partial"]
+	fake.mu.Unlock()
+	if stored {
+		t.Fatal("expected aborted upload not to be committed")
+	}
+}
+
+func TestGCSFileStoreExtend(t *testing.T) {
+	fake := newFakeGCS(t)
+	store := newTestGCSFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+	if err := store.Save(ctx, "extkey", strings.NewReader("data"), 4, 60); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.Extend(ctx, "extkey", 86400); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	fake.mu.Lock()
+	value := fake.customTimes["yopass/extkey"]
+	fake.mu.Unlock()
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("customTime is not RFC 3339: %q", value)
+	}
+	if expires.Before(time.Now().Add(86000 * time.Second)) {
+		t.Errorf("expected the expiry to move a day ahead, got %s", expires)
+	}
+	if err := store.Extend(ctx, "missing", 86400); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing object, got %v", err)
+	}
+}
+
+func TestGCSFileStoreLoadNotFound(t *testing.T) {
+	store := newTestGCSFileStore(t, newFakeGCS(t), "")
+	_, _, err := store.Load(context.Background(), "nonexistent")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestGCSFileStoreDelete(t *testing.T) {
+	store := newTestGCSFileStore(t, newFakeGCS(t), "")
+	ctx := context.Background()
+	if err := store.Save(ctx, "delkey", strings.NewReader("data"), 4, 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.Delete(ctx, "delkey"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, _, err := store.Load(ctx, "delkey"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound after deletion, got %v", err)
+	}
+	if err := store.Delete(ctx, "delkey"); err != nil {
+		t.Fatalf("deleting a missing object should succeed, got %v", err)
+	}
+}
+
+func TestCleanupExpiredGCS(t *testing.T) {
+	fake := newFakeGCS(t)
+	store := newTestGCSFileStore(t, fake, "yopass/")
+	ctx := context.Background()
+
+	for _, key := range []string{"expired", "valid"} {
+		if err := store.Save(ctx, key, strings.NewReader("data"), 4, 3600); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	past := time.Now().Add(-100 * time.Second).UTC().Format(time.RFC3339)
+	fake.mu.Lock()
+	fake.customTimes["yopass/expired"] = past
+	// Objects without a Custom-Time and outside the prefix are left alone.
+	fake.objects["yopass/external"] = []byte("data")
+	fake.objects["other/expired"] = []byte("data")
+	fake.customTimes["other/expired"] = past
+	fake.mu.Unlock()
+
+	cleanupExpiredGCS(ctx, store, zaptest.NewLogger(t))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.objects["yopass/expired"]; ok {
+		t.Error("expected expired object to be deleted")
+	}
+	for _, name := range []string{"yopass/valid", "yopass/external", "other/expired"} {
+		if _, ok := fake.objects[name]; !ok {
+			t.Errorf("expected object %s to remain", name)
+		}
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
@@ -95,6 +99,59 @@ func (s *S3FileStore) Load(ctx context.Context, key string) (io.ReadCloser, int6
 	return output.Body, size, nil
 }
 
+// LoadRange retrieves part of the object with a ranged GetObject.
+func (s *S3FileStore) LoadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error) {
+	var spec string
+	switch {
+	case offset < 0:
+		spec = fmt.Sprintf("bytes=%d", offset)
+	case length < 0:
+		spec = fmt.Sprintf("bytes=%d-", offset)
+	default:
+		spec = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+		Range:  aws.String(spec),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		var notFound *types.NotFound
+		var respErr *awshttp.ResponseError
+		switch {
+		case errors.As(err, &noSuchKey) || errors.As(err, &notFound):
+			return nil, 0, fmt.Errorf("s3 get failed: %v: %w", err, ErrKeyNotFound)
+		case errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable:
+			size := int64(-1)
+			head, headErr := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(s.objectKey(key)),
+			})
+			if headErr == nil && head.ContentLength != nil {
+				size = *head.ContentLength
+			}
+			return nil, size, ErrRangeNotSatisfiable
+		}
+		return nil, 0, fmt.Errorf("s3 get failed: %w", err)
+	}
+
+	// "bytes <first>-<last>/<size>"
+	if output.ContentRange != nil {
+		_, total, _ := strings.Cut(*output.ContentRange, "/")
+		if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+			return output.Body, size, nil
+		}
+	}
+	// Some S3-compatible services ignore Range and return the whole object.
+	var size int64
+	if output.ContentLength != nil {
+		size = *output.ContentLength
+	}
+	rc, err := skipToRange(output.Body, offset, length, size)
+	return rc, size, err
+}
+
 // Delete removes the object from S3.
 func (s *S3FileStore) Delete(ctx context.Context, key string) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -117,3 +174,5 @@ func (s *S3FileStore) Health(ctx context.Context) error {
 	}
 	return nil
 }
+
+var _ RangeLoader = (*S3FileStore)(nil)
@@ -122,8 +122,23 @@ func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
 			writeS3XMLError(w, "NoSuchKey", "The specified key does not exist.", http.StatusNotFound)
 			return
 		}
-		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 		w.Header().Set("ETag", `"abc123"`)
+		if r.Header.Get("Range") != "" {
+			// Let net/http resolve the range, translating a 416 into S3's
+			// InvalidRange error.
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, r, key, time.Time{}, bytes.NewReader(data))
+			if rec.Code == http.StatusRequestedRangeNotSatisfiable {
+				writeS3XMLError(w, "InvalidRange", "The requested range is not satisfiable", rec.Code)
+				return
+			}
+			w.Header().Set("Content-Range", rec.Header().Get("Content-Range"))
+			w.Header().Set("Content-Length", rec.Header().Get("Content-Length"))
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 		w.WriteHeader(http.StatusOK)
 		w.Write(data)
 
@@ -265,6 +280,17 @@ func TestS3FileStoreLoadNotFound(t *testing.T) {
 	}
 }
 
+func TestS3FileStoreLoadRange(t *testing.T) {
+	fake := newFakeS3(t)
+	store := newTestS3FileStore(t, fake, "testbucket", "yopass/")
+	data := []byte("0123456789")
+	if err := store.Save(context.Background(), "rangekey", bytes.NewReader(data), int64(len(data)), 3600); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	testRangeLoad(t, store.LoadRange, "rangekey")
+}
+
 func TestS3FileStoreDelete(t *testing.T) {
 	fake := newFakeS3(t)
 	store := newTestS3FileStore(t, fake, "testbucket", "")
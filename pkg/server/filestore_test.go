@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testRangeLoad checks a ranged read implementation against the LoadRange
+// contract using a 10-byte object stored under key.
+func testRangeLoad(t *testing.T, load func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error), key string) {
+	t.Helper()
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{"whole object", 0, -1, "0123456789"},
+		{"middle", 2, 3, "234"},
+		{"open ended", 7, -1, "789"},
+		{"length past end", 8, 10, "89"},
+		{"suffix", -4, -1, "6789"},
+		{"suffix longer than object", -20, -1, "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, size, err := load(context.Background(), key, tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("LoadRange(%d, %d): %v", tt.offset, tt.length, err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if size != 10 {
+				t.Errorf("expected total size 10, got %d", size)
+			}
+		})
+	}
+
+	t.Run("past end", func(t *testing.T) {
+		_, size, err := load(context.Background(), key, 10, -1)
+		if !errors.Is(err, ErrRangeNotSatisfiable) {
+			t.Fatalf("expected ErrRangeNotSatisfiable, got %v", err)
+		}
+		if size != 10 {
+			t.Errorf("expected total size 10 with the error, got %d", size)
+		}
+	})
+	t.Run("missing object", func(t *testing.T) {
+		if _, _, err := load(context.Background(), key+"-missing", 0, -1); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestLoadFileRangeFallback(t *testing.T) {
+	store := NewDatabaseFileStore(newTestDB())
+	if err := store.Save(context.Background(), "obj", bytes.NewReader([]byte("0123456789")), 10, 3600); err != nil {
+		t.Fatal(err)
+	}
+	testRangeLoad(t, func(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error) {
+		return loadFileRange(ctx, store, key, offset, length)
+	}, "obj")
+}
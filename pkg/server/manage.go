@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// manageKeyPrefix namespaces management records in the database so they can
+// never be read or deleted through the regular /secret/{key} endpoints (the
+// key route pattern cannot match the embedded slash).
+const manageKeyPrefix = "manage/"
+
+// manageTokenHeader carries the management token that identifies the creator
+// of a secret, authorizing revocation and extension.
+const manageTokenHeader = "X-Yopass-Manage-Token"
+
+// secretManagement is the stored representation of a secret's management
+// token. It is kept apart from the secret so the hash is never served to
+// recipients, and lives exactly as long as the secret.
+type secretManagement struct {
+	TokenHash string `json:"token_hash"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	// Owner is the creator index listing the secret, empty for anonymous
+	// creators.
+	Owner string `json:"owner,omitempty"`
+}
+
+// tokenValid compares the given management token against the stored hash in
+// constant time.
+func (m secretManagement) tokenValid(token string) bool {
+	return tokenMatchesHash(token, m.TokenHash)
+}
+
+// ExpiryExtender is implemented by file stores that can move the expiration
+// of a stored file, which extending a file secret requires.
+type ExpiryExtender interface {
+	// Extend sets the expiration of the file to expiration seconds from now.
+	// Errors follow Load.
+	Extend(ctx context.Context, key string, expiration int32) error
+}
+
+// createManagement stores the management record for the secret with the
+// given key and returns the management token. owner is the creator index
+// listing the secret, if any.
+func (y *Server) createManagement(id string, expiration int32, owner string) (string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	data, err := json.Marshal(secretManagement{
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now + int64(expiration),
+		Owner:     owner,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = y.DB.Put(manageKeyPrefix+id, yopass.Secret{
+		Message:    string(data),
+		Expiration: expiration,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// loadManagement fetches and decodes a management record from the database.
+func (y *Server) loadManagement(id string) (secretManagement, bool) {
+	s, err := y.DB.Status(manageKeyPrefix + id)
+	if err != nil {
+		return secretManagement{}, false
+	}
+	var m secretManagement
+	if err := json.Unmarshal([]byte(s.Message), &m); err != nil || m.TokenHash == "" {
+		return secretManagement{}, false
+	}
+	if secondsUntil(m.ExpiresAt) == 0 {
+		return secretManagement{}, false
+	}
+	return m, true
+}
+
+// deleteManagement removes the management record of a secret that was
+// deleted or consumed, so a leftover token cannot outlive it. A record that
+// fails to delete is left to expire.
+func (y *Server) deleteManagement(id string) {
+	if _, err := y.DB.Delete(manageKeyPrefix + id); err != nil {
+		y.Logger.Error("Failed to delete management record", zap.Error(err))
+	}
+}
+
+// authorizeManagement checks the management token presented with a request
+// for the secret id. It writes the error response and audit event itself
+// and reports whether the caller is the creator.
+func (y *Server) authorizeManagement(w http.ResponseWriter, request *http.Request, id string, audit *auditor) (secretManagement, bool) {
+	m, ok := y.loadManagement(id)
+	if !ok {
+		// A secret without a management record predates the feature or is
+		// already gone; either way no token can match.
+		audit.denied("invalid management token")
+		jsonError(w, http.StatusUnauthorized, "Invalid management token")
+		return m, false
+	}
+	if !m.tokenValid(request.Header.Get(manageTokenHeader)) {
+		audit.denied("invalid management token")
+		jsonError(w, http.StatusUnauthorized, "Invalid management token")
+		return m, false
+	}
+	return m, true
+}
+
+// extendSecretHandler returns the handler moving a secret's expiry further
+// out, authorized by the management token. Text secrets and files share it;
+// files additionally extend the stored blob (extendBlob).
+func (y *Server) extendSecretHandler(keyPrefix, auditEvent string, extendBlob bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		id := mux.Vars(request)["key"]
+		session, _ := y.getSession(request)
+		audit := y.newAuditor(auditEvent, y.getRealClientIP(request), session)
+		audit.setSecretID(id)
+
+		var body struct {
+			Expiration int32 `json:"expiration"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, request.Body, 4096)).Decode(&body); err != nil {
+			audit.failure("unable to parse json")
+			jsonError(w, http.StatusBadRequest, "Unable to parse json")
+			return
+		}
+
+		m, ok := y.authorizeManagement(w, request, id, audit)
+		if !ok {
+			return
+		}
+
+		// The expiration is the new remaining lifetime. Like at creation it
+		// must be within the policy, and the whole lifetime of the secret
+		// may not exceed the longest expiration either, so repeated
+		// extensions cannot keep a secret alive indefinitely.
+		now := time.Now().Unix()
+		expiresAt := now + int64(body.Expiration)
+		switch {
+		case y.ForceExpiration != "":
+			audit.failure("expiration is forced")
+			jsonError(w, http.StatusBadRequest, "Expiration does not match server policy")
+			return
+		case !y.validExpiration(body.Expiration) || expiresAt-m.CreatedAt > int64(y.expirationPolicy().Max):
+			audit.failure("invalid expiration")
+			jsonError(w, http.StatusBadRequest, "Invalid expiration specified")
+			return
+		case expiresAt <= m.ExpiresAt:
+			audit.failure("expiration not extended")
+			jsonError(w, http.StatusBadRequest, "Expiration must be later than the current one")
+			return
+		}
+
+		if _, err := y.DB.Status(keyPrefix + id); err != nil {
+			audit.failure("not found")
+			jsonError(w, http.StatusNotFound, "Secret not found")
+			return
+		}
+
+		// Extend the blob first: a file whose metadata outlives its blob
+		// would be reported as missing before its expiry.
+		if extendBlob {
+			extender, ok := y.FileStore.(ExpiryExtender)
+			if !ok {
+				audit.failure("file store cannot extend files")
+				jsonError(w, http.StatusConflict, "Files cannot be extended with this file store")
+				return
+			}
+			if err := extender.Extend(request.Context(), id, body.Expiration); err != nil {
+				y.Logger.Error("Failed to extend streaming file", zap.Error(err))
+				if errors.Is(err, ErrKeyNotFound) {
+					audit.failure("file not found in store")
+					jsonError(w, http.StatusNotFound, "File not found")
+					return
+				}
+				audit.failure("failed to extend file")
+				jsonError(w, http.StatusInternalServerError, "Failed to extend secret")
+				return
+			}
+		}
+
+		err := y.DB.Update(keyPrefix+id, func(s yopass.Secret) (yopass.Secret, error) {
+			return extendSecretRecord(s, expiresAt, body.Expiration), nil
+		})
+		if errors.Is(err, ErrKeyNotFound) {
+			audit.failure("not found")
+			jsonError(w, http.StatusNotFound, "Secret not found")
+			return
+		}
+		if err != nil {
+			y.Logger.Error("Failed to extend secret", zap.Error(err))
+			audit.failure("database error")
+			jsonError(w, http.StatusInternalServerError, "Failed to extend secret")
+			return
+		}
+
+		// The management record and receipt follow the secret. Failing to
+		// extend them only shortens what the creator can do later, so it
+		// is logged rather than reported.
+		m.ExpiresAt = expiresAt
+		if err := y.putManagement(id, m); err != nil {
+			y.Logger.Error("Failed to extend management record", zap.Error(err))
+		}
+		y.extendReceipt(id, expiresAt)
+		y.extendCreatorEntry(m.Owner, id, expiresAt)
+
+		audit.success(withExpiration(body.Expiration))
+		y.webhookExtended(id, body.Expiration)
+		y.writeJSON(w, http.StatusOK, map[string]int64{"expires_at": expiresAt})
+	}
+}
+
+// extendSecretRecord moves the expiry of a secret or file metadata record,
+// including the expiry timestamp the record keeps for later rewrites.
+func extendSecretRecord(s yopass.Secret, expiresAt int64, expiration int32) yopass.Secret {
+	if s.ExpiresAt != 0 {
+		s.ExpiresAt = expiresAt
+	}
+	s.Expiration = expiration
+	return s
+}
+
+func (y *Server) putManagement(id string, m secretManagement) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return y.DB.Put(manageKeyPrefix+id, yopass.Secret{
+		Message:    string(data),
+		Expiration: secondsUntil(m.ExpiresAt),
+	})
+}
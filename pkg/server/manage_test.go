@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+// createManagedSecret creates a secret through the API and returns its ID
+// and management token.
+func createManagedSecret(t *testing.T, handler http.Handler, fields map[string]interface{}) (id, token string) {
+	t.Helper()
+	encrypted, err := yopass.Encrypt(strings.NewReader("hunter2"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]interface{}{"message": encrypted, "expiration": 3600}
+	for k, v := range fields {
+		body[k] = v
+	}
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/create/secret", bytes.NewReader(data))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create secret: status %d body %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Message     string `json:"message"`
+		ManageToken string `json:"manage_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message == "" || resp.ManageToken == "" {
+		t.Fatalf("incomplete create response: %s", rr.Body.String())
+	}
+	return resp.Message, resp.ManageToken
+}
+
+func manageRequest(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(manageTokenHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestManagementTokenStoredHashed(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	id, token := createManagedSecret(t, y.HTTPHandler(), nil)
+
+	record, err := db.Get(manageKeyPrefix + id)
+	if err != nil {
+		t.Fatalf("management record not stored: %v", err)
+	}
+	if strings.Contains(record.Message, token) {
+		t.Error("management token must only be stored hashed")
+	}
+	if record.Expiration != 3600 {
+		t.Errorf("management record should share the secret TTL, got %d", record.Expiration)
+	}
+
+	// The record is not reachable through the secret endpoints.
+	s, _ := db.Get(id)
+	if strings.Contains(s.Message, "token_hash") {
+		t.Error("secret record must not carry the management token")
+	}
+}
+
+func TestDeleteSecretWithManagementToken(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	handler := y.HTTPHandler()
+	// RequireAuth guards recipients; the creator revokes with the token
+	// even without a session.
+	id, token := createManagedSecret(t, handler, nil)
+	s, _ := db.Get(id)
+	s.RequireAuth = true
+	db.Put(id, s)
+
+	if rr := manageRequest(handler, "DELETE", "/secret/"+id, "wrong", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: expected 401, got %d", rr.Code)
+	}
+	if rr := manageRequest(handler, "DELETE", "/secret/"+id, token, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(id); err == nil {
+		t.Error("secret should be deleted")
+	}
+	if _, err := db.Get(manageKeyPrefix + id); err == nil {
+		t.Error("management record should be deleted with the secret")
+	}
+}
+
+func TestRequireManageToken(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	y.RequireManageToken = true
+	handler := y.HTTPHandler()
+	id, token := createManagedSecret(t, handler, nil)
+
+	rr := manageRequest(handler, "DELETE", "/secret/"+id, "", "")
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "Management token required") {
+		t.Fatalf("plain-ID deletion: expected 401, got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(id); err != nil {
+		t.Fatal("secret must survive a rejected deletion")
+	}
+	if rr := manageRequest(handler, "DELETE", "/secret/"+id, token, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("deletion with token: expected 204, got %d", rr.Code)
+	}
+}
+
+func TestExtendSecret(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, true)
+	handler := y.HTTPHandler()
+	id, token := createManagedSecret(t, handler, map[string]interface{}{"max_views": 3, "receipt": true})
+
+	rr := manageRequest(handler, "POST", "/secret/"+id+"/extend", token, `{"expiration": 86400}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("extend: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		ExpiresAt int64 `json:"expires_at"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if want := time.Now().Unix() + 86400; resp.ExpiresAt < want-5 || resp.ExpiresAt > want {
+		t.Errorf("unexpected expires_at %d, want about %d", resp.ExpiresAt, want)
+	}
+
+	s, _ := db.Get(id)
+	if s.Expiration != 86400 || s.ExpiresAt != resp.ExpiresAt {
+		t.Errorf("secret not extended: expiration %d expires_at %d", s.Expiration, s.ExpiresAt)
+	}
+	m, ok := y.loadManagement(id)
+	if !ok || m.ExpiresAt != resp.ExpiresAt {
+		t.Errorf("management record not extended: %+v", m)
+	}
+	r, ok := y.loadReceipt(id)
+	if !ok || r.ExpiresAt != resp.ExpiresAt {
+		t.Errorf("receipt not extended: %+v", r)
+	}
+}
+
+func TestExtendSecretRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		body     string
+		wantCode int
+		wantMsg  string
+	}{
+		{"missing token", "", `{"expiration": 86400}`, http.StatusUnauthorized, "Invalid management token"},
+		{"wrong token", "wrong", `{"expiration": 86400}`, http.StatusUnauthorized, "Invalid management token"},
+		{"invalid json", "valid", `{`, http.StatusBadRequest, "Unable to parse json"},
+		{"outside policy", "valid", `{"expiration": 604801}`, http.StatusBadRequest, "Invalid expiration specified"},
+		{"lifetime beyond maximum", "valid", `{"expiration": 604800}`, http.StatusBadRequest, "Invalid expiration specified"},
+		{"not later", "valid", `{"expiration": 3600}`, http.StatusBadRequest, "Expiration must be later than the current one"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newMemoryDB()
+			y := newRequestTestServer(t, db, false)
+			handler := y.HTTPHandler()
+			id, token := createManagedSecret(t, handler, nil)
+			// Age the secret so a full week from now would exceed the
+			// maximum lifetime, and move its expiry beyond one hour from
+			// now.
+			m, _ := y.loadManagement(id)
+			m.CreatedAt -= 60
+			m.ExpiresAt += 60
+			y.putManagement(id, m)
+
+			if tc.token == "valid" {
+				tc.token = token
+			}
+			rr := manageRequest(handler, "POST", "/secret/"+id+"/extend", tc.token, tc.body)
+			if rr.Code != tc.wantCode || !strings.Contains(rr.Body.String(), tc.wantMsg) {
+				t.Fatalf("expected %d %q, got %d %s", tc.wantCode, tc.wantMsg, rr.Code, rr.Body.String())
+			}
+			if s, _ := db.Get(id); s.Expiration != 3600 {
+				t.Errorf("rejected extension changed the secret: %+v", s)
+			}
+		})
+	}
+}
+
+func TestExtendSecretForcedExpiration(t *testing.T) {
+	y := newRequestTestServer(t, newMemoryDB(), false)
+	y.ForceExpiration = "1h"
+	handler := y.HTTPHandler()
+	id, token := createManagedSecret(t, handler, nil)
+	rr := manageRequest(handler, "POST", "/secret/"+id+"/extend", token, `{"expiration": 86400}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 with a forced expiration, got %d", rr.Code)
+	}
+}
+
+// uploadManagedFile uploads a file and returns its ID and management token.
+func uploadManagedFile(t *testing.T, handler http.Handler, oneTime string) (id, token string) {
+	t.Helper()
+	req := streamUploadRequest(pgpBody("managed-file"), "3600", oneTime, "")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp["manage_token"] == "" {
+		t.Fatalf("upload response lacks a management token: %v", resp)
+	}
+	return resp["message"], resp["manage_token"]
+}
+
+func TestExtendFile(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+	id, token := uploadManagedFile(t, handler, "true")
+
+	rr := manageRequest(handler, "POST", "/file/"+id+"/extend", token, `{"expiration": 86400}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("extend: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	meta, _ := db.Get(streamKeyPrefix + id)
+	if meta.Expiration != 86400 || meta.ExpiresAt < time.Now().Unix()+86000 {
+		t.Errorf("file metadata not extended: %+v", meta)
+	}
+	// A later claim rewrite keeps the extended expiry.
+	if err := srv.updateStreamMeta(id, func(*streamMeta) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ = db.Get(streamKeyPrefix + id); meta.Expiration < 86000 {
+		t.Errorf("rewrite lost the extension: expiration %d", meta.Expiration)
+	}
+	blob, _ := db.Get(fileDataKeyPrefix + id)
+	if blob.Expiration != 86400 {
+		t.Errorf("file data not extended: expiration %d", blob.Expiration)
+	}
+
+	// The file is still served after the extension.
+	req := httptest.NewRequest("GET", "/file/"+id, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != pgpBody("managed-file") {
+		t.Errorf("download after extension: %d %q", w.Code, w.Body.String())
+	}
+}
+
+// plainFileStore hides the ExpiryExtender of the wrapped store.
+type plainFileStore struct{ FileStore }
+
+func TestExtendFileUnsupportedStore(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.FileStore = plainFileStore{srv.FileStore}
+	handler := srv.HTTPHandler()
+	id, token := uploadManagedFile(t, handler, "false")
+
+	rr := manageRequest(handler, "POST", "/file/"+id+"/extend", token, `{"expiration": 86400}`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if meta, _ := db.Get(streamKeyPrefix + id); meta.Expiration != 3600 {
+		t.Errorf("metadata must not outlive the file, got expiration %d", meta.Expiration)
+	}
+}
+
+func TestManagementRecordDeletedOnConsume(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+	get := func(path string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	managed := func(id string) bool {
+		_, err := db.Get(manageKeyPrefix + id)
+		return err == nil
+	}
+
+	oneTime, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": true})
+	get("/secret/" + oneTime)
+	if managed(oneTime) {
+		t.Error("management record should be deleted with a consumed one-time secret")
+	}
+
+	limited, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": false, "max_views": 2})
+	get("/secret/" + limited)
+	if !managed(limited) {
+		t.Fatal("management record must survive a view that leaves views remaining")
+	}
+	get("/secret/" + limited)
+	if managed(limited) {
+		t.Error("management record should be deleted after the last view")
+	}
+
+	file, _ := uploadManagedFile(t, handler, "true")
+	get("/file/" + file)
+	if managed(file) {
+		t.Error("management record should be deleted with a consumed one-time file")
+	}
+}
@@ -1,22 +1,140 @@
 package server
 
 import (
+	"crypto/md5"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/jhaals/yopass/pkg/yopass"
 )
 
-// NewMemcached returns a new memcached database client
-func NewMemcached(server string) *Memcached {
-	return &Memcached{memcache.New(server)}
+// NewMemcached returns a new memcached database client. Keys are spread over
+// the given nodes with consistent hashing, so losing a node only loses the
+// secrets stored on it, and adding or removing a node only remaps about 1/N
+// of the keys. Every yopass instance sharing the pool must list the same
+// nodes; the order does not matter.
+func NewMemcached(servers ...string) *Memcached {
+	ring := newMemcachedRing(servers)
+	m := &Memcached{Client: memcache.NewFromSelector(ring)}
+	for _, node := range ring.nodes {
+		m.nodes = append(m.nodes, memcachedNode{
+			addr:   node.String(),
+			client: memcache.NewFromSelector(newMemcachedRing([]string{node.String()})),
+		})
+	}
+	return m
 }
 
 // Memcached client
 type Memcached struct {
 	Client *memcache.Client
+
+	// nodes holds a single-node client per pool member for health probes.
+	nodes []memcachedNode
+}
+
+type memcachedNode struct {
+	addr   string
+	client *memcache.Client
+}
+
+// memcachedPointsPerNode is the number of positions each node takes on the
+// hash ring; enough to spread keys evenly over a handful of nodes.
+const memcachedPointsPerNode = 160
+
+// memcachedRing is a ketama-style consistent hash ring implementing
+// memcache.ServerSelector.
+type memcachedRing struct {
+	nodes  []net.Addr
+	points []uint32
+	owners []net.Addr // owners[i] holds points[i]
+}
+
+func newMemcachedRing(servers []string) *memcachedRing {
+	r := &memcachedRing{}
+	seen := map[string]bool{}
+	for _, server := range servers {
+		if server == "" || seen[server] {
+			continue
+		}
+		seen[server] = true
+		r.nodes = append(r.nodes, memcachedAddr(server))
+	}
+
+	type point struct {
+		hash  uint32
+		owner net.Addr
+	}
+	var points []point
+	for _, node := range r.nodes {
+		for i := 0; i < memcachedPointsPerNode/4; i++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", node, i)))
+			for j := 0; j < 4; j++ {
+				points = append(points, point{binary.LittleEndian.Uint32(digest[j*4:]), node})
+			}
+		}
+	}
+	// Ties are broken by address so every instance builds the same ring
+	// regardless of the order the nodes were configured in.
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner.String() < points[j].owner.String()
+	})
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// PickServer returns the node owning the first ring point at or after the
+// key's hash. A failed node keeps its keys: requests for them fail instead of
+// moving to another node, which would break CAS and one-time guarantees
+// once the node came back.
+func (r *memcachedRing) PickServer(key string) (net.Addr, error) {
+	if len(r.points) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+	digest := md5.Sum([]byte(key))
+	h := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i], nil
+}
+
+// Each calls fn for every node in the pool.
+func (r *memcachedRing) Each(fn func(net.Addr) error) error {
+	for _, node := range r.nodes {
+		if err := fn(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memcachedAddr is a node address resolved at dial time, so a node whose DNS
+// name is not yet resolvable at startup joins once it is.
+type memcachedAddr string
+
+func (a memcachedAddr) Network() string {
+	if strings.Contains(string(a), "/") {
+		return "unix"
+	}
+	return "tcp"
 }
 
+func (a memcachedAddr) String() string { return string(a) }
+
 // Status returns secret metadata without deleting it (safe for one-time secrets).
 func (m *Memcached) Status(key string) (yopass.Secret, error) {
 	var s yopass.Secret
@@ -68,11 +186,49 @@ func (m *Memcached) Put(key string, secret yopass.Secret) error {
 	return m.Client.Set(&memcache.Item{
 		Key:        key,
 		Value:      data,
-		Expiration: secret.Expiration})
+		Expiration: memcachedExpiration(secret.Expiration)})
+}
+
+// PutIfAbsent stores key in Memcached with add, which fails if the key is
+// already present.
+func (m *Memcached) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	data, err := secret.ToJSON()
+	if err != nil {
+		return false, err
+	}
+
+	err = m.Client.Add(&memcache.Item{
+		Key:        key,
+		Value:      data,
+		Expiration: memcachedExpiration(secret.Expiration)})
+	if err == memcache.ErrNotStored {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// memcachedMaxRelativeTTL is the longest expiration memcached reads as a
+// number of seconds; larger values are taken as a Unix timestamp.
+const memcachedMaxRelativeTTL = 30 * 24 * 3600
+
+// memcachedExpiration converts a TTL in seconds to memcached's expiration
+// field, switching to an absolute timestamp for lifetimes over 30 days.
+func memcachedExpiration(seconds int32) int32 {
+	if seconds <= memcachedMaxRelativeTTL {
+		return seconds
+	}
+	return int32(time.Now().Unix()) + seconds
 }
 
 // Update atomically applies fn to the value at key using memcached CAS
 // tokens, retrying on contention.
+//
+// In a multi-node pool every operation on a key goes to the one node that
+// owns it, so the CAS guarantee is that node's guarantee. If the node fails
+// mid-update the write either landed or did not, and Update returns the
+// network error rather than retrying elsewhere. If the node restarts, its
+// keys are gone and Update reports ErrKeyNotFound. Atomicity across yopass
+// instances requires they all configure the same node list.
 func (m *Memcached) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	var lastErr error
 	for i := 0; i < updateRetries; i++ {
@@ -96,7 +252,7 @@ func (m *Memcached) Update(key string, fn func(yopass.Secret) (yopass.Secret, er
 			return err
 		}
 		item.Value = data
-		item.Expiration = updated.Expiration
+		item.Expiration = memcachedExpiration(updated.Expiration)
 		switch err := m.Client.CompareAndSwap(item); err {
 		case nil:
 			return nil
@@ -124,13 +280,42 @@ func (m *Memcached) Delete(key string) (bool, error) {
 	return true, nil
 }
 
-// Health checks Memcached connectivity by attempting to get a non-existent key
+// Health probes every node by getting a non-existent key. The pool is
+// unhealthy only when no node answers; a partial outage is reported as a
+// *DegradedError since the remaining nodes keep serving their share of keys.
 func (m *Memcached) Health() error {
-	_, err := m.Client.Get("__yopass_health_check__")
-	// ErrCacheMiss means memcached is working (key doesn't exist, which is expected)
-	if err == memcache.ErrCacheMiss {
-		return nil
-	}
-	// Any other error (connection refused, timeout, etc.) means unhealthy
+	_, err := m.NodeHealth()
 	return err
 }
+
+// NodeHealth reports the health of each node in the pool, keyed by address,
+// and the pool's health derived from them as described for Health.
+func (m *Memcached) NodeHealth() (map[string]error, error) {
+	nodes := make(map[string]error, len(m.nodes))
+	var down []string
+	var lastErr error
+	for _, node := range m.nodes {
+		_, err := node.client.Get("__yopass_health_check__")
+		// ErrCacheMiss means memcached is working (key doesn't exist, which is expected)
+		if err == memcache.ErrCacheMiss {
+			err = nil
+		}
+		nodes[node.addr] = err
+		if err != nil {
+			down = append(down, node.addr)
+			lastErr = err
+		}
+	}
+	if len(m.nodes) == 0 {
+		return nodes, memcache.ErrNoServers
+	}
+	if len(down) == len(m.nodes) {
+		return nodes, lastErr
+	}
+	if len(down) > 0 {
+		sort.Strings(down)
+		return nodes, &DegradedError{Reason: fmt.Sprintf("%d of %d memcached nodes unreachable: %s",
+			len(down), len(m.nodes), strings.Join(down, ", "))}
+	}
+	return nodes, nil
+}
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/jhaals/yopass/pkg/yopass"
@@ -49,6 +54,162 @@ func TestMemcachedUnits(t *testing.T) {
 			t.Fatal("Client should be initialized")
 		}
 	})
+
+	t.Run("expirations over 30 days become timestamps", func(t *testing.T) {
+		if got := memcachedExpiration(3600); got != 3600 {
+			t.Errorf("expected relative TTL 3600, got %d", got)
+		}
+		if got := memcachedExpiration(memcachedMaxRelativeTTL); got != memcachedMaxRelativeTTL {
+			t.Errorf("expected 30 days to stay relative, got %d", got)
+		}
+		ttl := int32(60 * 24 * 3600)
+		want := time.Now().Unix() + int64(ttl)
+		if got := int64(memcachedExpiration(ttl)); got < want-5 || got > want+5 {
+			t.Errorf("expected timestamp near %d, got %d", want, got)
+		}
+	})
+}
+
+// fakeMemcached answers every get with a cache miss, enough for Health.
+func fakeMemcached(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte("END\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestMemcachedRing(t *testing.T) {
+	keys := make([]string, 3000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	owners := func(r *memcachedRing) map[string]string {
+		m := map[string]string{}
+		for _, k := range keys {
+			addr, err := r.PickServer(k)
+			if err != nil {
+				t.Fatalf("PickServer: %v", err)
+			}
+			m[k] = addr.String()
+		}
+		return m
+	}
+
+	three := owners(newMemcachedRing([]string{"a:11211", "b:11211", "c:11211"}))
+
+	t.Run("order independent", func(t *testing.T) {
+		reordered := owners(newMemcachedRing([]string{"c:11211", "a:11211", "b:11211", "a:11211"}))
+		for k, addr := range three {
+			if reordered[k] != addr {
+				t.Fatalf("key %s moved from %s to %s when reordering nodes", k, addr, reordered[k])
+			}
+		}
+	})
+
+	t.Run("spreads keys", func(t *testing.T) {
+		counts := map[string]int{}
+		for _, addr := range three {
+			counts[addr]++
+		}
+		for _, node := range []string{"a:11211", "b:11211", "c:11211"} {
+			if counts[node] < len(keys)/6 {
+				t.Errorf("node %s only owns %d of %d keys", node, counts[node], len(keys))
+			}
+		}
+	})
+
+	t.Run("removing a node only remaps its keys", func(t *testing.T) {
+		two := owners(newMemcachedRing([]string{"a:11211", "b:11211"}))
+		for k, addr := range three {
+			if addr != "c:11211" && two[k] != addr {
+				t.Fatalf("key %s moved from %s to %s although its node stayed", k, addr, two[k])
+			}
+		}
+	})
+
+	t.Run("empty ring", func(t *testing.T) {
+		if _, err := newMemcachedRing(nil).PickServer("key"); err != memcache.ErrNoServers {
+			t.Fatalf("expected ErrNoServers, got %v", err)
+		}
+	})
+}
+
+func TestMemcachedPoolHealth(t *testing.T) {
+	up := fakeMemcached(t)
+	down := closedAddr(t)
+
+	t.Run("all nodes up", func(t *testing.T) {
+		if err := NewMemcached(up).Health(); err != nil {
+			t.Fatalf("expected healthy pool, got %v", err)
+		}
+	})
+
+	t.Run("some nodes down is degraded", func(t *testing.T) {
+		m := NewMemcached(up, down)
+		var degraded *DegradedError
+		if err := m.Health(); !errors.As(err, &degraded) {
+			t.Fatalf("expected DegradedError, got %v", err)
+		}
+		if !strings.Contains(degraded.Reason, down) {
+			t.Errorf("expected reason to name %s, got %q", down, degraded.Reason)
+		}
+		nodes, err := m.NodeHealth()
+		if nodes[up] != nil || nodes[down] == nil {
+			t.Errorf("unexpected node health %v", nodes)
+		}
+		if !errors.As(err, &degraded) {
+			t.Errorf("expected NodeHealth to report the pool degraded, got %v", err)
+		}
+	})
+
+	t.Run("all nodes down", func(t *testing.T) {
+		err := NewMemcached(down).Health()
+		var degraded *DegradedError
+		if err == nil || errors.As(err, &degraded) {
+			t.Fatalf("expected plain error, got %v", err)
+		}
+	})
+
+	t.Run("no nodes", func(t *testing.T) {
+		if err := NewMemcached().Health(); err != memcache.ErrNoServers {
+			t.Fatalf("expected ErrNoServers, got %v", err)
+		}
+	})
 }
 
 func TestMemcachedStatus(t *testing.T) {
@@ -299,3 +460,25 @@ func TestMemcachedUpdate(t *testing.T) {
 		t.Fatalf("lost updates: expected %d, got %s", writers, s.Message)
 	}
 }
+
+func TestMemcachedPutIfAbsent(t *testing.T) {
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := NewMemcached(memcachedURL)
+
+	key := "put-if-absent-test-" + t.Name()
+	defer func() { _, _ = m.Delete(key) }()
+
+	if stored, err := m.PutIfAbsent(key, yopass.Secret{Message: "first", Expiration: 3600}); err != nil || !stored {
+		t.Fatalf("expected first PutIfAbsent() to store, got %v %v", stored, err)
+	}
+	if stored, err := m.PutIfAbsent(key, yopass.Secret{Message: "second", Expiration: 3600}); err != nil || stored {
+		t.Fatalf("expected second PutIfAbsent() not to store, got %v %v", stored, err)
+	}
+	if s, err := m.Status(key); err != nil || s.Message != "first" {
+		t.Fatalf("expected the first value to remain: %v %v", s, err)
+	}
+}
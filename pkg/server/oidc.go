@@ -40,6 +40,15 @@ type sessionData struct {
 	Sub   string `json:"sub"`
 	Email string `json:"email"`
 	Name  string `json:"name"`
+	// Groups holds the OIDC group claim at login, matched against the
+	// AllowedGroups of restricted secrets.
+	Groups []string `json:"groups,omitempty"`
+	// Scopes limits what an API token identity may do; nil for interactive
+	// sessions.
+	Scopes []string `json:"-"`
+	// Service marks an access token identity without an email, which the
+	// allowed-domains restriction does not apply to.
+	Service bool `json:"-"`
 }
 
 // NewCookieCodec creates a securecookie codec for session management.
@@ -210,11 +219,15 @@ func (y *Server) isCrossOrigin(r *http.Request) bool {
 // Returns nil, nil when no session cookie is present (unauthenticated).
 // A session placed on the request context (an API token identity resolved
 // by requireAuthMiddleware) takes precedence over the cookie so handlers
-// attribute audit events to the service account.
+// attribute audit events to the service account. A JWT access token, when
+// AccessTokens accepts them, is next in line.
 func (y *Server) getSession(r *http.Request) (*sessionData, error) {
 	if s := sessionFromContext(r.Context()); s != nil {
 		return s, nil
 	}
+	if s, err := y.accessTokenSession(r); s != nil || err != nil {
+		return s, err
+	}
 	if y.CookieCodec == nil {
 		return nil, nil
 	}
@@ -351,7 +364,7 @@ func (y *Server) emailAllowed(email string) bool {
 func (y *Server) oidcUserinfoCallback(
 	w http.ResponseWriter,
 	r *http.Request,
-	_ *oidc.Tokens[*oidc.IDTokenClaims],
+	tokens *oidc.Tokens[*oidc.IDTokenClaims],
 	_ string,
 	_ rp.RelyingParty,
 	info *oidc.UserInfo,
@@ -373,10 +386,11 @@ func (y *Server) oidcUserinfoCallback(
 	}
 
 	s := &sessionData{
-		ID:    randomState(),
-		Sub:   info.Subject,
-		Email: info.Email,
-		Name:  info.Name,
+		ID:     randomState(),
+		Sub:    info.Subject,
+		Email:  info.Email,
+		Name:   info.Name,
+		Groups: y.sessionGroups(tokens, info),
 	}
 	if err := y.setSession(w, r, s); err != nil {
 		y.Logger.Error("failed to set session cookie", zap.Error(err))
@@ -420,7 +434,7 @@ func (y *Server) oidcMeHandler(w http.ResponseWriter, r *http.Request) {
 	// Re-validate the domain on every request so that removing a domain from
 	// --oidc-allowed-domains takes effect immediately without waiting for
 	// existing sessions to expire.
-	if !y.emailAllowed(s.Email) {
+	if !y.sessionAllowed(s) {
 		jsonError(w, http.StatusForbidden, "email domain not permitted")
 		return
 	}
@@ -436,7 +450,7 @@ func (y *Server) oidcMeHandler(w http.ResponseWriter, r *http.Request) {
 // the email-domain restriction does not apply to them.
 func (y *Server) requireAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s := y.apiTokenSession(r); s != nil {
+		if s := y.tokenSession(r); s != nil {
 			next.ServeHTTP(w, r.WithContext(withSession(r.Context(), s)))
 			return
 		}
@@ -445,10 +459,10 @@ func (y *Server) requireAuthMiddleware(next http.Handler) http.Handler {
 			jsonError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
-		if !y.emailAllowed(s.Email) {
+		if !y.sessionAllowed(s) {
 			jsonError(w, http.StatusForbidden, "email domain not permitted")
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withSession(r.Context(), s)))
 	})
 }
@@ -624,7 +624,7 @@ func TestRequireAuthMiddleware_MalformedEmail(t *testing.T) {
 func TestMaybeRequireAuth_NoOIDC(t *testing.T) {
 	s := newOIDCTestServer(t) // OIDCProvider is nil
 	s.RequireAuth = true
-	h := s.maybeRequireAuth(okHandler)
+	h := s.maybeRequireAuth(ScopeCreateSecret, okHandler)
 
 	r := httptest.NewRequest(http.MethodGet, "/", nil) // no session
 	w := httptest.NewRecorder()
@@ -639,7 +639,7 @@ func TestMaybeRequireAuth_OIDCButRequireAuthFalse(t *testing.T) {
 	s := newOIDCTestServer(t)
 	s.RequireAuth = false
 	s.OIDCProvider = &mockOIDCProvider{} // non-nil provider
-	h := s.maybeRequireAuth(okHandler)
+	h := s.maybeRequireAuth(ScopeCreateSecret, okHandler)
 
 	r := httptest.NewRequest(http.MethodGet, "/", nil) // no session
 	w := httptest.NewRecorder()
@@ -653,7 +653,7 @@ func TestMaybeRequireAuth_OIDCAndRequireAuthTrue_Blocks(t *testing.T) {
 	s := newOIDCTestServer(t)
 	s.RequireAuth = true
 	s.OIDCProvider = &mockOIDCProvider{}
-	h := s.maybeRequireAuth(okHandler)
+	h := s.maybeRequireAuth(ScopeCreateSecret, okHandler)
 
 	r := httptest.NewRequest(http.MethodGet, "/", nil) // no session → should be blocked
 	w := httptest.NewRecorder()
@@ -667,7 +667,7 @@ func TestMaybeRequireAuth_OIDCAndRequireAuthTrue_Passes(t *testing.T) {
 	s := newOIDCTestServer(t)
 	s.RequireAuth = true
 	s.OIDCProvider = &mockOIDCProvider{}
-	h := s.maybeRequireAuth(okHandler)
+	h := s.maybeRequireAuth(ScopeCreateSecret, okHandler)
 
 	r := authedRequest(t, &s, "alice@example.com")
 	w := httptest.NewRecorder()
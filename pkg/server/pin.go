@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// pinHeader carries the PIN of a PIN-protected secret, both when the creator
+// sets it on a file upload and when a recipient retrieves the secret.
+const pinHeader = "X-Yopass-PIN"
+
+// PINs are meant to be short enough to send by SMS; the upper bound only
+// keeps the request reasonable.
+const (
+	minPINLength = 4
+	maxPINLength = 64
+)
+
+// DefaultMaxPINAttempts is the number of invalid PINs after which a secret is
+// destroyed when Server.MaxPINAttempts is not set.
+const DefaultMaxPINAttempts = 5
+
+// hashPIN returns the salted storage hash of a PIN as "salt:hash" in hex.
+// PINs are short, so the hash does not stop offline guessing by someone
+// holding the database; that attacker already has the ciphertext the PIN
+// guards. What protects the PIN is the server-side attempt limit.
+func hashPIN(pin string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(append(salt, pin...))
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(h[:]), nil
+}
+
+// pinMatches compares a presented PIN against a stored hashPIN value in
+// constant time.
+func pinMatches(pin, stored string) bool {
+	saltHex, hashHex, ok := strings.Cut(stored, ":")
+	if pin == "" || !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return false
+	}
+	h := sha256.Sum256(append(salt, pin...))
+	return subtle.ConstantTimeCompare(h[:], want) == 1
+}
+
+func (y *Server) maxPINAttempts() int32 {
+	if y.MaxPINAttempts <= 0 {
+		return DefaultMaxPINAttempts
+	}
+	return y.MaxPINAttempts
+}
+
+// errPINMatched aborts the checkPIN update of a correct PIN, which leaves
+// the record unchanged.
+var errPINMatched = errors.New("pin matched")
+
+// checkPIN enforces the PIN of a PIN-protected secret or file before its
+// content is served. A request without a PIN is refused without counting an
+// attempt, so link previews cannot use up the attempts. The PIN is matched
+// against the current record in the same atomic update that counts an
+// invalid one, so concurrent guesses can never exceed the limit, and the one
+// using up the last attempt destroys the secret. It writes the error response
+// and audit event itself and returns the record the PIN was confirmed on,
+// which is what the caller serves, reporting whether the request may proceed.
+func (y *Server) checkPIN(w http.ResponseWriter, request *http.Request, id, kind string, secret yopass.Secret, audit *auditor) (yopass.Secret, bool) {
+	if secret.PINHash == "" {
+		return secret, true
+	}
+	pin := request.Header.Get(pinHeader)
+	if pin == "" {
+		audit.denied("pin required")
+		jsonError(w, http.StatusUnauthorized, "PIN required")
+		return secret, false
+	}
+
+	dbKey := id
+	if kind == WebhookKindFile {
+		dbKey = streamKeyPrefix + id
+	}
+	max := y.maxPINAttempts()
+	var current yopass.Secret
+	var attempts int32
+	err := y.DB.Update(dbKey, func(s yopass.Secret) (yopass.Secret, error) {
+		if s.ExpiresAt == 0 {
+			s.ExpiresAt = time.Now().Unix() + int64(s.Expiration)
+		}
+		ttl := secondsUntil(s.ExpiresAt)
+		// An exhausted record is being destroyed by a concurrent request.
+		if ttl == 0 || s.PINAttempts >= max {
+			return s, ErrKeyNotFound
+		}
+		if pinMatches(pin, s.PINHash) {
+			current = s
+			return s, errPINMatched
+		}
+		s.PINAttempts++
+		s.Expiration = ttl
+		attempts = s.PINAttempts
+		return s, nil
+	})
+	if errors.Is(err, errPINMatched) {
+		return current, true
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		audit.denied("pin attempts exhausted")
+		jsonError(w, http.StatusNotFound, "Secret not found")
+		return secret, false
+	}
+	if err != nil {
+		y.Logger.Error("Failed to count PIN attempt", zap.Error(err))
+		audit.failure("failed to count pin attempt")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return secret, false
+	}
+
+	audit.denied("invalid pin")
+	if attempts < max {
+		y.writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"message":           "Invalid PIN",
+			"remainingAttempts": max - attempts,
+		})
+		return secret, false
+	}
+	y.destroySecret(id, kind, dbKey, audit)
+	jsonError(w, http.StatusNotFound, "Secret destroyed after too many invalid PINs")
+	return secret, false
+}
+
+// destroySecret removes a secret whose PIN attempts are used up, along with
+// its file and management record. Should the delete fail, the exhausted
+// attempt count still refuses every later request.
+func (y *Server) destroySecret(id, kind, dbKey string, audit *auditor) {
+	if _, err := y.DB.Delete(dbKey); err != nil {
+		y.Logger.Error("Failed to destroy secret", zap.Error(err))
+		audit.withEvent(kind + ".destroyed").failure("database error")
+		return
+	}
+	if kind == WebhookKindFile {
+		y.deleteConsumedFile(id, audit)
+	} else {
+		y.deleteManagement(id)
+	}
+	audit.withEvent(kind + ".destroyed").success()
+	y.webhookDestroyed(id, kind)
+}
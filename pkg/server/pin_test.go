@@ -0,0 +1,298 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+func pinRequest(handler http.Handler, method, path, pin, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if pin != "" {
+		req.Header.Set(pinHeader, pin)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPINHash(t *testing.T) {
+	hash, err := hashPIN("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, "1234") {
+		t.Error("hash must not contain the PIN")
+	}
+	if !pinMatches("1234", hash) {
+		t.Error("expected the PIN to match its hash")
+	}
+	other, _ := hashPIN("1234")
+	if other == hash {
+		t.Error("expected hashes of the same PIN to be salted")
+	}
+	for _, tc := range []struct{ pin, hash string }{
+		{"4321", hash},
+		{"", hash},
+		{"1234", ""},
+		{"1234", "nosalt"},
+		{"1234", "zz:zz"},
+	} {
+		if pinMatches(tc.pin, tc.hash) {
+			t.Errorf("pinMatches(%q, %q) should be false", tc.pin, tc.hash)
+		}
+	}
+}
+
+func TestSecretPIN(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	y.PrefetchSecret = true
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"pin": "1234"})
+
+	rr := pinRequest(handler, "GET", "/secret/"+id+"/status", "", "")
+	if !strings.Contains(rr.Body.String(), `"pinRequired":true`) {
+		t.Errorf("status should report the PIN: %s", rr.Body.String())
+	}
+
+	stored, _ := db.Get(id)
+	if stored.PINHash == "" || strings.Contains(stored.PINHash, "1234") || stored.ExpiresAt == 0 {
+		t.Fatalf("expected a salted PIN hash and pinned expiry, got %+v", stored)
+	}
+
+	// A missing PIN is refused without counting an attempt.
+	rr = pinRequest(handler, "GET", "/secret/"+id, "", "")
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "PIN required") {
+		t.Fatalf("missing PIN: expected 401, got %d %s", rr.Code, rr.Body.String())
+	}
+	if s, _ := db.Get(id); s.PINAttempts != 0 {
+		t.Errorf("missing PIN must not count an attempt, got %d", s.PINAttempts)
+	}
+
+	rr = pinRequest(handler, "GET", "/secret/"+id, "0000", "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong PIN: expected 401, got %d", rr.Code)
+	}
+	var resp struct {
+		Message           string `json:"message"`
+		RemainingAttempts int32  `json:"remainingAttempts"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Message != "Invalid PIN" || resp.RemainingAttempts != DefaultMaxPINAttempts-1 {
+		t.Errorf("unexpected wrong PIN response: %s", rr.Body.String())
+	}
+	if s, _ := db.Get(id); s.PINAttempts != 1 || s.Expiration > 3600 {
+		t.Errorf("expected one counted attempt keeping the expiry, got %+v", s)
+	}
+
+	rr = pinRequest(handler, "GET", "/secret/"+id, "1234", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("correct PIN: expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "pin_") {
+		t.Errorf("PIN state must not be served: %s", rr.Body.String())
+	}
+}
+
+func TestSecretPINLockout(t *testing.T) {
+	sink := newWebhookSink(t)
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, true)
+	y.MaxPINAttempts = 3
+	audit := &capturingAuditLogger{}
+	y.Audit = audit
+	y.Webhooks = newTestNotifier(t, WebhookConfig{URL: sink.server.URL})
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"pin": "1234"})
+	if d := sink.waitForEvent(t); d.event.Event != WebhookEventSecretCreated {
+		t.Fatalf("expected created event, got %s", d.event.Event)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := pinRequest(handler, "GET", "/secret/"+id, "0000", ""); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rr.Code)
+		}
+	}
+	rr := pinRequest(handler, "GET", "/secret/"+id, "0000", "")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "destroyed") {
+		t.Fatalf("last attempt: expected 404, got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Get(id); err == nil {
+		t.Error("secret should be destroyed")
+	}
+	if _, err := db.Get(manageKeyPrefix + id); err == nil {
+		t.Error("management record should be destroyed with the secret")
+	}
+	// The correct PIN is of no use afterwards.
+	if rr := pinRequest(handler, "GET", "/secret/"+id, "1234", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after destruction, got %d", rr.Code)
+	}
+
+	d := sink.waitForEvent(t)
+	if d.event.Event != WebhookEventSecretDestroyed || d.event.Kind != WebhookKindSecret || d.event.SecretID != redactSecretID(id) {
+		t.Errorf("unexpected webhook event: %+v", d.event)
+	}
+
+	var denied, destroyed int
+	for _, e := range audit.events {
+		switch {
+		case e.Event == "secret.accessed" && e.Outcome == OutcomeDenied && e.Error == "invalid pin":
+			denied++
+		case e.Event == "secret.destroyed" && e.Outcome == OutcomeSuccess:
+			destroyed++
+		}
+	}
+	if denied != 3 || destroyed != 1 {
+		t.Errorf("expected 3 invalid PIN and 1 destroyed audit events, got %d and %d", denied, destroyed)
+	}
+}
+
+// stalledStatusDB holds the first Status of the armed key until released,
+// so a request's snapshot can go stale while other requests proceed.
+type stalledStatusDB struct {
+	Database
+	mu      sync.Mutex
+	key     string
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (db *stalledStatusDB) arm(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.key = key
+}
+
+func (db *stalledStatusDB) Status(key string) (yopass.Secret, error) {
+	s, err := db.Database.Status(key)
+	db.mu.Lock()
+	stall := key == db.key
+	if stall {
+		db.key = ""
+	}
+	db.mu.Unlock()
+	if stall {
+		close(db.read)
+		<-db.release
+	}
+	return s, err
+}
+
+func TestSecretPINConcurrentGuesses(t *testing.T) {
+	db := &stalledStatusDB{Database: newMemoryDB(), read: make(chan struct{}), release: make(chan struct{})}
+	y := newRequestTestServer(t, db, true)
+	y.MaxPINAttempts = 3
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"pin": "1234", "one_time": false})
+
+	// The correct guess reads the secret before any invalid one is counted.
+	db.arm(id)
+	correct := make(chan *httptest.ResponseRecorder)
+	go func() { correct <- pinRequest(handler, "GET", "/secret/"+id, "1234", "") }()
+	<-db.read
+
+	const guesses = 10
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- pinRequest(handler, "GET", "/secret/"+id, "0000", "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	var invalid, gone int
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			invalid++
+		case http.StatusNotFound:
+			gone++
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if invalid != 2 || gone != guesses-2 {
+		t.Errorf("expected 2 counted and %d refused guesses, got %d and %d", guesses-2, invalid, gone)
+	}
+
+	// The secret was destroyed meanwhile; the stale snapshot must not be
+	// served.
+	close(db.release)
+	if rr := <-correct; rr.Code != http.StatusNotFound {
+		t.Errorf("correct PIN after lockout: expected 404, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSecretPINValidation(t *testing.T) {
+	y := newRequestTestServer(t, newMemoryDB(), false)
+	handler := y.HTTPHandler()
+	for _, pin := range []string{"123", strings.Repeat("1", maxPINLength+1)} {
+		body, _ := json.Marshal(map[string]interface{}{"message": contractPGPMessage, "expiration": 3600, "pin": pin})
+		rr := pinRequest(handler, "POST", "/create/secret", "", string(body))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "PIN must be between") {
+			t.Errorf("pin %q: expected 400, got %d %s", pin, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSecretPINTwoStepRetrieval(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, false)
+	y.TwoStepRetrieval = true
+	handler := y.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{"one_time": true, "pin": "1234"})
+
+	// The claim step needs no PIN; an invalid PIN keeps the nonce valid.
+	nonce := requestClaim(t, handler, "/secret/"+id)
+	if rr := pinRequest(handler, "POST", "/secret/"+id, "0000", claimBody(nonce)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong PIN: expected 401, got %d", rr.Code)
+	}
+	if rr := pinRequest(handler, "POST", "/secret/"+id, "1234", claimBody(nonce)); rr.Code != http.StatusOK {
+		t.Fatalf("correct PIN: expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestFilePINLockout(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.MaxPINAttempts = 2
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("pin-file"), "3600", "false", "")
+	req.Header.Set(pinHeader, "1234")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	id := created["message"]
+
+	if rr := pinRequest(handler, "GET", "/file/"+id, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("missing PIN: expected 401, got %d", rr.Code)
+	}
+	if rr := pinRequest(handler, "GET", "/file/"+id, "1234", ""); rr.Code != http.StatusOK || rr.Body.String() != pgpBody("pin-file") {
+		t.Fatalf("correct PIN: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	pinRequest(handler, "GET", "/file/"+id, "0000", "")
+	if rr := pinRequest(handler, "GET", "/file/"+id, "0000", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("last attempt: expected 404, got %d", rr.Code)
+	}
+	if _, err := db.Get(streamKeyPrefix + id); err == nil {
+		t.Error("file metadata should be destroyed")
+	}
+	if _, err := db.Get(fileDataKeyPrefix + id); err == nil {
+		t.Error("file data should be destroyed")
+	}
+}
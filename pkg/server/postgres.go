@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// postgresSchema creates the single table holding every key. expires_at is
+// NULL for entries stored with a zero expiration, which the other backends
+// also treat as "never expire".
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS yopass_secrets (
+		key        TEXT PRIMARY KEY,
+		value      TEXT NOT NULL,
+		expires_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS yopass_secrets_expires_at ON yopass_secrets (expires_at)`,
+}
+
+// postgresLive is the predicate matching entries whose TTL has not elapsed.
+// Expiry is enforced on every read so correctness never depends on the
+// reaper (StartPostgresCleanup) having run.
+const postgresLive = `(expires_at IS NULL OR expires_at > now())`
+
+// NewPostgres returns a new PostgreSQL database client. No connection is made
+// until the first operation, matching the Redis and Memcached clients; the
+// table is created on first use.
+func NewPostgres(url string) (*Postgres, error) {
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{pool: pool}, nil
+}
+
+// Postgres client
+type Postgres struct {
+	pool *pgxpool.Pool
+
+	schemaMu    sync.Mutex
+	schemaReady bool
+}
+
+// ensureSchema creates the table on first use. Unlike sync.Once it retries
+// after a failure, so a database that is unreachable at startup does not
+// leave the client permanently broken.
+func (p *Postgres) ensureSchema(ctx context.Context) error {
+	p.schemaMu.Lock()
+	defer p.schemaMu.Unlock()
+	if p.schemaReady {
+		return nil
+	}
+	for _, stmt := range postgresSchema {
+		if _, err := p.pool.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	p.schemaReady = true
+	return nil
+}
+
+// postgresExpiry converts a secret's relative expiration to the stored
+// expires_at value; nil (SQL NULL) for entries without a TTL.
+func postgresExpiry(expiration int32) *time.Time {
+	if expiration <= 0 {
+		return nil
+	}
+	t := time.Now().Add(time.Duration(expiration) * time.Second)
+	return &t
+}
+
+// Status returns secret metadata without deleting it (safe for one-time secrets).
+func (p *Postgres) Status(key string) (yopass.Secret, error) {
+	ctx := context.Background()
+	var s yopass.Secret
+	if err := p.ensureSchema(ctx); err != nil {
+		return s, err
+	}
+	var v string
+	err := p.pool.QueryRow(ctx,
+		`SELECT value FROM yopass_secrets WHERE key = $1 AND `+postgresLive, key,
+	).Scan(&v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrKeyNotFound
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// Get key from PostgreSQL. One-time secrets are deleted in the same
+// transaction that reads them, so two concurrent readers cannot both
+// receive the secret.
+func (p *Postgres) Get(key string) (yopass.Secret, error) {
+	ctx := context.Background()
+	var s yopass.Secret
+	if err := p.ensureSchema(ctx); err != nil {
+		return s, err
+	}
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return s, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var v string
+	err = tx.QueryRow(ctx,
+		`SELECT value FROM yopass_secrets WHERE key = $1 AND `+postgresLive+` FOR UPDATE`, key,
+	).Scan(&v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrKeyNotFound
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return s, err
+	}
+
+	if s.OneTime {
+		if _, err := tx.Exec(ctx, `DELETE FROM yopass_secrets WHERE key = $1`, key); err != nil {
+			return s, err
+		}
+	}
+	return s, tx.Commit(ctx)
+}
+
+// Put key to PostgreSQL, replacing any existing value and TTL.
+func (p *Postgres) Put(key string, secret yopass.Secret) error {
+	ctx := context.Background()
+	if err := p.ensureSchema(ctx); err != nil {
+		return err
+	}
+	data, err := secret.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx,
+		`INSERT INTO yopass_secrets (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`,
+		key, string(data), postgresExpiry(secret.Expiration),
+	)
+	return err
+}
+
+// PutIfAbsent inserts key into PostgreSQL unless a live row exists. An expired
+// row the reaper has not yet removed is overwritten, as it is already gone to
+// readers.
+func (p *Postgres) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	ctx := context.Background()
+	if err := p.ensureSchema(ctx); err != nil {
+		return false, err
+	}
+	data, err := secret.ToJSON()
+	if err != nil {
+		return false, err
+	}
+	tag, err := p.pool.Exec(ctx,
+		`INSERT INTO yopass_secrets (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+		WHERE yopass_secrets.expires_at IS NOT NULL AND yopass_secrets.expires_at <= now()`,
+		key, string(data), postgresExpiry(secret.Expiration),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Update atomically applies fn to the value at key. The row is locked with
+// SELECT ... FOR UPDATE for the duration of the transaction, so concurrent
+// updates serialize on the lock instead of retrying; a concurrent delete
+// makes the read find no row and the update reports ErrKeyNotFound.
+func (p *Postgres) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
+	ctx := context.Background()
+	if err := p.ensureSchema(ctx); err != nil {
+		return err
+	}
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var v string
+	err = tx.QueryRow(ctx,
+		`SELECT value FROM yopass_secrets WHERE key = $1 AND `+postgresLive+` FOR UPDATE`, key,
+	).Scan(&v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var s yopass.Secret
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return err
+	}
+	updated, err := fn(s)
+	if err != nil {
+		return err
+	}
+	data, err := updated.ToJSON()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE yopass_secrets SET value = $2, expires_at = $3 WHERE key = $1`,
+		key, string(data), postgresExpiry(updated.Expiration),
+	); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Delete key from PostgreSQL. An expired row that the reaper has not yet
+// removed is deleted too, but reported as not found: to callers it was
+// already gone.
+func (p *Postgres) Delete(key string) (bool, error) {
+	ctx := context.Background()
+	if err := p.ensureSchema(ctx); err != nil {
+		return false, err
+	}
+	var live bool
+	err := p.pool.QueryRow(ctx,
+		`DELETE FROM yopass_secrets WHERE key = $1 RETURNING `+postgresLive, key,
+	).Scan(&live)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return live, nil
+}
+
+// Health checks PostgreSQL connectivity and that the table exists.
+func (p *Postgres) Health() error {
+	ctx := context.Background()
+	if err := p.pool.Ping(ctx); err != nil {
+		return err
+	}
+	return p.ensureSchema(ctx)
+}
+
+// StartPostgresCleanup runs a background goroutine that periodically deletes
+// expired rows. Reads already ignore expired entries; the reaper only keeps
+// the table from growing without bound.
+func StartPostgresCleanup(ctx context.Context, db *Postgres, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanupExpiredPostgres(ctx, db, logger)
+		}
+	}
+}
+
+func cleanupExpiredPostgres(ctx context.Context, db *Postgres, logger *zap.Logger) {
+	if err := db.ensureSchema(ctx); err != nil {
+		logger.Warn("Postgres cleanup: schema unavailable", zap.Error(err))
+		return
+	}
+	tag, err := db.pool.Exec(ctx, `DELETE FROM yopass_secrets WHERE expires_at <= now()`)
+	if err != nil {
+		logger.Warn("Postgres cleanup: failed to delete expired rows", zap.Error(err))
+		return
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		logger.Debug("Cleaned up expired Postgres rows", zap.Int64("count", n))
+	}
+}
+
+var _ Database = (*Postgres)(nil)
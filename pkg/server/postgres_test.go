@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestPostgresUnits(t *testing.T) {
+	t.Run("NewPostgres with invalid URL", func(t *testing.T) {
+		_, err := NewPostgres("postgres://localhost:notaport/yopass")
+		if err == nil {
+			t.Fatal("Expected error for invalid Postgres URL")
+		}
+	})
+
+	t.Run("NewPostgres with valid URL does not connect", func(t *testing.T) {
+		p, err := NewPostgres("postgres://localhost:5432/yopass")
+		if err != nil {
+			t.Fatalf("Expected no error for valid Postgres URL, got: %v", err)
+		}
+		if p.pool == nil {
+			t.Fatal("Pool should be initialized")
+		}
+	})
+
+	t.Run("postgresExpiry", func(t *testing.T) {
+		if postgresExpiry(0) != nil {
+			t.Error("zero expiration must be stored without a TTL")
+		}
+		if postgresExpiry(3600) == nil {
+			t.Error("positive expiration must set expires_at")
+		}
+	})
+}
+
+func newTestPostgres(t *testing.T) *Postgres {
+	t.Helper()
+	postgresURL := os.Getenv("POSTGRES_URL")
+	if postgresURL == "" {
+		t.Skip("Specify POSTGRES_URL env variable to test Postgres database")
+	}
+	p, err := NewPostgres(postgresURL)
+	if err != nil {
+		t.Fatalf("error in NewPostgres(): %v", err)
+	}
+	return p
+}
+
+func TestPostgres(t *testing.T) {
+	p := newTestPostgres(t)
+
+	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
+	secret := yopass.Secret{Message: "foo", OneTime: true, Expiration: 3600}
+
+	if err := p.Put(key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	s, err := p.Status(key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if !s.OneTime {
+		t.Fatal("expected Status() to report OneTime")
+	}
+
+	storedVal, err := p.Get(key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if storedVal.Message != secret.Message {
+		t.Fatalf("expected value %s, got %s", secret.Message, storedVal.Message)
+	}
+
+	if _, err := p.Get(key); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound from Get() of consumed one-time secret, got %v", err)
+	}
+}
+
+func TestPostgresDelete(t *testing.T) {
+	p := newTestPostgres(t)
+	key := "delete-test-" + t.Name()
+
+	if err := p.Put(key, yopass.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	deleted, err := p.Delete(key)
+	if err != nil || !deleted {
+		t.Fatalf("expected first Delete() to remove the key, got %v %v", deleted, err)
+	}
+	deleted, err = p.Delete(key)
+	if err != nil || deleted {
+		t.Fatalf("expected second Delete() to report not found, got %v %v", deleted, err)
+	}
+}
+
+func TestPostgresExpiry(t *testing.T) {
+	p := newTestPostgres(t)
+	key := "expiry-test-" + t.Name()
+
+	if err := p.Put(key, yopass.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	// Backdate the row instead of sleeping through a TTL.
+	if _, err := p.pool.Exec(context.Background(),
+		`UPDATE yopass_secrets SET expires_at = now() - interval '1 second' WHERE key = $1`, key); err != nil {
+		t.Fatalf("failed to backdate row: %v", err)
+	}
+
+	if _, err := p.Status(key); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected expired key to be hidden from Status(), got %v", err)
+	}
+	if _, err := p.Get(key); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected expired key to be hidden from Get(), got %v", err)
+	}
+	if err := p.Update(key, func(s yopass.Secret) (yopass.Secret, error) { return s, nil }); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected expired key to be hidden from Update(), got %v", err)
+	}
+
+	cleanupExpiredPostgres(context.Background(), p, zaptest.NewLogger(t))
+	var n int
+	if err := p.pool.QueryRow(context.Background(),
+		`SELECT count(*) FROM yopass_secrets WHERE key = $1`, key).Scan(&n); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if n != 0 {
+		t.Fatal("expected the reaper to delete the expired row")
+	}
+}
+
+func TestPostgresHealth(t *testing.T) {
+	p := newTestPostgres(t)
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected Health() to succeed, got error: %v", err)
+	}
+
+	bad, err := NewPostgres("postgres://localhost:1/yopass?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("error in NewPostgres(): %v", err)
+	}
+	if err := bad.Health(); err == nil {
+		t.Fatal("expected Health() to fail with invalid Postgres connection")
+	}
+}
+
+func TestPostgresUpdate(t *testing.T) {
+	p := newTestPostgres(t)
+
+	key := "update-test-" + t.Name()
+	defer func() { _, _ = p.Delete(key) }()
+
+	// Update of a missing key reports ErrKeyNotFound
+	if err := p.Update(key, func(s yopass.Secret) (yopass.Secret, error) {
+		return s, nil
+	}); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := p.Put(key, yopass.Secret{Message: "0", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	// An error returned by fn aborts the update without writing
+	abort := errors.New("abort")
+	if err := p.Update(key, func(s yopass.Secret) (yopass.Secret, error) {
+		s.Message = "must not be stored"
+		return s, abort
+	}); err != abort {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+	if s, err := p.Status(key); err != nil || s.Message != "0" {
+		t.Fatalf("aborted update must not write: %v %v", s, err)
+	}
+
+	// Concurrent increments must all land exactly once; the row lock
+	// serializes them, so no caller-side retry is needed.
+	const writers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Update(key, func(s yopass.Secret) (yopass.Secret, error) {
+				n, err := strconv.Atoi(s.Message)
+				if err != nil {
+					return s, err
+				}
+				s.Message = strconv.Itoa(n + 1)
+				return s, nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("error in concurrent Update(): %v", err)
+		}
+	}
+
+	s, err := p.Status(key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if s.Message != strconv.Itoa(writers) {
+		t.Fatalf("lost updates: expected %d, got %s", writers, s.Message)
+	}
+}
+
+func TestPostgresPutIfAbsent(t *testing.T) {
+	p := newTestPostgres(t)
+
+	key := "put-if-absent-test-" + t.Name()
+	defer func() { _, _ = p.Delete(key) }()
+
+	if stored, err := p.PutIfAbsent(key, yopass.Secret{Message: "first", Expiration: 3600}); err != nil || !stored {
+		t.Fatalf("expected first PutIfAbsent() to store, got %v %v", stored, err)
+	}
+	if stored, err := p.PutIfAbsent(key, yopass.Secret{Message: "second", Expiration: 3600}); err != nil || stored {
+		t.Fatalf("expected second PutIfAbsent() not to store, got %v %v", stored, err)
+	}
+	if s, err := p.Status(key); err != nil || s.Message != "first" {
+		t.Fatalf("expected the first value to remain: %v %v", s, err)
+	}
+
+	// An expired row the reaper has not removed yet is replaced.
+	if _, err := p.pool.Exec(context.Background(),
+		`UPDATE yopass_secrets SET expires_at = now() - interval '1 second' WHERE key = $1`, key); err != nil {
+		t.Fatalf("failed to backdate row: %v", err)
+	}
+	if stored, err := p.PutIfAbsent(key, yopass.Secret{Message: "third", Expiration: 3600}); err != nil || !stored {
+		t.Fatalf("expected PutIfAbsent() to replace an expired row, got %v %v", stored, err)
+	}
+	if s, err := p.Status(key); err != nil || s.Message != "third" {
+		t.Fatalf("expected the replacing value: %v %v", s, err)
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Rate limiting gives every client a token bucket per route class. Clients
+// are identified by API token name, OIDC subject or, for anonymous callers,
+// their IP address. Separately, IPs that collect too many 404s on the
+// retrieval routes, as when guessing secret IDs, are banned for a while.
+
+// Route classes with their own rate limit.
+const (
+	RateLimitCreate   = "create"   // creating secrets and files, extending them
+	RateLimitRetrieve = "retrieve" // reading, checking and deleting secrets and files
+	RateLimitRequest  = "request"  // secret requests
+	RateLimitAuth     = "auth"     // OIDC login
+)
+
+// RateLimit allows Requests per Per with bursts of up to Requests. The zero
+// value disables the limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Enabled reports whether l limits anything.
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// ParseRateLimit parses a limit written as <requests>/<period>, such as
+// "30/1m" or "5/s". An empty string or zero requests disable the limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" || s == "0" {
+		return RateLimit{}, nil
+	}
+	n, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected <requests>/<period>, e.g. 30/1m", s)
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: requests must be a non-negative number", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per < time.Second {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: period must be a duration of at least 1s, such as 1m", s)
+	}
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+// RateLimitStore keeps the token buckets, not-found counters and bans.
+// Replicas sharing a store share their limits; *Redis implements it.
+type RateLimitStore interface {
+	// Allow takes a token from the bucket at key. When none is left it
+	// returns false and how long until the next token.
+	Allow(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+	// Count increments the counter at key, which resets window after its
+	// first increment, and returns the new count.
+	Count(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Ban marks key banned for d.
+	Ban(ctx context.Context, key string, d time.Duration) error
+	// Banned returns how long key remains banned, or zero.
+	Banned(ctx context.Context, key string) (time.Duration, error)
+}
+
+// Store keys, namespaced like the other records in the database.
+const (
+	rateLimitKeyPrefix = "ratelimit/"
+	notFoundKeyPrefix  = "notfound/"
+	banKeyPrefix       = "ban/"
+)
+
+// rateLimitEnabled reports whether any rate limit or the not-found ban is
+// configured.
+func (y *Server) rateLimitEnabled() bool {
+	for _, l := range y.RateLimits {
+		if l.Enabled() {
+			return true
+		}
+	}
+	return y.NotFoundBanThreshold > 0
+}
+
+// rateLimitClass returns the class of the route matched for r, or "" for
+// routes that are not limited.
+func rateLimitClass(r *http.Request) string {
+	if r.Method == http.MethodOptions {
+		return ""
+	}
+	path := normalizedPath(r)
+	switch {
+	case strings.HasPrefix(path, "/create/"), strings.HasSuffix(path, "/extend"):
+		return RateLimitCreate
+	case strings.HasPrefix(path, "/request"):
+		return RateLimitRequest
+	case path == "/auth/login", path == "/auth/callback":
+		return RateLimitAuth
+	case strings.HasPrefix(path, "/secret/"), strings.HasPrefix(path, "/file/"):
+		return RateLimitRetrieve
+	}
+	return ""
+}
+
+// rateLimitIdentity names the client a bucket belongs to. Subjects are
+// hashed so they never appear in store keys.
+func (y *Server) rateLimitIdentity(r *http.Request, ip string) string {
+	if s := y.tokenSession(r); s != nil {
+		return "token:" + s.Name
+	}
+	if s, _ := y.getSession(r); s != nil && s.Sub != "" {
+		return "sub:" + hashToken(s.Sub)
+	}
+	return "ip:" + ip
+}
+
+// newRateLimitMiddleware enforces RateLimits and the not-found ban on the
+// classified routes. Store errors are logged and let the request through, so
+// an unavailable store never takes the server down with it.
+func (y *Server) newRateLimitMiddleware(reg prometheus.Registerer) func(http.Handler) http.Handler {
+	store := y.RateLimitStore
+	if store == nil {
+		store = newMemoryRateLimitStore()
+	}
+	limited := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "yopass_rate_limited_requests_total",
+			Help: "Requests rejected by rate limiting, by route class and reason (limit or banned).",
+		},
+		[]string{"class", "reason"},
+	)
+	bans := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yopass_rate_limit_bans_total",
+		Help: "Clients banned for too many not found responses.",
+	})
+	reg.MustRegister(limited, bans)
+
+	reject := func(w http.ResponseWriter, r *http.Request, ip, class string, banned bool, wait time.Duration) {
+		label, auditReason := "limit", class+" rate limit exceeded"
+		if banned {
+			label, auditReason = "banned", "client banned"
+		}
+		limited.WithLabelValues(class, label).Inc()
+		session, _ := y.getSession(r)
+		y.newAuditor("ratelimit.denied", ip, session).denied(auditReason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+		jsonError(w, http.StatusTooManyRequests, "Too many requests")
+	}
+
+	countNotFound := func(ctx context.Context, r *http.Request, ip string) {
+		n, err := store.Count(ctx, notFoundKeyPrefix+ip, y.NotFoundBanWindow)
+		if err != nil {
+			y.Logger.Warn("Unable to count not found response", zap.Error(err))
+			return
+		}
+		if n < int64(y.NotFoundBanThreshold) {
+			return
+		}
+		if err := store.Ban(ctx, banKeyPrefix+ip, y.NotFoundBanDuration); err != nil {
+			y.Logger.Warn("Unable to ban client", zap.Error(err))
+			return
+		}
+		bans.Inc()
+		y.Logger.Warn("Banned client for too many not found responses",
+			zap.String("client_ip", ip), zap.Int64("count", n), zap.Duration("duration", y.NotFoundBanDuration))
+		session, _ := y.getSession(r)
+		y.newAuditor("ratelimit.banned", ip, session).denied("too many not found responses")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := rateLimitClass(r)
+			if class == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			ip := y.getRealClientIP(r)
+
+			if y.NotFoundBanThreshold > 0 {
+				if d, err := store.Banned(ctx, banKeyPrefix+ip); err != nil {
+					y.Logger.Warn("Unable to check client ban", zap.Error(err))
+				} else if d > 0 {
+					reject(w, r, ip, class, true, d)
+					return
+				}
+			}
+			if limit := y.RateLimits[class]; limit.Enabled() {
+				key := rateLimitKeyPrefix + class + "/" + y.rateLimitIdentity(r, ip)
+				if ok, wait, err := store.Allow(ctx, key, limit); err != nil {
+					y.Logger.Warn("Unable to apply rate limit", zap.Error(err))
+				} else if !ok {
+					reject(w, r, ip, class, false, wait)
+					return
+				}
+			}
+
+			if class != RateLimitRetrieve || y.NotFoundBanThreshold <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := statusCodeRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(&rec, r)
+			if rec.statusCode == http.StatusNotFound {
+				countNotFound(ctx, r, ip)
+			}
+		})
+	}
+}
+
+// memoryRateLimitStore keeps the limits of a single instance.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[string]*tokenBucket
+	counters  map[string]*windowCounter
+	bans      map[string]time.Time
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+type windowCounter struct {
+	count   int64
+	expires time.Time
+}
+
+// memorySweepInterval is how often idle buckets and expired counters and bans
+// are dropped.
+const memorySweepInterval = time.Minute
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		now:      time.Now,
+		buckets:  map[string]*tokenBucket{},
+		counters: map[string]*windowCounter{},
+		bans:     map[string]time.Time{},
+	}
+}
+
+func (m *memoryRateLimitStore) Allow(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.sweep()
+	capacity := float64(limit.Requests)
+	rate := capacity / float64(limit.Per)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated, b.per = now, limit.Per
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration(math.Ceil((1 - b.tokens) / rate)), nil
+}
+
+func (m *memoryRateLimitStore) Count(_ context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.sweep()
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &windowCounter{expires: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+func (m *memoryRateLimitStore) Ban(_ context.Context, key string, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[key] = m.sweep().Add(d)
+	return nil
+}
+
+func (m *memoryRateLimitStore) Banned(_ context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d := m.bans[key].Sub(m.sweep()); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
+
+// sweep drops state that no longer matters once per memorySweepInterval and
+// returns the current time. A bucket idle for its whole period is full again,
+// which is what a missing bucket means. Callers hold m.mu.
+func (m *memoryRateLimitStore) sweep() time.Time {
+	now := m.now()
+	if now.Sub(m.lastSweep) < memorySweepInterval {
+		return now
+	}
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if now.Sub(b.updated) >= b.per {
+			delete(m.buckets, k)
+		}
+	}
+	for k, c := range m.counters {
+		if !now.Before(c.expires) {
+			delete(m.counters, k)
+		}
+	}
+	for k, t := range m.bans {
+		if !now.Before(t) {
+			delete(m.bans, k)
+		}
+	}
+	return now
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis implements RateLimitStore so every replica sharing the database
+// shares its limits. Each operation touches a single key, which keeps the
+// scripts valid in cluster mode.
+
+// tokenBucketScript takes a token from the bucket at KEYS[1] holding up to
+// ARGV[1] tokens that refill completely over ARGV[2] milliseconds. It
+// returns 0 when a token was taken, or else the milliseconds until the next
+// one. The server clock is used so replicas with skewed clocks agree.
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local capacity = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local rate = capacity / per
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or capacity
+local ts = tonumber(b[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], per)
+return wait
+`)
+
+// windowCountScript increments the counter at KEYS[1], starting its window
+// of ARGV[1] milliseconds on the first increment.
+var windowCountScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Allow implements RateLimitStore.
+func (r *Redis) Allow(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	wait, err := tokenBucketScript.Run(ctx, r.client, []string{key}, limit.Requests, limit.Per.Milliseconds()).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return wait == 0, time.Duration(wait) * time.Millisecond, nil
+}
+
+// Count implements RateLimitStore.
+func (r *Redis) Count(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return windowCountScript.Run(ctx, r.client, []string{key}, window.Milliseconds()).Int64()
+}
+
+// Ban implements RateLimitStore.
+func (r *Redis) Ban(ctx context.Context, key string, d time.Duration) error {
+	return r.client.Set(ctx, key, 1, d).Err()
+}
+
+// Banned implements RateLimitStore.
+func (r *Redis) Banned(ctx context.Context, key string) (time.Duration, error) {
+	d, err := r.client.PTTL(ctx, key).Result()
+	if err != nil || d < 0 {
+		// PTTL reports a missing key as a negative duration.
+		return 0, err
+	}
+	return d, nil
+}
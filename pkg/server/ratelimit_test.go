@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimit
+		wantErr bool
+	}{
+		{in: "", want: RateLimit{}},
+		{in: "0", want: RateLimit{}},
+		{in: "30/1m", want: RateLimit{Requests: 30, Per: time.Minute}},
+		{in: "5/s", want: RateLimit{Requests: 5, Per: time.Second}},
+		{in: "100/2h", want: RateLimit{Requests: 100, Per: 2 * time.Hour}},
+		{in: "30", wantErr: true},
+		{in: "-1/m", wantErr: true},
+		{in: "x/m", wantErr: true},
+		{in: "5/soon", wantErr: true},
+		{in: "5/10ms", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseRateLimit(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	m := newMemoryRateLimitStore()
+	m.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 2, Per: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := m.Allow(ctx, "a", limit); !ok {
+			t.Fatalf("request %d within the burst was limited", i)
+		}
+	}
+	ok, wait, _ := m.Allow(ctx, "a", limit)
+	if ok || wait != 30*time.Second {
+		t.Fatalf("expected a 30s wait after the burst, got %v %v", ok, wait)
+	}
+	if ok, _, _ := m.Allow(ctx, "b", limit); !ok {
+		t.Error("buckets must be independent")
+	}
+	now = now.Add(30 * time.Second)
+	if ok, _, _ := m.Allow(ctx, "a", limit); !ok {
+		t.Error("a token should have been refilled")
+	}
+
+	if n, _ := m.Count(ctx, "c", time.Minute); n != 1 {
+		t.Errorf("first count: got %d", n)
+	}
+	if n, _ := m.Count(ctx, "c", time.Minute); n != 2 {
+		t.Errorf("second count: got %d", n)
+	}
+	now = now.Add(time.Minute)
+	if n, _ := m.Count(ctx, "c", time.Minute); n != 1 {
+		t.Errorf("count after the window: got %d", n)
+	}
+
+	m.Ban(ctx, "d", time.Minute)
+	if d, _ := m.Banned(ctx, "d"); d != time.Minute {
+		t.Errorf("ban: got %v", d)
+	}
+	now = now.Add(2 * time.Minute)
+	if d, _ := m.Banned(ctx, "d"); d != 0 {
+		t.Errorf("expired ban: got %v", d)
+	}
+	if len(m.buckets) != 0 || len(m.counters) != 0 || len(m.bans) != 0 {
+		t.Errorf("idle state should be swept: %d buckets, %d counters, %d bans", len(m.buckets), len(m.counters), len(m.bans))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+	srv.APITokens, _ = ParseAPITokens([]string{"ci:" + strings.Repeat("c", 32)})
+	srv.RateLimits = map[string]RateLimit{RateLimitCreate: {Requests: 2, Per: time.Hour}}
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+
+	create := func(remoteAddr, token, sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/create/secret", strings.NewReader(`{"message": "`+strings.ReplaceAll(pgpTestMessage, "\n", "\\n")+`", "expiration": 3600}`))
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if sub != "" {
+			signedIn(t, &srv, req, sub)
+		}
+		return serve(handler, req)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := create("192.0.2.1:1234", "", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+	rr := create("192.0.2.1:5678", "", "")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1800" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	last := audit.events[len(audit.events)-1]
+	if last.Event != "ratelimit.denied" || last.Outcome != OutcomeDenied || last.Error != "create rate limit exceeded" {
+		t.Errorf("unexpected audit event %+v", last)
+	}
+
+	// Other IPs, signed-in users and API tokens have their own buckets.
+	if rr := create("192.0.2.2:1234", "", ""); rr.Code != http.StatusOK {
+		t.Errorf("other IP: expected 200, got %d", rr.Code)
+	}
+	if rr := create("192.0.2.1:1234", "", "alice"); rr.Code != http.StatusOK {
+		t.Errorf("signed-in user: expected 200, got %d", rr.Code)
+	}
+	if rr := create("192.0.2.1:1234", strings.Repeat("c", 32), ""); rr.Code != http.StatusOK {
+		t.Errorf("API token: expected 200, got %d", rr.Code)
+	}
+
+	// Other route classes are not affected.
+	if rr := serve(handler, httptest.NewRequest("GET", "/secret/"+contractTestID, nil)); rr.Code == http.StatusTooManyRequests {
+		t.Error("retrieval should not share the create limit")
+	}
+	if got := counterValue(t, &srv, "yopass_rate_limited_requests_total"); got != 1 {
+		t.Errorf("expected one limited request in the metrics, got %v", got)
+	}
+}
+
+func TestNotFoundBan(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	srv.NotFoundBanThreshold = 3
+	srv.NotFoundBanWindow = time.Minute
+	srv.NotFoundBanDuration = time.Hour
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+	id := createAs(t, &srv, handler, "", nil)
+
+	get := func(remoteAddr, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		return serve(handler, req).Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := get("198.51.100.7:1", "/secret/"+strings.Repeat("x", 22)); code != http.StatusNotFound {
+			t.Fatalf("probe %d: expected 404, got %d", i, code)
+		}
+	}
+	last := audit.events[len(audit.events)-1]
+	if last.Event != "ratelimit.banned" || last.ClientIP != "198.51.100.7" {
+		t.Errorf("expected the ban to be audited, got %+v", last)
+	}
+	if code := get("198.51.100.7:2", "/secret/"+id); code != http.StatusTooManyRequests {
+		t.Errorf("banned IP: expected 429, got %d", code)
+	}
+	if code := get("198.51.100.8:1", "/secret/"+id); code != http.StatusOK {
+		t.Errorf("other IP: expected 200, got %d", code)
+	}
+	// The ban covers the retrieval routes, not the rest of the server.
+	if code := get("198.51.100.7:3", "/config"); code != http.StatusOK {
+		t.Errorf("config for banned IP: expected 200, got %d", code)
+	}
+	if got := counterValue(t, &srv, "yopass_rate_limit_bans_total"); got != 1 {
+		t.Errorf("expected one ban in the metrics, got %v", got)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+	srv.HTTPHandler()
+	families, _ := srv.Registry.Gather()
+	for _, f := range families {
+		if strings.HasPrefix(f.GetName(), "yopass_rate_limit") {
+			t.Errorf("unexpected metric %s without rate limits", f.GetName())
+		}
+	}
+}
+
+// counterValue sums the counter name across its labels in the server's
+// registry.
+func counterValue(t *testing.T, srv *Server, name string) float64 {
+	t.Helper()
+	families, err := srv.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			var sum float64
+			for _, m := range f.GetMetric() {
+				sum += m.GetCounter().GetValue()
+			}
+			return sum
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test the Redis rate limit store")
+	}
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := rateLimitKeyPrefix + "test/" + time.Now().Format(time.RFC3339Nano)
+	limit := RateLimit{Requests: 2, Per: time.Minute}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := r.Allow(ctx, key, limit); !ok || err != nil {
+			t.Fatalf("request %d within the burst: %v %v", i, ok, err)
+		}
+	}
+	if ok, wait, err := r.Allow(ctx, key, limit); ok || err != nil || wait <= 0 || wait > 30*time.Second {
+		t.Fatalf("expected a wait after the burst, got %v %v %v", ok, wait, err)
+	}
+	if n, err := r.Count(ctx, key+"/count", time.Minute); n != 1 || err != nil {
+		t.Errorf("count: %d %v", n, err)
+	}
+	if d, err := r.Banned(ctx, key+"/ban"); d != 0 || err != nil {
+		t.Errorf("not banned: %v %v", d, err)
+	}
+	r.Ban(ctx, key+"/ban", time.Minute)
+	if d, err := r.Banned(ctx, key+"/ban"); d <= 0 || err != nil {
+		t.Errorf("banned: %v %v", d, err)
+	}
+}
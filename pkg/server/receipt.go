@@ -37,6 +37,14 @@ type secretReceipt struct {
 	CreatedAt int64  `json:"created_at"`
 	ViewedAt  int64  `json:"viewed_at,omitempty"`
 	ExpiresAt int64  `json:"expires_at"`
+	// MaxViews and Views track view-limited secrets, whose receipt records
+	// every retrieval; LastViewedAt is the most recent one.
+	MaxViews     int32 `json:"max_views,omitempty"`
+	Views        int32 `json:"views,omitempty"`
+	LastViewedAt int64 `json:"last_viewed_at,omitempty"`
+	// Owner is the creator index of a signed-in creator, letting an API
+	// token with ScopeReadReceipt check the receipts of its own secrets.
+	Owner string `json:"owner,omitempty"`
 }
 
 // remainingTTL returns the number of seconds until the receipt expires,
@@ -59,9 +67,10 @@ func (y *Server) readReceiptsEnabled() bool {
 	return y.License.CurrentlyValid() && !y.DisableReadReceipts
 }
 
-// createReceipt stores a pending read receipt for the secret with the given
-// key and returns the receipt token. The receipt shares the secret's TTL.
-func (y *Server) createReceipt(id string, oneTime bool, expiration int32) (string, error) {
+// createReceipt stores a pending read receipt for the secret s stored under
+// the given key and returns the receipt token. The receipt shares the
+// secret's TTL.
+func (y *Server) createReceipt(id string, s yopass.Secret, owner string) (string, error) {
 	token, tokenHash, err := generateToken()
 	if err != nil {
 		return "", err
@@ -70,9 +79,11 @@ func (y *Server) createReceipt(id string, oneTime bool, expiration int32) (strin
 	r := secretReceipt{
 		TokenHash: tokenHash,
 		State:     ReceiptStatePending,
-		OneTime:   oneTime,
+		OneTime:   s.OneTime,
+		MaxViews:  s.MaxViews,
 		CreatedAt: now.Unix(),
-		ExpiresAt: now.Unix() + int64(expiration),
+		ExpiresAt: now.Unix() + int64(s.Expiration),
+		Owner:     owner,
 	}
 	data, err := json.Marshal(r)
 	if err != nil {
@@ -80,7 +91,7 @@ func (y *Server) createReceipt(id string, oneTime bool, expiration int32) (strin
 	}
 	err = y.DB.Put(receiptKeyPrefix+id, yopass.Secret{
 		Message:    string(data),
-		Expiration: expiration,
+		Expiration: s.Expiration,
 	})
 	if err != nil {
 		return "", err
@@ -108,7 +119,8 @@ func (y *Server) loadReceipt(id string) (secretReceipt, bool) {
 // change (missing, invalid, expired or already viewed receipt).
 var errReceiptUnchanged = errors.New("receipt unchanged")
 
-// markReceiptViewed flags a pending receipt as viewed. It is best-effort and
+// markReceiptViewed flags a pending receipt as viewed, or counts another view
+// on the receipt of a view-limited secret. It is best-effort and
 // runs on every successful secret access regardless of feature gating so that
 // receipts work in split deployments where retrieval happens on a read-only
 // instance: errors are logged but never fail secret delivery. The update uses
@@ -123,11 +135,18 @@ func (y *Server) markReceiptViewed(id string) {
 		// Compute the TTL once: a zero expiration means "never expire" to the
 		// backends, which would persist an expired receipt indefinitely.
 		ttl := r.remainingTTL()
-		if ttl == 0 || r.State == ReceiptStateViewed {
+		if ttl == 0 || (r.State == ReceiptStateViewed && r.MaxViews == 0) {
 			return s, errReceiptUnchanged
 		}
-		r.State = ReceiptStateViewed
-		r.ViewedAt = time.Now().Unix()
+		now := time.Now().Unix()
+		if r.State != ReceiptStateViewed {
+			r.State = ReceiptStateViewed
+			r.ViewedAt = now
+		}
+		if r.MaxViews > 0 {
+			r.Views++
+			r.LastViewedAt = now
+		}
 		data, err := json.Marshal(r)
 		if err != nil {
 			return s, err
@@ -142,12 +161,41 @@ func (y *Server) markReceiptViewed(id string) {
 	}
 }
 
+// extendReceipt moves a receipt's expiry along with an extended secret. Like
+// markReceiptViewed it is best-effort: errors are logged, and a secret
+// without a receipt is not an error.
+func (y *Server) extendReceipt(id string, expiresAt int64) {
+	err := y.DB.Update(receiptKeyPrefix+id, func(s yopass.Secret) (yopass.Secret, error) {
+		var r secretReceipt
+		if err := json.Unmarshal([]byte(s.Message), &r); err != nil || r.TokenHash == "" {
+			return s, errReceiptUnchanged
+		}
+		r.ExpiresAt = expiresAt
+		data, err := json.Marshal(r)
+		if err != nil {
+			return s, err
+		}
+		return yopass.Secret{
+			Message:    string(data),
+			Expiration: r.remainingTTL(),
+		}, nil
+	})
+	if err != nil && !errors.Is(err, errReceiptUnchanged) && !errors.Is(err, ErrKeyNotFound) {
+		y.Logger.Error("Unable to extend read receipt", zap.Error(err))
+	}
+}
+
 // getSecretReceipt returns the read receipt state for a secret. Requires the
-// receipt token returned at secret creation time.
+// receipt token returned at secret creation time, or the API token that
+// created the secret when it holds ScopeReadReceipt.
 func (y *Server) getSecretReceipt(w http.ResponseWriter, request *http.Request) {
 	w.Header().Set("Cache-Control", "private, no-cache")
 	id := mux.Vars(request)["key"]
-	audit := y.newAuditor("secret.receipt_checked", y.getRealClientIP(request), nil)
+	var session *sessionData
+	if s := y.tokenSession(request); s != nil && s.hasScope(ScopeReadReceipt) {
+		session = s
+	}
+	audit := y.newAuditor("secret.receipt_checked", y.getRealClientIP(request), session)
 	audit.setSecretID(id)
 
 	r, ok := y.loadReceipt(id)
@@ -157,7 +205,8 @@ func (y *Server) getSecretReceipt(w http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	if !r.tokenValid(request.Header.Get(receiptTokenHeader)) {
+	ownToken := session != nil && r.Owner != "" && r.Owner == creatorOwner(session)
+	if !ownToken && !r.tokenValid(request.Header.Get(receiptTokenHeader)) {
 		audit.denied("invalid receipt token")
 		jsonError(w, http.StatusUnauthorized, "Invalid receipt token")
 		return
@@ -173,5 +222,12 @@ func (y *Server) getSecretReceipt(w http.ResponseWriter, request *http.Request)
 	if r.ViewedAt != 0 {
 		resp["viewed_at"] = r.ViewedAt
 	}
+	if r.MaxViews > 0 {
+		resp["max_views"] = r.MaxViews
+		resp["views"] = r.Views
+		if r.LastViewedAt != 0 {
+			resp["last_viewed_at"] = r.LastViewedAt
+		}
+	}
 	y.writeJSON(w, http.StatusOK, resp)
 }
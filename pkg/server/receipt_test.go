@@ -466,3 +466,52 @@ func TestReadReceiptAuditTrail(t *testing.T) {
 		}
 	}
 }
+
+func TestReadReceiptCountsViews(t *testing.T) {
+	db := newMemoryDB()
+	y := newRequestTestServer(t, db, true)
+	handler := y.HTTPHandler()
+
+	encrypted, err := yopass.Encrypt(strings.NewReader("hunter2"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"message":    encrypted,
+		"expiration": 3600,
+		"max_views":  3,
+		"receipt":    true,
+	})
+	req, _ := http.NewRequest("POST", "/create/secret", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create secret: status %d body %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Message      string `json:"message"`
+		ReceiptToken string `json:"receipt_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	for views := 1; views <= 2; views++ {
+		req, _ := http.NewRequest("GET", "/secret/"+created.Message, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("view %d: status %d", views, rr.Code)
+		}
+		_, receipt := getReceipt(t, handler, created.Message, created.ReceiptToken)
+		if receipt["state"] != ReceiptStateViewed {
+			t.Errorf("view %d: expected viewed receipt, got %v", views, receipt["state"])
+		}
+		if receipt["views"] != float64(views) || receipt["max_views"] != float64(3) {
+			t.Errorf("view %d: unexpected view counts in %v", views, receipt)
+		}
+		if receipt["last_viewed_at"] == nil || receipt["viewed_at"] == nil {
+			t.Errorf("view %d: expected view timestamps in %v", views, receipt)
+		}
+	}
+}
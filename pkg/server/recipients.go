@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// A restricted secret can only be retrieved by signed-in users whose email is
+// one of its AllowedRecipients or who belong to one of its AllowedGroups.
+// The group membership comes from an OIDC claim read once at login.
+
+// DefaultOIDCGroupsClaim is the claim read for group membership when
+// Server.OIDCGroupsClaim is not set.
+const DefaultOIDCGroupsClaim = "groups"
+
+// Headers naming the recipients of a file upload, as comma-separated lists.
+const (
+	allowedRecipientsHeader = "X-Yopass-AllowedRecipients"
+	allowedGroupsHeader     = "X-Yopass-AllowedGroups"
+)
+
+// maxAllowedRecipients bounds each of the recipient and group lists of a
+// secret.
+const maxAllowedRecipients = 100
+
+// maxSessionGroups bounds the groups kept in the session cookie, which
+// browsers cap at about 4KB.
+const maxSessionGroups = 50
+
+func (y *Server) oidcGroupsClaim() string {
+	if y.OIDCGroupsClaim == "" {
+		return DefaultOIDCGroupsClaim
+	}
+	return y.OIDCGroupsClaim
+}
+
+// sessionGroups returns the groups claim of a login, taken from the userinfo
+// response or, when the provider only puts it in the ID token, from there.
+func (y *Server) sessionGroups(tokens *oidc.Tokens[*oidc.IDTokenClaims], info *oidc.UserInfo) []string {
+	claim := y.oidcGroupsClaim()
+	groups := claimStrings(info.Claims[claim])
+	if len(groups) == 0 && tokens != nil && tokens.IDTokenClaims != nil {
+		groups = claimStrings(tokens.IDTokenClaims.Claims[claim])
+	}
+	if len(groups) > maxSessionGroups {
+		y.Logger.Warn("OIDC groups claim truncated for the session cookie")
+		groups = groups[:maxSessionGroups]
+	}
+	return groups
+}
+
+// claimStrings reads a claim holding either a list of strings or a single
+// string.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// headerList splits a comma-separated header into its non-empty values.
+func headerList(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range strings.Split(r.Header.Get(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// normalizeRecipients lowercases and deduplicates the recipient emails and
+// deduplicates the groups, which are matched case-sensitively like the
+// claim. Naming recipients implies RequireAuth.
+func (p *creationPolicy) normalizeRecipients() {
+	p.allowedRecipients = uniqueValues(p.allowedRecipients, strings.ToLower)
+	p.allowedGroups = uniqueValues(p.allowedGroups, func(s string) string { return s })
+	if len(p.allowedRecipients) > 0 || len(p.allowedGroups) > 0 {
+		p.requireAuth = true
+	}
+}
+
+func uniqueValues(values []string, normalize func(string) string) []string {
+	var out []string
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = normalize(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// validRecipients reports whether the recipient lists of p are within bounds
+// and every recipient is an email address.
+func (p creationPolicy) validRecipients() bool {
+	if len(p.allowedRecipients) > maxAllowedRecipients || len(p.allowedGroups) > maxAllowedRecipients {
+		return false
+	}
+	for _, email := range p.allowedRecipients {
+		local, domain, ok := strings.Cut(email, "@")
+		if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRecipient returns the identity through which session may retrieve a
+// restricted secret, as "email:<address>" or "group:<name>", or "" when the
+// session matches neither list.
+func matchRecipient(secret yopass.Secret, session *sessionData) string {
+	if session == nil {
+		return ""
+	}
+	for _, email := range secret.AllowedRecipients {
+		if session.Email != "" && strings.EqualFold(session.Email, email) {
+			return "email:" + strings.ToLower(session.Email)
+		}
+	}
+	for _, group := range secret.AllowedGroups {
+		for _, g := range session.Groups {
+			if g == group {
+				return "group:" + group
+			}
+		}
+	}
+	return ""
+}
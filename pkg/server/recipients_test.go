@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// recipientRequest sends a request carrying a session for email and groups,
+// or no session when email is empty.
+func recipientRequest(t *testing.T, srv *Server, handler http.Handler, method, path, email string, groups ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if email != "" {
+		w := httptest.NewRecorder()
+		if err := srv.setSession(w, req, &sessionData{Sub: email, Email: email, Groups: groups}); err != nil {
+			t.Fatalf("setSession: %v", err)
+		}
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRestrictedSecret(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	srv.PrefetchSecret = true
+	audit := &capturingAuditLogger{}
+	srv.Audit = audit
+	handler := srv.HTTPHandler()
+	id, _ := createManagedSecret(t, handler, map[string]interface{}{
+		"allowed_recipients": []string{" Alice@Example.com", "alice@example.com", ""},
+		"allowed_groups":     []string{"ops"},
+	})
+
+	stored, _ := db.Get(id)
+	if !stored.RequireAuth || len(stored.AllowedRecipients) != 1 || stored.AllowedRecipients[0] != "alice@example.com" {
+		t.Fatalf("expected normalized recipients implying RequireAuth, got %+v", stored)
+	}
+
+	rr := recipientRequest(t, &srv, handler, "GET", "/secret/"+id+"/status", "")
+	if !strings.Contains(rr.Body.String(), `"restricted":true`) || strings.Contains(rr.Body.String(), "alice") {
+		t.Errorf("status should report the restriction without the recipients: %s", rr.Body.String())
+	}
+	rr = recipientRequest(t, &srv, handler, "GET", "/secret/"+id+"/status", "bob@example.com")
+	if !strings.Contains(rr.Body.String(), `"recipientAllowed":false`) {
+		t.Errorf("status should tell the viewer they are not a recipient: %s", rr.Body.String())
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		groups   []string
+		wantCode int
+		match    string
+	}{
+		{"no session", "", nil, http.StatusUnauthorized, ""},
+		{"other user", "bob@example.com", []string{"dev"}, http.StatusForbidden, ""},
+		{"named recipient", "ALICE@example.com", nil, http.StatusOK, "email:alice@example.com"},
+		{"group member", "carol@example.com", []string{"dev", "ops"}, http.StatusOK, "group:ops"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			audit.events = nil
+			rr := recipientRequest(t, &srv, handler, "GET", "/secret/"+id, tc.email, tc.groups...)
+			if rr.Code != tc.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusOK && strings.Contains(rr.Body.String(), "allowed_") {
+				t.Errorf("recipient lists must not be served: %s", rr.Body.String())
+			}
+			last := audit.events[len(audit.events)-1]
+			if last.MatchedRecipient != tc.match {
+				t.Errorf("expected matched recipient %q, got %+v", tc.match, last)
+			}
+			if tc.wantCode == http.StatusForbidden && last.Error != "not an allowed recipient" {
+				t.Errorf("unexpected denial reason %q", last.Error)
+			}
+		})
+	}
+}
+
+func TestRestrictedSecretValidation(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+	handler := srv.HTTPHandler()
+	for _, recipients := range [][]string{{"not-an-email"}, {"a@b@c"}, make([]string, maxAllowedRecipients+1)} {
+		for i := range recipients {
+			if recipients[i] == "" {
+				recipients[i] = strings.Repeat("x", i+1) + "@example.com"
+			}
+		}
+		body, _ := json.Marshal(map[string]interface{}{"message": contractPGPMessage, "expiration": 3600, "allowed_recipients": recipients})
+		rr := manageRequest(handler, "POST", "/create/secret", "", string(body))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Allowed recipients") {
+			t.Errorf("%d recipients: expected 400, got %d %s", len(recipients), rr.Code, rr.Body.String())
+		}
+	}
+
+	// Restrictions need OIDC, like RequireAuth.
+	y := newRequestTestServer(t, newMemoryDB(), true)
+	body, _ := json.Marshal(map[string]interface{}{"message": contractPGPMessage, "expiration": 3600, "allowed_groups": []string{"ops"}})
+	if rr := manageRequest(y.HTTPHandler(), "POST", "/create/secret", "", string(body)); rr.Code != http.StatusBadRequest {
+		t.Errorf("without OIDC: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestRestrictedFile(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	srv.PrefetchSecret = true
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("restricted"), "3600", "false", "")
+	req.Header.Set(allowedRecipientsHeader, "alice@example.com, bob@example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	id := created["message"]
+
+	if rr := recipientRequest(t, &srv, handler, "GET", "/file/"+id+"/status", "bob@example.com"); !strings.Contains(rr.Body.String(), `"recipientAllowed":true`) {
+		t.Errorf("status should tell the viewer they are a recipient: %s", rr.Body.String())
+	}
+	if rr := recipientRequest(t, &srv, handler, "GET", "/file/"+id, "carol@example.com"); rr.Code != http.StatusForbidden {
+		t.Fatalf("other user: expected 403, got %d", rr.Code)
+	}
+	if rr := recipientRequest(t, &srv, handler, "DELETE", "/file/"+id, "carol@example.com"); rr.Code != http.StatusForbidden {
+		t.Fatalf("other user deleting: expected 403, got %d", rr.Code)
+	}
+	if rr := recipientRequest(t, &srv, handler, "GET", "/file/"+id, "bob@example.com"); rr.Code != http.StatusOK || rr.Body.String() != pgpBody("restricted") {
+		t.Fatalf("recipient: got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSessionGroups(t *testing.T) {
+	srv := newServerWithOIDC(t, newTestDB())
+
+	info := &oidc.UserInfo{}
+	info.AppendClaims("groups", []interface{}{"ops", 42, "", "dev"})
+	if got := srv.sessionGroups(nil, info); strings.Join(got, ",") != "ops,dev" {
+		t.Errorf("userinfo groups: got %v", got)
+	}
+
+	// Providers that only put the groups in the ID token, under a custom
+	// claim name holding a single string.
+	srv.OIDCGroupsClaim = "roles"
+	tokens := &oidc.Tokens[*oidc.IDTokenClaims]{IDTokenClaims: &oidc.IDTokenClaims{Claims: map[string]interface{}{"roles": "admins"}}}
+	if got := srv.sessionGroups(tokens, &oidc.UserInfo{}); len(got) != 1 || got[0] != "admins" {
+		t.Errorf("ID token groups: got %v", got)
+	}
+}
@@ -3,24 +3,70 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/jhaals/yopass/pkg/yopass"
 	"github.com/redis/go-redis/v9"
 )
 
-// NewRedis returns a new Redis database client
+// NewRedis returns a new Redis database client. Besides a plain redis:// or
+// rediss:// URL it accepts two topologies, selected by scheme:
+//
+//	redis-sentinel://[user:pass@]sentinel1:26379/0?master_name=mymaster&addr=sentinel2:26379
+//	redis-cluster://[user:pass@]node1:6379?addr=node2:6379&addr=node3:6379
+//
+// For Sentinel the userinfo authenticates against the sentinels; the master's
+// credentials go in the username and password query parameters. Both
+// variants also come in a TLS flavour (rediss-sentinel://, rediss-cluster://).
 func NewRedis(url string) (*Redis, error) {
+	scheme, rest, _ := strings.Cut(url, "://")
+	switch scheme {
+	case "redis-sentinel", "rediss-sentinel":
+		options, err := redis.ParseFailoverURL(strings.TrimSuffix(scheme, "-sentinel") + "://" + rest)
+		if err != nil {
+			return nil, err
+		}
+		if options.MasterName == "" {
+			return nil, errors.New("redis: sentinel URL requires the master_name parameter")
+		}
+		r := &Redis{client: redis.NewFailoverClient(options), masterName: options.MasterName}
+		for _, addr := range options.SentinelAddrs {
+			r.sentinels = append(r.sentinels, redis.NewSentinelClient(&redis.Options{
+				Addr:        addr,
+				Username:    options.SentinelUsername,
+				Password:    options.SentinelPassword,
+				TLSConfig:   options.TLSConfig,
+				DialTimeout: options.DialTimeout,
+			}))
+		}
+		return r, nil
+	case "redis-cluster", "rediss-cluster":
+		options, err := redis.ParseClusterURL(strings.TrimSuffix(scheme, "-cluster") + "://" + rest)
+		if err != nil {
+			return nil, err
+		}
+		return &Redis{client: redis.NewClusterClient(options)}, nil
+	}
 	options, err := redis.ParseURL(url)
 	if err != nil {
 		return nil, err
 	}
-	return &Redis{redis.NewClient(options)}, nil
+	return &Redis{client: redis.NewClient(options)}, nil
 }
 
-// Redis client
+// Redis client. client is a single-node, Sentinel-managed or cluster client;
+// every operation touches one key, so all three behave the same apart from
+// Health.
 type Redis struct {
-	client *redis.Client
+	client redis.UniversalClient
+
+	// Set for Sentinel deployments, used by Health to inspect replicas.
+	masterName string
+	sentinels  []*redis.SentinelClient
 }
 
 // Status returns secret metadata without deleting it (safe for one-time secrets).
@@ -77,12 +123,29 @@ func (r *Redis) Put(key string, secret yopass.Secret) error {
 	).Err()
 }
 
+// PutIfAbsent stores key in Redis with SET NX.
+func (r *Redis) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	data, err := secret.ToJSON()
+	if err != nil {
+		return false, err
+	}
+	return r.client.SetNX(
+		context.Background(),
+		key,
+		data,
+		time.Duration(secret.Expiration)*time.Second,
+	).Result()
+}
+
 // updateRetries bounds the number of attempts an Update makes when it loses a
 // compare-and-swap race before giving up.
 const updateRetries = 5
 
 // Update atomically applies fn to the value at key using an optimistic
-// WATCH/MULTI/EXEC transaction, retrying on contention.
+// WATCH/MULTI/EXEC transaction, retrying on contention. In cluster mode the
+// transaction runs on the node owning the key's slot; the client follows
+// MOVED/ASK redirects during resharding by restarting the transaction, so
+// the guarantee holds whichever shard the key lands on.
 func (r *Redis) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	ctx := context.Background()
 	var lastErr error
@@ -130,7 +193,115 @@ func (r *Redis) Delete(key string) (bool, error) {
 	return res == 1, nil
 }
 
-// Health checks Redis connectivity using PING command
+// Health checks Redis connectivity using PING. For Sentinel and cluster
+// deployments it also inspects the replication topology: failed replicas are
+// reported as a *DegradedError, while an unreachable master or a cluster
+// that cannot serve all slots is a plain error.
 func (r *Redis) Health() error {
-	return r.client.Ping(context.Background()).Err()
+	ctx := context.Background()
+	switch c := r.client.(type) {
+	case *redis.ClusterClient:
+		return clusterHealth(ctx, c)
+	default:
+		if err := r.client.Ping(ctx).Err(); err != nil {
+			return err
+		}
+		if len(r.sentinels) > 0 {
+			return r.sentinelHealth(ctx)
+		}
+		return nil
+	}
+}
+
+// sentinelHealth asks the first reachable sentinel for the master's replicas.
+// The master already answered PING, so an unreachable sentinel quorum only
+// degrades failover, not service.
+func (r *Redis) sentinelHealth(ctx context.Context) error {
+	var lastErr error
+	for _, sentinel := range r.sentinels {
+		replicas, err := sentinel.Replicas(ctx, r.masterName).Result()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if problems := sentinelReplicaProblems(replicas); len(problems) > 0 {
+			return &DegradedError{Reason: strings.Join(problems, "; ")}
+		}
+		return nil
+	}
+	return &DegradedError{Reason: fmt.Sprintf("no sentinel reachable: %v", lastErr)}
+}
+
+// sentinelReplicaProblems lists replicas that SENTINEL REPLICAS reports as
+// down or disconnected from the master.
+func sentinelReplicaProblems(replicas []map[string]string) []string {
+	if len(replicas) == 0 {
+		return []string{"no replicas"}
+	}
+	var problems []string
+	for _, replica := range replicas {
+		addr := net.JoinHostPort(replica["ip"], replica["port"])
+		for _, flag := range strings.Split(replica["flags"], ",") {
+			if flag == "s_down" || flag == "o_down" || flag == "disconnected" {
+				problems = append(problems, fmt.Sprintf("replica %s is %s", addr, flag))
+			}
+		}
+		if status, ok := replica["master-link-status"]; ok && status != "ok" {
+			problems = append(problems, fmt.Sprintf("replica %s master link is %s", addr, status))
+		}
+	}
+	return problems
+}
+
+// clusterHealth requires every slot to be served and every master to answer
+// PING; failed nodes that do not leave slots uncovered are reported as
+// degraded.
+func clusterHealth(ctx context.Context, c *redis.ClusterClient) error {
+	info, err := c.ClusterInfo(ctx).Result()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(info, "cluster_state:ok") {
+		return errors.New("redis cluster state is not ok")
+	}
+	if err := c.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return node.Ping(ctx).Err()
+	}); err != nil {
+		return err
+	}
+	nodes, err := c.ClusterNodes(ctx).Result()
+	if err != nil {
+		return err
+	}
+	if problems := clusterNodeProblems(nodes); len(problems) > 0 {
+		return &DegradedError{Reason: strings.Join(problems, "; ")}
+	}
+	return nil
+}
+
+// clusterNodeProblems lists nodes that CLUSTER NODES flags as failing or
+// disconnected. Each line is "<id> <addr> <flags> <master> <ping> <pong>
+// <epoch> <link-state> <slots...>".
+func clusterNodeProblems(nodes string) []string {
+	var problems []string
+	for _, line := range strings.Split(strings.TrimSpace(nodes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		addr, _, _ := strings.Cut(fields[1], "@")
+		role := "replica"
+		if strings.Contains(fields[2], "master") {
+			role = "master"
+		}
+		for _, flag := range strings.Split(fields[2], ",") {
+			if flag == "fail" || flag == "fail?" {
+				problems = append(problems, fmt.Sprintf("%s %s is %s", role, addr, flag))
+			}
+		}
+		if fields[7] == "disconnected" && !strings.Contains(fields[2], "myself") {
+			problems = append(problems, fmt.Sprintf("%s %s is disconnected", role, addr))
+		}
+	}
+	return problems
 }
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
 
 	"github.com/jhaals/yopass/pkg/yopass"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestRedis(t *testing.T) {
@@ -62,6 +64,84 @@ func TestRedisUnits(t *testing.T) {
 			t.Fatal("Client should be initialized")
 		}
 	})
+
+	t.Run("NewRedis with sentinel URL", func(t *testing.T) {
+		r, err := NewRedis("redis-sentinel://localhost:26379/0?master_name=mymaster&addr=localhost:26380")
+		if err != nil {
+			t.Fatalf("Expected no error for valid sentinel URL, got: %v", err)
+		}
+		if _, ok := r.client.(*redis.Client); !ok {
+			t.Fatalf("Expected failover *redis.Client, got %T", r.client)
+		}
+		if r.masterName != "mymaster" || len(r.sentinels) != 2 {
+			t.Fatalf("Expected master mymaster with 2 sentinels, got %q with %d", r.masterName, len(r.sentinels))
+		}
+	})
+
+	t.Run("NewRedis with sentinel URL without master_name", func(t *testing.T) {
+		if _, err := NewRedis("redis-sentinel://localhost:26379/0"); err == nil {
+			t.Fatal("Expected error for sentinel URL without master_name")
+		}
+	})
+
+	t.Run("NewRedis with cluster URL", func(t *testing.T) {
+		r, err := NewRedis("redis-cluster://localhost:7000?addr=localhost:7001&addr=localhost:7002")
+		if err != nil {
+			t.Fatalf("Expected no error for valid cluster URL, got: %v", err)
+		}
+		if _, ok := r.client.(*redis.ClusterClient); !ok {
+			t.Fatalf("Expected *redis.ClusterClient, got %T", r.client)
+		}
+	})
+
+	t.Run("NewRedis with invalid cluster URL", func(t *testing.T) {
+		if _, err := NewRedis("redis-cluster://localhost:7000?unknown=1"); err == nil {
+			t.Fatal("Expected error for unknown cluster URL parameter")
+		}
+	})
+}
+
+func TestSentinelReplicaProblems(t *testing.T) {
+	healthy := []map[string]string{
+		{"ip": "10.0.0.2", "port": "6379", "flags": "slave", "master-link-status": "ok"},
+	}
+	if problems := sentinelReplicaProblems(healthy); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	if problems := sentinelReplicaProblems(nil); len(problems) != 1 || problems[0] != "no replicas" {
+		t.Fatalf("expected no replicas problem, got %v", problems)
+	}
+
+	degraded := []map[string]string{
+		{"ip": "10.0.0.2", "port": "6379", "flags": "s_down,slave,disconnected", "master-link-status": "err"},
+		{"ip": "10.0.0.3", "port": "6379", "flags": "slave", "master-link-status": "ok"},
+	}
+	want := []string{
+		"replica 10.0.0.2:6379 is s_down",
+		"replica 10.0.0.2:6379 is disconnected",
+		"replica 10.0.0.2:6379 master link is err",
+	}
+	if problems := sentinelReplicaProblems(degraded); !slices.Equal(problems, want) {
+		t.Fatalf("expected %v, got %v", want, problems)
+	}
+}
+
+func TestClusterNodeProblems(t *testing.T) {
+	nodes := `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383
+6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:30005@31005 slave,fail 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 0 1426238316232 5 disconnected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 127.0.0.1:30006@31006 slave 292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 0 1426238317741 6 connected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460
+`
+	want := []string{
+		"replica 127.0.0.1:30005 is fail",
+		"replica 127.0.0.1:30005 is disconnected",
+	}
+	if problems := clusterNodeProblems(nodes); !slices.Equal(problems, want) {
+		t.Fatalf("expected %v, got %v", want, problems)
+	}
 }
 
 func TestRedisStatus(t *testing.T) {
@@ -319,3 +399,28 @@ func TestRedisUpdate(t *testing.T) {
 		t.Fatalf("lost updates: expected %d, got %s", writers, s.Message)
 	}
 }
+
+func TestRedisPutIfAbsent(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	key := "put-if-absent-test-" + t.Name()
+	defer func() { _, _ = r.Delete(key) }()
+
+	if stored, err := r.PutIfAbsent(key, yopass.Secret{Message: "first", Expiration: 3600}); err != nil || !stored {
+		t.Fatalf("expected first PutIfAbsent() to store, got %v %v", stored, err)
+	}
+	if stored, err := r.PutIfAbsent(key, yopass.Secret{Message: "second", Expiration: 3600}); err != nil || stored {
+		t.Fatalf("expected second PutIfAbsent() not to store, got %v %v", stored, err)
+	}
+	if s, err := r.Status(key); err != nil || s.Message != "first" {
+		t.Fatalf("expected the first value to remain: %v %v", s, err)
+	}
+}
@@ -36,10 +36,7 @@ const (
 // requester to retrieve, revoke or rotate the key of a secret request.
 const requestTokenHeader = "X-Yopass-Request-Token"
 
-const (
-	maxRequestLabelLength = 100
-	maxPublicKeyLength    = 16 * 1024
-)
+const maxPublicKeyLength = 16 * 1024
 
 // SecretRequest is the stored representation of a secret request. Only the
 // public key ever reaches the server; the private key and management token
@@ -175,13 +172,13 @@ func (y *Server) createSecretRequest(w http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	if !validExpiration(body.Expiration) {
+	if !y.validExpiration(body.Expiration) {
 		audit.failure("invalid expiration")
 		jsonError(w, http.StatusBadRequest, "Invalid expiration specified")
 		return
 	}
 
-	if len(body.Label) > maxRequestLabelLength {
+	if len(body.Label) > maxLabelLength {
 		audit.failure("label too long")
 		jsonError(w, http.StatusBadRequest, "Label is too long")
 		return
@@ -221,6 +218,13 @@ func (y *Server) createSecretRequest(w http.ResponseWriter, request *http.Reques
 	}
 
 	audit.success(withExpiration(body.Expiration))
+	y.addCreatorEntry(creatorOwner(session), creatorEntry{
+		ID:        id,
+		Kind:      WebhookKindRequest,
+		Label:     body.Label,
+		CreatedAt: req.CreatedAt,
+		ExpiresAt: req.ExpiresAt,
+	})
 	y.webhookRequestCreated(id, body.Expiration)
 	y.writeJSON(w, http.StatusOK, map[string]interface{}{
 		"id":         id,
@@ -290,7 +294,7 @@ func maxArmoredFileLength(n int64) int64 {
 // must fit too.
 func (y *Server) fulfillRequestBodyLimit() int64 {
 	limit := jsonBodyLimit(int64(y.MaxLength))
-	if y.DisableUpload {
+	if y.uploadDisabled() {
 		return limit
 	}
 	if fileLimit := jsonBodyLimit(maxArmoredFileLength(y.effectiveRequestFileSize())); fileLimit > limit {
@@ -333,7 +337,7 @@ func (y *Server) fulfillSecretRequest(w http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	if kind == RequestSecretKindFile && y.DisableUpload {
+	if kind == RequestSecretKindFile && y.uploadDisabled() {
 		audit.denied("file responses disabled")
 		jsonError(w, http.StatusBadRequest, "File responses are disabled on this server")
 		return
@@ -51,6 +51,16 @@ func (db *memoryDB) Put(key string, secret yopass.Secret) error {
 	return nil
 }
 
+func (db *memoryDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.data[key]; ok {
+		return false, nil
+	}
+	db.data[key] = secret
+	return true, nil
+}
+
 func (db *memoryDB) Delete(key string) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -425,7 +435,7 @@ func TestSecretRequestValidation(t *testing.T) {
 		{"missing public key", map[string]interface{}{"expiration": 3600}},
 		{"invalid public key", map[string]interface{}{"public_key": "not a key", "expiration": 3600}},
 		{"invalid expiration", map[string]interface{}{"public_key": publicKey, "expiration": 1234}},
-		{"label too long", map[string]interface{}{"public_key": publicKey, "expiration": 3600, "label": strings.Repeat("a", maxRequestLabelLength+1)}},
+		{"label too long", map[string]interface{}{"public_key": publicKey, "expiration": 3600, "label": strings.Repeat("a", maxLabelLength+1)}},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -46,19 +47,45 @@ type Server struct {
 	Webhooks *WebhookNotifier
 
 	// Feature toggles
-	Argon2                bool
-	ReadOnly              bool
-	DisableUpload         bool
-	PrefetchSecret        bool
+	Argon2           bool
+	ReadOnly         bool
+	DisableUpload    bool
+	PrefetchSecret   bool
+	TwoStepRetrieval bool // one-time secrets are only released to a POST redeeming a claim nonce
+
+	// MaxPINAttempts is the number of invalid PINs after which a
+	// PIN-protected secret is destroyed; zero means DefaultMaxPINAttempts.
+	MaxPINAttempts        int32
 	DisableFeatures       bool
 	NoLanguageSwitcher    bool
 	DisableSecretRequests bool
 	DisableReadReceipts   bool
 
 	// Authentication
-	RequireAuth         bool       // require authentication to create secrets
-	AllowedEmailDomains []string   // restrict logins to these email domains
-	APITokens           []APIToken // static bearer tokens for machine-to-machine creation
+	RequireAuth         bool                 // require authentication to create secrets
+	RequireManageToken  bool                 // only creators holding the management token may delete secrets
+	AllowedEmailDomains []string             // restrict logins to these email domains
+	APITokens           []APIToken           // static bearer tokens for machine-to-machine creation
+	OIDCGroupsClaim     string               // claim listing the user's groups; empty means DefaultOIDCGroupsClaim
+	AccessTokens        *AccessTokenVerifier // accepts JWT access tokens from the OIDC provider as bearer credentials; nil disables
+
+	// Admin API, see admin.go
+	AdminGroups    []string   // OIDC groups whose members may use the admin API
+	AdminAPITokens []APIToken // static bearer tokens for the admin API
+
+	// Rate limiting, see ratelimit.go. RateLimits is keyed by route class
+	// (RateLimitCreate etc.); IPs answered NotFoundBanThreshold 404s within
+	// NotFoundBanWindow are banned for NotFoundBanDuration. A nil
+	// RateLimitStore keeps the limits per instance.
+	RateLimits           map[string]RateLimit
+	NotFoundBanThreshold int
+	NotFoundBanWindow    time.Duration
+	NotFoundBanDuration  time.Duration
+	RateLimitStore       RateLimitStore
+
+	// toggles caches the runtime overrides of ReadOnly and DisableUpload;
+	// set by HTTPHandler when the admin API is enabled.
+	toggles *toggleCache
 
 	// URLs and CORS
 	CORSAllowOrigin  string
@@ -75,12 +102,18 @@ type Server struct {
 	ThemeCustomLight string
 	ThemeCustomDark  string
 
-	// DefaultExpiry is the default secret lifetime ("1h", "1d" or "1w").
+	// DefaultExpiry is the default secret lifetime, e.g. "1h" or "3d".
 	DefaultExpiry string
 
 	// ForceExpiration, when non-empty, is the server-enforced secret lifetime
-	// ("1h", "1d" or "1w"). Clients may not choose a different value.
+	// (e.g. "1d"). Clients may not choose a different value.
 	ForceExpiration string
+
+	// MinExpiration and MaxExpiration bound the secret lifetimes clients may
+	// choose (e.g. "15m" and "30d"). Empty values fall back to
+	// yopass.DefaultExpirationPolicy.
+	MinExpiration string
+	MaxExpiration string
 }
 
 // jsonError writes a {"message": ...} error body with the given status code
@@ -146,11 +179,11 @@ func (y *Server) effectiveMaxFileSize() int64 {
 	return y.MaxFileSize
 }
 
-// authorizeSecretAccess enforces RequireAuth for secret retrieval and
-// deletion. It writes the error response and audit event itself and reports
-// whether the request may proceed.
+// authorizeSecretAccess enforces RequireAuth and the recipient restriction
+// for secret retrieval and deletion. It writes the error response and audit
+// event itself and reports whether the request may proceed.
 func (y *Server) authorizeSecretAccess(w http.ResponseWriter, secret yopass.Secret, session *sessionData, sessionErr error, audit *auditor) bool {
-	if !secret.RequireAuth {
+	if !secret.RequireAuth && !secret.Restricted() {
 		return true
 	}
 	if sessionErr != nil || session == nil {
@@ -158,11 +191,20 @@ func (y *Server) authorizeSecretAccess(w http.ResponseWriter, secret yopass.Secr
 		jsonError(w, http.StatusUnauthorized, "authentication required")
 		return false
 	}
-	if !y.emailAllowed(session.Email) {
+	if !y.sessionAllowed(session) {
 		audit.denied("email domain not permitted", withRequireAuth(true))
 		jsonError(w, http.StatusForbidden, "email domain not permitted")
 		return false
 	}
+	if secret.Restricted() {
+		match := matchRecipient(secret, session)
+		if match == "" {
+			audit.denied("not an allowed recipient", withRequireAuth(true))
+			jsonError(w, http.StatusForbidden, "not an allowed recipient")
+			return false
+		}
+		audit.setMatchedRecipient(match)
+	}
 	return true
 }
 
@@ -187,13 +229,72 @@ func (y *Server) claimOneTimeSecret(w http.ResponseWriter, dbKey string, audit *
 	return true
 }
 
+// claimSecretView atomically counts one view of a view-limited secret and
+// returns the updated record. The view using up the last one also deletes
+// the record; until that delete lands the exhausted record refuses further
+// claims, so concurrent requests can never exceed the limit. It writes the
+// error response and audit event itself and reports whether the caller may
+// serve the secret.
+func (y *Server) claimSecretView(w http.ResponseWriter, dbKey string, audit *auditor) (yopass.Secret, bool) {
+	var claimed yopass.Secret
+	err := y.DB.Update(dbKey, func(s yopass.Secret) (yopass.Secret, error) {
+		if s.ExpiresAt == 0 {
+			s.ExpiresAt = time.Now().Unix() + int64(s.Expiration)
+		}
+		// Compute the TTL once: a zero expiration means "never expire" to
+		// the backends.
+		ttl := secondsUntil(s.ExpiresAt)
+		if ttl == 0 || s.Views >= s.MaxViews {
+			return s, ErrKeyNotFound
+		}
+		s.Views++
+		s.Expiration = ttl
+		claimed = s
+		return s, nil
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		audit.denied("view limit reached")
+		jsonError(w, http.StatusNotFound, "Secret not found")
+		return claimed, false
+	}
+	if err != nil {
+		y.Logger.Error("Failed to count secret view", zap.Error(err))
+		audit.failure("failed to count view")
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return claimed, false
+	}
+	if claimed.Views == claimed.MaxViews {
+		if _, err := y.DB.Delete(dbKey); err != nil {
+			y.Logger.Error("Failed to delete secret after its last view", zap.Error(err))
+		}
+	}
+	return claimed, true
+}
+
 // creationPolicy holds the client-requested attributes common to text secret
 // and file creation, validated against server policy by checkCreationPolicy.
 type creationPolicy struct {
 	expiration  int32
 	oneTime     bool
+	maxViews    int32
 	requireAuth bool
 	receipt     bool
+	pin         string
+
+	allowedRecipients []string
+	allowedGroups     []string
+
+	// label names the secret in its creator's dashboard; it is not stored
+	// with the secret.
+	label string
+}
+
+// normalizeViews expresses a limit of a single view as a one-time secret, so
+// the rest of the server only deals with view limits of two and more.
+func (p *creationPolicy) normalizeViews() {
+	if p.maxViews == 1 {
+		p.oneTime, p.maxViews = true, 0
+	}
 }
 
 // checkCreationPolicy enforces the server-side creation policy shared by
@@ -205,7 +306,32 @@ func (y *Server) checkCreationPolicy(w http.ResponseWriter, p creationPolicy, au
 		jsonError(w, http.StatusBadRequest, "Read receipts are not enabled on this server")
 		return false
 	}
-	if !validExpiration(p.expiration) {
+	if p.maxViews < 0 {
+		audit.failure("invalid max views")
+		jsonError(w, http.StatusBadRequest, "Invalid max_views specified")
+		return false
+	}
+	if p.oneTime && p.maxViews > 1 {
+		audit.failure("one-time with max views")
+		jsonError(w, http.StatusBadRequest, "one_time and max_views cannot be combined")
+		return false
+	}
+	if p.pin != "" && (len(p.pin) < minPINLength || len(p.pin) > maxPINLength) {
+		audit.failure("invalid pin")
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("PIN must be between %d and %d characters", minPINLength, maxPINLength))
+		return false
+	}
+	if len(p.label) > maxLabelLength {
+		audit.failure("label too long")
+		jsonError(w, http.StatusBadRequest, "Label is too long")
+		return false
+	}
+	if !p.validRecipients() {
+		audit.failure("invalid recipients")
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("Allowed recipients must be at most %d email addresses and %d groups", maxAllowedRecipients, maxAllowedRecipients))
+		return false
+	}
+	if !y.validExpiration(p.expiration) {
 		audit.failure("invalid expiration")
 		jsonError(w, http.StatusBadRequest, "Invalid expiration specified")
 		return false
@@ -239,7 +365,9 @@ func (y *Server) createSecret(w http.ResponseWriter, request *http.Request) {
 	reader := http.MaxBytesReader(w, request.Body, jsonBodyLimit(int64(y.MaxLength)))
 	var body struct {
 		yopass.Secret
-		Receipt bool `json:"receipt"`
+		Receipt bool   `json:"receipt"`
+		PIN     string `json:"pin"`
+		Label   string `json:"label"`
 	}
 	if err := json.NewDecoder(reader).Decode(&body); err != nil {
 		var maxBytesErr *http.MaxBytesError
@@ -254,14 +382,40 @@ func (y *Server) createSecret(w http.ResponseWriter, request *http.Request) {
 	}
 	s := body.Secret
 
-	if !y.checkCreationPolicy(w, creationPolicy{
+	policy := creationPolicy{
 		expiration:  s.Expiration,
 		oneTime:     s.OneTime,
+		maxViews:    s.MaxViews,
 		requireAuth: s.RequireAuth,
 		receipt:     body.Receipt,
-	}, audit) {
+		pin:         body.PIN,
+
+		allowedRecipients: s.AllowedRecipients,
+		allowedGroups:     s.AllowedGroups,
+		label:             body.Label,
+	}
+	policy.normalizeViews()
+	policy.normalizeRecipients()
+	if !y.checkCreationPolicy(w, policy, audit) {
 		return
 	}
+	s.OneTime, s.MaxViews, s.Views, s.ExpiresAt = policy.oneTime, policy.maxViews, 0, 0
+	s.PINHash, s.PINAttempts = "", 0
+	s.RequireAuth, s.AllowedRecipients, s.AllowedGroups = policy.requireAuth, policy.allowedRecipients, policy.allowedGroups
+	if policy.pin != "" {
+		var err error
+		if s.PINHash, err = hashPIN(policy.pin); err != nil {
+			y.Logger.Error("Unable to hash PIN", zap.Error(err))
+			audit.failure("failed to hash pin")
+			jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+			return
+		}
+	}
+	// Counting views and PIN attempts rewrites the record, which must keep
+	// the remaining lifetime.
+	if s.MaxViews > 0 || s.PINHash != "" {
+		s.ExpiresAt = time.Now().Unix() + int64(s.Expiration)
+	}
 
 	if len(s.Message) > y.MaxLength {
 		audit.failure("message too long")
@@ -287,8 +441,9 @@ func (y *Server) createSecret(w http.ResponseWriter, request *http.Request) {
 	// Store the receipt before the secret: if it fails the request aborts
 	// without leaving a secret that silently lacks its requested receipt.
 	response := map[string]string{"message": key}
+	owner := creatorOwner(session)
 	if body.Receipt {
-		token, err := y.createReceipt(key, s.OneTime, s.Expiration)
+		token, err := y.createReceipt(key, s, owner)
 		if err != nil {
 			y.Logger.Error("Unable to store read receipt", zap.Error(err))
 			audit.failure("failed to store receipt")
@@ -306,12 +461,37 @@ func (y *Server) createSecret(w http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	audit.success(withOneTime(s.OneTime), withExpiration(s.Expiration), withRequireAuth(s.RequireAuth))
-	y.webhookCreated(key, WebhookKindSecret, s.OneTime, s.Expiration)
+	// The link is only handed out together with its management token, so a
+	// secret whose token cannot be stored is removed again.
+	token, err := y.createManagement(key, s.Expiration, owner)
+	if err != nil {
+		y.Logger.Error("Unable to store management token", zap.Error(err))
+		if _, delErr := y.DB.Delete(key); delErr != nil {
+			y.Logger.Error("Failed to delete secret after management token error", zap.Error(delErr))
+		}
+		audit.failure("failed to store management token")
+		jsonError(w, http.StatusInternalServerError, "Failed to store management token in database")
+		return
+	}
+	response["manage_token"] = token
+
+	audit.success(withOneTime(s.OneTime), withExpiration(s.Expiration), withRequireAuth(s.RequireAuth), withViews(s))
+	now := time.Now().Unix()
+	y.addCreatorEntry(owner, creatorEntry{
+		ID:        key,
+		Kind:      WebhookKindSecret,
+		Label:     policy.label,
+		CreatedAt: now,
+		ExpiresAt: now + int64(s.Expiration),
+	})
+	y.webhookCreated(key, WebhookKindSecret, s)
 	y.writeJSON(w, http.StatusOK, response)
 }
 
-// getSecret returns a secret, consuming it when it is one-time.
+// getSecret returns a secret, consuming it when it is one-time and counting
+// the view when it is view-limited. With TwoStepRetrieval, a GET of a
+// one-time secret only issues a claim nonce and the POST redeeming it
+// returns the secret.
 func (y *Server) getSecret(w http.ResponseWriter, request *http.Request) {
 	w.Header().Set("Cache-Control", "private, no-cache")
 
@@ -333,11 +513,36 @@ func (y *Server) getSecret(w http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// The PIN is checked between the two retrieval steps, so an invalid PIN
+	// does not use up the claim nonce.
+	if y.requiresClaim(secret) && request.Method != http.MethodPost {
+		y.issueClaim(w, secretKey, secret, audit.withEvent("secret.claim_issued"))
+		return
+	}
+	var ok bool
+	if secret, ok = y.checkPIN(w, request, secretKey, WebhookKindSecret, secret, audit); !ok {
+		return
+	}
+	if y.requiresClaim(secret) && !y.redeemClaim(w, request, secretKey, audit) {
+		return
+	}
 	if secret.OneTime && !y.claimOneTimeSecret(w, secretKey, audit) {
 		return
 	}
+	if secret.MaxViews > 0 {
+		if secret, ok = y.claimSecretView(w, secretKey, audit); !ok {
+			return
+		}
+	}
+	if secret.OneTime || (secret.MaxViews > 0 && secret.Views == secret.MaxViews) {
+		y.deleteManagement(secretKey)
+	}
 
-	data, err := secret.ToJSON()
+	// The PIN hash and recipient lists stay on the server.
+	served := secret
+	served.PINHash, served.PINAttempts = "", 0
+	served.AllowedRecipients, served.AllowedGroups = nil, nil
+	data, err := served.ToJSON()
 	if err != nil {
 		y.Logger.Error("Failed to encode request", zap.Error(err))
 		jsonError(w, http.StatusInternalServerError, "Failed to encode secret")
@@ -347,9 +552,9 @@ func (y *Server) getSecret(w http.ResponseWriter, request *http.Request) {
 	// Log success before writing: for one-time secrets the secret has already
 	// been deleted, so the meaningful outcome (consumed) is already determined.
 	// Logging after a write failure would record the wrong outcome.
-	audit.success(withOneTime(secret.OneTime), withRequireAuth(secret.RequireAuth))
+	audit.success(withOneTime(secret.OneTime), withRequireAuth(secret.RequireAuth), withViews(secret))
 	y.markReceiptViewed(secretKey)
-	y.webhookViewed(secretKey, WebhookKindSecret, secret.OneTime)
+	y.webhookViewed(secretKey, WebhookKindSecret, secret)
 	if _, err := w.Write(data); err != nil {
 		y.Logger.Error("Failed to write response", zap.Error(err))
 	}
@@ -375,18 +580,36 @@ func (y *Server) secretStatusHandler(keyPrefix, auditEvent string) http.HandlerF
 			return
 		}
 
-		audit.success(withOneTime(secret.OneTime), withRequireAuth(secret.RequireAuth))
-		y.writeJSON(w, http.StatusOK, map[string]bool{
+		audit.success(withOneTime(secret.OneTime), withRequireAuth(secret.RequireAuth), withViews(secret))
+		status := map[string]interface{}{
 			"oneTime":     secret.OneTime,
 			"requireAuth": secret.RequireAuth,
-		})
+		}
+		if secret.MaxViews > 0 {
+			status["maxViews"] = secret.MaxViews
+			status["remainingViews"] = secret.MaxViews - secret.Views
+		}
+		if secret.PINHash != "" {
+			status["pinRequired"] = true
+		}
+		// The recipient lists are not disclosed to whoever holds the link;
+		// a signed-in viewer learns whether they are on them.
+		if secret.Restricted() {
+			status["restricted"] = true
+			if session != nil {
+				status["recipientAllowed"] = matchRecipient(secret, session) != ""
+			}
+		}
+		y.writeJSON(w, http.StatusOK, status)
 	}
 }
 
 // deleteSecretHandler returns the handler removing a secret ahead of its
-// expiration, enforcing RequireAuth before the deletion is allowed. Text
-// secrets and files share it; files additionally remove the stored blob
-// (deleteBlob) after the metadata key is gone.
+// expiration. The creator revokes it with the management token; otherwise
+// the ID alone authorizes deletion, subject to RequireAuth, unless
+// RequireManageToken is set. Text secrets and files share it; files
+// additionally remove the stored blob (deleteBlob) after the metadata key is
+// gone.
 func (y *Server) deleteSecretHandler(keyPrefix, auditEvent string, deleteBlob bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, request *http.Request) {
 		key := mux.Vars(request)["key"]
@@ -402,8 +625,20 @@ func (y *Server) deleteSecretHandler(keyPrefix, auditEvent string, deleteBlob bo
 			return
 		}
 
-		if !y.authorizeSecretAccess(w, secret, session, sessionErr, audit) {
+		switch {
+		case request.Header.Get(manageTokenHeader) != "":
+			// RequireAuth guards recipients, not the creator.
+			if _, ok := y.authorizeManagement(w, request, key, audit); !ok {
+				return
+			}
+		case y.RequireManageToken:
+			audit.denied("management token required")
+			jsonError(w, http.StatusUnauthorized, "Management token required")
 			return
+		default:
+			if !y.authorizeSecretAccess(w, secret, session, sessionErr, audit) {
+				return
+			}
 		}
 
 		deleted, err := y.DB.Delete(keyPrefix + key)
@@ -428,6 +663,8 @@ func (y *Server) deleteSecretHandler(keyPrefix, auditEvent string, deleteBlob bo
 			}
 		}
 
+		y.deleteManagement(key)
+
 		audit.success()
 		y.webhookDeleted(key)
 		w.WriteHeader(http.StatusNoContent)
@@ -450,9 +687,10 @@ func (y *Server) configHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	config := map[string]interface{}{
-		"DISABLE_UPLOAD":        y.DisableUpload,
-		"READ_ONLY":             y.ReadOnly,
+		"DISABLE_UPLOAD":        y.uploadDisabled(),
+		"READ_ONLY":             y.readOnly(),
 		"PREFETCH_SECRET":       y.PrefetchSecret,
+		"TWO_STEP_RETRIEVAL":    y.TwoStepRetrieval,
 		"DISABLE_FEATURES":      y.DisableFeatures,
 		"NO_LANGUAGE_SWITCHER":  y.NoLanguageSwitcher,
 		"FORCE_ONETIME_SECRETS": y.ForceOneTimeSecrets,
@@ -462,6 +700,9 @@ func (y *Server) configHandler(w http.ResponseWriter, r *http.Request) {
 	if y.ForceExpiration != "" {
 		config["FORCE_EXPIRATION"] = expirationInSeconds(y.ForceExpiration)
 	}
+	policy := y.expirationPolicy()
+	config["MIN_EXPIRATION"] = policy.Min
+	config["MAX_EXPIRATION"] = policy.Max
 	if maxFileSize := y.effectiveMaxFileSize(); maxFileSize > 0 {
 		config["MAX_FILE_SIZE"] = FormatSize(maxFileSize)
 	}
@@ -485,12 +726,12 @@ func (y *Server) configHandler(w http.ResponseWriter, r *http.Request) {
 	config["SECRET_REQUESTS"] = y.secretRequestsEnabled()
 	// File responses to secret requests have their own, stricter size limit
 	// (they are stored in the database backend, not the file store).
-	if y.secretRequestsEnabled() && !y.DisableUpload {
+	if y.secretRequestsEnabled() && !y.uploadDisabled() {
 		config["MAX_REQUEST_FILE_SIZE"] = FormatSize(y.effectiveRequestFileSize())
 	}
 	// The toggle is only useful where secrets can be created, so read-only
 	// instances report false even with a valid license.
-	config["READ_RECEIPTS"] = y.readReceiptsEnabled() && !y.ReadOnly
+	config["READ_RECEIPTS"] = y.readReceiptsEnabled() && !y.readOnly()
 
 	if y.License.CurrentlyValid() {
 		config["THEME_LIGHT"] = y.ThemeLight
@@ -540,6 +781,15 @@ func (y *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	y.writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
+// databaseHealth checks the database, probing each node of a multi-node
+// backend only once for both the per-node and the aggregate result.
+func (y *Server) databaseHealth() (map[string]error, error) {
+	if checker, ok := y.DB.(NodeHealthChecker); ok {
+		return checker.NodeHealth()
+	}
+	return nil, y.DB.Health()
+}
+
 // readyHandler performs readiness check (deep check - can handle traffic)
 func (y *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -561,19 +811,49 @@ func (y *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := y.DB.Health(); err != nil {
-		notReady("Readiness check failed", "database connectivity failed", err)
+	// Multi-node backends list each node as "ok" or "unreachable"; error
+	// details stay in the logs.
+	nodeErrs, dbErr := y.databaseHealth()
+	var nodes map[string]string
+	for addr, err := range nodeErrs {
+		if nodes == nil {
+			nodes = map[string]string{}
+		}
+		nodes[addr] = "ok"
+		if err != nil {
+			nodes[addr] = "unreachable"
+		}
+	}
+
+	var degraded *DegradedError
+	if errors.As(dbErr, &degraded) {
+		y.Logger.Warn("Database degraded", zap.Error(dbErr))
+	} else if dbErr != nil {
+		y.Logger.Debug("Readiness check failed", zap.Error(dbErr))
+		body := map[string]interface{}{"status": "not ready", "error": "database connectivity failed"}
+		if nodes != nil {
+			body["nodes"] = nodes
+		}
+		y.writeJSON(w, http.StatusServiceUnavailable, body)
 		return
 	}
 
-	if y.FileStore != nil {
-		if err := y.FileStore.Health(r.Context()); err != nil {
+	// A database-backed file store would only probe the database again.
+	if _, inDB := y.FileStore.(*DatabaseFileStore); y.FileStore != nil && !inDB {
+		if err := y.FileStore.Health(r.Context()); err != nil && !errors.As(err, new(*DegradedError)) {
 			notReady("Readiness check failed: file store", "file store connectivity failed", err)
 			return
 		}
 	}
 
-	y.writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	body := map[string]interface{}{"status": "ready"}
+	if degraded != nil {
+		body["degraded"] = degraded.Reason
+	}
+	if nodes != nil {
+		body["nodes"] = nodes
+	}
+	y.writeJSON(w, http.StatusOK, body)
 }
 
 // secretRequestsEnabled reports whether the secret request feature is active:
@@ -581,15 +861,15 @@ func (y *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
 // Checked both at route registration and per request, so creating new
 // requests stops as soon as the license expires.
 func (y *Server) secretRequestsEnabled() bool {
-	return y.License.CurrentlyValid() && !y.ReadOnly && !y.DisableSecretRequests
+	return y.License.CurrentlyValid() && !y.readOnly() && !y.DisableSecretRequests
 }
 
 // maybeRequireAuth wraps a handler with requireAuthMiddleware when OIDC is
-// configured and the --require-auth flag is set. Otherwise it returns the
-// handler as-is.
-func (y *Server) maybeRequireAuth(h http.HandlerFunc) http.Handler {
+// configured and the --require-auth flag is set, admitting API tokens that
+// hold scope. Otherwise it returns the handler as-is.
+func (y *Server) maybeRequireAuth(scope string, h http.HandlerFunc) http.Handler {
 	if y.oidcEnabled() && y.RequireAuth {
-		return y.requireAuthMiddleware(h)
+		return y.requireAuthMiddleware(requireScope(scope, h))
 	}
 	return h
 }
@@ -602,15 +882,26 @@ func (y *Server) HTTPHandler() http.Handler {
 	mx := mux.NewRouter()
 	mx.Use(newMetricsMiddleware(y.Registry))
 	mx.Use(y.corsMiddleware)
+	if y.rateLimitEnabled() {
+		mx.Use(y.newRateLimitMiddleware(y.Registry))
+	}
 
 	secretOptions := corsPreflight("POST, OPTIONS", "Content-Type")
 	requestOptions := corsPreflight("GET, POST, PUT, DELETE, OPTIONS", "Content-Type, "+requestTokenHeader)
 
-	// Only register write endpoints if not in read-only mode
-	if !y.ReadOnly {
-		mx.Handle("/create/secret", y.maybeRequireAuth(y.createSecret)).Methods(http.MethodPost)
-		mx.HandleFunc("/create/secret", secretOptions).Methods(http.MethodOptions)
+	// The admin API can toggle ReadOnly and DisableUpload at runtime, so the
+	// endpoints they switch off are always registered on these subrouters
+	// and only match while enabled; see admin.go.
+	if y.adminEnabled() {
+		y.toggles = &toggleCache{}
 	}
+	writable := mx.NewRoute().MatcherFunc(y.whenWritable).Subrouter()
+	uploads := mx.NewRoute().MatcherFunc(y.whenUploads).Subrouter()
+	fileWritable := mx.NewRoute().MatcherFunc(y.whenFileWritable).Subrouter()
+
+	// Write endpoints are not available in read-only mode
+	writable.Handle("/create/secret", y.maybeRequireAuth(ScopeCreateSecret, y.createSecret)).Methods(http.MethodPost)
+	writable.HandleFunc("/create/secret", secretOptions).Methods(http.MethodOptions)
 
 	// Secret request endpoints — business feature, requires a valid license.
 	// Note the asymmetry on /request/{id}/secret: POST is the *responder*
@@ -620,17 +911,18 @@ func (y *Server) HTTPHandler() http.Handler {
 	// requests per request, while the remaining endpoints stay functional so
 	// already-issued requests (TTL-bounded to at most a week) can drain
 	// instead of stranding their participants.
-	if y.secretRequestsEnabled() {
-		mx.Handle("/request", y.maybeRequireAuth(y.createSecretRequest)).Methods(http.MethodPost)
-		mx.HandleFunc("/request", requestOptions).Methods(http.MethodOptions)
-		mx.HandleFunc("/request/"+keyParameter, y.getSecretRequest).Methods(http.MethodGet)
-		mx.HandleFunc("/request/"+keyParameter, y.revokeSecretRequest).Methods(http.MethodDelete)
-		mx.HandleFunc("/request/"+keyParameter, requestOptions).Methods(http.MethodOptions)
-		mx.HandleFunc("/request/"+keyParameter+"/secret", y.fulfillSecretRequest).Methods(http.MethodPost)
-		mx.HandleFunc("/request/"+keyParameter+"/secret", y.fetchRequestSecret).Methods(http.MethodGet)
-		mx.HandleFunc("/request/"+keyParameter+"/secret", requestOptions).Methods(http.MethodOptions)
-		mx.HandleFunc("/request/"+keyParameter+"/key", y.rotateRequestKey).Methods(http.MethodPut)
-		mx.HandleFunc("/request/"+keyParameter+"/key", requestOptions).Methods(http.MethodOptions)
+	// Like the write endpoints, requests are unavailable in read-only mode.
+	if y.License.CurrentlyValid() && !y.DisableSecretRequests {
+		writable.Handle("/request", y.maybeRequireAuth(ScopeCreateRequest, y.createSecretRequest)).Methods(http.MethodPost)
+		writable.HandleFunc("/request", requestOptions).Methods(http.MethodOptions)
+		writable.HandleFunc("/request/"+keyParameter, y.getSecretRequest).Methods(http.MethodGet)
+		writable.HandleFunc("/request/"+keyParameter, y.revokeSecretRequest).Methods(http.MethodDelete)
+		writable.HandleFunc("/request/"+keyParameter, requestOptions).Methods(http.MethodOptions)
+		writable.HandleFunc("/request/"+keyParameter+"/secret", y.fulfillSecretRequest).Methods(http.MethodPost)
+		writable.HandleFunc("/request/"+keyParameter+"/secret", y.fetchRequestSecret).Methods(http.MethodGet)
+		writable.HandleFunc("/request/"+keyParameter+"/secret", requestOptions).Methods(http.MethodOptions)
+		writable.HandleFunc("/request/"+keyParameter+"/key", y.rotateRequestKey).Methods(http.MethodPut)
+		writable.HandleFunc("/request/"+keyParameter+"/key", requestOptions).Methods(http.MethodOptions)
 	}
 
 	// Read endpoints - always available
@@ -639,6 +931,19 @@ func (y *Server) HTTPHandler() http.Handler {
 	}
 	mx.HandleFunc("/secret/"+keyParameter, y.getSecret).Methods(http.MethodGet)
 	mx.HandleFunc("/secret/"+keyParameter, y.deleteSecretHandler("", "secret.deleted", false)).Methods(http.MethodDelete)
+	if y.TwoStepRetrieval {
+		// Second retrieval step, redeeming the claim nonce issued by GET.
+		mx.HandleFunc("/secret/"+keyParameter, y.getSecret).Methods(http.MethodPost)
+		mx.HandleFunc("/secret/"+keyParameter, corsPreflight("GET, POST, DELETE, OPTIONS", "Content-Type, "+pinHeader+", "+manageTokenHeader)).Methods(http.MethodOptions)
+	} else {
+		mx.HandleFunc("/secret/"+keyParameter, corsPreflight("GET, DELETE, OPTIONS", pinHeader+", "+manageTokenHeader)).Methods(http.MethodOptions)
+	}
+
+	// Extending a secret is a creator action; like creation it is not
+	// available on read-only instances.
+	manageOptions := corsPreflight("POST, OPTIONS", "Content-Type, "+manageTokenHeader)
+	writable.HandleFunc("/secret/"+keyParameter+"/extend", y.extendSecretHandler("", "secret.extended", false)).Methods(http.MethodPost)
+	writable.HandleFunc("/secret/"+keyParameter+"/extend", manageOptions).Methods(http.MethodOptions)
 
 	// Read receipt status — registered unconditionally so receipts created on
 	// a licensed write instance stay checkable through read-only replicas;
@@ -659,23 +964,56 @@ func (y *Server) HTTPHandler() http.Handler {
 		mx.HandleFunc("/auth/callback", y.oidcCallbackHandler).Methods(http.MethodGet)
 		mx.HandleFunc("/auth/logout", y.oidcLogoutHandler).Methods(http.MethodPost)
 		mx.HandleFunc("/auth/me", y.oidcMeHandler).Methods(http.MethodGet)
+
+		// The creator dashboard lists what the signed-in user has shared,
+		// see creator.go.
+		mx.Handle("/me/secrets", y.requireAuthMiddleware(http.HandlerFunc(y.listMySecrets))).Methods(http.MethodGet)
+		mx.HandleFunc("/me/secrets", corsPreflight("GET, OPTIONS", "")).Methods(http.MethodOptions)
+		mx.Handle("/me/secrets/revoke", y.requireAuthMiddleware(http.HandlerFunc(y.revokeMySecrets))).Methods(http.MethodPost)
+		mx.HandleFunc("/me/secrets/revoke", corsPreflight("POST, OPTIONS", "Content-Type")).Methods(http.MethodOptions)
 	}
 
-	// File upload/download endpoints
-	if y.FileStore == nil && !y.DisableUpload {
+	// File upload/download endpoints. The file store is needed as soon as
+	// uploads may be switched on at runtime.
+	if y.FileStore == nil && (!y.DisableUpload || y.adminEnabled()) {
 		y.FileStore = NewDatabaseFileStore(y.DB)
 	}
-	if !y.ReadOnly && !y.DisableUpload {
-		mx.Handle("/create/file", y.maybeRequireAuth(y.streamUpload)).Methods(http.MethodPost)
-		mx.HandleFunc("/create/file", y.streamOptions).Methods(http.MethodOptions)
+	fileWritable.Handle("/create/file", y.maybeRequireAuth(ScopeCreateFile, y.streamUpload)).Methods(http.MethodPost)
+	fileWritable.HandleFunc("/create/file", y.streamOptions).Methods(http.MethodOptions)
+
+	// Resumable uploads, see server_upload.go.
+	uploadOptions := corsPreflight("GET, POST, PUT, DELETE, OPTIONS", "Content-Type, X-Yopass-Expiration, X-Yopass-OneTime, X-Yopass-RequireAuth, X-Yopass-Receipt, X-Yopass-MaxViews, "+pinHeader+", "+allowedRecipientsHeader+", "+allowedGroupsHeader+", "+labelHeader)
+	fileWritable.Handle("/create/file/upload", y.maybeRequireAuth(ScopeCreateFile, y.createUpload)).Methods(http.MethodPost)
+	fileWritable.HandleFunc("/create/file/upload", uploadOptions).Methods(http.MethodOptions)
+	fileWritable.Handle("/create/file/upload/"+keyParameter, y.maybeRequireAuth(ScopeCreateFile, y.getUpload)).Methods(http.MethodGet)
+	fileWritable.Handle("/create/file/upload/"+keyParameter, y.maybeRequireAuth(ScopeCreateFile, y.abortUpload)).Methods(http.MethodDelete)
+	fileWritable.HandleFunc("/create/file/upload/"+keyParameter, uploadOptions).Methods(http.MethodOptions)
+	fileWritable.Handle("/create/file/upload/"+keyParameter+"/{chunk:[0-9]+}", y.maybeRequireAuth(ScopeCreateFile, y.uploadChunk)).Methods(http.MethodPut)
+	fileWritable.HandleFunc("/create/file/upload/"+keyParameter+"/{chunk:[0-9]+}", uploadOptions).Methods(http.MethodOptions)
+	fileWritable.Handle("/create/file/upload/"+keyParameter+"/finalize", y.maybeRequireAuth(ScopeCreateFile, y.finalizeUpload)).Methods(http.MethodPost)
+	fileWritable.HandleFunc("/create/file/upload/"+keyParameter+"/finalize", uploadOptions).Methods(http.MethodOptions)
+
+	uploads.HandleFunc("/file/"+keyParameter, y.streamDownload).Methods(http.MethodGet)
+	if y.TwoStepRetrieval {
+		uploads.HandleFunc("/file/"+keyParameter, y.streamDownload).Methods(http.MethodPost)
+	}
+	uploads.HandleFunc("/file/"+keyParameter, y.streamOptions).Methods(http.MethodOptions)
+	uploads.HandleFunc("/file/"+keyParameter, y.deleteSecretHandler(streamKeyPrefix, "file.deleted", true)).Methods(http.MethodDelete)
+	fileWritable.HandleFunc("/file/"+keyParameter+"/extend", y.extendSecretHandler(streamKeyPrefix, "file.extended", true)).Methods(http.MethodPost)
+	fileWritable.HandleFunc("/file/"+keyParameter+"/extend", manageOptions).Methods(http.MethodOptions)
+	if y.PrefetchSecret {
+		uploads.HandleFunc("/file/"+keyParameter+"/status", y.secretStatusHandler(streamKeyPrefix, "file.status_checked")).Methods(http.MethodGet)
 	}
-	if !y.DisableUpload {
-		mx.HandleFunc("/file/"+keyParameter, y.streamDownload).Methods(http.MethodGet)
-		mx.HandleFunc("/file/"+keyParameter, y.streamOptions).Methods(http.MethodOptions)
-		mx.HandleFunc("/file/"+keyParameter, y.deleteSecretHandler(streamKeyPrefix, "file.deleted", true)).Methods(http.MethodDelete)
-		if y.PrefetchSecret {
-			mx.HandleFunc("/file/"+keyParameter+"/status", y.secretStatusHandler(streamKeyPrefix, "file.status_checked")).Methods(http.MethodGet)
-		}
+
+	// Operator API, see admin.go.
+	if y.adminEnabled() {
+		mx.Handle("/admin/status", y.requireAdminMiddleware(y.adminStatus)).Methods(http.MethodGet)
+		mx.Handle("/admin/toggles", y.requireAdminMiddleware(y.putAdminToggles)).Methods(http.MethodPut)
+		mx.Handle("/admin/secrets/"+keyParameter, y.requireAdminMiddleware(y.adminRevokeSecret)).Methods(http.MethodDelete)
+		mx.Handle("/admin/users/purge", y.requireAdminMiddleware(y.adminPurgeUser)).Methods(http.MethodPost)
+		mx.Handle("/admin/tokens", y.requireAdminMiddleware(y.listAPITokens)).Methods(http.MethodGet)
+		mx.Handle("/admin/tokens", y.requireAdminMiddleware(y.createAPIToken)).Methods(http.MethodPost)
+		mx.Handle("/admin/tokens/{name}", y.requireAdminMiddleware(y.revokeAPIToken)).Methods(http.MethodDelete)
 	}
 
 	mx.HandleFunc("/health", y.healthHandler).Methods(http.MethodGet, http.MethodHead)
@@ -708,24 +1046,37 @@ const (
 	DefaultThemeDark  = "dim"
 )
 
-// The supported secret lifetimes live in pkg/yopass so the server and the
-// CLI client share one table; these helpers adapt it to this package's needs.
+// Expiry durations are parsed by pkg/yopass so the server and the CLI client
+// accept the same syntax; these helpers adapt it to this package's needs.
 
-// ValidExpiryString reports whether s is a supported human-readable expiry
-// duration ("1h", "1d" or "1w").
+// ValidExpiryString reports whether s is a well-formed human-readable expiry
+// duration such as "15m", "1h" or "3d". It does not check the policy.
 func ValidExpiryString(s string) bool {
 	_, ok := yopass.ExpirationSeconds(s)
 	return ok
 }
 
-// validExpiration reports whether expiration matches one of the supported
-// lifetimes in seconds.
-func validExpiration(expiration int32) bool {
-	return yopass.ValidExpirationSeconds(expiration)
+// expirationPolicy returns the configured lifetime bounds, falling back to
+// yopass.DefaultExpirationPolicy for unset or malformed values.
+func (y *Server) expirationPolicy() yopass.ExpirationPolicy {
+	policy := yopass.DefaultExpirationPolicy
+	if ttl, ok := yopass.ExpirationSeconds(y.MinExpiration); ok {
+		policy.Min = ttl
+	}
+	if ttl, ok := yopass.ExpirationSeconds(y.MaxExpiration); ok {
+		policy.Max = ttl
+	}
+	return policy
+}
+
+// validExpiration reports whether expiration, in seconds, is within the
+// server's expiration policy.
+func (y *Server) validExpiration(expiration int32) bool {
+	return y.expirationPolicy().Allows(expiration)
 }
 
-// expirationInSeconds converts a human-readable expiry duration string
-// [1h, 1d, 1w] to its equivalent in seconds, defaulting to one hour.
+// expirationInSeconds converts a human-readable expiry duration string to its
+// equivalent in seconds, defaulting to one hour.
 func expirationInSeconds(s string) int32 {
 	if ttl, ok := yopass.ExpirationSeconds(s); ok {
 		return ttl
@@ -3,11 +3,16 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -31,28 +36,8 @@ func (y *Server) streamUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse headers
-	expirationStr := r.Header.Get("X-Yopass-Expiration")
-	if expirationStr == "" {
-		audit.failure("missing expiration header")
-		jsonError(w, http.StatusBadRequest, "X-Yopass-Expiration header required")
-		return
-	}
-	// A malformed value parses to 0, which checkCreationPolicy rejects as an
-	// invalid expiration.
-	parsed, _ := strconv.ParseInt(expirationStr, 10, 32)
-	expiration := int32(parsed)
-
-	oneTime := r.Header.Get("X-Yopass-OneTime") == "true"
-	requireAuth := r.Header.Get("X-Yopass-RequireAuth") == "true"
-	receipt := r.Header.Get("X-Yopass-Receipt") == "true"
-
-	if !y.checkCreationPolicy(w, creationPolicy{
-		expiration:  expiration,
-		oneTime:     oneTime,
-		requireAuth: requireAuth,
-		receipt:     receipt,
-	}, audit) {
+	policy, ok := fileCreationPolicy(w, r, audit)
+	if !ok || !y.checkCreationPolicy(w, policy, audit) {
 		return
 	}
 
@@ -84,33 +69,106 @@ func (y *Server) streamUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	body = io.MultiReader(bytes.NewReader(peek[:]), body)
 
+	// Content-Length may be -1 if unknown.
+	y.storeFile(r.Context(), w, policy, session, body, r.ContentLength, audit)
+}
+
+// fileCreationPolicy reads the X-Yopass-* creation headers of a file upload.
+// It writes the error response and reports false when the expiration header
+// is missing; the values themselves are validated by checkCreationPolicy.
+func fileCreationPolicy(w http.ResponseWriter, r *http.Request, audit *auditor) (creationPolicy, bool) {
+	expirationStr := r.Header.Get("X-Yopass-Expiration")
+	if expirationStr == "" {
+		audit.failure("missing expiration header")
+		jsonError(w, http.StatusBadRequest, "X-Yopass-Expiration header required")
+		return creationPolicy{}, false
+	}
+	// A malformed value parses to 0, which checkCreationPolicy rejects as an
+	// invalid expiration.
+	parsed, _ := strconv.ParseInt(expirationStr, 10, 32)
+	p := creationPolicy{
+		expiration:  int32(parsed),
+		oneTime:     r.Header.Get("X-Yopass-OneTime") == "true",
+		requireAuth: r.Header.Get("X-Yopass-RequireAuth") == "true",
+		receipt:     r.Header.Get("X-Yopass-Receipt") == "true",
+		pin:         r.Header.Get(pinHeader),
+
+		allowedRecipients: headerList(r, allowedRecipientsHeader),
+		allowedGroups:     headerList(r, allowedGroupsHeader),
+		label:             r.Header.Get(labelHeader),
+	}
+	if v := r.Header.Get("X-Yopass-MaxViews"); v != "" {
+		// Likewise a malformed limit becomes -1, rejected as invalid.
+		maxViews, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			maxViews = -1
+		}
+		p.maxViews = int32(maxViews)
+	}
+	p.normalizeViews()
+	p.normalizeRecipients()
+	return p, true
+}
+
+// storeFile stores an already validated encrypted file under a new ID along
+// with its metadata and optional receipt, then responds with the ID. It is
+// the final step of both single-request and resumable uploads and reports
+// whether the file was stored. session is the uploader's, if signed in.
+func (y *Server) storeFile(ctx context.Context, w http.ResponseWriter, p creationPolicy, session *sessionData, body io.Reader, contentLength int64, audit *auditor) bool {
 	key, err := yopass.GenerateID()
 	if err != nil {
 		y.Logger.Error("Unable to generate ID", zap.Error(err))
 		audit.failure("failed to generate ID")
 		jsonError(w, http.StatusInternalServerError, "Unable to generate ID")
-		return
+		return false
 	}
 	audit.setSecretID(key)
 
-	// Store the receipt before the file: if it fails the request aborts
-	// without leaving a file that silently lacks its requested receipt.
+	meta := yopass.Secret{
+		Expiration:  p.expiration,
+		OneTime:     p.oneTime,
+		MaxViews:    p.maxViews,
+		RequireAuth: p.requireAuth,
+
+		AllowedRecipients: p.allowedRecipients,
+		AllowedGroups:     p.allowedGroups,
+	}
+	if p.pin != "" {
+		if meta.PINHash, err = hashPIN(p.pin); err != nil {
+			y.Logger.Error("Unable to hash PIN", zap.Error(err))
+			audit.failure("failed to hash pin")
+			jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+			return false
+		}
+	}
+	meta.ExpiresAt = time.Now().Unix() + int64(meta.Expiration)
+
+	// Store the receipt and management token before the file: if either
+	// fails the request aborts without leaving a file that silently lacks
+	// them.
 	response := map[string]string{"message": key}
-	if receipt {
-		token, err := y.createReceipt(key, oneTime, expiration)
+	owner := creatorOwner(session)
+	if p.receipt {
+		token, err := y.createReceipt(key, meta, owner)
 		if err != nil {
 			y.Logger.Error("Unable to store read receipt", zap.Error(err))
 			audit.failure("failed to store receipt")
 			jsonError(w, http.StatusInternalServerError, "Failed to store receipt in database")
-			return
+			return false
 		}
 		response["receipt_token"] = token
 	}
+	token, err := y.createManagement(key, p.expiration, owner)
+	if err != nil {
+		y.Logger.Error("Unable to store management token", zap.Error(err))
+		audit.failure("failed to store management token")
+		jsonError(w, http.StatusInternalServerError, "Failed to store management token in database")
+		return false
+	}
+	response["manage_token"] = token
 
 	// Stream body to file store with expiration set atomically.
-	contentLength := r.ContentLength // may be -1 if unknown
-	ctx := r.Context()
-	if err := y.FileStore.Save(ctx, key, body, contentLength, expiration); err != nil {
+	if err := y.FileStore.Save(ctx, key, body, contentLength, p.expiration); err != nil {
 		y.Logger.Error("Failed to save streaming file", zap.Error(err))
 		var maxBytesErr *http.MaxBytesError
 		if errors.As(err, &maxBytesErr) {
@@ -120,15 +178,10 @@ func (y *Server) streamUpload(w http.ResponseWriter, r *http.Request) {
 			audit.failure("failed to store file")
 			jsonError(w, http.StatusInternalServerError, "Failed to store file")
 		}
-		return
+		return false
 	}
 
 	// Store metadata in database
-	meta := yopass.Secret{
-		Expiration:  expiration,
-		OneTime:     oneTime,
-		RequireAuth: requireAuth,
-	}
 	if err := y.DB.Put(streamKeyPrefix+key, meta); err != nil {
 		y.Logger.Error("Failed to store stream metadata", zap.Error(err))
 		// Clean up the file since metadata storage failed
@@ -137,15 +190,27 @@ func (y *Server) streamUpload(w http.ResponseWriter, r *http.Request) {
 		}
 		audit.failure("failed to store metadata")
 		jsonError(w, http.StatusInternalServerError, "Failed to store metadata")
-		return
+		return false
 	}
 
-	audit.success(withOneTime(oneTime), withExpiration(expiration), withRequireAuth(requireAuth))
-	y.webhookCreated(key, WebhookKindFile, oneTime, expiration)
+	audit.success(withOneTime(p.oneTime), withExpiration(p.expiration), withRequireAuth(p.requireAuth), withViews(meta))
+	now := time.Now().Unix()
+	y.addCreatorEntry(owner, creatorEntry{
+		ID:        key,
+		Kind:      WebhookKindFile,
+		Label:     p.label,
+		CreatedAt: now,
+		ExpiresAt: now + int64(p.expiration),
+	})
+	y.webhookCreated(key, WebhookKindFile, meta)
 	y.writeJSON(w, http.StatusOK, response)
+	return true
 }
 
-// streamDownload serves the encrypted file as a binary stream.
+// streamDownload serves the encrypted file as a binary stream. Files without
+// a view limit honor single byte ranges (Range/If-Range) so interrupted
+// downloads can resume; one-time and view-limited files are always served
+// whole.
 func (y *Server) streamDownload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "private, no-cache")
 
@@ -167,33 +232,90 @@ func (y *Server) streamDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// See getSecret: the POST redeeming the claim nonce downloads the file.
+	if y.requiresClaim(secret) && r.Method != http.MethodPost {
+		y.issueClaim(w, streamKeyPrefix+key, secret, audit.withEvent("file.claim_issued"))
+		return
+	}
+	var ok bool
+	if secret, ok = y.checkPIN(w, r, key, WebhookKindFile, secret, audit); !ok {
+		return
+	}
+	if y.requiresClaim(secret) && !y.redeemClaim(w, r, streamKeyPrefix+key, audit) {
+		return
+	}
+
 	isOneTime := secret.OneTime
 
-	// For one-time secrets: atomically claim ownership by deleting the metadata
-	// key BEFORE loading the file.
-	if isOneTime && !y.claimOneTimeSecret(w, streamKeyPrefix+key, audit) {
-		return
+	// A one-time file counts as consumed only once its final byte has been
+	// delivered. Until then this request holds an exclusive claim on it, so
+	// concurrent requests are refused while an interrupted download releases
+	// the claim and can be retried.
+	var claim *downloadClaim
+	if isOneTime {
+		claim = y.claimOneTimeDownload(w, key, audit)
+		if claim == nil {
+			return
+		}
+		defer claim.release()
+	}
+
+	// A view-limited file counts the view as the download starts, so
+	// concurrent downloads can never exceed the limit; an interrupted
+	// download still uses up its view. Once the last view is claimed the
+	// metadata is gone and the file is removed however this request ends.
+	viewLimited := secret.MaxViews > 0
+	if viewLimited {
+		if secret, ok = y.claimSecretView(w, streamKeyPrefix+key, audit); !ok {
+			return
+		}
+		if secret.Views == secret.MaxViews {
+			defer y.deleteConsumedFile(key, audit)
+		}
+	}
+
+	// The content behind a key never changes, so a fingerprint of the key is
+	// a valid strong validator for If-Range.
+	etag := `"` + redactSecretID(key) + `"`
+	offset, length, ranged := int64(0), int64(-1), false
+	if !isOneTime && !viewLimited {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+		if ifRange := r.Header.Get("If-Range"); ifRange == "" || ifRange == etag {
+			offset, length, ranged = parseByteRange(r.Header.Get("Range"))
+		}
 	}
 
 	// Load file from store
 	ctx := r.Context()
-	reader, size, err := y.FileStore.Load(ctx, key)
+	var reader io.ReadCloser
+	var size int64
+	if ranged {
+		reader, size, err = loadFileRange(ctx, y.FileStore, key, offset, length)
+	} else {
+		reader, size, err = y.FileStore.Load(ctx, key)
+	}
+	if errors.Is(err, ErrRangeNotSatisfiable) {
+		if size >= 0 {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		}
+		audit.failure("range not satisfiable")
+		jsonError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable")
+		return
+	}
 	if err != nil {
 		y.Logger.Error("Failed to load streaming file", zap.Error(err))
 		// Only a definite not-found means the file is gone. Anything else
-		// (network blip, credentials, disk) is transient. A multi-view secret
-		// keeps its metadata and is retrievable once the store recovers; a
-		// one-time secret was already claimed above and is lost either way.
+		// (network blip, credentials, disk) is transient and the file stays
+		// retrievable once the store recovers.
 		if !errors.Is(err, ErrKeyNotFound) {
 			audit.failure("file store unavailable")
 			jsonError(w, http.StatusServiceUnavailable, "File store temporarily unavailable")
 			return
 		}
 		// DB metadata exists but the file is gone — clean up the stale DB entry.
-		if !isOneTime {
-			if _, delErr := y.DB.Delete(streamKeyPrefix + key); delErr != nil {
-				y.Logger.Error("Failed to clean up stale stream metadata", zap.Error(delErr))
-			}
+		if _, delErr := y.DB.Delete(streamKeyPrefix + key); delErr != nil {
+			y.Logger.Error("Failed to clean up stale stream metadata", zap.Error(delErr))
 		}
 		audit.failure("file not found in store")
 		jsonError(w, http.StatusNotFound, "File not found")
@@ -203,31 +325,104 @@ func (y *Server) streamDownload(w http.ResponseWriter, r *http.Request) {
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length")
-	if size > 0 {
-		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag")
+	status := http.StatusOK
+	served := size
+	partial := false
+	var auditFields []auditField
+	if ranged {
+		start, n, _ := resolveRange(offset, length, size)
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, size)
+		w.Header().Set("Content-Range", contentRange)
+		status, served = http.StatusPartialContent, n
+		auditFields = append(auditFields, withRange(contentRange))
+		// Only a range reaching the end completes a download.
+		partial = start+n < size
+	}
+	if served > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(served, 10))
 	}
+	w.WriteHeader(status)
 
 	// Stream the file
-	if _, err := io.Copy(w, reader); err != nil {
+	var out io.Writer = w
+	if claim != nil {
+		out = claim.guard(w)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
 		y.Logger.Error("Failed to stream file", zap.Error(err))
+		audit.failure("download interrupted", append(auditFields, withOneTime(isOneTime))...)
+		return
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		y.Logger.Error("Failed to flush file", zap.Error(err))
+		audit.failure("download interrupted", append(auditFields, withOneTime(isOneTime))...)
 		return
 	}
 
-	audit.success(withOneTime(isOneTime), withRequireAuth(secret.RequireAuth))
+	audit.success(append(auditFields, withOneTime(isOneTime), withRequireAuth(secret.RequireAuth), withViews(secret))...)
+	if partial {
+		// Part of a resumed download; the view is reported once the range
+		// containing the final byte has been served.
+		return
+	}
+	if claim != nil {
+		claim.consume(audit)
+	}
 	y.markReceiptViewed(key)
-	y.webhookViewed(key, WebhookKindFile, isOneTime)
+	y.webhookViewed(key, WebhookKindFile, secret)
 
-	// Delete the file after streaming for one-time secrets.
-	// Metadata was already deleted above (before file load) to prevent replay.
+	// Delete the file after streaming for one-time secrets. Its metadata
+	// was consumed above, so the download cannot be repeated.
 	if isOneTime {
-		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := y.FileStore.Delete(delCtx, key); err != nil {
-			y.Logger.Error("Failed to delete one-time streaming file", zap.Error(err))
-			audit.withEvent("file.cleanup_failed").failure("failed to delete file from store after delivery")
+		y.deleteConsumedFile(key, audit)
+	}
+}
+
+// deleteConsumedFile removes a file from the store, along with its
+// management record, once its metadata has been consumed by its last
+// permitted download.
+func (y *Server) deleteConsumedFile(key string, audit *auditor) {
+	y.deleteManagement(key)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := y.FileStore.Delete(ctx, key); err != nil {
+		y.Logger.Error("Failed to delete consumed streaming file", zap.Error(err))
+		audit.withEvent("file.cleanup_failed").failure("failed to delete file from store after delivery")
+	}
+}
+
+// parseByteRange parses a Range header holding a single byte range into a
+// LoadRange offset and length. Anything else, including multiple ranges,
+// reports false so the whole file is served, as RFC 9110 permits.
+func parseByteRange(header string) (offset, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
 		}
+		return -n, -1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if last == "" {
+		return start, -1, true
 	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
 }
 
 // isOpenPGPBinary reports whether b is a valid OpenPGP packet tag byte
@@ -248,8 +443,179 @@ func isOpenPGPBinary(b byte) bool {
 }
 
 // streamOptions handles CORS preflight for streaming endpoints, which also
-// expose Content-Length so browsers can track download progress.
+// expose Content-Length so browsers can track download progress, and the
+// range headers needed to resume a download.
 func (y *Server) streamOptions(w http.ResponseWriter, r *http.Request) {
-	corsPreflight("POST, GET, DELETE, OPTIONS", "Content-Type, X-Yopass-Expiration, X-Yopass-OneTime, X-Yopass-RequireAuth, X-Yopass-Receipt")(w, r)
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+	corsPreflight("POST, GET, DELETE, OPTIONS", "Content-Type, Range, If-Range, X-Yopass-Expiration, X-Yopass-OneTime, X-Yopass-RequireAuth, X-Yopass-Receipt, X-Yopass-MaxViews, "+pinHeader+", "+allowedRecipientsHeader+", "+allowedGroupsHeader+", "+labelHeader+", "+manageTokenHeader)(w, r)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag")
+}
+
+// oneTimeDownloadLease is how long a claim on a one-time file survives
+// without renewal, bounding how long a crashed download blocks retries.
+const oneTimeDownloadLease = 2 * time.Minute
+
+var (
+	errDownloadInProgress = errors.New("download in progress")
+	errClaimLost          = errors.New("download claim lost")
+)
+
+// streamMeta is kept in the Message of a file's metadata record once it is
+// first rewritten; a new record has an empty Message.
+type streamMeta struct {
+	ClaimToken   string `json:"claim_token,omitempty"`
+	ClaimedUntil int64  `json:"claimed_until,omitempty"`
+}
+
+// updateStreamMeta applies fn to a file's metadata atomically.
+func (y *Server) updateStreamMeta(key string, fn func(*streamMeta) error) error {
+	return y.DB.Update(streamKeyPrefix+key, func(s yopass.Secret) (yopass.Secret, error) {
+		var m streamMeta
+		if s.Message != "" {
+			if err := json.Unmarshal([]byte(s.Message), &m); err != nil {
+				return s, err
+			}
+		}
+		// The expiry is pinned when the file is stored so rewrites keep the
+		// remaining TTL; a record without one keeps its stored expiration.
+		if s.ExpiresAt != 0 {
+			ttl := secondsUntil(s.ExpiresAt)
+			if ttl == 0 {
+				return s, ErrKeyNotFound
+			}
+			s.Expiration = ttl
+		}
+		if err := fn(&m); err != nil {
+			return s, err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return s, err
+		}
+		s.Message = string(data)
+		return s, nil
+	})
+}
+
+// downloadClaim is an exclusive, renewed lease on a one-time file held for
+// the duration of its download.
+type downloadClaim struct {
+	y     *Server
+	key   string
+	token string
+	lost  atomic.Bool
+	stop  chan struct{}
+	done  sync.Once
+}
+
+// claimOneTimeDownload takes the download claim on a one-time file. It
+// writes the error response and audit event itself and returns nil when the
+// file cannot be claimed.
+func (y *Server) claimOneTimeDownload(w http.ResponseWriter, key string, audit *auditor) *downloadClaim {
+	token, _, err := generateToken()
+	if err == nil {
+		err = y.updateStreamMeta(key, func(m *streamMeta) error {
+			now := time.Now()
+			if m.ClaimedUntil > now.Unix() {
+				return errDownloadInProgress
+			}
+			m.ClaimToken = token
+			m.ClaimedUntil = now.Add(oneTimeDownloadLease).Unix()
+			return nil
+		})
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrKeyNotFound):
+		audit.denied("claimed by concurrent request", withOneTime(true))
+		jsonError(w, http.StatusNotFound, "Secret not found")
+		return nil
+	case errors.Is(err, errDownloadInProgress):
+		audit.denied("download in progress", withOneTime(true))
+		jsonError(w, http.StatusConflict, "File download already in progress")
+		return nil
+	default:
+		y.Logger.Error("Failed to claim one-time file", zap.Error(err))
+		audit.failure("failed to claim one-time secret", withOneTime(true))
+		jsonError(w, http.StatusInternalServerError, "Failed to process secret")
+		return nil
+	}
+
+	c := &downloadClaim{y: y, key: key, token: token, stop: make(chan struct{})}
+	go c.renew()
+	return c
+}
+
+// renew extends the lease until the claim is consumed or released. A failed
+// renewal marks the claim lost, which aborts the download through guard.
+func (c *downloadClaim) renew() {
+	ticker := time.NewTicker(oneTimeDownloadLease / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			err := c.y.updateStreamMeta(c.key, func(m *streamMeta) error {
+				if m.ClaimToken != c.token {
+					return errClaimLost
+				}
+				m.ClaimedUntil = time.Now().Add(oneTimeDownloadLease).Unix()
+				return nil
+			})
+			if err != nil {
+				c.y.Logger.Warn("Lost claim on one-time file", zap.Error(err))
+				c.lost.Store(true)
+				return
+			}
+		}
+	}
+}
+
+// guard returns a writer that fails once the claim is lost, so a download
+// whose lease lapsed cannot complete alongside another claimant.
+func (c *downloadClaim) guard(w io.Writer) io.Writer {
+	return claimGuardWriter{w: w, claim: c}
+}
+
+type claimGuardWriter struct {
+	w     io.Writer
+	claim *downloadClaim
+}
+
+func (g claimGuardWriter) Write(p []byte) (int, error) {
+	if g.claim.lost.Load() {
+		return 0, errClaimLost
+	}
+	return g.w.Write(p)
+}
+
+// consume ends the claim by deleting the metadata after the final byte has
+// been delivered.
+func (c *downloadClaim) consume(audit *auditor) {
+	c.done.Do(func() {
+		close(c.stop)
+		if _, err := c.y.DB.Delete(streamKeyPrefix + c.key); err != nil {
+			c.y.Logger.Error("Failed to consume one-time file", zap.Error(err))
+			audit.withEvent("file.cleanup_failed").failure("failed to delete metadata after delivery")
+		}
+	})
+}
+
+// release gives up an unconsumed claim so the download can be retried. It
+// is a no-op after consume.
+func (c *downloadClaim) release() {
+	c.done.Do(func() {
+		close(c.stop)
+		err := c.y.updateStreamMeta(c.key, func(m *streamMeta) error {
+			if m.ClaimToken != c.token {
+				return errClaimLost
+			}
+			m.ClaimToken = ""
+			m.ClaimedUntil = 0
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, errClaimLost) {
+			c.y.Logger.Error("Failed to release claim on one-time file", zap.Error(err))
+		}
+	})
 }
@@ -7,10 +7,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap/zaptest"
@@ -562,3 +564,313 @@ func TestIsOpenPGPBinary(t *testing.T) {
 		})
 	}
 }
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header         string
+		offset, length int64
+		ok             bool
+	}{
+		{"bytes=0-9", 0, 10, true},
+		{"bytes=5-", 5, -1, true},
+		{"bytes=-3", -3, -1, true},
+		{"bytes=3-3", 3, 1, true},
+		{"", 0, 0, false},
+		{"bytes=5-2", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 0, false},
+		{"items=0-9", 0, 0, false},
+		{"bytes=a-b", 0, 0, false},
+	}
+	for _, tt := range tests {
+		offset, length, ok := parseByteRange(tt.header)
+		if ok != tt.ok || offset != tt.offset || length != tt.length {
+			t.Errorf("parseByteRange(%q) = %d, %d, %v; want %d, %d, %v", tt.header, offset, length, ok, tt.offset, tt.length, tt.ok)
+		}
+	}
+}
+
+func rangeDownload(handler http.Handler, key string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/file/"+key, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestStreamDownloadRange(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+	key := doStreamUpload(t, handler)
+	full := pgpBody("encrypted-test-data")
+	size := strconv.Itoa(len(full))
+
+	w := rangeDownload(handler, key, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected 200 with Accept-Ranges, got %d %q", w.Code, w.Header().Get("Accept-Ranges"))
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || strings.Contains(etag, key) {
+		t.Fatalf("expected an ETag not exposing the key, got %q", etag)
+	}
+
+	w = rangeDownload(handler, key, map[string]string{"Range": "bytes=1-9"})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 1-9/"+size {
+		t.Errorf("unexpected Content-Range %q", got)
+	}
+	if w.Body.String() != full[1:10] || w.Header().Get("Content-Length") != "9" {
+		t.Errorf("unexpected partial body %q (Content-Length %s)", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+
+	// Resume from where the first request stopped.
+	w = rangeDownload(handler, key, map[string]string{"Range": "bytes=10-", "If-Range": etag})
+	if w.Code != http.StatusPartialContent || w.Body.String() != full[10:] {
+		t.Errorf("resume: got %d %q", w.Code, w.Body.String())
+	}
+
+	// A stale validator or an unsupported range falls back to the whole file.
+	for _, h := range []map[string]string{
+		{"Range": "bytes=10-", "If-Range": `"stale"`},
+		{"Range": "bytes=0-1,4-5"},
+	} {
+		w = rangeDownload(handler, key, h)
+		if w.Code != http.StatusOK || w.Body.String() != full {
+			t.Errorf("%v: expected whole file, got %d %q", h, w.Code, w.Body.String())
+		}
+	}
+
+	w = rangeDownload(handler, key, map[string]string{"Range": "bytes=1000-"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */"+size {
+		t.Errorf("expected 416 with size, got %d %q", w.Code, w.Header().Get("Content-Range"))
+	}
+}
+
+func TestStreamDownloadRangeReportsViewOnFinalByte(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.License = validLicense()
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("encrypted-test-data"), "3600", "false", "")
+	req.Header.Set("X-Yopass-Receipt", "true")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	key := resp["message"]
+
+	receiptState := func() string {
+		r, ok := srv.loadReceipt(key)
+		if !ok {
+			t.Fatal("receipt missing")
+		}
+		return r.State
+	}
+
+	rangeDownload(handler, key, map[string]string{"Range": "bytes=0-4"})
+	if state := receiptState(); state != ReceiptStatePending {
+		t.Errorf("partial range must not mark the receipt viewed, got %s", state)
+	}
+	rangeDownload(handler, key, map[string]string{"Range": "bytes=5-"})
+	if state := receiptState(); state != ReceiptStateViewed {
+		t.Errorf("final range must mark the receipt viewed, got %s", state)
+	}
+}
+
+func TestStreamDownloadOneTimeIgnoresRange(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("one-time-data"), "3600", "true", "")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	w = rangeDownload(handler, resp["message"], map[string]string{"Range": "bytes=0-1"})
+	if w.Code != http.StatusOK || w.Body.String() != pgpBody("one-time-data") {
+		t.Fatalf("expected whole one-time file, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Accept-Ranges") != "" || w.Header().Get("ETag") != "" {
+		t.Error("one-time files must not advertise range support")
+	}
+}
+
+// interruptingFileStore serves a reader that fails partway through, once.
+type interruptingFileStore struct {
+	FileStore
+	interrupted atomic.Bool
+}
+
+func (s *interruptingFileStore) Load(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	rc, size, err := s.FileStore.Load(ctx, key)
+	if err != nil || s.interrupted.Swap(true) {
+		return rc, size, err
+	}
+	return readCloser{Reader: io.MultiReader(io.LimitReader(rc, 2), iotestErrReader{}), Closer: rc}, size, nil
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestStreamDownloadOneTimeInterruptedCanRetry(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.FileStore = &interruptingFileStore{FileStore: NewDatabaseFileStore(db)}
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("one-time-data"), "3600", "true", "")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	key := resp["message"]
+
+	rangeDownload(handler, key, nil)
+	if _, err := db.Status(streamKeyPrefix + key); err != nil {
+		t.Fatal("an interrupted download must not consume the one-time file")
+	}
+
+	w = rangeDownload(handler, key, nil)
+	if w.Code != http.StatusOK || w.Body.String() != pgpBody("one-time-data") {
+		t.Fatalf("retry: expected whole file, got %d %q", w.Code, w.Body.String())
+	}
+	if _, err := db.Status(streamKeyPrefix + key); err == nil {
+		t.Error("a completed download must consume the one-time file")
+	}
+	if w := rangeDownload(handler, key, nil); w.Code != http.StatusNotFound {
+		t.Errorf("third download: expected 404, got %d", w.Code)
+	}
+}
+
+func TestStreamDownloadOneTimeClaimed(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("one-time-data"), "3600", "true", "")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	key := resp["message"]
+
+	// Another request holds the claim.
+	setClaim := func(until time.Time) {
+		err := srv.updateStreamMeta(key, func(m *streamMeta) error {
+			m.ClaimToken = "other"
+			m.ClaimedUntil = until.Unix()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	setClaim(time.Now().Add(time.Minute))
+	if w := rangeDownload(handler, key, nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while claimed, got %d", w.Code)
+	}
+
+	// A lapsed claim (e.g. a crashed instance) no longer blocks downloads.
+	setClaim(time.Now().Add(-time.Second))
+	if w := rangeDownload(handler, key, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the claim lapsed, got %d", w.Code)
+	}
+}
+
+func TestUpdateStreamMetaKeepsRemainingTTL(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, streamUploadRequest(pgpBody("data"), "3600", "true", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	key := resp["message"]
+
+	// Pretend the file was stored 20 minutes ago: even the first rewrite
+	// must keep the remaining TTL rather than restart the full one.
+	s, _ := db.Status(streamKeyPrefix + key)
+	s.ExpiresAt -= 1200
+	db.Put(streamKeyPrefix+key, s)
+	noop := func(*streamMeta) error { return nil }
+	if err := srv.updateStreamMeta(key, noop); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = db.Status(streamKeyPrefix + key)
+	if s.Expiration > 2400 || s.Expiration < 2390 {
+		t.Errorf("expected the remaining TTL, got %d", s.Expiration)
+	}
+	if !s.OneTime {
+		t.Error("rewrite must keep the one-time flag")
+	}
+	if err := srv.updateStreamMeta(key, noop); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = db.Status(streamKeyPrefix + key); s.Expiration > 2400 {
+		t.Errorf("a second rewrite extended the TTL to %d", s.Expiration)
+	}
+}
+
+func TestStreamDownloadMaxViews(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	req := streamUploadRequest(pgpBody("limited-data"), "3600", "", "")
+	req.Header.Set("X-Yopass-MaxViews", "2")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	key := resp["message"]
+
+	for view := 1; view <= 2; view++ {
+		// View-limited files are served whole, so every request is a view.
+		w = rangeDownload(handler, key, map[string]string{"Range": "bytes=0-1"})
+		if w.Code != http.StatusOK || w.Body.String() != pgpBody("limited-data") {
+			t.Fatalf("view %d: got %d %q", view, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Accept-Ranges") != "" {
+			t.Error("view-limited files must not advertise range support")
+		}
+	}
+
+	w = rangeDownload(handler, key, nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after the last view, got %d", w.Code)
+	}
+	if _, _, err := srv.FileStore.Load(context.Background(), key); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the file to be deleted after its last view, got %v", err)
+	}
+}
+
+func TestStreamUploadInvalidMaxViews(t *testing.T) {
+	for _, v := range []string{"-2", "many"} {
+		srv := newStreamTestServer(t, newTestDB())
+		req := streamUploadRequest(pgpBody("data"), "3600", "", "")
+		req.Header.Set("X-Yopass-MaxViews", v)
+		w := httptest.NewRecorder()
+		srv.HTTPHandler().ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("max views %q: expected 400, got %d", v, w.Code)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -46,6 +49,9 @@ func (db *mockDB) Status(key string) (yopass.Secret, error) {
 func (db *mockDB) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return nil
 }
+func (db *mockDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return true, nil
+}
 func (db *mockDB) Health() error { return nil }
 
 type brokenDB struct{}
@@ -61,6 +67,9 @@ func (db *brokenDB) Status(key string) (yopass.Secret, error) {
 func (db *brokenDB) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return fmt.Errorf("Some error")
 }
+func (db *brokenDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return false, fmt.Errorf("Some error")
+}
 func (db *brokenDB) Health() error { return fmt.Errorf("Some error") }
 
 // brokenDeleteDB simulates a DB where Status succeeds but Delete returns an error.
@@ -73,6 +82,9 @@ func (db *brokenDeleteDB) Status(key string) (yopass.Secret, error)   { return y
 func (db *brokenDeleteDB) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return nil
 }
+func (db *brokenDeleteDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return true, nil
+}
 func (db *brokenDeleteDB) Health() error { return nil }
 
 // mockBrokenDB2 simulates a DB where Get succeeds but Delete reports not found.
@@ -87,6 +99,9 @@ func (db *mockBrokenDB2) Status(key string) (yopass.Secret, error)   { return yo
 func (db *mockBrokenDB2) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return fmt.Errorf("Some error")
 }
+func (db *mockBrokenDB2) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return false, fmt.Errorf("Some error")
+}
 func (db *mockBrokenDB2) Health() error { return nil }
 
 // mockStatusDB returns a configurable secret for status/get tests.
@@ -112,6 +127,9 @@ func (db *mockStatusDB) Status(key string) (yopass.Secret, error) {
 func (db *mockStatusDB) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return nil
 }
+func (db *mockStatusDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return true, nil
+}
 func (db *mockStatusDB) Health() error { return nil }
 
 // armorShaped returns n bytes with armor's 64-character line wrapping: the
@@ -1642,6 +1660,8 @@ dhgGsvKwXJm0kEwGwqj6mJq/j28FSFoP9Et/LtRuEe3Ct06WOrrHQ4v9DC4=
 
 type mockHealthDB struct {
 	healthy bool
+	// err, when set, is returned by Health instead of the healthy flag.
+	err error
 }
 
 func (db *mockHealthDB) Get(key string) (yopass.Secret, error) {
@@ -1659,13 +1679,42 @@ func (db *mockHealthDB) Status(key string) (yopass.Secret, error) {
 func (db *mockHealthDB) Update(key string, fn func(yopass.Secret) (yopass.Secret, error)) error {
 	return nil
 }
+func (db *mockHealthDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	return true, nil
+}
 func (db *mockHealthDB) Health() error {
+	if db.err != nil {
+		return db.err
+	}
 	if !db.healthy {
 		return fmt.Errorf("database unhealthy")
 	}
 	return nil
 }
 
+// mockNodeHealthDB is a multi-node backend that is degraded when any node
+// is down. It counts its probes.
+type mockNodeHealthDB struct {
+	mockHealthDB
+	nodes  map[string]error
+	probes int
+}
+
+func (db *mockNodeHealthDB) NodeHealth() (map[string]error, error) {
+	db.probes++
+	for _, err := range db.nodes {
+		if err != nil {
+			return db.nodes, &DegradedError{Reason: "node down"}
+		}
+	}
+	return db.nodes, nil
+}
+
+func (db *mockNodeHealthDB) Health() error {
+	_, err := db.NodeHealth()
+	return err
+}
+
 func TestHealthHandler(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -1741,6 +1790,35 @@ func TestReadyHandler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:       "degraded database stays ready",
+			statusCode: 200,
+			db:         &mockHealthDB{err: &DegradedError{Reason: "replica 10.0.0.2:6379 is s_down"}},
+			checkBody: func(t *testing.T, body string) {
+				if !strings.Contains(body, `"status":"ready"`) {
+					t.Errorf("expected ready status in response: %s", body)
+				}
+				if !strings.Contains(body, `"degraded":"replica 10.0.0.2:6379 is s_down"`) {
+					t.Errorf("expected degraded reason in response: %s", body)
+				}
+			},
+		},
+		{
+			name:       "multi-node database lists nodes",
+			statusCode: 200,
+			db: &mockNodeHealthDB{nodes: map[string]error{
+				"10.0.0.1:11211": nil,
+				"10.0.0.2:11211": errors.New("dial tcp: connection refused"),
+			}},
+			checkBody: func(t *testing.T, body string) {
+				if !strings.Contains(body, `"nodes":{"10.0.0.1:11211":"ok","10.0.0.2:11211":"unreachable"}`) {
+					t.Errorf("expected node states in response: %s", body)
+				}
+				if strings.Contains(body, "connection refused") {
+					t.Errorf("expected node errors to stay out of the response: %s", body)
+				}
+			},
+		},
 		{
 			name:       "nil database returns 503",
 			statusCode: 503,
@@ -1772,6 +1850,19 @@ func TestReadyHandler(t *testing.T) {
 	}
 }
 
+func TestReadyHandlerProbesNodesOnce(t *testing.T) {
+	db := &mockNodeHealthDB{nodes: map[string]error{"10.0.0.1:11211": nil}}
+	y := newTestServer(t, db, 1, false)
+	rr := httptest.NewRecorder()
+	y.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code 200; got %d", rr.Code)
+	}
+	if db.probes != 1 {
+		t.Errorf("expected the nodes to be probed once, got %d", db.probes)
+	}
+}
+
 func TestHealthEndpointRoutes(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -2492,3 +2583,224 @@ func TestConfigHandler_ForceExpiration(t *testing.T) {
 		}
 	})
 }
+
+func TestCreateSecret_ExpirationPolicy(t *testing.T) {
+	validPGPMessage := `-----BEGIN PGP MESSAGE-----
+Version: OpenPGP.js v4.10.8
+Comment: https://openpgpjs.org
+
+wy4ECQMIRthQ3aO85NvgAfASIX3dTwsFVt0gshPu7n1tN05e8rpqxOk6PYNm
+xtt90k4BqHuTCLNlFRJjuiuE8zdIc+j5zTN5zihxUReVqokeqULLOx2FBMHZ
+sbfqaG/iDbp+qDOc98IagMyPrEqKDxnhVVOraXy5dD9RDsntLso=
+=0vwU
+-----END PGP MESSAGE-----`
+	escapedPGP := strings.ReplaceAll(validPGPMessage, "\n", "\\n")
+
+	tests := []struct {
+		name       string
+		min, max   string
+		expiration int32
+		wantCode   int
+	}{
+		{"default policy accepts arbitrary duration", "", "", 7200, http.StatusOK},
+		{"default policy rejects below one hour", "", "", 900, http.StatusBadRequest},
+		{"default policy rejects above one week", "", "", 604801, http.StatusBadRequest},
+		{"widened minimum", "15m", "", 900, http.StatusOK},
+		{"widened maximum", "", "30d", 2592000, http.StatusOK},
+		{"above widened maximum", "", "30d", 2592001, http.StatusBadRequest},
+		{"narrowed maximum", "", "1d", 604800, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			y := newTestServer(t, &mockDB{}, 10000, false)
+			y.MinExpiration = tc.min
+			y.MaxExpiration = tc.max
+			body := strings.NewReader(fmt.Sprintf(`{"message": "%s", "expiration": %d}`, escapedPGP, tc.expiration))
+			req, _ := http.NewRequest("POST", "/secret", body)
+			rr := httptest.NewRecorder()
+			y.createSecret(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestConfigHandler_ExpirationPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max string
+		wantMin  float64
+		wantMax  float64
+	}{
+		{"defaults", "", "", 3600, 604800},
+		{"configured", "15m", "30d", 900, 2592000},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{
+				Registry:      prometheus.NewRegistry(),
+				Logger:        zaptest.NewLogger(t),
+				MinExpiration: tc.min,
+				MaxExpiration: tc.max,
+			}
+			req := httptest.NewRequest(http.MethodGet, "/config", nil)
+			w := httptest.NewRecorder()
+			s.configHandler(w, req)
+
+			var cfg map[string]interface{}
+			if err := json.NewDecoder(w.Result().Body).Decode(&cfg); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if cfg["MIN_EXPIRATION"] != tc.wantMin || cfg["MAX_EXPIRATION"] != tc.wantMax {
+				t.Errorf("expected MIN_EXPIRATION %v and MAX_EXPIRATION %v, got %v and %v",
+					tc.wantMin, tc.wantMax, cfg["MIN_EXPIRATION"], cfg["MAX_EXPIRATION"])
+			}
+		})
+	}
+}
+
+// createViewLimitedSecret creates a secret through the API and returns its
+// key, failing the test unless the server answers wantCode.
+func createViewLimitedSecret(t *testing.T, handler http.Handler, fields string, wantCode int) string {
+	t.Helper()
+	encrypted, err := yopass.Encrypt(strings.NewReader("hunter2"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := json.Marshal(encrypted)
+	body := fmt.Sprintf(`{"message": %s, "expiration": 3600%s}`, message, fields)
+	req, _ := http.NewRequest("POST", "/create/secret", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != wantCode {
+		t.Fatalf("expected %d, got %d: %s", wantCode, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp.Message
+}
+
+func TestCreateSecret_MaxViewsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   string
+		wantCode int
+		wantMsg  string
+	}{
+		{"negative", `, "max_views": -1`, http.StatusBadRequest, "Invalid max_views specified"},
+		{"combined with one_time", `, "max_views": 2, "one_time": true`, http.StatusBadRequest, "one_time and max_views cannot be combined"},
+		{"one view is one-time", `, "max_views": 1, "one_time": true`, http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newMemoryDB()
+			y := newTestServer(t, db, 10000, false)
+			handler := y.HTTPHandler()
+			key := createViewLimitedSecret(t, handler, tc.fields, tc.wantCode)
+			if tc.wantMsg != "" && key != tc.wantMsg {
+				t.Errorf("expected message %q, got %q", tc.wantMsg, key)
+			}
+			if tc.wantCode == http.StatusOK {
+				s, _ := db.Get(key)
+				if !s.OneTime || s.MaxViews != 0 {
+					t.Errorf("expected a single view to be stored as one-time, got %+v", s)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSecret_MaxViewsRespectsForceOneTime(t *testing.T) {
+	y := newTestServer(t, newMemoryDB(), 10000, true)
+	createViewLimitedSecret(t, y.HTTPHandler(), `, "max_views": 3`, http.StatusBadRequest)
+}
+
+func TestGetSecret_MaxViews(t *testing.T) {
+	db := newMemoryDB()
+	y := newTestServer(t, db, 10000, false)
+	y.PrefetchSecret = true
+	handler := y.HTTPHandler()
+	// Client-supplied counters are ignored.
+	key := createViewLimitedSecret(t, handler, `, "max_views": 3, "views": 2`, http.StatusOK)
+
+	status := func() string {
+		req, _ := http.NewRequest("GET", "/secret/"+key+"/status", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return strings.TrimSpace(rr.Body.String())
+	}
+	if got := status(); got != `{"maxViews":3,"oneTime":false,"remainingViews":3,"requireAuth":false}` {
+		t.Fatalf("unexpected status before viewing: %s", got)
+	}
+
+	for view := 1; view <= 3; view++ {
+		req, _ := http.NewRequest("GET", "/secret/"+key, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("view %d: expected 200, got %d", view, rr.Code)
+		}
+		if view == 2 {
+			if got := status(); got != `{"maxViews":3,"oneTime":false,"remainingViews":1,"requireAuth":false}` {
+				t.Errorf("unexpected status after two views: %s", got)
+			}
+		}
+	}
+
+	if _, err := db.Get(key); err == nil {
+		t.Error("expected the secret to be deleted after its last view")
+	}
+	req, _ := http.NewRequest("GET", "/secret/"+key, nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after the last view, got %d", rr.Code)
+	}
+}
+
+func TestGetSecret_MaxViewsKeepsRemainingLifetime(t *testing.T) {
+	db := newMemoryDB()
+	y := newTestServer(t, db, 10000, false)
+	handler := y.HTTPHandler()
+	key := createViewLimitedSecret(t, handler, `, "max_views": 3`, http.StatusOK)
+
+	// Pretend most of the lifetime has passed.
+	s, _ := db.Get(key)
+	s.ExpiresAt = time.Now().Unix() + 60
+	db.Put(key, s)
+
+	req, _ := http.NewRequest("GET", "/secret/"+key, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	s, _ = db.Get(key)
+	if s.Views != 1 || s.Expiration > 60 {
+		t.Errorf("expected one view with the remaining TTL, got views %d expiration %d", s.Views, s.Expiration)
+	}
+}
+
+func TestGetSecret_MaxViewsConcurrent(t *testing.T) {
+	db := newMemoryDB()
+	y := newTestServer(t, db, 10000, false)
+	handler := y.HTTPHandler()
+	key := createViewLimitedSecret(t, handler, `, "max_views": 5`, http.StatusOK)
+
+	var served atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			req, _ := http.NewRequest("GET", "/secret/"+key, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code == http.StatusOK {
+				served.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if got := served.Load(); got != 5 {
+		t.Errorf("expected exactly 5 successful views, got %d", got)
+	}
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhaals/yopass/pkg/yopass"
+	"go.uber.org/zap"
+)
+
+// Resumable uploads let clients send a large encrypted file as numbered
+// chunks over several requests:
+//
+//	POST   /create/file/upload                   start a session
+//	PUT    /create/file/upload/{id}/{n}          store chunk n (retry freely)
+//	GET    /create/file/upload/{id}              list received chunks to resume
+//	POST   /create/file/upload/{id}/finalize     assemble chunks into a file
+//	DELETE /create/file/upload/{id}              abort
+//
+// Chunks are kept in the FileStore and the session record in the database,
+// both with the session TTL, so abandoned uploads expire through the regular
+// cleanup. Every attempt at a chunk is written under a new key that the
+// session then points at, so a retried chunk never overwrites one that
+// finalize is reading. Only finalize creates a secret: the creation policy, receipt and
+// webhook are handled exactly as for a single-request upload.
+
+// uploadKeyPrefix namespaces upload sessions in the database. The embedded
+// slash keeps them out of reach of the /secret/{key} routes.
+const uploadKeyPrefix = "upload/"
+
+// uploadSessionTTL bounds how long an upload may take from start to finalize.
+const uploadSessionTTL = 24 * time.Hour
+
+// maxUploadChunks caps the number of chunks per upload.
+const maxUploadChunks = 10000
+
+// uploadSession is the stored state of a resumable upload.
+type uploadSession struct {
+	// Owner is the OIDC subject that started the upload, if any. Only the
+	// same subject may continue it.
+	Owner  string        `json:"owner,omitempty"`
+	Chunks map[int]int64 `json:"chunks"`
+	// Parts holds the tag of each chunk's FileStore key, see uploadChunkKey.
+	Parts      map[int]string `json:"parts,omitempty"`
+	Finalizing bool           `json:"finalizing,omitempty"`
+	CreatedAt  int64          `json:"created_at"`
+	ExpiresAt  int64          `json:"expires_at"`
+}
+
+// size returns the number of bytes received so far.
+func (u uploadSession) size() int64 {
+	var total int64
+	for _, n := range u.Chunks {
+		total += n
+	}
+	return total
+}
+
+// complete reports whether the received chunks are numbered 0..n-1 without
+// gaps.
+func (u uploadSession) complete() bool {
+	if len(u.Chunks) == 0 {
+		return false
+	}
+	for i := 0; i < len(u.Chunks); i++ {
+		if _, ok := u.Chunks[i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadChunkKey returns the FileStore key of the attempt at chunk n tagged
+// tag. The dot cannot occur in generated IDs, so chunks never collide with
+// finished files.
+func uploadChunkKey(id string, n int, tag string) string {
+	key := id + ".part" + strconv.Itoa(n)
+	if tag != "" {
+		key += "." + tag
+	}
+	return key
+}
+
+// chunkKey returns the FileStore key of the recorded copy of chunk n.
+// Sessions started before chunk keys were tagged have no tags.
+func (u uploadSession) chunkKey(id string, n int) string {
+	return uploadChunkKey(id, n, u.Parts[n])
+}
+
+var (
+	errUploadFinalizing = errors.New("upload is being finalized")
+	errUploadTooLarge   = errors.New("upload too large")
+)
+
+// loadUploadSession fetches and decodes an unexpired upload session.
+func (y *Server) loadUploadSession(id string) (uploadSession, bool) {
+	s, err := y.DB.Status(uploadKeyPrefix + id)
+	if err != nil {
+		return uploadSession{}, false
+	}
+	var u uploadSession
+	if err := json.Unmarshal([]byte(s.Message), &u); err != nil || secondsUntil(u.ExpiresAt) == 0 {
+		return uploadSession{}, false
+	}
+	return u, true
+}
+
+// putUploadSession encodes u into a database record expiring with the
+// session.
+func putUploadSession(u uploadSession) (yopass.Secret, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return yopass.Secret{}, err
+	}
+	return yopass.Secret{Message: string(data), Expiration: secondsUntil(u.ExpiresAt)}, nil
+}
+
+// updateUploadSession applies fn to the stored session atomically.
+func (y *Server) updateUploadSession(id string, fn func(*uploadSession) error) error {
+	return y.DB.Update(uploadKeyPrefix+id, func(s yopass.Secret) (yopass.Secret, error) {
+		var u uploadSession
+		if err := json.Unmarshal([]byte(s.Message), &u); err != nil {
+			return s, err
+		}
+		if secondsUntil(u.ExpiresAt) == 0 {
+			return s, ErrKeyNotFound
+		}
+		if err := fn(&u); err != nil {
+			return s, err
+		}
+		return putUploadSession(u)
+	})
+}
+
+// openUploadSession loads the session named in the route and checks that the
+// caller owns it. It writes the error response and reports whether the
+// request may proceed.
+func (y *Server) openUploadSession(w http.ResponseWriter, r *http.Request) (string, uploadSession, bool) {
+	id := mux.Vars(r)["key"]
+	u, ok := y.loadUploadSession(id)
+	if !ok {
+		jsonError(w, http.StatusNotFound, "Upload not found")
+		return id, u, false
+	}
+	if u.Owner != "" {
+		session, err := y.getSession(r)
+		if err != nil || session == nil || session.Sub != u.Owner {
+			jsonError(w, http.StatusForbidden, "Upload belongs to another user")
+			return id, u, false
+		}
+	}
+	return id, u, true
+}
+
+// writeUploadSession responds with the resumable state of an upload.
+func (y *Server) writeUploadSession(w http.ResponseWriter, id string, u uploadSession) {
+	chunks := make([]int, 0, len(u.Chunks))
+	for n := range u.Chunks {
+		chunks = append(chunks, n)
+	}
+	sort.Ints(chunks)
+	y.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"upload_id":  id,
+		"chunks":     chunks,
+		"size":       u.size(),
+		"expires_at": u.ExpiresAt,
+	})
+}
+
+// createUpload starts a resumable upload session.
+func (y *Server) createUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := yopass.GenerateID()
+	if err != nil {
+		y.Logger.Error("Unable to generate ID", zap.Error(err))
+		jsonError(w, http.StatusInternalServerError, "Unable to generate ID")
+		return
+	}
+	now := time.Now()
+	u := uploadSession{
+		Chunks:    map[int]int64{},
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(uploadSessionTTL).Unix(),
+	}
+	if session, err := y.getSession(r); err == nil && session != nil {
+		u.Owner = session.Sub
+	}
+	record, err := putUploadSession(u)
+	if err == nil {
+		err = y.DB.Put(uploadKeyPrefix+id, record)
+	}
+	if err != nil {
+		y.Logger.Error("Failed to store upload session", zap.Error(err))
+		jsonError(w, http.StatusInternalServerError, "Failed to store upload session")
+		return
+	}
+	y.writeUploadSession(w, id, u)
+}
+
+// getUpload reports the chunks received so far so a client can resume.
+func (y *Server) getUpload(w http.ResponseWriter, r *http.Request) {
+	id, u, ok := y.openUploadSession(w, r)
+	if !ok {
+		return
+	}
+	y.writeUploadSession(w, id, u)
+}
+
+// uploadChunk stores one chunk of a resumable upload. Uploading the same
+// chunk number again replaces it, so a chunk interrupted mid-transfer is
+// simply retried.
+func (y *Server) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(mux.Vars(r)["chunk"])
+	if err != nil || n >= maxUploadChunks {
+		jsonError(w, http.StatusBadRequest, "Invalid chunk number")
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/octet-stream" {
+		jsonError(w, http.StatusBadRequest, "Content-Type must be application/octet-stream")
+		return
+	}
+	id, u, ok := y.openUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if u.Finalizing {
+		jsonError(w, http.StatusConflict, "Upload is being finalized")
+		return
+	}
+
+	// The chunk may use whatever the rest of the upload leaves of the limit.
+	// The total is checked again when the chunk is recorded, since other
+	// chunks may be arriving concurrently.
+	var body io.Reader = r.Body
+	maxFileSize := y.effectiveMaxFileSize()
+	if maxFileSize > 0 {
+		remaining := maxFileSize - u.size() + u.Chunks[n]
+		if r.ContentLength > remaining {
+			jsonError(w, http.StatusRequestEntityTooLarge, "File too large")
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, remaining)
+	}
+
+	var peek [1]byte
+	if _, err := io.ReadFull(body, peek[:]); err != nil {
+		jsonError(w, http.StatusBadRequest, "Empty chunk")
+		return
+	}
+	if n == 0 && !isOpenPGPBinary(peek[0]) {
+		jsonError(w, http.StatusBadRequest, "Invalid data: not an OpenPGP message")
+		return
+	}
+	counter := &countingReader{r: io.MultiReader(bytes.NewReader(peek[:]), body)}
+
+	tag, err := yopass.GenerateID()
+	if err != nil {
+		y.Logger.Error("Unable to generate ID", zap.Error(err))
+		jsonError(w, http.StatusInternalServerError, "Unable to generate ID")
+		return
+	}
+	key := uploadChunkKey(id, n, tag)
+	if err := y.FileStore.Save(r.Context(), key, counter, r.ContentLength, secondsUntil(u.ExpiresAt)); err != nil {
+		y.Logger.Error("Failed to save upload chunk", zap.Error(err))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			jsonError(w, http.StatusRequestEntityTooLarge, "File too large")
+		} else {
+			jsonError(w, http.StatusInternalServerError, "Failed to store file")
+		}
+		return
+	}
+
+	// The session only points at the new copy once it is fully stored. A
+	// finalize that claimed the session first keeps reading the copies it
+	// recorded, and this one is discarded.
+	var replaced string
+	err = y.updateUploadSession(id, func(s *uploadSession) error {
+		if s.Finalizing {
+			return errUploadFinalizing
+		}
+		replaced = ""
+		if _, ok := s.Chunks[n]; ok {
+			replaced = s.chunkKey(id, n)
+		}
+		s.Chunks[n] = counter.n
+		if s.Parts == nil {
+			s.Parts = map[int]string{}
+		}
+		s.Parts[n] = tag
+		if maxFileSize > 0 && s.size() > maxFileSize {
+			return errUploadTooLarge
+		}
+		u = *s
+		return nil
+	})
+	if err == nil && replaced != "" {
+		if delErr := y.FileStore.Delete(r.Context(), replaced); delErr != nil {
+			y.Logger.Error("Failed to delete replaced upload chunk", zap.Error(delErr))
+		}
+	}
+	if err != nil {
+		if delErr := y.FileStore.Delete(r.Context(), key); delErr != nil {
+			y.Logger.Error("Failed to delete rejected upload chunk", zap.Error(delErr))
+		}
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			jsonError(w, http.StatusNotFound, "Upload not found")
+		case errors.Is(err, errUploadFinalizing):
+			jsonError(w, http.StatusConflict, "Upload is being finalized")
+		case errors.Is(err, errUploadTooLarge):
+			jsonError(w, http.StatusRequestEntityTooLarge, "File too large")
+		default:
+			y.Logger.Error("Failed to record upload chunk", zap.Error(err))
+			jsonError(w, http.StatusInternalServerError, "Failed to store upload session")
+		}
+		return
+	}
+	y.writeUploadSession(w, id, u)
+}
+
+// finalizeUpload assembles the received chunks into a file secret. It takes
+// the same X-Yopass-* headers as a single-request upload and applies the same
+// creation policy. A failed finalize leaves the session intact for a retry.
+func (y *Server) finalizeUpload(w http.ResponseWriter, r *http.Request) {
+	session, _ := y.getSession(r)
+	audit := y.newAuditor("file.uploaded", y.getRealClientIP(r), session)
+
+	id, u, ok := y.openUploadSession(w, r)
+	if !ok {
+		return
+	}
+	policy, ok := fileCreationPolicy(w, r, audit)
+	if !ok || !y.checkCreationPolicy(w, policy, audit) {
+		return
+	}
+	if !u.complete() {
+		audit.failure("upload incomplete")
+		jsonError(w, http.StatusBadRequest, "Upload is missing chunks")
+		return
+	}
+	if maxFileSize := y.effectiveMaxFileSize(); maxFileSize > 0 && u.size() > maxFileSize {
+		audit.failure("file too large")
+		jsonError(w, http.StatusRequestEntityTooLarge, "File too large")
+		return
+	}
+
+	// Claim the session so concurrent finalize calls or late chunks cannot
+	// change the chunks being assembled.
+	err := y.updateUploadSession(id, func(s *uploadSession) error {
+		if s.Finalizing {
+			return errUploadFinalizing
+		}
+		s.Finalizing = true
+		u = *s
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			jsonError(w, http.StatusNotFound, "Upload not found")
+		case errors.Is(err, errUploadFinalizing):
+			jsonError(w, http.StatusConflict, "Upload is being finalized")
+		default:
+			y.Logger.Error("Failed to claim upload session", zap.Error(err))
+			audit.failure("failed to claim upload")
+			jsonError(w, http.StatusInternalServerError, "Failed to store upload session")
+		}
+		return
+	}
+
+	keys := make([]string, len(u.Chunks))
+	for n := range keys {
+		keys[n] = u.chunkKey(id, n)
+	}
+	body := &uploadChunkReader{ctx: r.Context(), store: y.FileStore, keys: keys}
+	stored := y.storeFile(r.Context(), w, policy, session, body, u.size(), audit)
+	body.Close()
+	if !stored {
+		err := y.updateUploadSession(id, func(s *uploadSession) error {
+			s.Finalizing = false
+			return nil
+		})
+		if err != nil {
+			y.Logger.Error("Failed to release upload session", zap.Error(err))
+		}
+		return
+	}
+	y.discardUpload(id, u)
+}
+
+// abortUpload deletes an upload session and its chunks.
+func (y *Server) abortUpload(w http.ResponseWriter, r *http.Request) {
+	id, u, ok := y.openUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if u.Finalizing {
+		jsonError(w, http.StatusConflict, "Upload is being finalized")
+		return
+	}
+	y.discardUpload(id, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUpload removes the session record and chunks. Failures are only
+// logged: anything left behind expires with the session TTL.
+func (y *Server) discardUpload(id string, u uploadSession) {
+	if _, err := y.DB.Delete(uploadKeyPrefix + id); err != nil {
+		y.Logger.Error("Failed to delete upload session", zap.Error(err))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for n := range u.Chunks {
+		if err := y.FileStore.Delete(ctx, u.chunkKey(id, n)); err != nil {
+			y.Logger.Error("Failed to delete upload chunk", zap.Error(err))
+		}
+	}
+}
+
+// uploadChunkReader streams the chunks stored under keys from the FileStore
+// as one continuous file, opening each chunk only when it is reached.
+type uploadChunkReader struct {
+	ctx   context.Context
+	store FileStore
+	keys  []string
+	next  int
+	cur   io.ReadCloser
+}
+
+func (c *uploadChunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if c.next == len(c.keys) {
+				return 0, io.EOF
+			}
+			rc, _, err := c.store.Load(c.ctx, c.keys[c.next])
+			if err != nil {
+				return 0, fmt.Errorf("could not load upload chunk %d: %w", c.next, err)
+			}
+			c.cur = rc
+			c.next++
+		}
+		n, err := c.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close releases the chunk currently being read, if any.
+func (c *uploadChunkReader) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
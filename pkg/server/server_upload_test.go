@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uploadTestCall performs a request against the resumable upload routes.
+func uploadTestCall(t *testing.T, handler http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// startUpload creates an upload session and returns its ID.
+func startUpload(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	w := uploadTestCall(t, handler, http.MethodPost, "/create/file/upload", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create upload: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.UploadID == "" {
+		t.Fatalf("invalid create response %q: %v", w.Body.String(), err)
+	}
+	return resp.UploadID
+}
+
+func putChunk(t *testing.T, handler http.Handler, id, n, data string) *httptest.ResponseRecorder {
+	t.Helper()
+	return uploadTestCall(t, handler, http.MethodPut, "/create/file/upload/"+id+"/"+n, data,
+		map[string]string{"Content-Type": "application/octet-stream"})
+}
+
+func finalizeUploadCall(t *testing.T, handler http.Handler, id string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := map[string]string{"X-Yopass-Expiration": "3600"}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return uploadTestCall(t, handler, http.MethodPost, "/create/file/upload/"+id+"/finalize", "", h)
+}
+
+func TestResumableUpload(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+
+	// Chunks may arrive out of order and be retried.
+	for _, c := range []struct{ n, data string }{
+		{"1", "-second"},
+		{"0", pgpBody("lost")},
+		{"0", pgpBody("first")},
+	} {
+		if w := putChunk(t, handler, id, c.n, c.data); w.Code != http.StatusOK {
+			t.Fatalf("chunk %s: %d %s", c.n, w.Code, w.Body.String())
+		}
+	}
+
+	w := uploadTestCall(t, handler, http.MethodGet, "/create/file/upload/"+id, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: %d %s", w.Code, w.Body.String())
+	}
+	var status struct {
+		Chunks []int `json:"chunks"`
+		Size   int64 `json:"size"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Chunks) != 2 || status.Chunks[0] != 0 || status.Chunks[1] != 1 {
+		t.Errorf("expected chunks [0 1], got %v", status.Chunks)
+	}
+	if want := int64(len(pgpBody("first-second"))); status.Size != want {
+		t.Errorf("expected size %d, got %d", want, status.Size)
+	}
+
+	w = finalizeUploadCall(t, handler, id, map[string]string{"X-Yopass-OneTime": "true"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["message"] == "" {
+		t.Fatalf("invalid finalize response %q: %v", w.Body.String(), err)
+	}
+
+	w = uploadTestCall(t, handler, http.MethodGet, "/file/"+resp["message"], "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Body.String(); got != pgpBody("first-second") {
+		t.Errorf("expected assembled file, got %q", got)
+	}
+
+	// The session and its chunks are gone once the file exists.
+	for key := range db.store {
+		if strings.HasPrefix(key, uploadKeyPrefix) || strings.Contains(key, ".part") {
+			t.Errorf("upload state left behind: %s", key)
+		}
+	}
+	if w := finalizeUploadCall(t, handler, id, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second finalize: expected 404, got %d", w.Code)
+	}
+}
+
+func TestResumableUploadPolicyOnFinalize(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.ForceOneTimeSecrets = true
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	if w := putChunk(t, handler, id, "0", pgpBody("data")); w.Code != http.StatusOK {
+		t.Fatalf("chunk: %d %s", w.Code, w.Body.String())
+	}
+
+	w := finalizeUploadCall(t, handler, id, map[string]string{"X-Yopass-OneTime": "false"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Secret must be one time download") {
+		t.Fatalf("expected policy rejection, got %d %s", w.Code, w.Body.String())
+	}
+	w = finalizeUploadCall(t, handler, id, map[string]string{"X-Yopass-Expiration": ""})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected missing expiration rejection, got %d %s", w.Code, w.Body.String())
+	}
+
+	// A rejected finalize leaves the upload intact.
+	w = finalizeUploadCall(t, handler, id, map[string]string{"X-Yopass-OneTime": "true"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize after fix: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestResumableUploadReceiptAndWebhookOnFinalize(t *testing.T) {
+	sink := newWebhookSink(t)
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.License = validLicense()
+	srv.Webhooks = newTestNotifier(t, WebhookConfig{URL: sink.server.URL, Secret: "signing-key"})
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	if w := putChunk(t, handler, id, "0", pgpBody("data")); w.Code != http.StatusOK {
+		t.Fatalf("chunk: %d %s", w.Code, w.Body.String())
+	}
+	sink.assertNoEvent(t, 100*time.Millisecond)
+
+	w := finalizeUploadCall(t, handler, id, map[string]string{"X-Yopass-Receipt": "true"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["receipt_token"] == "" {
+		t.Error("expected receipt token from finalize")
+	}
+	if _, err := db.Status(receiptKeyPrefix + resp["message"]); err != nil {
+		t.Errorf("expected receipt for the finished file: %v", err)
+	}
+	d := sink.waitForEvent(t)
+	if d.event.Event != WebhookEventSecretCreated || d.event.Kind != WebhookKindFile {
+		t.Errorf("unexpected webhook event: %+v", d.event)
+	}
+}
+
+func TestResumableUploadMissingChunks(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	if w := finalizeUploadCall(t, handler, id, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("empty upload: expected 400, got %d", w.Code)
+	}
+	putChunk(t, handler, id, "0", pgpBody("a"))
+	putChunk(t, handler, id, "2", "c")
+	w := finalizeUploadCall(t, handler, id, nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing chunks") {
+		t.Errorf("gap: expected 400, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestResumableUploadChunkValidation(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.MaxFileSize = 16
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	tests := []struct {
+		name string
+		n    string
+		data string
+		want int
+	}{
+		{"first chunk not OpenPGP", "0", "plain", http.StatusBadRequest},
+		{"empty chunk", "1", "", http.StatusBadRequest},
+		{"chunk number out of range", "10000", "x", http.StatusBadRequest},
+		{"first chunk", "0", pgpBody("0123456789"), http.StatusOK},
+		{"total over limit", "1", "0123456789", http.StatusRequestEntityTooLarge},
+		{"total within limit", "1", "01234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := putChunk(t, handler, id, tt.n, tt.data); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if w := putChunk(t, handler, "AAAAAAAAAAAAAAAAAAAAAA", "0", pgpBody("x")); w.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: expected 404, got %d", w.Code)
+	}
+}
+
+func TestResumableUploadAbort(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	putChunk(t, handler, id, "0", pgpBody("data"))
+
+	if w := uploadTestCall(t, handler, http.MethodDelete, "/create/file/upload/"+id, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("abort: expected 204, got %d", w.Code)
+	}
+	if len(db.store) != 0 {
+		t.Errorf("expected no stored state after abort, got %d entries", len(db.store))
+	}
+	if w := putChunk(t, handler, id, "1", "more"); w.Code != http.StatusNotFound {
+		t.Errorf("chunk after abort: expected 404, got %d", w.Code)
+	}
+}
+
+// expiryRecordingStore records the expiration each key was saved with.
+type expiryRecordingStore struct {
+	FileStore
+	expirations map[string]int32
+}
+
+func (s *expiryRecordingStore) Save(ctx context.Context, key string, data io.Reader, contentLength int64, expiration int32) error {
+	s.expirations[key] = expiration
+	return s.FileStore.Save(ctx, key, data, contentLength, expiration)
+}
+
+func TestResumableUploadChunksExpireWithSession(t *testing.T) {
+	db := newTestDB()
+	store := &expiryRecordingStore{FileStore: NewDatabaseFileStore(db), expirations: map[string]int32{}}
+	srv := newStreamTestServer(t, db)
+	srv.FileStore = store
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	putChunk(t, handler, id, "0", pgpBody("data"))
+
+	u, ok := srv.loadUploadSession(id)
+	if !ok {
+		t.Fatal("upload session not found")
+	}
+	exp, ok := store.expirations[u.chunkKey(id, 0)]
+	if !ok {
+		t.Fatal("chunk not saved to the file store")
+	}
+	if exp <= 0 || exp > int32(uploadSessionTTL/time.Second) {
+		t.Errorf("expected chunk to expire with the session, got %d", exp)
+	}
+	session, err := db.Status(uploadKeyPrefix + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Expiration <= 0 || session.Expiration > int32(uploadSessionTTL/time.Second) {
+		t.Errorf("expected session record TTL, got %d", session.Expiration)
+	}
+}
+
+// failOnceStore fails the first Save of a key without the chunk suffix,
+// i.e. the assembled file.
+type failOnceStore struct {
+	FileStore
+	failed bool
+}
+
+func (s *failOnceStore) Save(ctx context.Context, key string, data io.Reader, contentLength int64, expiration int32) error {
+	if !s.failed && !strings.Contains(key, ".part") {
+		s.failed = true
+		return errors.New("store unavailable")
+	}
+	return s.FileStore.Save(ctx, key, data, contentLength, expiration)
+}
+
+func TestResumableUploadFinalizeRetry(t *testing.T) {
+	db := newTestDB()
+	srv := newStreamTestServer(t, db)
+	srv.FileStore = &failOnceStore{FileStore: NewDatabaseFileStore(db)}
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	putChunk(t, handler, id, "0", pgpBody("data"))
+
+	if w := finalizeUploadCall(t, handler, id, nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 from failing store, got %d", w.Code)
+	}
+	// The session is released so the same chunks can be finalized again.
+	if w := finalizeUploadCall(t, handler, id, nil); w.Code != http.StatusOK {
+		t.Fatalf("retry: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// hookedSaveStore runs onSave for chunk keys before storing them.
+type hookedSaveStore struct {
+	FileStore
+	onSave func()
+}
+
+func (s *hookedSaveStore) Save(ctx context.Context, key string, data io.Reader, contentLength int64, expiration int32) error {
+	if s.onSave != nil && strings.Contains(key, ".part") {
+		s.onSave()
+	}
+	return s.FileStore.Save(ctx, key, data, contentLength, expiration)
+}
+
+func TestResumableUploadChunkDuringFinalize(t *testing.T) {
+	db := newTestDB()
+	store := &hookedSaveStore{FileStore: NewDatabaseFileStore(db)}
+	srv := newStreamTestServer(t, db)
+	srv.FileStore = store
+	handler := srv.HTTPHandler()
+
+	id := startUpload(t, handler)
+	putChunk(t, handler, id, "0", pgpBody("first"))
+	putChunk(t, handler, id, "1", "-second")
+
+	// A resent chunk passes the early finalizing check, then finalize claims
+	// the session while the chunk is being written.
+	store.onSave = func() {
+		store.onSave = nil
+		if err := srv.updateUploadSession(id, func(s *uploadSession) error {
+			s.Finalizing = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := putChunk(t, handler, id, "0", pgpBody("late")); w.Code != http.StatusConflict {
+		t.Fatalf("chunk during finalize: expected 409, got %d %s", w.Code, w.Body.String())
+	}
+
+	// The chunks finalize recorded are untouched.
+	if err := srv.updateUploadSession(id, func(s *uploadSession) error {
+		s.Finalizing = false
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w := finalizeUploadCall(t, handler, id, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	w = uploadTestCall(t, handler, http.MethodGet, "/file/"+resp["message"], "", nil)
+	if got := w.Body.String(); got != pgpBody("first-second") {
+		t.Errorf("expected the recorded chunks, got %q", got)
+	}
+	for key := range db.store {
+		if strings.Contains(key, ".part") {
+			t.Errorf("upload chunk left behind: %s", key)
+		}
+	}
+}
+
+func TestResumableUploadOwner(t *testing.T) {
+	db := newTestDB()
+	srv := newServerWithOIDC(t, db)
+	handler := srv.HTTPHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/create/file/upload", nil)
+	for _, c := range sessionCookiesFor(t, &srv) {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create upload: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload started by a signed-in user cannot be continued anonymously.
+	if w := putChunk(t, handler, resp.UploadID, "0", pgpBody("data")); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous chunk: expected 403, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/create/file/upload/"+resp.UploadID+"/0", strings.NewReader(pgpBody("data")))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for _, c := range sessionCookiesFor(t, &srv) {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("owner chunk: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+}
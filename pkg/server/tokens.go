@@ -10,7 +10,8 @@ import (
 )
 
 // Helpers shared by the token-protected, TTL-bounded records stored in the
-// database: secret requests and read receipts.
+// database: secret requests, read receipts, management records and claim
+// nonces.
 
 // secondsUntil returns the number of seconds until the unix timestamp
 // expiresAt, or 0 if it has already passed.
@@ -45,6 +46,12 @@ func generateToken() (token, hash string, err error) {
 		return "", "", err
 	}
 	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the storage hash of a token, for records looked up by
+// the token itself.
+func hashToken(token string) string {
 	h := sha256.Sum256([]byte(token))
-	return token, hex.EncodeToString(h[:]), nil
+	return hex.EncodeToString(h[:])
 }
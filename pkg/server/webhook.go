@@ -22,9 +22,10 @@ import (
 // Webhook event names. A delivery's event name is also carried in the
 // X-Yopass-Event header so receivers can route without parsing the body.
 const (
-	WebhookEventSecretCreated = "secret.created"
-	WebhookEventSecretViewed  = "secret.viewed"
-	WebhookEventSecretExpired = "secret.expired"
+	WebhookEventSecretCreated   = "secret.created"
+	WebhookEventSecretViewed    = "secret.viewed"
+	WebhookEventSecretExpired   = "secret.expired"
+	WebhookEventSecretDestroyed = "secret.destroyed"
 
 	WebhookEventRequestCreated   = "request.created"
 	WebhookEventRequestFulfilled = "request.fulfilled"
@@ -53,6 +54,10 @@ type WebhookEvent struct {
 	Kind              string    `json:"kind"`
 	OneTime           bool      `json:"one_time"`
 	ExpirationSeconds int32     `json:"expiration_seconds,omitempty"`
+	// MaxViews is set for view-limited secrets; their viewed events also
+	// carry the views left after that retrieval, which may be zero.
+	MaxViews       int32  `json:"max_views,omitempty"`
+	RemainingViews *int32 `json:"remaining_views,omitempty"`
 }
 
 // WebhookConfig configures the notifier. URL is required; everything else has
@@ -241,6 +246,62 @@ func (n *WebhookNotifier) SecretViewed(id, kind string, oneTime bool) {
 	})
 }
 
+// ViewLimitedSecretCreated is SecretCreated for a secret that can be
+// retrieved maxViews times.
+func (n *WebhookNotifier) ViewLimitedSecretCreated(id, kind string, maxViews, expiration int32) {
+	n.trackExpiry(id, webhookExpiry{
+		kind:         kind,
+		lifetime:     expiration,
+		expiredEvent: WebhookEventSecretExpired,
+	})
+	n.enqueue(WebhookEvent{
+		Event:             WebhookEventSecretCreated,
+		SecretID:          redactSecretID(id),
+		Kind:              kind,
+		ExpirationSeconds: expiration,
+		MaxViews:          maxViews,
+	})
+}
+
+// ViewLimitedSecretViewed enqueues a viewed event for every retrieval of a
+// view-limited secret. The last view deletes the secret, so its expiry
+// tracking is cancelled like for one-time secrets.
+func (n *WebhookNotifier) ViewLimitedSecretViewed(id, kind string, maxViews, remaining int32) {
+	if remaining == 0 {
+		n.cancelExpiry(id)
+	}
+	n.enqueue(WebhookEvent{
+		Event:          WebhookEventSecretViewed,
+		SecretID:       redactSecretID(id),
+		Kind:           kind,
+		MaxViews:       maxViews,
+		RemainingViews: &remaining,
+	})
+}
+
+// SecretExtended moves the expiry tracking of a secret whose creator
+// extended it to expiration seconds from now. Extension does not emit an
+// event of its own.
+func (n *WebhookNotifier) SecretExtended(id string, expiration int32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if e, ok := n.expiries[id]; ok {
+		e.deadline = time.Now().Add(time.Duration(expiration) * time.Second)
+		heap.Fix(&n.heap, e.index)
+	}
+}
+
+// SecretDestroyed enqueues a destroyed event for a PIN-protected secret
+// whose PIN attempts were used up, and cancels its expiry tracking.
+func (n *WebhookNotifier) SecretDestroyed(id, kind string) {
+	n.cancelExpiry(id)
+	n.enqueue(WebhookEvent{
+		Event:    WebhookEventSecretDestroyed,
+		SecretID: redactSecretID(id),
+		Kind:     kind,
+	})
+}
+
 // SecretDeleted cancels expiry tracking for an explicitly deleted secret.
 // Deletion ahead of expiry does not emit an event of its own.
 func (n *WebhookNotifier) SecretDeleted(id string) {
@@ -445,15 +506,37 @@ func signWebhookBody(secret string, body []byte) string {
 // The Server wrappers below are nil-safe so handlers can call them
 // unconditionally whether or not webhooks are configured.
 
-func (y *Server) webhookCreated(id, kind string, oneTime bool, expiration int32) {
+func (y *Server) webhookCreated(id, kind string, s yopass.Secret) {
+	if y.Webhooks == nil || !y.License.CurrentlyValid() {
+		return
+	}
+	if s.MaxViews > 0 {
+		y.Webhooks.ViewLimitedSecretCreated(id, kind, s.MaxViews, s.Expiration)
+		return
+	}
+	y.Webhooks.SecretCreated(id, kind, s.OneTime, s.Expiration)
+}
+
+func (y *Server) webhookViewed(id, kind string, s yopass.Secret) {
+	if y.Webhooks == nil || !y.License.CurrentlyValid() {
+		return
+	}
+	if s.MaxViews > 0 {
+		y.Webhooks.ViewLimitedSecretViewed(id, kind, s.MaxViews, s.MaxViews-s.Views)
+		return
+	}
+	y.Webhooks.SecretViewed(id, kind, s.OneTime)
+}
+
+func (y *Server) webhookExtended(id string, expiration int32) {
 	if y.Webhooks != nil && y.License.CurrentlyValid() {
-		y.Webhooks.SecretCreated(id, kind, oneTime, expiration)
+		y.Webhooks.SecretExtended(id, expiration)
 	}
 }
 
-func (y *Server) webhookViewed(id, kind string, oneTime bool) {
+func (y *Server) webhookDestroyed(id, kind string) {
 	if y.Webhooks != nil && y.License.CurrentlyValid() {
-		y.Webhooks.SecretViewed(id, kind, oneTime)
+		y.Webhooks.SecretDestroyed(id, kind)
 	}
 }
 
@@ -263,6 +263,23 @@ func TestWebhookNonOneTimeViewKeepsExpiryTracking(t *testing.T) {
 	}
 }
 
+func TestWebhookExtendMovesExpiry(t *testing.T) {
+	sink := newWebhookSink(t)
+	notifier := newTestNotifier(t, WebhookConfig{URL: sink.server.URL})
+
+	notifier.SecretCreated("extended-id", WebhookKindSecret, false, 3600)
+	sink.waitForEvent(t) // created
+	notifier.SecretExtended("extended-id", 86400)
+
+	notifier.mu.Lock()
+	exp := notifier.expiries["extended-id"]
+	notifier.mu.Unlock()
+	if exp == nil || time.Until(exp.deadline) < 86000*time.Second {
+		t.Fatalf("expected the expiry deadline to move a day ahead, got %+v", exp)
+	}
+	sink.assertNoEvent(t, 100*time.Millisecond)
+}
+
 func TestWebhookRetriesOnFailure(t *testing.T) {
 	sink := newWebhookSink(t)
 	atomic.StoreInt32(&sink.failures, 2) // first two attempts get a 500
@@ -547,3 +564,34 @@ func TestWebhookEnqueueDropsWhenQueueFull(t *testing.T) {
 		t.Fatal("enqueue blocked with a full queue")
 	}
 }
+
+func TestWebhookViewLimitedEvents(t *testing.T) {
+	sink := newWebhookSink(t)
+	notifier := newTestNotifier(t, WebhookConfig{URL: sink.server.URL})
+
+	notifier.ViewLimitedSecretCreated("limited-id", WebhookKindSecret, 2, 3600)
+	d := sink.waitForEvent(t)
+	if d.event.Event != WebhookEventSecretCreated || d.event.MaxViews != 2 || d.event.OneTime {
+		t.Fatalf("unexpected created event: %+v", d.event)
+	}
+	if d.event.RemainingViews != nil {
+		t.Errorf("created event should not carry remaining views, got %d", *d.event.RemainingViews)
+	}
+
+	for _, remaining := range []int32{1, 0} {
+		notifier.ViewLimitedSecretViewed("limited-id", WebhookKindSecret, 2, remaining)
+		d = sink.waitForEvent(t)
+		if d.event.Event != WebhookEventSecretViewed || d.event.RemainingViews == nil || *d.event.RemainingViews != remaining {
+			t.Fatalf("unexpected viewed event: %+v", d.event)
+		}
+		if !bytes.Contains(d.body, []byte(`"remaining_views":`)) {
+			t.Errorf("remaining_views must be sent even when zero: %s", d.body)
+		}
+		notifier.mu.Lock()
+		_, tracked := notifier.expiries["limited-id"]
+		notifier.mu.Unlock()
+		if tracked != (remaining > 0) {
+			t.Errorf("remaining %d: expected tracked=%v", remaining, remaining > 0)
+		}
+	}
+}
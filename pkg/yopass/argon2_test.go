@@ -105,6 +105,16 @@ func TestEncryptBinaryWithArgon2(t *testing.T) {
 	}
 }
 
+func TestEncryptStreamWithArgon2(t *testing.T) {
+	var data bytes.Buffer
+	if err := EncryptStreamWithArgon2(&data, strings.NewReader("argon2 stream"), "test-key", "stream.txt"); err != nil {
+		t.Fatalf("expected no encryption error, got %v", err)
+	}
+	if mode := packetS2KMode(t, data.Bytes()); mode != s2k.Argon2S2K {
+		t.Errorf("expected S2K mode %d (Argon2), got %d", s2k.Argon2S2K, mode)
+	}
+}
+
 // TestArgon2DoesNotStick guards against the Argon2 option leaking into
 // subsequent default encryption within the same process.
 func TestArgon2DoesNotStick(t *testing.T) {
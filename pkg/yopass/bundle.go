@@ -0,0 +1,404 @@
+package yopass
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A bundle carries several files in one encrypted upload. Its plaintext is
+//
+//	bundleMagic | uint64 manifest length (big endian) | manifest JSON | contents
+//
+// with the contents of every file entry concatenated in manifest order. The
+// whole bundle, manifest included, is encrypted like a single file, so the
+// server sees one opaque object and applies its size limit to the total.
+const bundleMagic = "yopass-bundle/1\n"
+
+// BundleFileName is the file name recorded in the OpenPGP message of an
+// encrypted bundle.
+const BundleFileName = "bundle.yopass"
+
+// maxManifestSize bounds the manifest read from an untrusted bundle.
+const maxManifestSize = 16 << 20
+
+// ErrInvalidBundle is returned when a bundle is malformed.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// BundleEntry describes one file or directory in a bundle.
+type BundleEntry struct {
+	// Path is slash-separated and relative to the bundle root.
+	Path string      `json:"path"`
+	Size int64       `json:"size,omitempty"`
+	Mode fs.FileMode `json:"mode"`
+	Dir  bool        `json:"dir,omitempty"`
+}
+
+type bundleManifest struct {
+	Entries []BundleEntry `json:"entries"`
+}
+
+// BundleFile is a local file or directory to be written into a bundle.
+type BundleFile struct {
+	BundleEntry
+	// Source is the local path the contents are read from.
+	Source string
+}
+
+// CollectBundleFiles turns files and directories into bundle entries. A file
+// is added under its base name and a directory under its own name with its
+// whole tree, so `a.txt docs/` yields a.txt, docs/, docs/... Only regular
+// files and directories are supported.
+func CollectBundleFiles(paths ...string) ([]BundleFile, error) {
+	var files []BundleFile
+	seen := map[string]bool{}
+	add := func(entryPath, source string, info fs.FileInfo) error {
+		switch {
+		case info.IsDir():
+		case info.Mode().IsRegular():
+		default:
+			return fmt.Errorf("%s: only regular files and directories can be shared", source)
+		}
+		if seen[entryPath] {
+			return fmt.Errorf("%s: more than one file would be named %s", source, entryPath)
+		}
+		seen[entryPath] = true
+		f := BundleFile{
+			BundleEntry: BundleEntry{Path: entryPath, Mode: info.Mode().Perm(), Dir: info.IsDir()},
+			Source:      source,
+		}
+		if !f.Dir {
+			f.Size = info.Size()
+		}
+		files = append(files, f)
+		return nil
+	}
+
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		root := filepath.Base(filepath.Clean(p))
+		if !info.IsDir() {
+			if err := add(root, p, info); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(p, func(source string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(p, source)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return add(path.Join(root, filepath.ToSlash(rel)), source, info)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// WriteBundle writes the bundle plaintext for files to w.
+func WriteBundle(w io.Writer, files []BundleFile) error {
+	manifest := bundleManifest{Entries: make([]BundleEntry, len(files))}
+	for i, f := range files {
+		manifest.Entries[i] = f.BundleEntry
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %w", err)
+	}
+	header := make([]byte, len(bundleMagic)+8)
+	copy(header, bundleMagic)
+	binary.BigEndian.PutUint64(header[len(bundleMagic):], uint64(len(data)))
+	if _, err := w.Write(append(header, data...)); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.Dir {
+			continue
+		}
+		if err := copyBundleFile(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBundleFile(w io.Writer, f BundleFile) error {
+	in, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// Exactly the size recorded in the manifest is written; a file that
+	// shrank since it was collected would corrupt every following entry.
+	if _, err := io.CopyN(w, in, f.Size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: file changed while bundling", f.Source)
+		}
+		return err
+	}
+	return nil
+}
+
+// IsBundle reports whether a decrypted plaintext is a bundle.
+func IsBundle(plaintext string) bool {
+	return strings.HasPrefix(plaintext, bundleMagic)
+}
+
+// PeekBundle reports whether the plaintext buffered in r is a bundle,
+// without consuming it. It is IsBundle for streamed plaintext.
+func PeekBundle(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(bundleMagic))
+	return string(magic) == bundleMagic
+}
+
+// BundleReader reads the entries of a bundle in order.
+type BundleReader struct {
+	r       io.Reader
+	entries []BundleEntry
+	next    int
+	cur     *io.LimitedReader
+}
+
+// NewBundleReader reads and validates the bundle manifest from r.
+func NewBundleReader(r io.Reader) (*BundleReader, error) {
+	header := make([]byte, len(bundleMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(bundleMagic)]) != bundleMagic {
+		return nil, ErrInvalidBundle
+	}
+	size := binary.BigEndian.Uint64(header[len(bundleMagic):])
+	if size > maxManifestSize {
+		return nil, fmt.Errorf("%w: manifest too large", ErrInvalidBundle)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrInvalidBundle
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	seen := map[string]bool{}
+	for _, e := range manifest.Entries {
+		if !validBundlePath(e.Path) || e.Size < 0 || seen[e.Path] {
+			return nil, fmt.Errorf("%w: bad entry %q", ErrInvalidBundle, e.Path)
+		}
+		seen[e.Path] = true
+	}
+	return &BundleReader{r: r, entries: manifest.Entries}, nil
+}
+
+// validBundlePath accepts relative, slash-separated paths that stay inside
+// the bundle root on every platform.
+func validBundlePath(p string) bool {
+	return fs.ValidPath(p) && p != "." && !strings.ContainsAny(p, `\:`)
+}
+
+// Entries returns the bundle manifest.
+func (b *BundleReader) Entries() []BundleEntry {
+	return b.entries
+}
+
+// Next advances to the next entry and returns it with a reader for its
+// contents, which is empty for directories. It returns io.EOF after the
+// last entry.
+func (b *BundleReader) Next() (BundleEntry, io.Reader, error) {
+	if b.cur != nil {
+		if _, err := io.Copy(io.Discard, b.cur); err != nil {
+			return BundleEntry{}, nil, err
+		}
+	}
+	if b.next == len(b.entries) {
+		return BundleEntry{}, nil, io.EOF
+	}
+	e := b.entries[b.next]
+	b.next++
+	b.cur = &io.LimitedReader{R: b.r, N: e.Size}
+	if e.Dir {
+		b.cur.N = 0
+	}
+	return e, b.cur, nil
+}
+
+// ExtractBundle recreates the bundle tree below dir and returns the paths it
+// created. Existing files are never overwritten and entries cannot escape
+// dir, even through symlinks already present in it.
+//
+// The entries are first written to a temporary directory inside dir and r is
+// read to its end, so a decrypting reader gets to check its final
+// authentication tag. Only then are they moved into place. On any error
+// nothing extracted is left behind.
+func ExtractBundle(r io.Reader, dir string) ([]string, error) {
+	br, err := NewBundleReader(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	// Refuse before decrypting anything if a file is already there.
+	for _, e := range br.Entries() {
+		if e.Dir {
+			continue
+		}
+		if err := checkBundleTarget(root, dir, filepath.FromSlash(e.Path)); err != nil {
+			return nil, err
+		}
+	}
+
+	staging, err := os.MkdirTemp(dir, ".yopass-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	stage := filepath.Base(staging)
+	defer root.RemoveAll(stage)
+
+	if err := stageBundle(root, stage, br); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	return placeBundle(root, dir, stage, br.Entries())
+}
+
+// stageBundle writes every entry of br below stage.
+func stageBundle(root *os.Root, stage string, br *BundleReader) error {
+	for {
+		e, contents, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(stage, filepath.FromSlash(e.Path))
+		if e.Dir {
+			if err := root.MkdirAll(name, 0o700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := root.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+			return err
+		}
+		if err := extractBundleFile(root, name, e, contents); err != nil {
+			return err
+		}
+	}
+}
+
+// placeBundle moves the staged entries to their place below the root and
+// returns the files it moved. If one cannot be placed, the files and
+// directories placed so far are removed again.
+func placeBundle(root *os.Root, dir, stage string, entries []BundleEntry) ([]string, error) {
+	var created, placed, dirs []string
+	// mkdirAll is root.MkdirAll remembering the directories it created.
+	var mkdirAll func(name string) error
+	mkdirAll = func(name string) error {
+		if name == "." {
+			return nil
+		}
+		err := root.Mkdir(name, 0o700)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := mkdirAll(filepath.Dir(name)); err != nil {
+				return err
+			}
+			err = root.Mkdir(name, 0o700)
+		}
+		switch {
+		case err == nil:
+			dirs = append(dirs, name)
+			return nil
+		case errors.Is(err, fs.ErrExist):
+			return nil
+		default:
+			return err
+		}
+	}
+	place := func(e BundleEntry) error {
+		name := filepath.FromSlash(e.Path)
+		if e.Dir {
+			return mkdirAll(name)
+		}
+		if err := mkdirAll(filepath.Dir(name)); err != nil {
+			return err
+		}
+		if err := checkBundleTarget(root, dir, name); err != nil {
+			return err
+		}
+		if err := root.Rename(filepath.Join(stage, name), name); err != nil {
+			return err
+		}
+		placed = append(placed, name)
+		created = append(created, filepath.Join(dir, name))
+		return nil
+	}
+	for _, e := range entries {
+		if err := place(e); err != nil {
+			for _, name := range placed {
+				root.Remove(name)
+			}
+			for i := len(dirs) - 1; i >= 0; i-- {
+				root.Remove(dirs[i])
+			}
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// checkBundleTarget fails if a file entry would overwrite something at name.
+func checkBundleTarget(root *os.Root, dir, name string) error {
+	_, err := root.Lstat(name)
+	if err == nil {
+		return &fs.PathError{Op: "extract", Path: filepath.Join(dir, name), Err: fs.ErrExist}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func extractBundleFile(root *os.Root, name string, e BundleEntry, contents io.Reader) error {
+	// Files stay private to the user; only the executable bit is carried
+	// over from the sender.
+	out, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600|e.Mode.Perm()&0o100)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, contents)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != e.Size {
+		err = fmt.Errorf("%w: %s is truncated", ErrInvalidBundle, e.Path)
+	}
+	if err != nil {
+		root.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package yopass_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rawBundle builds bundle plaintext from a literal manifest, for bundles
+// CollectBundleFiles would never produce.
+func rawBundle(manifest, contents string) []byte {
+	b := []byte("yopass-bundle/1\n")
+	b = binary.BigEndian.AppendUint64(b, uint64(len(manifest)))
+	return append(append(b, manifest...), contents...)
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "first file")
+	writeFile(t, filepath.Join(src, "docs", "b.txt"), "second file")
+	writeFile(t, filepath.Join(src, "docs", "nested", "c.txt"), "")
+	if err := os.Mkdir(filepath.Join(src, "docs", "empty"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := yopass.CollectBundleFiles(filepath.Join(src, "a.txt"), filepath.Join(src, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := yopass.WriteBundle(&buf, files); err != nil {
+		t.Fatal(err)
+	}
+	if !yopass.IsBundle(buf.String()) {
+		t.Fatal("expected written bundle to be detected")
+	}
+
+	dst := t.TempDir()
+	created, err := yopass.ExtractBundle(&buf, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 3 {
+		t.Errorf("expected 3 files created, got %v", created)
+	}
+	for name, want := range map[string]string{
+		"a.txt":             "first file",
+		"docs/b.txt":        "second file",
+		"docs/nested/c.txt": "",
+	} {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "docs", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("expected empty directory to be recreated, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 2 {
+		t.Errorf("expected only the bundle entries in the target directory, got %v", entries)
+	}
+}
+
+func TestCollectBundleFilesDuplicateName(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "one", "config"), "a")
+	writeFile(t, filepath.Join(dir, "two", "config"), "b")
+
+	_, err := yopass.CollectBundleFiles(filepath.Join(dir, "one", "config"), filepath.Join(dir, "two", "config"))
+	if err == nil || !strings.Contains(err.Error(), "more than one file would be named config") {
+		t.Errorf("expected duplicate name error, got %v", err)
+	}
+}
+
+func TestExtractBundleRejectsUnsafePaths(t *testing.T) {
+	for _, path := range []string{"../escape", "/etc/passwd", "a/../../b", `a\b`, "C:x", "", "."} {
+		t.Run(path, func(t *testing.T) {
+			bundle := rawBundle(`{"entries":[{"path":"`+strings.ReplaceAll(path, `\`, `\\`)+`","size":1,"mode":384}]}`, "x")
+			dst := t.TempDir()
+			_, err := yopass.ExtractBundle(bytes.NewReader(bundle), dst)
+			if !errors.Is(err, yopass.ErrInvalidBundle) {
+				t.Errorf("expected ErrInvalidBundle, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractBundleDoesNotFollowSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	dst := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dst, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	bundle := rawBundle(`{"entries":[{"path":"link/file","size":1,"mode":384}]}`, "x")
+	if _, err := yopass.ExtractBundle(bytes.NewReader(bundle), dst); err == nil {
+		t.Fatal("expected extraction through a symlink to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written outside the target directory, got %v", err)
+	}
+}
+
+func TestExtractBundleKeepsExistingFiles(t *testing.T) {
+	dst := t.TempDir()
+	writeFile(t, filepath.Join(dst, "a.txt"), "original")
+	bundle := rawBundle(`{"entries":[{"path":"a.txt","size":3,"mode":384}]}`, "new")
+	if _, err := yopass.ExtractBundle(bytes.NewReader(bundle), dst); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected existing file error, got %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(got) != "original" {
+		t.Errorf("expected existing file to be kept, got %q", got)
+	}
+}
+
+func TestExtractBundleTruncated(t *testing.T) {
+	bundle := rawBundle(`{"entries":[{"path":"a.txt","size":10,"mode":384}]}`, "short")
+	dst := t.TempDir()
+	if _, err := yopass.ExtractBundle(bytes.NewReader(bundle), dst); !errors.Is(err, yopass.ErrInvalidBundle) {
+		t.Errorf("expected ErrInvalidBundle, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+		t.Errorf("expected the partial file to be removed, got %v", entries)
+	}
+}
+
+func TestExtractBundleCorruptChunk(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), "first file")
+	// Random contents do not compress, so the ciphertext spans several
+	// 256 KiB AEAD chunks.
+	big := make([]byte, 1<<20)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "docs", "big.bin"), string(big))
+	files, err := yopass.CollectBundleFiles(filepath.Join(src, "a.txt"), filepath.Join(src, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle, ciphertext bytes.Buffer
+	if err := yopass.WriteBundle(&bundle, files); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := yopass.GenerateKey()
+	if err := yopass.EncryptStream(&ciphertext, &bundle, key, yopass.BundleFileName); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupting a middle chunk fails decryption after a.txt and part of
+	// big.bin have already been released.
+	tampered := ciphertext.Bytes()
+	tampered[len(tampered)/2] ^= 0xff
+
+	pt, _, err := yopass.DecryptStream(bytes.NewReader(tampered), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if _, err := yopass.ExtractBundle(pt, dst); err == nil {
+		t.Fatal("expected a corrupted bundle to fail extraction")
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+		t.Errorf("expected nothing left in the target directory, got %v", entries)
+	}
+}
@@ -2,18 +2,48 @@ package yopass
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// HTTPClient allows modifying the underlying http.Client.
+// HTTPClient allows modifying the underlying http.Client of the package-level
+// functions. Clients created with NewClient use WithHTTPClient instead.
 var HTTPClient = http.DefaultClient
 
-// ServerError represents a yopass server error.
+// DefaultUserAgent is sent by clients that do not set WithUserAgent.
+const DefaultUserAgent = "yopass-client"
+
+// maxRetryWait caps the wait between two attempts, including waits requested
+// by the server through Retry-After.
+const maxRetryWait = 30 * time.Second
+
+// Headers carrying the credentials of secret creators and recipients.
+const (
+	manageTokenHeader = "X-Yopass-Manage-Token"
+	pinHeader         = "X-Yopass-PIN"
+)
+
+// maxErrorBody bounds how much of an error response is read for its message.
+const maxErrorBody = 64 * 1024
+
+// ServerError represents a yopass server error. Errors the server responded
+// with carry its HTTP status code and message; transport errors leave
+// StatusCode zero.
 type ServerError struct {
+	StatusCode int
+	Message    string
+	// RemainingAttempts is the number of PIN attempts left after the server
+	// rejected an invalid PIN, and zero otherwise.
+	RemainingAttempts int32
+
 	err error
 }
 
@@ -25,99 +55,638 @@ func (e *ServerError) Unwrap() error {
 	return e.err
 }
 
-type serverResponse struct {
-	Message string `json:"message"`
-}
-
 // ServerConfig holds the subset of the server /config response relevant to
-// the CLI client. Unknown fields are ignored so older servers remain
+// API clients. Unknown fields are ignored so older servers remain
 // compatible.
 type ServerConfig struct {
 	Argon2 bool `json:"ARGON2"`
+	// MinExpiration and MaxExpiration bound the accepted secret lifetime in
+	// seconds; they are zero for servers that predate expiration policies.
+	MinExpiration int32 `json:"MIN_EXPIRATION"`
+	MaxExpiration int32 `json:"MAX_EXPIRATION"`
+	// ForceExpiration is the only lifetime accepted when non-zero.
+	ForceExpiration int32 `json:"FORCE_EXPIRATION"`
+
+	DisableUpload       bool `json:"DISABLE_UPLOAD"`
+	ReadOnly            bool `json:"READ_ONLY"`
+	ForceOneTimeSecrets bool `json:"FORCE_ONETIME_SECRETS"`
+	PrefetchSecret      bool `json:"PREFETCH_SECRET"`
+	RequireAuth         bool `json:"REQUIRE_AUTH"`
+	SecretRequests      bool `json:"SECRET_REQUESTS"`
+	ReadReceipts        bool `json:"READ_RECEIPTS"`
+	// MaxFileSize and MaxRequestFileSize are human-readable sizes such as
+	// "10MB"; they are empty when there is no limit.
+	MaxFileSize        string `json:"MAX_FILE_SIZE"`
+	MaxRequestFileSize string `json:"MAX_REQUEST_FILE_SIZE"`
 }
 
-// FetchServerConfig retrieves the public configuration from the specified
-// server's /config endpoint.
-func FetchServerConfig(server string) (ServerConfig, error) {
-	return FetchServerConfigWithToken(server, "")
+// ExpirationPolicy returns the server's expiration policy, or
+// DefaultExpirationPolicy when the server does not announce one.
+func (c ServerConfig) ExpirationPolicy() ExpirationPolicy {
+	if c.MaxExpiration == 0 {
+		return DefaultExpirationPolicy
+	}
+	return ExpirationPolicy{Min: c.MinExpiration, Max: c.MaxExpiration}
 }
 
-// FetchServerConfigWithToken retrieves the public configuration from the
-// specified server's /config endpoint using the provided Bearer token.
-func FetchServerConfigWithToken(server, token string) (ServerConfig, error) {
-	server = strings.TrimSuffix(server, "/")
+// Client talks to the API of one yopass server. It is safe for concurrent
+// use. Content is never encrypted or decrypted by the client: secrets and
+// files must already be encrypted with Encrypt or EncryptBinary.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	userAgent  string
+	retries    int
+	backoff    time.Duration
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// NewClient returns a client for the yopass server at baseURL, for example
+// "https://yopass.example.com".
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		userAgent:  DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithToken authenticates every request with the given Bearer token, such as
+// an API token or an OIDC access token. A "Bearer " prefix is optional.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the http.Client requests are sent with.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithRetries retries failed requests up to n times, waiting backoff before
+// the first retry and doubling the wait for each further one. A Retry-After
+// header sent by the server takes precedence. Only failures that cannot have
+// changed anything on the server, rate limiting and unavailability, are
+// retried for non-idempotent requests. Retrievals of secrets, files and
+// request secrets count as such since they may consume what they read, and
+// streamed uploads are never retried because their body cannot be replayed.
+func WithRetries(n int, backoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// CreateOptions configures a secret or file created through a Client.
+type CreateOptions struct {
+	// Expiration is the lifetime in seconds.
+	Expiration int32
+	OneTime    bool
+	// MaxViews limits how often the secret can be retrieved; zero means no
+	// limit besides OneTime.
+	MaxViews    int32
+	RequireAuth bool
+	// AllowedRecipients and AllowedGroups restrict retrieval to signed-in
+	// users with one of the listed emails or OIDC groups.
+	AllowedRecipients []string
+	AllowedGroups     []string
+	// PIN must be presented by recipients in addition to the link.
+	PIN string
+	// Label is shown to the creator in their secret list; it is not
+	// encrypted.
+	Label string
+	// Receipt requests a read receipt; its token is returned in Created.
+	Receipt bool
+}
+
+// Created is the server's response to creating a secret or file.
+type Created struct {
+	ID string `json:"message"`
+	// ManageToken authorizes deleting and extending the secret.
+	ManageToken string `json:"manage_token,omitempty"`
+	// ReceiptToken authorizes reading the receipt, if one was requested.
+	ReceiptToken string `json:"receipt_token,omitempty"`
+}
+
+// FetchOptions configures the retrieval of a secret or file.
+type FetchOptions struct {
+	// PIN is required for PIN-protected secrets.
+	PIN string
+}
+
+// Status is the non-destructive status of a secret or file. It is only
+// available on servers with PrefetchSecret enabled.
+type Status struct {
+	OneTime        bool  `json:"oneTime"`
+	RequireAuth    bool  `json:"requireAuth"`
+	MaxViews       int32 `json:"maxViews,omitempty"`
+	RemainingViews int32 `json:"remainingViews,omitempty"`
+	PINRequired    bool  `json:"pinRequired,omitempty"`
+	Restricted     bool  `json:"restricted,omitempty"`
+	// RecipientAllowed reports whether the authenticated caller may retrieve
+	// a restricted secret; it is nil for anonymous callers.
+	RecipientAllowed *bool `json:"recipientAllowed,omitempty"`
+}
+
+// Receipt is the read receipt of a secret or file.
+type Receipt struct {
+	// State is "pending" until the secret is first retrieved, then "viewed".
+	State     string `json:"state"`
+	OneTime   bool   `json:"one_time"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	ViewedAt  int64  `json:"viewed_at,omitempty"`
+	// MaxViews, Views and LastViewedAt are set for view-limited secrets.
+	MaxViews     int32 `json:"max_views,omitempty"`
+	Views        int32 `json:"views,omitempty"`
+	LastViewedAt int64 `json:"last_viewed_at,omitempty"`
+}
 
+// Config retrieves the public configuration from the server's /config
+// endpoint.
+func (c *Client) Config(ctx context.Context) (ServerConfig, error) {
 	var config ServerConfig
-	req, err := http.NewRequest(http.MethodGet, server+"/config", nil)
+	req, err := c.newRequest(ctx, http.MethodGet, "/config", nil)
 	if err != nil {
-		return config, fmt.Errorf("could not create request: %w", err)
+		return config, err
+	}
+	if err := c.doJSON(req, &config); err != nil {
+		return config, err
 	}
-	setAuthorization(req, token)
+	return config, nil
+}
 
-	resp, err := HTTPClient.Do(req)
+// CreateSecret stores an encrypted message and returns its ID and tokens.
+func (c *Client) CreateSecret(ctx context.Context, message string, opts CreateOptions) (Created, error) {
+	body := struct {
+		Secret
+		Receipt bool   `json:"receipt,omitempty"`
+		PIN     string `json:"pin,omitempty"`
+		Label   string `json:"label,omitempty"`
+	}{
+		Secret: Secret{
+			Message:           message,
+			Expiration:        opts.Expiration,
+			OneTime:           opts.OneTime,
+			MaxViews:          opts.MaxViews,
+			RequireAuth:       opts.RequireAuth,
+			AllowedRecipients: opts.AllowedRecipients,
+			AllowedGroups:     opts.AllowedGroups,
+		},
+		Receipt: opts.Receipt,
+		PIN:     opts.PIN,
+		Label:   opts.Label,
+	}
+	var created Created
+	req, err := c.newJSONRequest(ctx, http.MethodPost, "/create/secret", body)
 	if err != nil {
-		return config, &ServerError{err: err}
+		return created, err
 	}
-	defer resp.Body.Close()
+	if err := c.doJSON(req, &created); err != nil {
+		return created, err
+	}
+	return created, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return config, &ServerError{err: fmt.Errorf("unexpected response %s", resp.Status)}
+// FetchSecret retrieves the encrypted message of a secret. One-time secrets
+// are gone afterwards.
+func (c *Client) FetchSecret(ctx context.Context, id string, opts FetchOptions) (string, error) {
+	resp, err := c.retrieve(ctx, "/secret/"+url.PathEscape(id), "", opts)
+	if err != nil {
+		return "", err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
-		return config, fmt.Errorf("could not decode server config: %w", err)
+	var s struct {
+		Message string `json:"message"`
 	}
-	return config, nil
+	if err := decodeResponse(resp, &s); err != nil {
+		return "", err
+	}
+	return s.Message, nil
 }
 
-// Fetch retrieves a secret by its ID from the specified server.
-func Fetch(server string, id string) (string, error) {
-	return FetchWithToken(server, id, "")
+// UploadFile streams an encrypted file to the server and returns its ID and
+// tokens. The data must be binary OpenPGP as produced by EncryptBinary.
+func (c *Client) UploadFile(ctx context.Context, data io.Reader, opts CreateOptions) (Created, error) {
+	var created Created
+	req, err := c.newRequest(ctx, http.MethodPost, "/create/file", data)
+	if err != nil {
+		return created, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Yopass-Expiration", strconv.FormatInt(int64(opts.Expiration), 10))
+	req.Header.Set("X-Yopass-OneTime", strconv.FormatBool(opts.OneTime))
+	if opts.MaxViews != 0 {
+		req.Header.Set("X-Yopass-MaxViews", strconv.FormatInt(int64(opts.MaxViews), 10))
+	}
+	if opts.RequireAuth {
+		req.Header.Set("X-Yopass-RequireAuth", "true")
+	}
+	if opts.Receipt {
+		req.Header.Set("X-Yopass-Receipt", "true")
+	}
+	if len(opts.AllowedRecipients) > 0 {
+		req.Header.Set("X-Yopass-AllowedRecipients", strings.Join(opts.AllowedRecipients, ","))
+	}
+	if len(opts.AllowedGroups) > 0 {
+		req.Header.Set("X-Yopass-AllowedGroups", strings.Join(opts.AllowedGroups, ","))
+	}
+	if opts.PIN != "" {
+		req.Header.Set(pinHeader, opts.PIN)
+	}
+	if opts.Label != "" {
+		req.Header.Set("X-Yopass-Label", opts.Label)
+	}
+	if err := c.doJSON(req, &created); err != nil {
+		return created, err
+	}
+	return created, nil
 }
 
-// FetchWithToken retrieves a secret by its ID from the specified server using
-// the provided Bearer token.
-func FetchWithToken(server string, id string, token string) (string, error) {
-	server = strings.TrimSuffix(server, "/")
+// DownloadFile retrieves an encrypted file as a stream, which the caller
+// must close. One-time files are gone once the download started.
+func (c *Client) DownloadFile(ctx context.Context, id string, opts FetchOptions) (io.ReadCloser, error) {
+	resp, err := c.retrieve(ctx, "/file/"+url.PathEscape(id), "application/octet-stream", opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// SecretStatus returns the status of a secret without retrieving it.
+func (c *Client) SecretStatus(ctx context.Context, id string) (Status, error) {
+	return c.status(ctx, "/secret/"+url.PathEscape(id)+"/status")
+}
+
+// FileStatus returns the status of a file without retrieving it.
+func (c *Client) FileStatus(ctx context.Context, id string) (Status, error) {
+	return c.status(ctx, "/file/"+url.PathEscape(id)+"/status")
+}
+
+func (c *Client) status(ctx context.Context, path string) (Status, error) {
+	var status Status
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return status, err
+	}
+	if err := c.doJSON(req, &status); err != nil {
+		return status, err
+	}
+	return status, nil
+}
 
-	req, err := http.NewRequest(http.MethodGet, server+"/secret/"+id, nil)
+// DeleteSecret deletes a secret ahead of its expiration. manageToken is the
+// token returned at creation; servers not requiring it accept an empty one.
+func (c *Client) DeleteSecret(ctx context.Context, id, manageToken string) error {
+	return c.delete(ctx, "/secret/"+url.PathEscape(id), manageToken)
+}
+
+// DeleteFile deletes a file ahead of its expiration. manageToken is the
+// token returned at creation; servers not requiring it accept an empty one.
+func (c *Client) DeleteFile(ctx context.Context, id, manageToken string) error {
+	return c.delete(ctx, "/file/"+url.PathEscape(id), manageToken)
+}
+
+func (c *Client) delete(ctx context.Context, path, manageToken string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, path, nil)
 	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
+		return err
 	}
-	setAuthorization(req, token)
+	if manageToken != "" {
+		req.Header.Set(manageTokenHeader, manageToken)
+	}
+	return c.doJSON(req, nil)
+}
+
+// ExtendSecret sets the remaining lifetime of a secret to expiration seconds
+// and returns the new expiry as a Unix timestamp.
+func (c *Client) ExtendSecret(ctx context.Context, id, manageToken string, expiration int32) (int64, error) {
+	return c.extend(ctx, "/secret/"+url.PathEscape(id)+"/extend", manageToken, expiration)
+}
+
+// ExtendFile sets the remaining lifetime of a file to expiration seconds and
+// returns the new expiry as a Unix timestamp.
+func (c *Client) ExtendFile(ctx context.Context, id, manageToken string, expiration int32) (int64, error) {
+	return c.extend(ctx, "/file/"+url.PathEscape(id)+"/extend", manageToken, expiration)
+}
 
-	resp, err := HTTPClient.Do(req)
+func (c *Client) extend(ctx context.Context, path, manageToken string, expiration int32) (int64, error) {
+	req, err := c.newJSONRequest(ctx, http.MethodPost, path, map[string]int32{"expiration": expiration})
 	if err != nil {
-		return "", &ServerError{err: err}
+		return 0, err
+	}
+	req.Header.Set(manageTokenHeader, manageToken)
+	var resp struct {
+		ExpiresAt int64 `json:"expires_at"`
 	}
-	return handleServerResponse(resp)
+	if err := c.doJSON(req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ExpiresAt, nil
 }
 
-// Store sends the secret to the specified server and returns the secret ID.
-func Store(server string, s Secret) (string, error) {
-	return StoreWithToken(server, s, "")
+// Receipt returns the read receipt of a secret or file. receiptToken is the
+// token returned at creation; it may be empty when the client's API token
+// created the secret and holds the read-receipt scope.
+func (c *Client) Receipt(ctx context.Context, id, receiptToken string) (Receipt, error) {
+	var receipt Receipt
+	req, err := c.newRequest(ctx, http.MethodGet, "/secret/"+url.PathEscape(id)+"/receipt", nil)
+	if err != nil {
+		return receipt, err
+	}
+	if receiptToken != "" {
+		req.Header.Set("X-Yopass-Receipt-Token", receiptToken)
+	}
+	if err := c.doJSON(req, &receipt); err != nil {
+		return receipt, err
+	}
+	return receipt, nil
 }
 
-// StoreWithToken sends the secret to the specified server and returns the
-// secret ID using the provided Bearer token.
-func StoreWithToken(server string, s Secret, token string) (string, error) {
-	server = strings.TrimSuffix(server, "/")
+// retrieve performs a secret or file retrieval and returns the successful
+// response. Servers with two-step retrieval answer the GET of a one-time
+// secret with 202 Accepted and a claim nonce instead of the secret; the
+// secret is then released by POSTing the nonce back to the same URL.
+func (c *Client) retrieve(ctx context.Context, path, accept string, opts FetchOptions) (*http.Response, error) {
+	newRequest := func(method string, body interface{}) (*http.Request, error) {
+		var req *http.Request
+		var err error
+		if body == nil {
+			req, err = c.newRequest(ctx, method, path, nil)
+		} else {
+			req, err = c.newJSONRequest(ctx, method, path, body)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if opts.PIN != "" {
+			req.Header.Set(pinHeader, opts.PIN)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest(http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(consuming(req))
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		return resp, err
+	}
+
+	var claim struct {
+		Claim string `json:"claim"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&claim)
+	resp.Body.Close()
+	if err != nil || claim.Claim == "" {
+		return nil, &ServerError{StatusCode: resp.StatusCode, err: fmt.Errorf("unexpected response %s without claim", resp.Status)}
+	}
+	if req, err = newRequest(http.MethodPost, claim); err != nil {
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// newRequest creates a request for path on the server carrying the client's
+// token and user agent.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	setAuthorization(req, c.token)
+	return req, nil
+}
 
-	var j bytes.Buffer
-	if err := (json.NewEncoder(&j)).Encode(&s); err != nil {
-		return "", fmt.Errorf("could not encode request: %w", err)
+// newJSONRequest creates a request with v encoded as its JSON body.
+func (c *Client) newJSONRequest(ctx context.Context, method, path string, v interface{}) (*http.Request, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode request: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, server+"/create/secret", &j)
+	req, err := c.newRequest(ctx, method, path, bytes.NewReader(data))
 	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	setAuthorization(req, token)
+	return req, nil
+}
+
+// doJSON sends req and decodes the JSON body of a successful response into
+// v. A nil v discards the body.
+func (c *Client) doJSON(req *http.Request, v interface{}) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, v)
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+	return nil
+}
+
+// send sends req, retrying as configured, and returns the response if its
+// status is 2xx. Any other status is returned as a ServerError.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.httpClient.Do(req)
+		if attempt < c.retries && retryable(req, resp, err) {
+			wait := c.retryWait(attempt, resp)
+			if resp != nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+				resp.Body.Close()
+			}
+			next, rerr := rewind(req)
+			if rerr != nil {
+				return nil, rerr
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, &ServerError{err: req.Context().Err()}
+			case <-timer.C:
+			}
+			req = next
+			continue
+		}
+		if err != nil {
+			return nil, &ServerError{err: err}
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, responseError(resp)
+		}
+		return resp, nil
+	}
+}
+
+// consumingRequest marks a request context for a GET that may consume what
+// it reads.
+type consumingRequest struct{}
 
-	resp, err := HTTPClient.Do(req)
+// consuming marks req as a retrieval that may delete what it reads, such as
+// a one-time secret, so it is not treated as idempotent despite its method.
+func consuming(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), consumingRequest{}, true))
+}
+
+// retryable reports whether a failed attempt may be repeated. Requests whose
+// body cannot be replayed never are. Rate limiting and unavailability mean
+// the server did not act on the request; other failures are only retried
+// for idempotent requests, so a secret is never created twice and a one-time
+// secret whose response was lost is not fetched again.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+		return false
+	}
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		return true
+	}
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodPut || req.Method == http.MethodDelete
+	if req.Context().Value(consumingRequest{}) != nil {
+		idempotent = false
+	}
 	if err != nil {
-		return "", &ServerError{err: err}
+		return idempotent
+	}
+	return idempotent && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusGatewayTimeout)
+}
+
+// retryWait returns how long to wait before the retry following attempt:
+// the server's Retry-After if it sent one, otherwise exponential backoff.
+func (c *Client) retryWait(attempt int, resp *http.Response) time.Duration {
+	wait := c.backoff << attempt
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			wait = time.Duration(s) * time.Second
+		}
+	}
+	if wait > maxRetryWait || wait < 0 {
+		wait = maxRetryWait
 	}
-	return handleServerResponse(resp)
+	return wait
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("could not replay request: %w", err)
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// responseError converts an unsuccessful response into a ServerError
+// carrying the status code and the message from the server's JSON error
+// body, or the raw body if it is not JSON.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var body struct {
+		Message           string `json:"message"`
+		RemainingAttempts int32  `json:"remainingAttempts"`
+	}
+	msg := strings.TrimSpace(string(data))
+	if err := json.Unmarshal(data, &body); err == nil {
+		msg = body.Message
+	}
+	return &ServerError{
+		StatusCode:        resp.StatusCode,
+		Message:           msg,
+		RemainingAttempts: body.RemainingAttempts,
+		err:               fmt.Errorf("unexpected response %s: %s", resp.Status, msg),
+	}
+}
+
+// IsNotFound reports whether err is a ServerError for a secret, file or
+// request that does not exist (anymore).
+func IsNotFound(err error) bool {
+	var se *ServerError
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+// defaultClient returns the client the package-level functions use.
+func defaultClient(server, token string) *Client {
+	return NewClient(server, WithToken(token), WithHTTPClient(HTTPClient))
+}
+
+// FetchServerConfig retrieves the public configuration from the specified
+// server's /config endpoint.
+func FetchServerConfig(server string) (ServerConfig, error) {
+	return FetchServerConfigWithToken(server, "")
+}
+
+// FetchServerConfigWithToken retrieves the public configuration from the
+// specified server's /config endpoint using the provided Bearer token.
+func FetchServerConfigWithToken(server, token string) (ServerConfig, error) {
+	return defaultClient(server, token).Config(context.Background())
+}
+
+// Fetch retrieves a secret by its ID from the specified server.
+func Fetch(server string, id string) (string, error) {
+	return FetchWithToken(server, id, "")
+}
+
+// FetchWithToken retrieves a secret by its ID from the specified server using
+// the provided Bearer token.
+func FetchWithToken(server string, id string, token string) (string, error) {
+	return defaultClient(server, token).FetchSecret(context.Background(), id, FetchOptions{})
+}
+
+// Store sends the secret to the specified server and returns the secret ID.
+func Store(server string, s Secret) (string, error) {
+	return StoreWithToken(server, s, "")
+}
+
+// StoreWithToken sends the secret to the specified server and returns the
+// secret ID using the provided Bearer token.
+func StoreWithToken(server string, s Secret, token string) (string, error) {
+	created, err := defaultClient(server, token).CreateSecret(context.Background(), s.Message, CreateOptions{
+		Expiration:        s.Expiration,
+		OneTime:           s.OneTime,
+		MaxViews:          s.MaxViews,
+		RequireAuth:       s.RequireAuth,
+		AllowedRecipients: s.AllowedRecipients,
+		AllowedGroups:     s.AllowedGroups,
+	})
+	return created.ID, err
 }
 
 // StoreFile uploads encrypted file data to the streaming endpoint and returns the file ID.
@@ -129,22 +698,11 @@ func StoreFile(server string, data []byte, expiration int32, oneTime bool) (stri
 // StoreFileWithToken uploads encrypted file data to the streaming endpoint and
 // returns the file ID using the provided Bearer token.
 func StoreFileWithToken(server string, data []byte, expiration int32, oneTime bool, token string) (string, error) {
-	server = strings.TrimSuffix(server, "/")
-
-	req, err := http.NewRequest(http.MethodPost, server+"/create/file", bytes.NewReader(data))
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("X-Yopass-Expiration", fmt.Sprintf("%d", expiration))
-	req.Header.Set("X-Yopass-OneTime", fmt.Sprintf("%t", oneTime))
-	setAuthorization(req, token)
-
-	resp, err := HTTPClient.Do(req)
-	if err != nil {
-		return "", &ServerError{err: err}
-	}
-	return handleServerResponse(resp)
+	created, err := defaultClient(server, token).UploadFile(context.Background(), bytes.NewReader(data), CreateOptions{
+		Expiration: expiration,
+		OneTime:    oneTime,
+	})
+	return created.ID, err
 }
 
 // FetchFile retrieves a streaming file by its ID and returns the encrypted body.
@@ -156,32 +714,17 @@ func FetchFile(server string, id string) ([]byte, error) {
 // FetchFileWithToken retrieves a streaming file by its ID and returns the
 // encrypted body using the provided Bearer token.
 func FetchFileWithToken(server string, id string, token string) ([]byte, error) {
-	server = strings.TrimSuffix(server, "/")
-
-	req, err := http.NewRequest(http.MethodGet, server+"/file/"+id, nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("Accept", "application/octet-stream")
-	setAuthorization(req, token)
-
-	resp, err := HTTPClient.Do(req)
+	body, err := defaultClient(server, token).DownloadFile(context.Background(), id, FetchOptions{})
 	if err != nil {
-		return nil, &ServerError{err: err}
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		msg, _ := io.ReadAll(resp.Body)
-		return nil, &ServerError{err: fmt.Errorf("unexpected response %s: %s", resp.Status, string(msg))}
+		return nil, err
 	}
+	defer body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(body)
 	if err != nil {
 		return nil, fmt.Errorf("could not read response: %w", err)
 	}
-
-	return body, nil
+	return data, nil
 }
 
 func setAuthorization(req *http.Request, token string) {
@@ -194,23 +737,3 @@ func setAuthorization(req *http.Request, token string) {
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
 }
-
-func handleServerResponse(resp *http.Response) (string, error) {
-	defer resp.Body.Close()
-
-	var r serverResponse
-	if resp.StatusCode != http.StatusOK {
-		msg, _ := io.ReadAll(resp.Body)
-		if err := json.Unmarshal(msg, &r); err == nil {
-			msg = []byte(r.Message)
-		}
-		err := fmt.Errorf("unexpected response %s: %s", resp.Status, string(msg))
-		return "", &ServerError{err: err}
-	}
-
-	if err := (json.NewDecoder(resp.Body)).Decode(&r); err != nil {
-		return "", fmt.Errorf("could not decode server response: %w", err)
-	}
-
-	return r.Message, nil
-}
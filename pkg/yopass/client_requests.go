@@ -0,0 +1,131 @@
+package yopass
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Kinds of secret a responder can provide for a secret request.
+const (
+	RequestKindText = "text"
+	RequestKindFile = "file"
+)
+
+// requestTokenHeader carries the management token of a secret request.
+const requestTokenHeader = "X-Yopass-Request-Token"
+
+// CreatedRequest is the server's response to creating a secret request.
+type CreatedRequest struct {
+	ID string `json:"id"`
+	// Token authorizes fetching the provided secret, revoking the request
+	// and rotating its key. It is only returned once.
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// SecretRequest is the public information about a secret request that a
+// responder needs to provide the secret.
+type SecretRequest struct {
+	// PublicKey is the armored PGP key the secret must be encrypted to.
+	PublicKey string `json:"public_key"`
+	Label     string `json:"label"`
+	// State is "pending" until a secret is provided, then "fulfilled".
+	State     string `json:"state"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RequestSecret is the secret a responder provided for a request.
+type RequestSecret struct {
+	// Message is PGP encrypted to the request's public key; file responses
+	// are armored too.
+	Message string `json:"message"`
+	// Kind is RequestKindText or RequestKindFile.
+	Kind string `json:"kind"`
+}
+
+// CreateRequest asks for a secret to be encrypted to publicKey. The request
+// expires after expiration seconds.
+func (c *Client) CreateRequest(ctx context.Context, publicKey, label string, expiration int32) (CreatedRequest, error) {
+	var created CreatedRequest
+	req, err := c.newJSONRequest(ctx, http.MethodPost, "/request", map[string]interface{}{
+		"public_key": publicKey,
+		"label":      label,
+		"expiration": expiration,
+	})
+	if err != nil {
+		return created, err
+	}
+	if err := c.doJSON(req, &created); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// GetRequest returns the public information about a secret request.
+func (c *Client) GetRequest(ctx context.Context, id string) (SecretRequest, error) {
+	var r SecretRequest
+	req, err := c.newRequest(ctx, http.MethodGet, requestPath(id, ""), nil)
+	if err != nil {
+		return r, err
+	}
+	if err := c.doJSON(req, &r); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// FulfillRequest provides the secret for a pending request. message must be
+// PGP encrypted to the request's public key; kind is RequestKindText or
+// RequestKindFile.
+func (c *Client) FulfillRequest(ctx context.Context, id, message, kind string) error {
+	req, err := c.newJSONRequest(ctx, http.MethodPost, requestPath(id, "/secret"), RequestSecret{Message: message, Kind: kind})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(req, nil)
+}
+
+// FetchRequestSecret retrieves the secret provided for a request, which
+// deletes the request. token is the request's management token.
+func (c *Client) FetchRequestSecret(ctx context.Context, id, token string) (RequestSecret, error) {
+	var s RequestSecret
+	req, err := c.newRequest(ctx, http.MethodGet, requestPath(id, "/secret"), nil)
+	if err != nil {
+		return s, err
+	}
+	req.Header.Set(requestTokenHeader, token)
+	if err := c.doJSON(consuming(req), &s); err != nil {
+		return s, err
+	}
+	if s.Kind == "" {
+		s.Kind = RequestKindText
+	}
+	return s, nil
+}
+
+// RevokeRequest deletes a secret request. token is the request's management
+// token.
+func (c *Client) RevokeRequest(ctx context.Context, id, token string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, requestPath(id, ""), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(requestTokenHeader, token)
+	return c.doJSON(req, nil)
+}
+
+// RotateRequestKey replaces the public key of a pending request. token is
+// the request's management token.
+func (c *Client) RotateRequestKey(ctx context.Context, id, token, publicKey string) error {
+	req, err := c.newJSONRequest(ctx, http.MethodPut, requestPath(id, "/key"), map[string]string{"public_key": publicKey})
+	if err != nil {
+		return err
+	}
+	req.Header.Set(requestTokenHeader, token)
+	return c.doJSON(req, nil)
+}
+
+func requestPath(id, suffix string) string {
+	return "/request/" + url.PathEscape(id) + suffix
+}
@@ -0,0 +1,85 @@
+package yopass_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+func TestClientSecretRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+			}
+		}
+		if r.URL.Path != "/request" && r.URL.Path != "/request/req-id" && r.Method != http.MethodPost {
+			if got := r.Header.Get("X-Yopass-Request-Token"); got != "req-token" {
+				t.Errorf("%s %s: expected the request token, got %q", r.Method, r.URL.Path, got)
+			}
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /request":
+			if body["public_key"] != "public-key" || body["label"] != "vpn" || body["expiration"] != float64(3600) {
+				t.Errorf("unexpected request body %v", body)
+			}
+			_, _ = io.WriteString(w, `{"id":"req-id","token":"req-token","expires_at":42}`)
+		case "GET /request/req-id":
+			_, _ = io.WriteString(w, `{"public_key":"public-key","label":"vpn","state":"pending","expires_at":42}`)
+		case "POST /request/req-id/secret":
+			if body["message"] != "encrypted" || body["kind"] != yopass.RequestKindFile {
+				t.Errorf("unexpected fulfill body %v", body)
+			}
+			_, _ = io.WriteString(w, `{"message":"secret provided"}`)
+		case "GET /request/req-id/secret":
+			_, _ = io.WriteString(w, `{"message":"encrypted"}`)
+		case "PUT /request/req-id/key":
+			if body["public_key"] != "new-key" {
+				t.Errorf("unexpected rotate body %v", body)
+			}
+			_, _ = io.WriteString(w, `{"message":"public key updated"}`)
+		case "DELETE /request/req-id":
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE /request/gone":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"message":"Secret request not found"}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	c := yopass.NewClient(ts.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateRequest(ctx, "public-key", "vpn", 3600)
+	if err != nil || created != (yopass.CreatedRequest{ID: "req-id", Token: "req-token", ExpiresAt: 42}) {
+		t.Fatalf("CreateRequest: got %+v, %v", created, err)
+	}
+	info, err := c.GetRequest(ctx, created.ID)
+	if err != nil || info.PublicKey != "public-key" || info.State != "pending" {
+		t.Errorf("GetRequest: got %+v, %v", info, err)
+	}
+	if err := c.RotateRequestKey(ctx, created.ID, created.Token, "new-key"); err != nil {
+		t.Errorf("RotateRequestKey: %v", err)
+	}
+	if err := c.FulfillRequest(ctx, created.ID, "encrypted", yopass.RequestKindFile); err != nil {
+		t.Errorf("FulfillRequest: %v", err)
+	}
+	secret, err := c.FetchRequestSecret(ctx, created.ID, created.Token)
+	if err != nil || secret != (yopass.RequestSecret{Message: "encrypted", Kind: yopass.RequestKindText}) {
+		t.Errorf("FetchRequestSecret: got %+v, %v", secret, err)
+	}
+	if err := c.RevokeRequest(ctx, created.ID, created.Token); err != nil {
+		t.Errorf("RevokeRequest: %v", err)
+	}
+	if err := c.RevokeRequest(ctx, "gone", created.Token); !yopass.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
@@ -1,12 +1,16 @@
 package yopass_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap/zaptest"
 
@@ -117,6 +121,14 @@ func (db *testDB) Put(key string, secret yopass.Secret) error {
 	return nil
 }
 
+func (db *testDB) PutIfAbsent(key string, secret yopass.Secret) (bool, error) {
+	if _, ok := (map[string]string(*db))[key]; ok {
+		return false, nil
+	}
+	(map[string]string(*db))[key] = secret.Message
+	return true, nil
+}
+
 func (db *testDB) Delete(key string) (bool, error) {
 	delete((map[string]string(*db)), key)
 	return true, nil
@@ -296,6 +308,40 @@ func TestTokenAuthHeaders(t *testing.T) {
 	}
 }
 
+func TestFetchTwoStepRetrieval(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = io.WriteString(w, `{"oneTime":true,"requireAuth":false,"claim":"nonce","claimExpiresIn":300}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != `{"claim":"nonce"}` {
+			t.Errorf("expected the claim to be POSTed back, got %s %s", r.Method, body)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/file/test-file" {
+			if got := r.Header.Get("Accept"); got != "application/octet-stream" {
+				t.Errorf("expected Accept header to be kept, got %q", got)
+			}
+			_, _ = io.WriteString(w, `encrypted-file-data`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"message":"secret"}`)
+	}))
+	defer ts.Close()
+
+	msg, err := yopass.Fetch(ts.URL, "test-secret")
+	if err != nil || msg != "secret" {
+		t.Fatalf("Fetch: got %q, %v", msg, err)
+	}
+	data, err := yopass.FetchFile(ts.URL, "test-file")
+	if err != nil || string(data) != "encrypted-file-data" {
+		t.Fatalf("FetchFile: got %q, %v", data, err)
+	}
+}
+
 func TestTokenNormalization(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -349,3 +395,264 @@ func TestFetchFileServerError(t *testing.T) {
 		t.Fatalf("expected ServerError, got %T", err)
 	}
 }
+
+func newClientTestServer(t *testing.T) (*httptest.Server, *testDB) {
+	t.Helper()
+	db := testDB(map[string]string{})
+	y := server.Server{
+		DB:             &db,
+		FileStore:      server.NewDatabaseFileStore(&db),
+		MaxLength:      10000,
+		MaxFileSize:    10 * 1024 * 1024,
+		PrefetchSecret: true,
+		Registry:       prometheus.NewRegistry(),
+		Logger:         zaptest.NewLogger(t),
+	}
+	ts := httptest.NewServer(y.HTTPHandler())
+	t.Cleanup(ts.Close)
+	return ts, &db
+}
+
+func TestClientSecretLifecycle(t *testing.T) {
+	ts, _ := newClientTestServer(t)
+	ctx := context.Background()
+	c := yopass.NewClient(ts.URL + "/")
+
+	msg, err := yopass.Encrypt(strings.NewReader("hello"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.CreateSecret(ctx, msg, yopass.CreateOptions{Expiration: 3600, Label: "db password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.ManageToken == "" {
+		t.Fatalf("expected an ID and a management token, got %+v", created)
+	}
+
+	if _, err := c.SecretStatus(ctx, created.ID); err != nil {
+		t.Errorf("SecretStatus: %v", err)
+	}
+	expiresAt, err := c.ExtendSecret(ctx, created.ID, created.ManageToken, 86400)
+	if err != nil {
+		t.Fatalf("ExtendSecret: %v", err)
+	}
+	if expiresAt < time.Now().Unix()+86000 {
+		t.Errorf("expected the expiry to be extended, got %d", expiresAt)
+	}
+	got, err := c.FetchSecret(ctx, created.ID, yopass.FetchOptions{})
+	if err != nil || got != msg {
+		t.Fatalf("FetchSecret: got %q, %v", got, err)
+	}
+
+	err = c.DeleteSecret(ctx, created.ID, "wrong-token")
+	var se *yopass.ServerError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized || se.Message == "" {
+		t.Errorf("expected a 401 ServerError with a message, got %v", err)
+	}
+	if err := c.DeleteSecret(ctx, created.ID, created.ManageToken); err != nil {
+		t.Fatalf("DeleteSecret: %v", err)
+	}
+	_, err = c.FetchSecret(ctx, created.ID, yopass.FetchOptions{})
+	if !yopass.IsNotFound(err) {
+		t.Errorf("expected a deleted secret to be not found, got %v", err)
+	}
+	if !errors.As(err, &se) || se.Message != "Secret not found" {
+		t.Errorf("expected the server's message, got %v", err)
+	}
+}
+
+func TestClientFileStreaming(t *testing.T) {
+	ts, _ := newClientTestServer(t)
+	ctx := context.Background()
+	c := yopass.NewClient(ts.URL)
+
+	// An io.Reader without a known length is uploaded chunked.
+	payload := append([]byte{0xC3}, bytes.Repeat([]byte("encrypted-data"), 1000)...)
+	created, err := c.UploadFile(ctx, io.MultiReader(bytes.NewReader(payload)), yopass.CreateOptions{Expiration: 3600})
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if _, err := c.FileStatus(ctx, created.ID); err != nil {
+		t.Errorf("FileStatus: %v", err)
+	}
+	body, err := c.DownloadFile(ctx, created.ID, yopass.FetchOptions{})
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("expected the payload back, got %d bytes, %v", len(data), err)
+	}
+	if err := c.DeleteFile(ctx, created.ID, created.ManageToken); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, err := c.DownloadFile(ctx, created.ID, yopass.FetchOptions{}); !yopass.IsNotFound(err) {
+		t.Errorf("expected a deleted file to be not found, got %v", err)
+	}
+}
+
+func TestClientRequestHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("expected the token, got %q", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "backup-job/1.0" {
+			t.Errorf("expected the user agent, got %q", got)
+		}
+		switch r.URL.Path {
+		case "/create/file":
+			want := map[string]string{
+				"X-Yopass-Expiration":        "3600",
+				"X-Yopass-OneTime":           "false",
+				"X-Yopass-MaxViews":          "3",
+				"X-Yopass-RequireAuth":       "true",
+				"X-Yopass-Receipt":           "true",
+				"X-Yopass-AllowedRecipients": "alice@example.com,bob@example.com",
+				"X-Yopass-PIN":               "1234",
+				"X-Yopass-Label":             "backup",
+			}
+			for k, v := range want {
+				if got := r.Header.Get(k); got != v {
+					t.Errorf("%s: expected %q, got %q", k, v, got)
+				}
+			}
+			_, _ = io.WriteString(w, `{"message":"file-id","manage_token":"m","receipt_token":"r"}`)
+		case "/secret/file-id/receipt":
+			if got := r.Header.Get("X-Yopass-Receipt-Token"); got != "r" {
+				t.Errorf("expected the receipt token, got %q", got)
+			}
+			_, _ = io.WriteString(w, `{"state":"viewed","one_time":false,"created_at":1,"expires_at":2,"max_views":3,"views":1}`)
+		default:
+			t.Errorf("unexpected request path %s", r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := yopass.NewClient(ts.URL, yopass.WithToken("Bearer test-token"), yopass.WithUserAgent("backup-job/1.0"))
+	created, err := c.UploadFile(context.Background(), strings.NewReader("data"), yopass.CreateOptions{
+		Expiration:        3600,
+		MaxViews:          3,
+		RequireAuth:       true,
+		Receipt:           true,
+		AllowedRecipients: []string{"alice@example.com", "bob@example.com"},
+		PIN:               "1234",
+		Label:             "backup",
+	})
+	if err != nil || created != (yopass.Created{ID: "file-id", ManageToken: "m", ReceiptToken: "r"}) {
+		t.Fatalf("UploadFile: got %+v, %v", created, err)
+	}
+	receipt, err := c.Receipt(context.Background(), created.ID, created.ReceiptToken)
+	if err != nil || receipt.State != "viewed" || receipt.Views != 1 {
+		t.Errorf("Receipt: got %+v, %v", receipt, err)
+	}
+}
+
+func TestClientInvalidPIN(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Yopass-PIN") != "0000" {
+			t.Errorf("expected the PIN header, got %q", r.Header.Get("X-Yopass-PIN"))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, `{"message":"Invalid PIN","remainingAttempts":2}`)
+	}))
+	defer ts.Close()
+
+	_, err := yopass.NewClient(ts.URL).FetchSecret(context.Background(), "id", yopass.FetchOptions{PIN: "0000"})
+	var se *yopass.ServerError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized || se.Message != "Invalid PIN" || se.RemainingAttempts != 2 {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/create/secret" && attempts == 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"message":"Too many requests"}`, http.StatusTooManyRequests)
+		case r.URL.Path == "/create/secret":
+			if !strings.Contains(string(body), `"message":"msg"`) {
+				t.Errorf("expected the body to be replayed, got %s", body)
+			}
+			_, _ = io.WriteString(w, `{"message":"id"}`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	c := yopass.NewClient(ts.URL, yopass.WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	if created, err := c.CreateSecret(ctx, "msg", yopass.CreateOptions{Expiration: 3600}); err != nil || created.ID != "id" || attempts != 2 {
+		t.Fatalf("expected the rate limited request to be retried, got %+v, %v after %d attempts", created, err, attempts)
+	}
+
+	attempts = 0
+	if _, err := c.Config(ctx); err == nil || attempts != 3 {
+		t.Errorf("expected a GET to be attempted 3 times, got %d: %v", attempts, err)
+	}
+
+	attempts = 0
+	if _, err := c.UploadFile(ctx, strings.NewReader("data"), yopass.CreateOptions{Expiration: 3600}); err == nil || attempts != 1 {
+		t.Errorf("expected a POST failing with 502 not to be retried, got %d attempts: %v", attempts, err)
+	}
+
+	// Retrievals may consume a one-time secret, so a lost response is not
+	// fetched again.
+	attempts = 0
+	if _, err := c.FetchSecret(ctx, "id", yopass.FetchOptions{}); err == nil || attempts != 1 {
+		t.Errorf("expected a secret retrieval failing with 502 not to be retried, got %d attempts: %v", attempts, err)
+	}
+	attempts = 0
+	if _, err := c.DownloadFile(ctx, "id", yopass.FetchOptions{}); err == nil || attempts != 1 {
+		t.Errorf("expected a file download failing with 502 not to be retried, got %d attempts: %v", attempts, err)
+	}
+	attempts = 0
+	if _, err := c.FetchRequestSecret(ctx, "id", "token"); err == nil || attempts != 1 {
+		t.Errorf("expected a request secret retrieval failing with 502 not to be retried, got %d attempts: %v", attempts, err)
+	}
+}
+
+func TestClientRetriesUnavailableRetrieval(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, `{"message":"encrypted"}`)
+	}))
+	defer ts.Close()
+
+	c := yopass.NewClient(ts.URL, yopass.WithRetries(2, time.Millisecond))
+	msg, err := c.FetchSecret(context.Background(), "id", yopass.FetchOptions{})
+	if err != nil || msg != "encrypted" || attempts != 2 {
+		t.Errorf("expected a retrieval refused with 503 to be retried, got %q, %v after %d attempts", msg, err, attempts)
+	}
+}
+
+func TestClientContextCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := yopass.NewClient(ts.URL, yopass.WithRetries(5, time.Second))
+	start := time.Now()
+	_, err := c.Config(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to end the retries, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected the backoff to be interrupted")
+	}
+}
@@ -0,0 +1,110 @@
+package yopass
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// expirationUnits maps the duration suffixes accepted by ExpirationSeconds to
+// their length in seconds, largest first so FormatExpiration can walk it.
+var expirationUnits = []struct {
+	suffix  byte
+	seconds int64
+}{
+	{'w', 7 * 24 * 3600},
+	{'d', 24 * 3600},
+	{'h', 3600},
+	{'m', 60},
+	{'s', 1},
+}
+
+// ExpirationSeconds converts a human-readable expiry duration such as "15m",
+// "1h", "3d", "1w" or "1h30m" to seconds. Every number needs a unit (s, m, h,
+// d or w). ok is false for malformed, zero and out of range values; whether
+// the lifetime is acceptable is decided separately by an ExpirationPolicy.
+func ExpirationSeconds(s string) (seconds int32, ok bool) {
+	if s == "" {
+		return 0, false
+	}
+	var total int64
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		unit := int64(0)
+		for _, u := range expirationUnits {
+			if u.suffix == s[i] {
+				unit = u.seconds
+			}
+		}
+		if unit == 0 || n > math.MaxInt32/unit {
+			return 0, false
+		}
+		total += n * unit
+		if total > math.MaxInt32 {
+			return 0, false
+		}
+		s = s[i+1:]
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return int32(total), true
+}
+
+// FormatExpiration renders seconds in the form accepted by
+// ExpirationSeconds, using the largest units first: 5400 becomes "1h30m".
+// Weeks are only used for whole weeks, so 30 days stays "30d".
+func FormatExpiration(seconds int32) string {
+	if seconds <= 0 {
+		return "0s"
+	}
+	var b strings.Builder
+	rest := int64(seconds)
+	for _, u := range expirationUnits {
+		if u.suffix == 'w' && rest%u.seconds != 0 {
+			continue
+		}
+		if rest >= u.seconds {
+			fmt.Fprintf(&b, "%d%c", rest/u.seconds, u.suffix)
+			rest %= u.seconds
+		}
+	}
+	return b.String()
+}
+
+// ExpirationPolicy bounds the secret lifetimes a server accepts, in seconds.
+type ExpirationPolicy struct {
+	Min int32
+	Max int32
+}
+
+// DefaultExpirationPolicy is the range servers accept unless configured
+// otherwise: one hour to one week.
+var DefaultExpirationPolicy = ExpirationPolicy{Min: 3600, Max: 7 * 24 * 3600}
+
+// Allows reports whether seconds is within the policy.
+func (p ExpirationPolicy) Allows(seconds int32) bool {
+	return seconds >= p.Min && seconds <= p.Max
+}
+
+// String describes the policy for error messages, e.g. "between 1h and 1w".
+func (p ExpirationPolicy) String() string {
+	return "between " + FormatExpiration(p.Min) + " and " + FormatExpiration(p.Max)
+}
+
+// ValidExpirationSeconds reports whether seconds is a lifetime accepted by
+// the default expiration policy.
+func ValidExpirationSeconds(seconds int32) bool {
+	return DefaultExpirationPolicy.Allows(seconds)
+}
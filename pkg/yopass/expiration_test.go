@@ -0,0 +1,81 @@
+package yopass_test
+
+import (
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+func TestExpirationSeconds(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int32
+		ok    bool
+	}{
+		{"1h", 3600, true},
+		{"1d", 86400, true},
+		{"1w", 604800, true},
+		{"15m", 900, true},
+		{"30d", 2592000, true},
+		{"1h30m", 5400, true},
+		{"90s", 90, true},
+		{"", 0, false},
+		{"0h", 0, false},
+		{"3600", 0, false},
+		{"h", 0, false},
+		{"1y", 0, false},
+		{"-1h", 0, false},
+		{"1.5h", 0, false},
+		{"1 h", 0, false},
+		{"99999999w", 0, false},
+		{"3551w", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, ok := yopass.ExpirationSeconds(tc.input)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("ExpirationSeconds(%q) = %d, %v; want %d, %v", tc.input, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestFormatExpiration(t *testing.T) {
+	for seconds, want := range map[int32]string{
+		3600:    "1h",
+		5400:    "1h30m",
+		604800:  "1w",
+		1209600: "2w",
+		2592000: "30d",
+		90061:   "1d1h1m1s",
+		0:       "0s",
+	} {
+		if got := yopass.FormatExpiration(seconds); got != want {
+			t.Errorf("FormatExpiration(%d) = %q, want %q", seconds, got, want)
+		}
+		if seconds == 0 {
+			continue
+		}
+		if back, ok := yopass.ExpirationSeconds(want); !ok || back != seconds {
+			t.Errorf("ExpirationSeconds(%q) = %d, %v; want %d", want, back, ok, seconds)
+		}
+	}
+}
+
+func TestExpirationPolicy(t *testing.T) {
+	p := yopass.ExpirationPolicy{Min: 900, Max: 2592000}
+	for seconds, want := range map[int32]bool{899: false, 900: true, 86400: true, 2592000: true, 2592001: false} {
+		if got := p.Allows(seconds); got != want {
+			t.Errorf("Allows(%d) = %v, want %v", seconds, got, want)
+		}
+	}
+	if got := p.String(); got != "between 15m and 30d" {
+		t.Errorf("unexpected policy description %q", got)
+	}
+	for _, s := range []string{"1h", "1d", "1w"} {
+		seconds, _ := yopass.ExpirationSeconds(s)
+		if !yopass.ValidExpirationSeconds(seconds) {
+			t.Errorf("expected %s to be valid under the default policy", s)
+		}
+	}
+}
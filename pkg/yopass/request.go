@@ -0,0 +1,203 @@
+package yopass
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Secret requests turn the usual flow around: the requester generates a key
+// pair, registers the public key with the server and hands out a request
+// link; the responder encrypts the secret to that public key. Unlike shared
+// secrets, which use a symmetric key carried in the link, only the holder of
+// the private key can decrypt the response.
+
+// requestKeyConfig matches the ECC key pairs generated by the web UI.
+var requestKeyConfig = &packet.Config{
+	Algorithm:   packet.PubKeyAlgoEdDSA,
+	Curve:       packet.Curve25519,
+	DefaultHash: pgpConfig.DefaultHash,
+}
+
+// requestKeyName is the user ID of generated request key pairs.
+const requestKeyName = "Yopass Secret Request"
+
+// shortFingerprintLength is the number of trailing fingerprint hex digits
+// carried in request links.
+const shortFingerprintLength = 16
+
+// ErrFingerprintMismatch is returned when a request's public key does not
+// match the fingerprint in its link, which means the key was replaced on
+// the server.
+var ErrFingerprintMismatch = errors.New("public key does not match the request link")
+
+// RequestKey is a key pair generated for a secret request.
+type RequestKey struct {
+	// PrivateKey is the armored private key. It must never leave the
+	// requester.
+	PrivateKey string
+	// PublicKey is the armored public key registered with the server.
+	PublicKey string
+	// Fingerprint is the hex fingerprint of the public key.
+	Fingerprint string
+}
+
+// GenerateRequestKey creates a new key pair for a secret request.
+func GenerateRequestKey() (RequestKey, error) {
+	entity, err := openpgp.NewEntity(requestKeyName, "", "", requestKeyConfig)
+	if err != nil {
+		return RequestKey{}, fmt.Errorf("could not generate key: %w", err)
+	}
+	var private, public bytes.Buffer
+	if err := armorKey(&private, openpgp.PrivateKeyType, func(w io.Writer) error { return entity.SerializePrivate(w, nil) }); err != nil {
+		return RequestKey{}, err
+	}
+	if err := armorKey(&public, openpgp.PublicKeyType, entity.Serialize); err != nil {
+		return RequestKey{}, err
+	}
+	return RequestKey{
+		PrivateKey:  private.String(),
+		PublicKey:   public.String(),
+		Fingerprint: hex.EncodeToString(entity.PrimaryKey.Fingerprint),
+	}, nil
+}
+
+func armorKey(buf *bytes.Buffer, blockType string, serialize func(io.Writer) error) error {
+	a, err := armor.Encode(buf, blockType, nil)
+	if err != nil {
+		return fmt.Errorf("could not create armor encoder: %w", err)
+	}
+	if err := serialize(a); err != nil {
+		return fmt.Errorf("could not serialize key: %w", err)
+	}
+	return a.Close()
+}
+
+// readArmoredKey returns the single key in an armored key block.
+func readArmoredKey(armored string) (*openpgp.Entity, error) {
+	ring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil || len(ring) != 1 {
+		return nil, ErrInvalidKey
+	}
+	return ring[0], nil
+}
+
+// PublicKeyFingerprint returns the hex fingerprint of an armored public key.
+func PublicKeyFingerprint(publicKey string) (string, error) {
+	entity, err := readArmoredKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(entity.PrimaryKey.Fingerprint), nil
+}
+
+// ShortFingerprint returns the part of a fingerprint carried in request
+// links.
+func ShortFingerprint(fingerprint string) string {
+	fingerprint = strings.ToLower(fingerprint)
+	if len(fingerprint) > shortFingerprintLength {
+		return fingerprint[len(fingerprint)-shortFingerprintLength:]
+	}
+	return fingerprint
+}
+
+// VerifyRequestKey checks publicKey against the short fingerprint from a
+// request link. The fingerprint is in the URL fragment and never reaches
+// the server, so a server that swapped the public key is detected here.
+func VerifyRequestKey(publicKey, fingerprint string) error {
+	fp, err := PublicKeyFingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	if fingerprint == "" || ShortFingerprint(fp) != strings.ToLower(fingerprint) {
+		return ErrFingerprintMismatch
+	}
+	return nil
+}
+
+// RequestURL returns the link a responder opens to provide a secret.
+func RequestURL(url, id, fingerprint string) string {
+	return fmt.Sprintf("%s/#/r/%s/%s", strings.TrimSuffix(url, "/"), id, ShortFingerprint(fingerprint))
+}
+
+// ParseRequestURL returns the request ID and short fingerprint from a
+// request link.
+func ParseRequestURL(s string) (id, fingerprint string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL: %w", err)
+	}
+	f := strings.Split(u.Fragment, "/")
+	if len(f) != 4 || f[0] != "" || f[1] != "r" || f[2] == "" || f[3] == "" {
+		return "", "", fmt.Errorf("unexpected URL: %q", s)
+	}
+	return f[2], f[3], nil
+}
+
+// EncryptForRequest encrypts the data from r to the public key of a secret
+// request and returns the armored message. A non-empty filename marks the
+// response as a file; it travels inside the encrypted message.
+func EncryptForRequest(r io.Reader, publicKey, filename string) (string, error) {
+	recipient, err := readArmoredKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	var hints *openpgp.FileHints
+	if filename != "" {
+		hints = &openpgp.FileHints{IsBinary: true, FileName: filename}
+	}
+
+	buf := new(bytes.Buffer)
+	a, err := armor.Encode(buf, "PGP MESSAGE", pgpHeader)
+	if err != nil {
+		return "", fmt.Errorf("could not create armor encoder: %w", err)
+	}
+	w, err := openpgp.Encrypt(a, []*openpgp.Entity{recipient}, nil, hints, pgpConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not encrypt: %w", err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return "", fmt.Errorf("could not copy data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("could not close writer: %w", err)
+	}
+	if err := a.Close(); err != nil {
+		return "", fmt.Errorf("could not close armor: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// DecryptRequestSecret decrypts the armored response to a secret request
+// with the request's private key. The filename is set for file responses.
+func DecryptRequestSecret(r io.Reader, privateKey string) (content, filename string, err error) {
+	entity, err := readArmoredKey(privateKey)
+	if err != nil || entity.PrivateKey == nil {
+		return "", "", ErrInvalidKey
+	}
+	a, err := armor.Decode(r)
+	if err != nil {
+		return "", "", ErrInvalidMessage
+	}
+	m, err := openpgp.ReadMessage(a.Body, openpgp.EntityList{entity}, nil, pgpConfig)
+	if err != nil {
+		if errors.Is(err, pgperrors.ErrKeyIncorrect) {
+			return "", "", fmt.Errorf("could not decrypt: %w", ErrInvalidKey)
+		}
+		return "", "", ErrInvalidMessage
+	}
+	p, err := io.ReadAll(m.UnverifiedBody)
+	if err != nil {
+		return "", "", fmt.Errorf("could not read plaintext: %w", err)
+	}
+	return string(p), m.LiteralData.FileName, nil
+}
@@ -0,0 +1,90 @@
+package yopass_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jhaals/yopass/pkg/yopass"
+)
+
+func TestRequestEncryptionRoundTrip(t *testing.T) {
+	key, err := yopass.GenerateRequestKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp, err := yopass.PublicKeyFingerprint(key.PublicKey); err != nil || fp != key.Fingerprint {
+		t.Fatalf("expected fingerprint %s, got %s, %v", key.Fingerprint, fp, err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		filename string
+	}{
+		{"text", "db-password", ""},
+		{"file", "\x00\x01binary", "id_ed25519"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := yopass.EncryptForRequest(strings.NewReader(tc.content), key.PublicKey, tc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(msg, "-----BEGIN PGP MESSAGE-----") {
+				t.Fatalf("expected an armored message, got %q", msg)
+			}
+			content, filename, err := yopass.DecryptRequestSecret(strings.NewReader(msg), key.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content != tc.content || filename != tc.filename {
+				t.Errorf("expected %q/%q, got %q/%q", tc.content, tc.filename, content, filename)
+			}
+		})
+	}
+
+	other, err := yopass.GenerateRequestKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := yopass.EncryptForRequest(strings.NewReader("secret"), key.PublicKey, "")
+	if _, _, err := yopass.DecryptRequestSecret(strings.NewReader(msg), other.PrivateKey); !errors.Is(err, yopass.ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for another private key, got %v", err)
+	}
+	if _, _, err := yopass.DecryptRequestSecret(strings.NewReader(msg), key.PublicKey); !errors.Is(err, yopass.ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for a public key, got %v", err)
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	key, err := yopass.GenerateRequestKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := yopass.RequestURL("https://yopass.example.com/", "req-id", key.Fingerprint)
+	if want := "https://yopass.example.com/#/r/req-id/" + key.Fingerprint[len(key.Fingerprint)-16:]; link != want {
+		t.Fatalf("expected %s, got %s", want, link)
+	}
+	id, fp, err := yopass.ParseRequestURL(link)
+	if err != nil || id != "req-id" {
+		t.Fatalf("ParseRequestURL: got %q, %v", id, err)
+	}
+	if err := yopass.VerifyRequestKey(key.PublicKey, fp); err != nil {
+		t.Errorf("expected the key to match its link, got %v", err)
+	}
+	other, _ := yopass.GenerateRequestKey()
+	if err := yopass.VerifyRequestKey(other.PublicKey, fp); !errors.Is(err, yopass.ErrFingerprintMismatch) {
+		t.Errorf("expected a swapped key to be detected, got %v", err)
+	}
+
+	for _, bad := range []string{
+		"https://yopass.example.com/#/s/id/key",
+		"https://yopass.example.com/#/r/id",
+		"https://yopass.example.com/#/r//fp",
+	} {
+		if _, _, err := yopass.ParseRequestURL(bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
@@ -50,39 +50,36 @@ var pgpConfigArgon2 = func() *packet.Config {
 	return &cfg
 }()
 
-// expirations is the single source of truth for the supported secret
-// lifetimes, mapping the human-readable form to seconds. pkg/server and the
-// CLI both derive their validation from it.
-var expirations = map[string]int32{
-	"1h": 3600,
-	"1d": 86400,
-	"1w": 604800,
-}
-
-// ExpirationSeconds converts a human-readable expiry duration ("1h", "1d" or
-// "1w") to its equivalent in seconds. ok is false for unsupported values.
-func ExpirationSeconds(s string) (seconds int32, ok bool) {
-	seconds, ok = expirations[s]
-	return seconds, ok
-}
-
-// ValidExpirationSeconds reports whether seconds matches one of the
-// supported secret lifetimes.
-func ValidExpirationSeconds(seconds int32) bool {
-	for _, ttl := range expirations {
-		if ttl == seconds {
-			return true
-		}
-	}
-	return false
-}
-
 // Secret holds the encrypted message
 type Secret struct {
 	Expiration  int32  `json:"expiration,omitempty"`
 	Message     string `json:"message"`
 	OneTime     bool   `json:"one_time,omitempty"`
 	RequireAuth bool   `json:"require_auth,omitempty"`
+	// MaxViews limits how often the secret can be retrieved; zero means no
+	// limit besides OneTime.
+	MaxViews int32 `json:"max_views,omitempty"`
+	// Views and ExpiresAt are maintained by the server for view-limited
+	// secrets: the retrievals so far, and the Unix time the secret expires
+	// so that counting a view keeps the remaining lifetime.
+	Views     int32 `json:"views,omitempty"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// PINHash and PINAttempts are maintained by the server for PIN-protected
+	// secrets: the salted hash of the PIN recipients must present, and the
+	// invalid PINs presented so far.
+	PINHash     string `json:"pin_hash,omitempty"`
+	PINAttempts int32  `json:"pin_attempts,omitempty"`
+	// AllowedRecipients and AllowedGroups restrict retrieval to signed-in
+	// users with one of the listed emails or OIDC groups; either list
+	// implies RequireAuth.
+	AllowedRecipients []string `json:"allowed_recipients,omitempty"`
+	AllowedGroups     []string `json:"allowed_groups,omitempty"`
+}
+
+// Restricted reports whether the secret is limited to named recipients or
+// groups.
+func (s *Secret) Restricted() bool {
+	return len(s.AllowedRecipients) > 0 || len(s.AllowedGroups) > 0
 }
 
 // ToJSON converts a Secret to json
@@ -93,6 +90,22 @@ func (s *Secret) ToJSON() ([]byte, error) {
 // Decrypt reads the provided ciphertext and returns the plaintext decrypted
 // with the given key. It auto-detects armored vs binary PGP format.
 func Decrypt(r io.Reader, key string) (content, filename string, err error) {
+	pt, filename, err := DecryptStream(r, key)
+	if err != nil {
+		return "", "", err
+	}
+	p, err := io.ReadAll(pt)
+	if err != nil {
+		return "", "", err
+	}
+	return string(p), filename, nil
+}
+
+// DecryptStream is Decrypt for messages too large to hold in memory: it
+// returns a reader of the plaintext, decrypted as it is read from r. The
+// message's integrity is only confirmed once the reader reaches io.EOF; if
+// reading fails, whatever was read so far must be discarded.
+func DecryptStream(r io.Reader, key string) (plaintext io.Reader, filename string, err error) {
 	tried := false
 	prompt := func([]openpgp.Key, bool) ([]byte, error) {
 		if tried {
@@ -113,7 +126,7 @@ func Decrypt(r io.Reader, key string) (content, filename string, err error) {
 		// Armored PGP
 		a, err := armor.Decode(combined)
 		if err != nil {
-			return "", "", ErrInvalidMessage
+			return nil, "", ErrInvalidMessage
 		}
 		msgReader = a.Body
 	} else {
@@ -124,18 +137,28 @@ func Decrypt(r io.Reader, key string) (content, filename string, err error) {
 	m, err := openpgp.ReadMessage(msgReader, nil, prompt, pgpConfig)
 	if err != nil {
 		if errors.Is(err, ErrInvalidKey) {
-			return "", "", fmt.Errorf("could not decrypt: %w", err)
+			return nil, "", fmt.Errorf("could not decrypt: %w", err)
 		}
-		return "", "", ErrInvalidMessage
-	}
-	p, err := io.ReadAll(m.UnverifiedBody)
-	if err != nil {
-		return "", "", fmt.Errorf("could not read plaintext: %w", err)
+		return nil, "", ErrInvalidMessage
 	}
 	if m.LiteralData.IsBinary {
 		filename = m.LiteralData.FileName
 	}
-	return string(p), filename, nil
+	return plaintextReader{m.UnverifiedBody}, filename, nil
+}
+
+// plaintextReader reports failures of the decrypting reader, including a
+// failed integrity check at the end of the message, as read errors.
+type plaintextReader struct {
+	r io.Reader
+}
+
+func (p plaintextReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("could not read plaintext: %w", err)
+	}
+	return n, err
 }
 
 const armorHeader = "-----BEGIN PGP MESSAGE-----"
@@ -155,8 +178,29 @@ func EncryptBinaryWithArgon2(r io.Reader, key string, filename string) ([]byte,
 }
 
 func encryptBinary(r io.Reader, key string, filename string, config *packet.Config) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encryptStream(buf, r, key, filename, config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncryptStream is EncryptBinary for files too large to hold in memory: it
+// encrypts the data from r and writes the binary PGP message to w as it goes,
+// for example into the request body of Client.UploadFile.
+func EncryptStream(w io.Writer, r io.Reader, key string, filename string) error {
+	return encryptStream(w, r, key, filename, pgpConfig)
+}
+
+// EncryptStreamWithArgon2 is EncryptStream with Argon2id key derivation, see
+// EncryptBinaryWithArgon2.
+func EncryptStreamWithArgon2(w io.Writer, r io.Reader, key string, filename string) error {
+	return encryptStream(w, r, key, filename, pgpConfigArgon2)
+}
+
+func encryptStream(dst io.Writer, r io.Reader, key string, filename string, config *packet.Config) error {
 	if key == "" {
-		return nil, ErrEmptyKey
+		return ErrEmptyKey
 	}
 
 	hints := &openpgp.FileHints{
@@ -164,19 +208,17 @@ func encryptBinary(r io.Reader, key string, filename string, config *packet.Conf
 		FileName: filename,
 	}
 
-	buf := new(bytes.Buffer)
-	w, err := openpgp.SymmetricallyEncrypt(buf, []byte(key), hints, config)
+	w, err := openpgp.SymmetricallyEncrypt(dst, []byte(key), hints, config)
 	if err != nil {
-		return nil, fmt.Errorf("could not encrypt: %w", err)
+		return fmt.Errorf("could not encrypt: %w", err)
 	}
 	if _, err := io.Copy(w, r); err != nil {
-		return nil, fmt.Errorf("could not copy data: %w", err)
+		return fmt.Errorf("could not copy data: %w", err)
 	}
 	if err := w.Close(); err != nil {
-		return nil, fmt.Errorf("could not close writer: %w", err)
+		return fmt.Errorf("could not close writer: %w", err)
 	}
-
-	return buf.Bytes(), nil
+	return nil
 }
 
 // Encrypt reads the provided plaintext and returns a ciphertext encrypted with
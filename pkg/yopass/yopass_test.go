@@ -373,6 +373,57 @@ func TestEncryptBinaryReadError(t *testing.T) {
 	}
 }
 
+func TestEncryptStream(t *testing.T) {
+	key := "TestStreamKey1234567890"
+	// Large enough to span several AEAD chunks.
+	plaintext := bytes.Repeat([]byte("stream test "), 512*1024)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(yopass.EncryptStream(pw, bytes.NewReader(plaintext), key, "large.bin"))
+	}()
+	pt, name, err := yopass.DecryptStream(pr, key)
+	if err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if name != "large.bin" {
+		t.Errorf("expected filename large.bin, got %q", name)
+	}
+	got, err := io.ReadAll(pt)
+	if err != nil {
+		t.Fatalf("reading plaintext failed: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("expected %d bytes of plaintext back, got %d", len(plaintext), len(got))
+	}
+}
+
+func TestDecryptStreamTruncated(t *testing.T) {
+	key := "TestStreamKey1234567890"
+	var encrypted bytes.Buffer
+	if err := yopass.EncryptStream(&encrypted, bytes.NewReader(bytes.Repeat([]byte("x"), 1<<20)), key, "file.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	pt, _, err := yopass.DecryptStream(bytes.NewReader(encrypted.Bytes()[:encrypted.Len()/2]), key)
+	if err != nil {
+		t.Fatalf("expected the header to decrypt, got %v", err)
+	}
+	if _, err := io.ReadAll(pt); err == nil {
+		t.Error("expected reading a truncated message to fail")
+	}
+}
+
+func TestDecryptStreamWrongKey(t *testing.T) {
+	var encrypted bytes.Buffer
+	if err := yopass.EncryptStream(&encrypted, strings.NewReader("secret"), "TestStreamKey1234567890", "file.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := yopass.DecryptStream(&encrypted, "wrong key"); !errors.Is(err, yopass.ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+}
+
 func TestParseURL(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -2,7 +2,11 @@ import { readMessage, decrypt } from 'openpgp';
 import { useEffect, useRef, useState } from 'react';
 import { useTranslation } from 'react-i18next';
 import { useParams } from 'react-router-dom';
-import { backendDomain, crossOriginCredentials } from '@shared/lib/api';
+import {
+  backendDomain,
+  claimFile,
+  crossOriginCredentials,
+} from '@shared/lib/api';
 import { downloadUrl } from '@shared/lib/download';
 import { useConfig } from '@shared/hooks/useConfig';
 import AuthRequiredNotice from '@shared/components/AuthRequiredNotice';
@@ -53,10 +57,15 @@ export default function StreamingDecryptor({
     let phase: DecryptFailure = 'download';
     try {
       // Fetch the encrypted binary stream
-      const response = await fetch(`${backendDomain}/file/${secretKey}`, {
+      const url = `${backendDomain}/file/${secretKey}`;
+      const init: RequestInit = {
         headers: { Accept: 'application/octet-stream' },
         ...crossOriginCredentials(OIDC_ENABLED),
-      });
+      };
+      let response = await fetch(url, init);
+      if (response.status === 202) {
+        response = await claimFile(url, response, init);
+      }
 
       if (response.status === 401) {
         setAuthRequired(true);
@@ -3,6 +3,7 @@ import { useTranslation } from 'react-i18next';
 import { QRCodeSVG } from 'qrcode.react';
 import { useConfig } from '@shared/hooks/useConfig';
 import { useCopy } from '@shared/hooks/useCopy';
+import { useExpirationOptions } from '@shared/hooks/useExpirationOptions';
 import { generateRequestKeyPair } from '@shared/lib/crypto';
 import { createSecretRequest } from '@shared/lib/api';
 import { saveStoredRequest } from '@shared/lib/requestStore';
@@ -18,6 +19,7 @@ interface CreatedRequest {
 export default function CreateRequest() {
   const { t } = useTranslation();
   const config = useConfig();
+  const { options: expirationOptions } = useExpirationOptions();
   const [label, setLabel] = useState('');
   const [expiration, setExpiration] = useState(
     String(config?.DEFAULT_EXPIRY ?? 3600),
@@ -182,11 +184,7 @@ export default function CreateRequest() {
             {t('expiration.legend')}
           </legend>
           <div className="join w-full mt-2">
-            {[
-              { value: '3600', label: t('expiration.optionOneHourLabel') },
-              { value: '86400', label: t('expiration.optionOneDayLabel') },
-              { value: '604800', label: t('expiration.optionOneWeekLabel') },
-            ].map(option => (
+            {expirationOptions.map(option => (
               <input
                 key={option.value}
                 type="radio"
@@ -2,6 +2,7 @@ import { useEffect } from 'react';
 import { useTranslation } from 'react-i18next';
 import type { UseFormRegister, UseFormSetValue } from 'react-hook-form';
 import { useConfig } from '@shared/hooks/useConfig';
+import { useExpirationOptions } from '@shared/hooks/useExpirationOptions';
 
 type SecretFormFields = {
   expiration: string;
@@ -45,16 +46,11 @@ export function SecretOptions<T extends SecretFormFields>({
   const setValue = setValueProp as unknown as UseFormSetValue<SecretFormFields>;
   const { t } = useTranslation();
   const config = useConfig();
+  const { options: expirationOptions, labelFor } = useExpirationOptions();
 
   const forceExpiration = config?.FORCE_EXPIRATION;
   const forcedExpirationLabel = forceExpiration
-    ? forceExpiration === 3600
-      ? t('expiration.optionOneHourLabel')
-      : forceExpiration === 86400
-        ? t('expiration.optionOneDayLabel')
-        : forceExpiration === 604800
-          ? t('expiration.optionOneWeekLabel')
-          : t('expiration.optionOneHourLabel')
+    ? labelFor(forceExpiration)
     : undefined;
 
   useEffect(() => {
@@ -80,11 +76,7 @@ export function SecretOptions<T extends SecretFormFields>({
           </p>
         ) : (
           <div className="join w-full mt-2">
-            {[
-              { value: '3600', label: t('expiration.optionOneHourLabel') },
-              { value: '86400', label: t('expiration.optionOneDayLabel') },
-              { value: '604800', label: t('expiration.optionOneWeekLabel') },
-            ].map(option => (
+            {expirationOptions.map(option => (
               <input
                 key={option.value}
                 type="radio"
@@ -14,6 +14,8 @@ export interface Config {
   MAX_REQUEST_FILE_SIZE?: string;
   DEFAULT_EXPIRY?: number;
   FORCE_EXPIRATION?: number;
+  MIN_EXPIRATION?: number;
+  MAX_EXPIRATION?: number;
   PRIVACY_NOTICE_URL?: string;
   IMPRINT_URL?: string;
   THEME_LIGHT: string;
@@ -130,6 +132,14 @@ async function loadConfig(): Promise<Config> {
           typeof data.FORCE_EXPIRATION === 'number'
             ? data.FORCE_EXPIRATION
             : undefined,
+        MIN_EXPIRATION:
+          typeof data.MIN_EXPIRATION === 'number'
+            ? data.MIN_EXPIRATION
+            : undefined,
+        MAX_EXPIRATION:
+          typeof data.MAX_EXPIRATION === 'number'
+            ? data.MAX_EXPIRATION
+            : undefined,
         PRIVACY_NOTICE_URL: data.PRIVACY_NOTICE_URL,
         IMPRINT_URL: data.IMPRINT_URL,
         THEME_LIGHT: asString(data.THEME_LIGHT) ?? defaultConfig.THEME_LIGHT,
@@ -0,0 +1,27 @@
+import { useTranslation } from 'react-i18next';
+import { useConfig } from '@shared/hooks/useConfig';
+import { allowedExpirations, formatExpiration } from '@shared/lib/expiration';
+
+// useExpirationOptions returns the expiration choices allowed by the server
+// policy and a label for any lifetime. The presets keep their translated
+// labels; other lifetimes are formatted by the browser in the UI language.
+export function useExpirationOptions() {
+  const { t, i18n } = useTranslation();
+  const config = useConfig();
+
+  const presetLabels: Record<number, string> = {
+    3600: t('expiration.optionOneHourLabel'),
+    86400: t('expiration.optionOneDayLabel'),
+    604800: t('expiration.optionOneWeekLabel'),
+  };
+  const labelFor = (seconds: number) =>
+    presetLabels[seconds] ?? formatExpiration(seconds, i18n.language);
+
+  const options = allowedExpirations(
+    config?.MIN_EXPIRATION,
+    config?.MAX_EXPIRATION,
+    config?.DEFAULT_EXPIRY,
+  ).map(seconds => ({ value: String(seconds), label: labelFor(seconds) }));
+
+  return { options, labelFor };
+}
@@ -54,6 +54,27 @@ describe('jsonFetch (via getSecret)', () => {
     });
   });
 
+  it('redeems the claim nonce of a two-step retrieval', async () => {
+    fetchMock
+      .mockResolvedValueOnce(
+        fakeResponse({ status: 202, body: { oneTime: true, claim: 'nonce' } }),
+      )
+      .mockResolvedValueOnce(
+        fakeResponse({ status: 200, body: { message: 'encrypted' } }),
+      );
+
+    const result = await getSecret('abc', false);
+
+    expect(result).toEqual({
+      data: { message: 'encrypted' },
+      status: 200,
+    });
+    expect(fetchMock).toHaveBeenLastCalledWith('/secret/abc', {
+      method: 'POST',
+      body: JSON.stringify({ claim: 'nonce' }),
+    });
+  });
+
   it('extracts the error message from a non-OK JSON body', async () => {
     fetchMock.mockResolvedValue(
       fakeResponse({ status: 400, body: { message: 'Secret not found' } }),
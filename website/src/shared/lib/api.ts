@@ -80,10 +80,48 @@ export async function getSecretStatus(
 
 // Fetches (and for one-time secrets, consumes) an encrypted text secret.
 export async function getSecret(id: string, oidcEnabled: boolean) {
-  return jsonFetch<{ message: string }>(`${backendDomain}/secret/${id}`, {
+  const url = `${backendDomain}/secret/${id}`;
+  const result = await jsonFetch<{ message: string; claim?: string }>(url, {
     method: 'GET',
     ...crossOriginCredentials(oidcEnabled),
   });
+  const claim = result.data?.claim;
+  if (result.status !== 202 || !claim) {
+    return result;
+  }
+  return claimSecret(url, claim, oidcEnabled);
+}
+
+// Servers with two-step retrieval answer the GET of a one-time secret with
+// 202 and a claim nonce instead of the secret. POSTing the nonce back to the
+// same URL releases (and consumes) it; link previews never get that far.
+export async function claimSecret(
+  url: string,
+  claim: string,
+  oidcEnabled: boolean,
+) {
+  return jsonFetch<{ message: string }>(url, {
+    method: 'POST',
+    body: JSON.stringify({ claim }),
+    ...crossOriginCredentials(oidcEnabled),
+  });
+}
+
+// Redeems the claim nonce of a 202 file response; see claimSecret.
+export async function claimFile(
+  url: string,
+  response: Response,
+  init: RequestInit,
+): Promise<Response> {
+  const { claim } = (await response.json()) as { claim?: string };
+  if (!claim) {
+    throw new Error('Missing claim');
+  }
+  return fetch(url, {
+    ...init,
+    method: 'POST',
+    body: JSON.stringify({ claim }),
+  });
 }
 
 // --- Read receipts (business feature) ---
@@ -0,0 +1,40 @@
+import { describe, expect, it } from 'vitest';
+
+import { allowedExpirations, formatExpiration } from './expiration';
+
+describe('allowedExpirations', () => {
+  it('offers all presets under the default policy', () => {
+    expect(allowedExpirations()).toEqual([3600, 86400, 604800]);
+  });
+
+  it('drops presets outside the policy', () => {
+    expect(allowedExpirations(900, 86400)).toEqual([3600, 86400]);
+    expect(allowedExpirations(86400, 2592000)).toEqual([86400, 604800]);
+  });
+
+  it('adds a non-preset default expiry', () => {
+    expect(allowedExpirations(900, 2592000, 1800)).toEqual([
+      1800, 3600, 86400, 604800,
+    ]);
+  });
+
+  it('ignores a default expiry outside the policy', () => {
+    expect(allowedExpirations(3600, 604800, 60)).toEqual([
+      3600, 86400, 604800,
+    ]);
+  });
+
+  it('falls back to the minimum when no preset fits', () => {
+    expect(allowedExpirations(900, 1800)).toEqual([900]);
+  });
+});
+
+describe('formatExpiration', () => {
+  it('uses the largest unit dividing the lifetime', () => {
+    expect(formatExpiration(1800, 'en')).toBe('30 minutes');
+    expect(formatExpiration(3600, 'en')).toBe('1 hour');
+    expect(formatExpiration(2592000, 'en')).toBe('30 days');
+    expect(formatExpiration(1209600, 'en')).toBe('2 weeks');
+    expect(formatExpiration(5400, 'en')).toBe('90 minutes');
+  });
+});
@@ -0,0 +1,53 @@
+// Expiration presets offered by the UI, and helpers to fit them to the
+// server's --min-expiration/--max-expiration policy from /config.
+
+export const EXPIRATION_PRESETS = [3600, 86400, 604800];
+
+// Mirrors yopass.DefaultExpirationPolicy, used when /config predates it.
+export const DEFAULT_MIN_EXPIRATION = 3600;
+export const DEFAULT_MAX_EXPIRATION = 604800;
+
+const UNITS: [Intl.NumberFormatOptions['unit'], number][] = [
+  ['week', 604800],
+  ['day', 86400],
+  ['hour', 3600],
+  ['minute', 60],
+  ['second', 1],
+];
+
+// formatExpiration renders a lifetime in seconds in the largest unit that
+// divides it evenly, localized by the browser: 1800 becomes "30 minutes".
+export function formatExpiration(seconds: number, locale?: string): string {
+  const [unit, size] = UNITS.find(([, size]) => seconds % size === 0) ?? [
+    'second',
+    1,
+  ];
+  return new Intl.NumberFormat(locale, {
+    style: 'unit',
+    unit,
+    unitDisplay: 'long',
+  }).format(seconds / size);
+}
+
+// allowedExpirations returns the lifetimes to offer: the presets within
+// [min, max], plus the server default when it is not a preset. If nothing
+// fits, the minimum is offered so the form always has a valid choice.
+export function allowedExpirations(
+  min = DEFAULT_MIN_EXPIRATION,
+  max = DEFAULT_MAX_EXPIRATION,
+  defaultExpiry?: number,
+): number[] {
+  const options = EXPIRATION_PRESETS.filter(s => s >= min && s <= max);
+  if (
+    defaultExpiry !== undefined &&
+    defaultExpiry >= min &&
+    defaultExpiry <= max &&
+    !options.includes(defaultExpiry)
+  ) {
+    options.push(defaultExpiry);
+  }
+  if (options.length === 0) {
+    options.push(min);
+  }
+  return options.sort((a, b) => a - b);
+}